@@ -0,0 +1,1337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ INTERFAZ DE ALMACENAMIENTO ------------
+
+// Almacen abstrae dónde viven los datos del taller. Los menús y la simulación
+// trabajan siempre a través de esta interfaz, de modo que da igual si los datos
+// están sólo en memoria o también en disco.
+type Almacen interface {
+	Clientes() []*Cliente
+	Cliente(id int) *Cliente
+	GuardarCliente(c *Cliente) error
+	BorrarCliente(id int) error
+
+	Vehiculos() []*Vehiculo
+	Vehiculo(mat string) *Vehiculo
+	GuardarVehiculo(v *Vehiculo) error
+	BorrarVehiculo(mat string) error
+
+	Incidencias() []*Incidencia
+	Incidencia(id int) *Incidencia
+	GuardarIncidencia(inc *Incidencia) error
+	BorrarIncidencia(id int) error
+
+	Mecanicos() []*Mecanico
+	Mecanico(id int) *Mecanico
+	GuardarMecanico(m *Mecanico) error
+	BorrarMecanico(id int) error
+
+	Plazas() []*Plaza
+	Plaza(id int) *Plaza
+	GuardarPlaza(p *Plaza) error
+	BorrarPlaza(id int) error
+
+	Citas() []*Cita
+	Cita(id int) *Cita
+	GuardarCita(c *Cita) error
+	BorrarCita(id int) error
+
+	Piezas() []*Pieza
+	Pieza(id int) *Pieza
+	GuardarPieza(p *Pieza) error
+	BorrarPieza(id int) error
+
+	// Los movimientos de stock sólo se añaden: son el historial del almacén
+	Movimientos() []MovimientoStock
+	RegistrarMovimiento(mv MovimientoStock) error
+
+	Presupuestos() []*Presupuesto
+	Presupuesto(id int) *Presupuesto
+	GuardarPresupuesto(p *Presupuesto) error
+
+	// Las facturas emitidas no se modifican ni se borran
+	Facturas() []*Factura
+	Factura(numero int) *Factura
+	GuardarFactura(f *Factura) error
+
+	Visitas() []*Visita
+	Visita(id int) *Visita
+	GuardarVisita(vi *Visita) error
+
+	// Avisos de la bandeja de salida sin entregar (ver BandejaSalida)
+	EstadoAvisos() EstadoAvisos
+	GuardarEstadoAvisos(e EstadoAvisos) error
+
+	// Vehículos de la flota repartida que siguen en un nodo que ya no es su
+	// dueño (ver Enrutador), por matrícula
+	FlotaFuera() map[string]string
+	GuardarFlotaFuera(fuera map[string]string) error
+
+	// Transaccion ejecuta fn de forma atómica: si devuelve error se
+	// deshacen todos los cambios hechos dentro. Se llama con el taller
+	// cogido (ver conTaller).
+	Transaccion(fn func() error) error
+	// Sincronizar vuelca el estado actual (útil tras modificar los datos
+	// directamente a través de punteros, como hace la simulación).
+	Sincronizar() error
+}
+
+var errNoEncontrado = errors.New("no encontrado")
+
+// El taller y su almacén sólo se tocan con t.mu cogido, que hace también de
+// cerrojo del almacén: mientras dura una transacción nadie más escribe, así
+// que deshacerla no pisa los cambios de otros. El menú principal lo tiene
+// salvo mientras espera al usuario; el resto de goroutines (mecánicos,
+// servidores RPC...) lo cogen con conTaller sólo mientras tocan los datos,
+// nunca mientras esperan.
+func (t *Taller) conTaller(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+}
+
+// Suelta el taller mientras se ejecuta fn (que espera a otros sin tocarlo)
+// y lo vuelve a coger. Sólo para quien ya lo tiene.
+func (t *Taller) sinTaller(fn func()) {
+	t.mu.Unlock()
+	defer t.mu.Lock()
+	fn()
+}
+
+// Devuelve el almacén del taller (en memoria si no se ha configurado otro)
+func (t *Taller) datos() Almacen {
+	if t.almacen == nil {
+		return almacenMemoria{t: t}
+	}
+	return t.almacen
+}
+
+// Crea un taller con el almacén indicado ("memoria" o "fichero")
+func nuevoTaller(tipo string, dir string) (*Taller, error) {
+	t := &Taller{}
+	switch tipo {
+	case "", "memoria":
+		return t, nil
+	case "fichero":
+		a, err := abrirAlmacenFichero(dir, t)
+		if err != nil {
+			return nil, err
+		}
+		t.almacen = a
+		return t, nil
+	default:
+		return nil, fmt.Errorf("tipo de almacén desconocido (%s): debe ser 'memoria' o 'fichero'", tipo)
+	}
+}
+
+// ------------ ALMACÉN EN MEMORIA ------------
+
+// almacenMemoria guarda los datos en las listas del propio Taller,
+// igual que se hacía antes de existir la interfaz.
+type almacenMemoria struct {
+	t *Taller
+}
+
+func (a almacenMemoria) Clientes() []*Cliente { return a.t.Clientes }
+
+func (a almacenMemoria) Cliente(id int) *Cliente {
+	for _, c := range a.t.Clientes {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarCliente(c *Cliente) error {
+	for i, existente := range a.t.Clientes {
+		if existente.ID == c.ID {
+			a.t.Clientes[i] = c
+			return nil
+		}
+	}
+	a.t.Clientes = append(a.t.Clientes, c)
+	return nil
+}
+
+func (a almacenMemoria) BorrarCliente(id int) error {
+	for i, c := range a.t.Clientes {
+		if c.ID == id {
+			a.t.Clientes = append(a.t.Clientes[:i], a.t.Clientes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("cliente con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Vehiculos() []*Vehiculo { return a.t.Vehiculos }
+
+func (a almacenMemoria) Vehiculo(mat string) *Vehiculo {
+	for _, v := range a.t.Vehiculos {
+		if v.Matricula == mat {
+			return v
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarVehiculo(v *Vehiculo) error {
+	for i, existente := range a.t.Vehiculos {
+		if existente.Matricula == v.Matricula {
+			a.t.Vehiculos[i] = v
+			return nil
+		}
+	}
+	a.t.Vehiculos = append(a.t.Vehiculos, v)
+	return nil
+}
+
+func (a almacenMemoria) BorrarVehiculo(mat string) error {
+	for i, v := range a.t.Vehiculos {
+		if v.Matricula == mat {
+			a.t.Vehiculos = append(a.t.Vehiculos[:i], a.t.Vehiculos[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("vehículo con matrícula %s: %w", mat, errNoEncontrado)
+}
+
+func (a almacenMemoria) Incidencias() []*Incidencia { return a.t.Incidencias }
+
+func (a almacenMemoria) Incidencia(id int) *Incidencia {
+	for _, inc := range a.t.Incidencias {
+		if inc.ID == id {
+			return inc
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarIncidencia(inc *Incidencia) error {
+	for i, existente := range a.t.Incidencias {
+		if existente.ID == inc.ID {
+			a.t.Incidencias[i] = inc
+			return nil
+		}
+	}
+	a.t.Incidencias = append(a.t.Incidencias, inc)
+	return nil
+}
+
+func (a almacenMemoria) BorrarIncidencia(id int) error {
+	for i, inc := range a.t.Incidencias {
+		if inc.ID == id {
+			a.t.Incidencias = append(a.t.Incidencias[:i], a.t.Incidencias[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("incidencia con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Mecanicos() []*Mecanico { return a.t.Mecanicos }
+
+func (a almacenMemoria) Mecanico(id int) *Mecanico {
+	for _, m := range a.t.Mecanicos {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarMecanico(m *Mecanico) error {
+	for i, existente := range a.t.Mecanicos {
+		if existente.ID == m.ID {
+			a.t.Mecanicos[i] = m
+			return nil
+		}
+	}
+	a.t.Mecanicos = append(a.t.Mecanicos, m)
+	return nil
+}
+
+func (a almacenMemoria) BorrarMecanico(id int) error {
+	for i, m := range a.t.Mecanicos {
+		if m.ID == id {
+			a.t.Mecanicos = append(a.t.Mecanicos[:i], a.t.Mecanicos[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("mecánico con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Plazas() []*Plaza { return a.t.Plazas }
+
+func (a almacenMemoria) Plaza(id int) *Plaza {
+	for _, p := range a.t.Plazas {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarPlaza(p *Plaza) error {
+	for i, existente := range a.t.Plazas {
+		if existente.ID == p.ID {
+			a.t.Plazas[i] = p
+			return nil
+		}
+	}
+	a.t.Plazas = append(a.t.Plazas, p)
+	return nil
+}
+
+func (a almacenMemoria) BorrarPlaza(id int) error {
+	for i, p := range a.t.Plazas {
+		if p.ID == id {
+			a.t.Plazas = append(a.t.Plazas[:i], a.t.Plazas[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("plaza con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Citas() []*Cita { return a.t.Citas }
+
+func (a almacenMemoria) Cita(id int) *Cita {
+	for _, c := range a.t.Citas {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarCita(c *Cita) error {
+	for i, existente := range a.t.Citas {
+		if existente.ID == c.ID {
+			a.t.Citas[i] = c
+			return nil
+		}
+	}
+	a.t.Citas = append(a.t.Citas, c)
+	return nil
+}
+
+func (a almacenMemoria) BorrarCita(id int) error {
+	for i, c := range a.t.Citas {
+		if c.ID == id {
+			a.t.Citas = append(a.t.Citas[:i], a.t.Citas[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("cita con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Piezas() []*Pieza { return a.t.Piezas }
+
+func (a almacenMemoria) Pieza(id int) *Pieza {
+	for _, p := range a.t.Piezas {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarPieza(p *Pieza) error {
+	for i, existente := range a.t.Piezas {
+		if existente.ID == p.ID {
+			a.t.Piezas[i] = p
+			return nil
+		}
+	}
+	a.t.Piezas = append(a.t.Piezas, p)
+	return nil
+}
+
+func (a almacenMemoria) BorrarPieza(id int) error {
+	for i, p := range a.t.Piezas {
+		if p.ID == id {
+			a.t.Piezas = append(a.t.Piezas[:i], a.t.Piezas[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("pieza con ID %d: %w", id, errNoEncontrado)
+}
+
+func (a almacenMemoria) Movimientos() []MovimientoStock { return a.t.Movimientos }
+
+func (a almacenMemoria) RegistrarMovimiento(mv MovimientoStock) error {
+	a.t.Movimientos = append(a.t.Movimientos, mv)
+	return nil
+}
+
+func (a almacenMemoria) Presupuestos() []*Presupuesto { return a.t.Presupuestos }
+
+func (a almacenMemoria) Presupuesto(id int) *Presupuesto {
+	for _, p := range a.t.Presupuestos {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarPresupuesto(p *Presupuesto) error {
+	for i, existente := range a.t.Presupuestos {
+		if existente.ID == p.ID {
+			a.t.Presupuestos[i] = p
+			return nil
+		}
+	}
+	a.t.Presupuestos = append(a.t.Presupuestos, p)
+	return nil
+}
+
+func (a almacenMemoria) Facturas() []*Factura { return a.t.Facturas }
+
+func (a almacenMemoria) Factura(numero int) *Factura {
+	for _, f := range a.t.Facturas {
+		if f.Numero == numero {
+			return f
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarFactura(f *Factura) error {
+	if a.Factura(f.Numero) != nil {
+		return fmt.Errorf("la factura %s ya existe", f.Codigo())
+	}
+	a.t.Facturas = append(a.t.Facturas, f)
+	return nil
+}
+
+func (a almacenMemoria) Visitas() []*Visita { return a.t.Visitas }
+
+func (a almacenMemoria) Visita(id int) *Visita {
+	for _, vi := range a.t.Visitas {
+		if vi.ID == id {
+			return vi
+		}
+	}
+	return nil
+}
+
+func (a almacenMemoria) GuardarVisita(vi *Visita) error {
+	for i, existente := range a.t.Visitas {
+		if existente.ID == vi.ID {
+			a.t.Visitas[i] = vi
+			return nil
+		}
+	}
+	a.t.Visitas = append(a.t.Visitas, vi)
+	return nil
+}
+
+func (a almacenMemoria) EstadoAvisos() EstadoAvisos { return a.t.Avisos }
+
+func (a almacenMemoria) GuardarEstadoAvisos(e EstadoAvisos) error {
+	a.t.Avisos = e
+	return nil
+}
+
+func (a almacenMemoria) FlotaFuera() map[string]string { return maps.Clone(a.t.FlotaFuera) }
+
+func (a almacenMemoria) GuardarFlotaFuera(fuera map[string]string) error {
+	a.t.FlotaFuera = maps.Clone(fuera)
+	return nil
+}
+
+// En memoria la transacción guarda una copia del estado y la restaura si fn
+// falla. Se llama con el taller cogido (ver conTaller).
+func (a almacenMemoria) Transaccion(fn func() error) error {
+	copia := tomarInstantanea(a.t)
+	if err := fn(); err != nil {
+		copia.restaurar(a.t)
+		return err
+	}
+	return nil
+}
+
+func (a almacenMemoria) Sincronizar() error { return nil }
+
+// instantanea guarda las listas del taller y el contenido de cada elemento
+type instantanea struct {
+	clientes     []*Cliente
+	vehiculos    []*Vehiculo
+	incidencias  []*Incidencia
+	mecanicos    []*Mecanico
+	plazas       []*Plaza
+	citas        []*Cita
+	piezas       []*Pieza
+	movimientos  []MovimientoStock
+	presupuestos []*Presupuesto
+	facturas     []*Factura
+	visitas      []*Visita
+	avisos       EstadoAvisos
+	flotaFuera   map[string]string
+
+	valClientes     []Cliente
+	valVehiculos    []Vehiculo
+	valIncidencias  []Incidencia
+	valMecanicos    []Mecanico
+	valPlazas       []Plaza
+	valCitas        []Cita
+	valPiezas       []Pieza
+	valPresupuestos []Presupuesto
+	valVisitas      []Visita
+
+	nextClienteID     int
+	nextIncidenciaID  int
+	nextMecanicoID    int
+	nextCitaID        int
+	nextPiezaID       int
+	nextPresupuestoID int
+	nextFacturaNum    int
+	nextVisitaID      int
+}
+
+func tomarInstantanea(t *Taller) *instantanea {
+	s := &instantanea{
+		clientes:          slices.Clone(t.Clientes),
+		vehiculos:         slices.Clone(t.Vehiculos),
+		incidencias:       slices.Clone(t.Incidencias),
+		mecanicos:         slices.Clone(t.Mecanicos),
+		plazas:            slices.Clone(t.Plazas),
+		citas:             slices.Clone(t.Citas),
+		piezas:            slices.Clone(t.Piezas),
+		movimientos:       slices.Clone(t.Movimientos),
+		presupuestos:      slices.Clone(t.Presupuestos),
+		facturas:          slices.Clone(t.Facturas),
+		visitas:           slices.Clone(t.Visitas),
+		avisos:            t.Avisos,
+		flotaFuera:        t.FlotaFuera,
+		nextClienteID:     t.nextClienteID,
+		nextIncidenciaID:  t.nextIncidenciaID,
+		nextMecanicoID:    t.nextMecanicoID,
+		nextCitaID:        t.nextCitaID,
+		nextPiezaID:       t.nextPiezaID,
+		nextPresupuestoID: t.nextPresupuestoID,
+		nextFacturaNum:    t.nextFacturaNum,
+		nextVisitaID:      t.nextVisitaID,
+	}
+	for _, c := range t.Clientes {
+		val := *c
+		val.Vehiculos = slices.Clone(c.Vehiculos)
+		s.valClientes = append(s.valClientes, val)
+	}
+	for _, v := range t.Vehiculos {
+		val := *v
+		val.Incidencias = slices.Clone(v.Incidencias)
+		s.valVehiculos = append(s.valVehiculos, val)
+	}
+	for _, inc := range t.Incidencias {
+		val := *inc
+		val.Mecanicos = slices.Clone(inc.Mecanicos)
+		val.Piezas = slices.Clone(inc.Piezas)
+		val.ManoDeObra = slices.Clone(inc.ManoDeObra)
+		s.valIncidencias = append(s.valIncidencias, val)
+	}
+	for _, m := range t.Mecanicos {
+		val := *m
+		val.Turnos = slices.Clone(m.Turnos)
+		val.Ausencias = slices.Clone(m.Ausencias)
+		s.valMecanicos = append(s.valMecanicos, val)
+	}
+	for _, p := range t.Plazas {
+		s.valPlazas = append(s.valPlazas, *p)
+	}
+	for _, c := range t.Citas {
+		val := *c
+		val.Tipos = slices.Clone(c.Tipos)
+		s.valCitas = append(s.valCitas, val)
+	}
+	for _, p := range t.Piezas {
+		s.valPiezas = append(s.valPiezas, *p)
+	}
+	for _, p := range t.Presupuestos {
+		val := *p
+		val.Incidencias = slices.Clone(p.Incidencias)
+		val.Lineas = slices.Clone(p.Lineas)
+		s.valPresupuestos = append(s.valPresupuestos, val)
+	}
+	for _, vi := range t.Visitas {
+		val := *vi
+		val.Incidencias = slices.Clone(vi.Incidencias)
+		s.valVisitas = append(s.valVisitas, val)
+	}
+	return s
+}
+
+func (s *instantanea) restaurar(t *Taller) {
+	for i, c := range s.clientes {
+		*c = s.valClientes[i]
+	}
+	for i, v := range s.vehiculos {
+		*v = s.valVehiculos[i]
+	}
+	for i, inc := range s.incidencias {
+		*inc = s.valIncidencias[i]
+	}
+	for i, m := range s.mecanicos {
+		*m = s.valMecanicos[i]
+	}
+	for i, p := range s.plazas {
+		*p = s.valPlazas[i]
+	}
+	for i, c := range s.citas {
+		*c = s.valCitas[i]
+	}
+	for i, p := range s.piezas {
+		*p = s.valPiezas[i]
+	}
+	for i, p := range s.presupuestos {
+		*p = s.valPresupuestos[i]
+	}
+	for i, vi := range s.visitas {
+		*vi = s.valVisitas[i]
+	}
+	t.Clientes = s.clientes
+	t.Vehiculos = s.vehiculos
+	t.Incidencias = s.incidencias
+	t.Mecanicos = s.mecanicos
+	t.Plazas = s.plazas
+	t.Citas = s.citas
+	t.Piezas = s.piezas
+	t.Movimientos = s.movimientos
+	t.Presupuestos = s.presupuestos
+	t.Facturas = s.facturas
+	t.Visitas = s.visitas
+	t.Avisos = s.avisos
+	t.FlotaFuera = s.flotaFuera
+	t.nextClienteID = s.nextClienteID
+	t.nextIncidenciaID = s.nextIncidenciaID
+	t.nextMecanicoID = s.nextMecanicoID
+	t.nextCitaID = s.nextCitaID
+	t.nextPiezaID = s.nextPiezaID
+	t.nextPresupuestoID = s.nextPresupuestoID
+	t.nextFacturaNum = s.nextFacturaNum
+	t.nextVisitaID = s.nextVisitaID
+}
+
+// ------------ ALMACÉN EN FICHERO ------------
+
+// almacenFichero mantiene los datos en memoria (como caché) y escribe un
+// fichero JSON por colección en dir cada vez que algo cambia.
+type almacenFichero struct {
+	almacenMemoria
+	dir  string
+	mu   sync.Mutex
+	enTx int // profundidad de transacciones abiertas (no se escribe hasta el final)
+
+	// Con varios nodos de admisión sobre el mismo directorio sólo se
+	// escribe con el turno de las plazas (ver conPlazas); lo que cambia sin
+	// él se escribe la próxima vez que se coge
+	plazasCompartidas bool
+	conTurno          bool
+	sinEscribir       bool
+}
+
+// Ficheros de cada colección dentro del directorio de datos
+const (
+	ficheroClientes     = "clientes.json"
+	ficheroVehiculos    = "vehiculos.json"
+	ficheroIncidencias  = "incidencias.json"
+	ficheroMecanicos    = "mecanicos.json"
+	ficheroPlazas       = "plazas.json"
+	ficheroCitas        = "citas.json"
+	ficheroPiezas       = "piezas.json"
+	ficheroMovimientos  = "movimientos.json"
+	ficheroPresupuestos = "presupuestos.json"
+	ficheroFacturas     = "facturas.json"
+	ficheroVisitas      = "visitas.json"
+	ficheroAvisos       = "bandeja.json"
+	ficheroFlotaFuera   = "flota.json"
+	ficheroContadores   = "contadores.json"
+
+	prefijoConfirmar = "confirmar" // listas de cambios de cada escritura (ver escribirTodos)
+)
+
+// En disco las relaciones se guardan como identificadores en lugar de punteros
+type registroCliente struct {
+	ID        int
+	Nombre    string
+	Telefono  string
+	Email     string
+	Vehiculos []string
+}
+
+type registroVehiculo struct {
+	Matricula    string
+	Marca        string
+	Modelo       string
+	FechaEntrada time.Time
+	FechaSalida  time.Time
+	Incidencias  []int
+	TiempoTotal  int
+	Prioritario  bool
+	Sede         string
+}
+
+type registroIncidencia struct {
+	ID              int
+	Mecanicos       []int
+	Tipo            Especialidad
+	Prioridad       string
+	Descripcion     string
+	Estado          int
+	TiempoAcumulado int
+	Hecho           int
+	Piezas          []PiezaNecesaria
+	PiezasServidas  bool
+	ManoDeObra      []ParteTrabajo
+	Facturada       bool
+}
+
+type registroContadores struct {
+	NextClienteID     int
+	NextIncidenciaID  int
+	NextMecanicoID    int
+	NextCitaID        int
+	NextPiezaID       int
+	NextPresupuestoID int
+	NextFacturaNum    int
+	NextVisitaID      int
+}
+
+// Abre (o crea) el directorio de datos y carga su contenido en t
+func abrirAlmacenFichero(dir string, t *Taller) (*almacenFichero, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear el directorio de datos %s: %w", dir, err)
+	}
+	a := &almacenFichero{almacenMemoria: almacenMemoria{t: t}, dir: dir}
+	if err := a.cargar(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *almacenFichero) leer(nombre string, destino any) (bool, error) {
+	datos, err := os.ReadFile(filepath.Join(a.dir, nombre))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(datos, destino); err != nil {
+		return false, fmt.Errorf("fichero %s corrupto: %w", nombre, err)
+	}
+	return true, nil
+}
+
+// Escribe el contenido del fichero nombre en un temporal y devuelve su
+// ruta. El temporal es de cada escritura porque varios procesos pueden
+// compartir el directorio (ver conPlazas).
+func (a *almacenFichero) escribirTemporal(nombre string, origen any) (string, error) {
+	datos, err := json.MarshalIndent(origen, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(a.dir, nombre+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(datos); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Escribe en un temporal y renombra, para no dejar nunca un fichero a medias
+func (a *almacenFichero) escribir(nombre string, origen any) error {
+	tmp, err := a.escribirTemporal(nombre, origen)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(a.dir, nombre))
+}
+
+// Escribe varios ficheros a la vez: o se quedan todos los nuevos o todos los
+// viejos. Primero se escriben los temporales; después, la lista de cambios
+// (confirmar.*.json, una por escritura), que es lo que los da por buenos;
+// por último se renombran y se borra la lista. Si el proceso se cae antes de
+// escribir la lista no ha cambiado nada, y si se cae después, al abrir el
+// almacén se terminan los renombrados (ver recuperar).
+func (a *almacenFichero) escribirTodos(colecciones map[string]any) error {
+	cambios := map[string]string{} // temporal -> fichero
+	descartar := func() {
+		for tmp := range cambios {
+			os.Remove(filepath.Join(a.dir, tmp))
+		}
+	}
+	for nombre, origen := range colecciones {
+		tmp, err := a.escribirTemporal(nombre, origen)
+		if err != nil {
+			descartar()
+			return fmt.Errorf("error guardando %s: %w", nombre, err)
+		}
+		cambios[filepath.Base(tmp)] = nombre
+	}
+	tmp, err := a.escribirTemporal(prefijoConfirmar, cambios)
+	if err == nil {
+		err = os.Rename(tmp, strings.TrimSuffix(tmp, ".tmp")+".json")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		descartar()
+		return fmt.Errorf("error guardando la lista de cambios: %w", err)
+	}
+	return a.renombrar(strings.TrimSuffix(filepath.Base(tmp), ".tmp")+".json", cambios)
+}
+
+// Renombra los temporales de la lista de cambios y la borra. Los que ya no
+// están se renombraron antes de una caída (o los terminó otro proceso que
+// comparte el directorio).
+func (a *almacenFichero) renombrar(lista string, cambios map[string]string) error {
+	for tmp, nombre := range cambios {
+		err := os.Rename(filepath.Join(a.dir, tmp), filepath.Join(a.dir, nombre))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error guardando %s: %w", nombre, err)
+		}
+	}
+	err := os.Remove(filepath.Join(a.dir, lista))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Termina las escrituras que se quedaron a medias
+func (a *almacenFichero) recuperar() error {
+	listas, err := filepath.Glob(filepath.Join(a.dir, prefijoConfirmar+".*.json"))
+	if err != nil {
+		return err
+	}
+	for _, ruta := range listas {
+		var cambios map[string]string
+		if _, err := a.leer(filepath.Base(ruta), &cambios); err != nil {
+			return err
+		}
+		if err := a.renombrar(filepath.Base(ruta), cambios); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lo que hay en el directorio de datos, con las relaciones ya resueltas
+type contenidoFichero struct {
+	clientes     []*Cliente
+	vehiculos    []*Vehiculo
+	incidencias  []*Incidencia
+	mecanicos    []*Mecanico
+	plazas       []*Plaza
+	citas        []*Cita
+	piezas       []*Pieza
+	movimientos  []MovimientoStock
+	presupuestos []*Presupuesto
+	facturas     []*Factura
+	visitas      []*Visita
+	avisos       EstadoAvisos
+	flotaFuera   map[string]string
+	contadores   registroContadores
+}
+
+func (a *almacenFichero) cargar() error {
+	if err := a.recuperar(); err != nil {
+		return err
+	}
+	c, err := a.leerTodo()
+	if err != nil {
+		return err
+	}
+	t := a.t
+	t.Clientes = c.clientes
+	t.Vehiculos = c.vehiculos
+	t.Incidencias = c.incidencias
+	t.Mecanicos = c.mecanicos
+	t.Plazas = c.plazas
+	t.Citas = c.citas
+	t.Piezas = c.piezas
+	t.Movimientos = c.movimientos
+	t.Presupuestos = c.presupuestos
+	t.Facturas = c.facturas
+	t.Visitas = c.visitas
+	t.Avisos = c.avisos
+	t.FlotaFuera = c.flotaFuera
+	t.nextClienteID = c.contadores.NextClienteID
+	t.nextIncidenciaID = c.contadores.NextIncidenciaID
+	t.nextMecanicoID = c.contadores.NextMecanicoID
+	t.nextCitaID = c.contadores.NextCitaID
+	t.nextPiezaID = c.contadores.NextPiezaID
+	t.nextPresupuestoID = c.contadores.NextPresupuestoID
+	t.nextFacturaNum = c.contadores.NextFacturaNum
+	t.nextVisitaID = c.contadores.NextVisitaID
+	return nil
+}
+
+// Lee todas las colecciones del directorio
+func (a *almacenFichero) leerTodo() (*contenidoFichero, error) {
+	var (
+		mecanicos    []*Mecanico
+		plazas       []*Plaza
+		citas        []*Cita
+		piezas       []*Pieza
+		movimientos  []MovimientoStock
+		presupuestos []*Presupuesto
+		facturas     []*Factura
+		visitas      []*Visita
+		avisos       EstadoAvisos
+		flotaFuera   map[string]string
+		incidencias  []registroIncidencia
+		vehiculos    []registroVehiculo
+		clientes     []registroCliente
+		contadores   registroContadores
+	)
+	for nombre, destino := range map[string]any{
+		ficheroMecanicos:    &mecanicos,
+		ficheroPlazas:       &plazas,
+		ficheroCitas:        &citas,
+		ficheroPiezas:       &piezas,
+		ficheroMovimientos:  &movimientos,
+		ficheroPresupuestos: &presupuestos,
+		ficheroFacturas:     &facturas,
+		ficheroVisitas:      &visitas,
+		ficheroAvisos:       &avisos,
+		ficheroFlotaFuera:   &flotaFuera,
+		ficheroIncidencias:  &incidencias,
+		ficheroVehiculos:    &vehiculos,
+		ficheroClientes:     &clientes,
+		ficheroContadores:   &contadores,
+	} {
+		if _, err := a.leer(nombre, destino); err != nil {
+			return nil, err
+		}
+	}
+	c := &contenidoFichero{
+		mecanicos:    mecanicos,
+		plazas:       plazas,
+		citas:        citas,
+		piezas:       piezas,
+		movimientos:  movimientos,
+		presupuestos: presupuestos,
+		facturas:     facturas,
+		visitas:      visitas,
+		avisos:       avisos,
+		flotaFuera:   flotaFuera,
+		contadores:   contadores,
+	}
+
+	mecPorID := map[int]*Mecanico{}
+	for _, m := range mecanicos {
+		mecPorID[m.ID] = m
+	}
+	incPorID := map[int]*Incidencia{}
+	for _, r := range incidencias {
+		inc := &Incidencia{
+			ID:              r.ID,
+			Tipo:            r.Tipo,
+			Prioridad:       r.Prioridad,
+			Descripcion:     r.Descripcion,
+			Estado:          r.Estado,
+			TiempoAcumulado: r.TiempoAcumulado,
+			Hecho:           r.Hecho,
+			Piezas:          r.Piezas,
+			PiezasServidas:  r.PiezasServidas,
+			ManoDeObra:      r.ManoDeObra,
+			Facturada:       r.Facturada,
+		}
+		for _, id := range r.Mecanicos {
+			if m := mecPorID[id]; m != nil {
+				inc.Mecanicos = append(inc.Mecanicos, m)
+			}
+		}
+		incPorID[inc.ID] = inc
+		c.incidencias = append(c.incidencias, inc)
+	}
+	vehPorMat := map[string]*Vehiculo{}
+	for _, r := range vehiculos {
+		v := &Vehiculo{
+			Matricula:    r.Matricula,
+			Marca:        r.Marca,
+			Modelo:       r.Modelo,
+			FechaEntrada: r.FechaEntrada,
+			FechaSalida:  r.FechaSalida,
+			TiempoTotal:  r.TiempoTotal,
+			Prioritario:  r.Prioritario,
+			Sede:         r.Sede,
+		}
+		for _, id := range r.Incidencias {
+			if inc := incPorID[id]; inc != nil {
+				v.Incidencias = append(v.Incidencias, inc)
+			}
+		}
+		vehPorMat[v.Matricula] = v
+		c.vehiculos = append(c.vehiculos, v)
+	}
+	for _, r := range clientes {
+		cli := &Cliente{ID: r.ID, Nombre: r.Nombre, Telefono: r.Telefono, Email: r.Email}
+		for _, mat := range r.Vehiculos {
+			if v := vehPorMat[mat]; v != nil {
+				cli.Vehiculos = append(cli.Vehiculos, v)
+			}
+		}
+		c.clientes = append(c.clientes, cli)
+	}
+	return c, nil
+}
+
+// Vuelca todas las colecciones a disco
+func (a *almacenFichero) persistir() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.enTx > 0 {
+		return nil
+	}
+	if a.plazasCompartidas && !a.conTurno {
+		a.sinEscribir = true
+		return nil
+	}
+	a.sinEscribir = false
+	t := a.t
+
+	clientes := []registroCliente{}
+	for _, c := range t.Clientes {
+		r := registroCliente{ID: c.ID, Nombre: c.Nombre, Telefono: c.Telefono, Email: c.Email}
+		for _, v := range c.Vehiculos {
+			r.Vehiculos = append(r.Vehiculos, v.Matricula)
+		}
+		clientes = append(clientes, r)
+	}
+	vehiculos := []registroVehiculo{}
+	for _, v := range t.Vehiculos {
+		r := registroVehiculo{
+			Matricula:    v.Matricula,
+			Marca:        v.Marca,
+			Modelo:       v.Modelo,
+			FechaEntrada: v.FechaEntrada,
+			FechaSalida:  v.FechaSalida,
+			TiempoTotal:  v.TiempoTotal,
+			Prioritario:  v.Prioritario,
+			Sede:         v.Sede,
+		}
+		for _, inc := range v.Incidencias {
+			r.Incidencias = append(r.Incidencias, inc.ID)
+		}
+		vehiculos = append(vehiculos, r)
+	}
+	incidencias := []registroIncidencia{}
+	for _, inc := range t.Incidencias {
+		r := registroIncidencia{
+			ID:              inc.ID,
+			Tipo:            inc.Tipo,
+			Prioridad:       inc.Prioridad,
+			Descripcion:     inc.Descripcion,
+			Estado:          inc.Estado,
+			TiempoAcumulado: inc.TiempoAcumulado,
+			Hecho:           inc.Hecho,
+			Piezas:          inc.Piezas,
+			PiezasServidas:  inc.PiezasServidas,
+			ManoDeObra:      inc.ManoDeObra,
+			Facturada:       inc.Facturada,
+		}
+		for _, m := range inc.Mecanicos {
+			r.Mecanicos = append(r.Mecanicos, m.ID)
+		}
+		incidencias = append(incidencias, r)
+	}
+	contadores := registroContadores{
+		NextClienteID:     t.nextClienteID,
+		NextIncidenciaID:  t.nextIncidenciaID,
+		NextMecanicoID:    t.nextMecanicoID,
+		NextCitaID:        t.nextCitaID,
+		NextPiezaID:       t.nextPiezaID,
+		NextPresupuestoID: t.nextPresupuestoID,
+		NextFacturaNum:    t.nextFacturaNum,
+		NextVisitaID:      t.nextVisitaID,
+	}
+
+	colecciones := map[string]any{
+		ficheroClientes:     clientes,
+		ficheroVehiculos:    vehiculos,
+		ficheroIncidencias:  incidencias,
+		ficheroMecanicos:    t.Mecanicos,
+		ficheroPlazas:       t.Plazas,
+		ficheroCitas:        t.Citas,
+		ficheroPiezas:       t.Piezas,
+		ficheroMovimientos:  t.Movimientos,
+		ficheroPresupuestos: t.Presupuestos,
+		ficheroFacturas:     t.Facturas,
+		ficheroVisitas:      t.Visitas,
+		ficheroAvisos:       t.Avisos,
+		ficheroFlotaFuera:   t.FlotaFuera,
+		ficheroContadores:   contadores,
+	}
+	return a.escribirTodos(colecciones)
+}
+
+func (a *almacenFichero) compartirPlazas() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.plazasCompartidas = true
+}
+
+// Al coger el turno de las plazas se lee lo que hayan escrito los demás
+// nodos. Las plazas del fichero mandan; del resto de colecciones se añade
+// lo que este nodo no tenga y los contadores se quedan con el mayor. Lo de
+// este nodo que aún no esté en el fichero se conserva.
+func (a *almacenFichero) tomarPlazas() error {
+	c, err := a.leerTodo()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.conTurno = true
+	a.fusionar(c)
+	pendiente := a.sinEscribir
+	a.mu.Unlock()
+	if pendiente {
+		return a.persistir()
+	}
+	return nil
+}
+
+func (a *almacenFichero) soltarPlazas() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conTurno = false
+}
+
+// Añade a la memoria lo leído del fichero. Se llama con a.mu cogido.
+func (a *almacenFichero) fusionar(c *contenidoFichero) {
+	t := a.t
+	for _, p := range c.plazas {
+		if local := a.almacenMemoria.Plaza(p.ID); local != nil {
+			*local = *p
+		} else {
+			t.Plazas = append(t.Plazas, p)
+		}
+	}
+
+	mecanicos := map[int]*Mecanico{}
+	for _, m := range t.Mecanicos {
+		mecanicos[m.ID] = m
+	}
+	for _, m := range c.mecanicos {
+		if mecanicos[m.ID] == nil {
+			mecanicos[m.ID] = m
+			t.Mecanicos = append(t.Mecanicos, m)
+		}
+	}
+	incidencias := map[int]*Incidencia{}
+	for _, inc := range t.Incidencias {
+		incidencias[inc.ID] = inc
+	}
+	for _, inc := range c.incidencias {
+		if incidencias[inc.ID] == nil {
+			for i, m := range inc.Mecanicos {
+				inc.Mecanicos[i] = mecanicos[m.ID]
+			}
+			incidencias[inc.ID] = inc
+			t.Incidencias = append(t.Incidencias, inc)
+		}
+	}
+	vehiculos := map[string]*Vehiculo{}
+	for _, v := range t.Vehiculos {
+		vehiculos[v.Matricula] = v
+	}
+	for _, v := range c.vehiculos {
+		if vehiculos[v.Matricula] == nil {
+			for i, inc := range v.Incidencias {
+				v.Incidencias[i] = incidencias[inc.ID]
+			}
+			vehiculos[v.Matricula] = v
+			t.Vehiculos = append(t.Vehiculos, v)
+		}
+	}
+	clientes := map[int]bool{}
+	for _, cli := range t.Clientes {
+		clientes[cli.ID] = true
+	}
+	for _, cli := range c.clientes {
+		if !clientes[cli.ID] {
+			for i, v := range cli.Vehiculos {
+				cli.Vehiculos[i] = vehiculos[v.Matricula]
+			}
+			t.Clientes = append(t.Clientes, cli)
+		}
+	}
+
+	t.Citas = añadirFaltan(t.Citas, c.citas, func(ci *Cita) int { return ci.ID })
+	t.Piezas = añadirFaltan(t.Piezas, c.piezas, func(p *Pieza) int { return p.ID })
+	t.Presupuestos = añadirFaltan(t.Presupuestos, c.presupuestos, func(p *Presupuesto) int { return p.ID })
+	t.Facturas = añadirFaltan(t.Facturas, c.facturas, func(f *Factura) int { return f.Numero })
+	t.Visitas = añadirFaltan(t.Visitas, c.visitas, func(vi *Visita) int { return vi.ID })
+	t.Movimientos = añadirFaltan(t.Movimientos, c.movimientos, func(mv MovimientoStock) string {
+		return fmt.Sprintf("%s/%d/%s/%d/%d", mv.Fecha.UTC().Format(time.RFC3339Nano), mv.PiezaID, mv.Tipo, mv.Cantidad, mv.IncidenciaID)
+	})
+
+	t.nextClienteID = max(t.nextClienteID, c.contadores.NextClienteID)
+	t.nextIncidenciaID = max(t.nextIncidenciaID, c.contadores.NextIncidenciaID)
+	t.nextMecanicoID = max(t.nextMecanicoID, c.contadores.NextMecanicoID)
+	t.nextCitaID = max(t.nextCitaID, c.contadores.NextCitaID)
+	t.nextPiezaID = max(t.nextPiezaID, c.contadores.NextPiezaID)
+	t.nextPresupuestoID = max(t.nextPresupuestoID, c.contadores.NextPresupuestoID)
+	t.nextFacturaNum = max(t.nextFacturaNum, c.contadores.NextFacturaNum)
+	t.nextVisitaID = max(t.nextVisitaID, c.contadores.NextVisitaID)
+}
+
+// Añade a locales los elementos de leidos cuya clave no está
+func añadirFaltan[E any, K comparable](locales, leidos []E, clave func(E) K) []E {
+	hay := map[K]bool{}
+	for _, e := range locales {
+		hay[clave(e)] = true
+	}
+	for _, e := range leidos {
+		if !hay[clave(e)] {
+			hay[clave(e)] = true
+			locales = append(locales, e)
+		}
+	}
+	return locales
+}
+
+// Si la operación en memoria fue bien, escribe el resultado en disco
+func (a *almacenFichero) tras(err error) error {
+	if err != nil {
+		return err
+	}
+	return a.persistir()
+}
+
+func (a *almacenFichero) GuardarCliente(c *Cliente) error {
+	return a.tras(a.almacenMemoria.GuardarCliente(c))
+}
+
+func (a *almacenFichero) BorrarCliente(id int) error {
+	return a.tras(a.almacenMemoria.BorrarCliente(id))
+}
+
+func (a *almacenFichero) GuardarVehiculo(v *Vehiculo) error {
+	return a.tras(a.almacenMemoria.GuardarVehiculo(v))
+}
+
+func (a *almacenFichero) BorrarVehiculo(mat string) error {
+	return a.tras(a.almacenMemoria.BorrarVehiculo(mat))
+}
+
+func (a *almacenFichero) GuardarIncidencia(inc *Incidencia) error {
+	return a.tras(a.almacenMemoria.GuardarIncidencia(inc))
+}
+
+func (a *almacenFichero) BorrarIncidencia(id int) error {
+	return a.tras(a.almacenMemoria.BorrarIncidencia(id))
+}
+
+func (a *almacenFichero) GuardarMecanico(m *Mecanico) error {
+	return a.tras(a.almacenMemoria.GuardarMecanico(m))
+}
+
+func (a *almacenFichero) BorrarMecanico(id int) error {
+	return a.tras(a.almacenMemoria.BorrarMecanico(id))
+}
+
+func (a *almacenFichero) GuardarPlaza(p *Plaza) error {
+	return a.tras(a.almacenMemoria.GuardarPlaza(p))
+}
+
+func (a *almacenFichero) BorrarPlaza(id int) error {
+	return a.tras(a.almacenMemoria.BorrarPlaza(id))
+}
+
+func (a *almacenFichero) GuardarCita(c *Cita) error {
+	return a.tras(a.almacenMemoria.GuardarCita(c))
+}
+
+func (a *almacenFichero) BorrarCita(id int) error {
+	return a.tras(a.almacenMemoria.BorrarCita(id))
+}
+
+func (a *almacenFichero) GuardarPieza(p *Pieza) error {
+	return a.tras(a.almacenMemoria.GuardarPieza(p))
+}
+
+func (a *almacenFichero) BorrarPieza(id int) error {
+	return a.tras(a.almacenMemoria.BorrarPieza(id))
+}
+
+func (a *almacenFichero) RegistrarMovimiento(mv MovimientoStock) error {
+	return a.tras(a.almacenMemoria.RegistrarMovimiento(mv))
+}
+
+func (a *almacenFichero) GuardarPresupuesto(p *Presupuesto) error {
+	return a.tras(a.almacenMemoria.GuardarPresupuesto(p))
+}
+
+func (a *almacenFichero) GuardarFactura(f *Factura) error {
+	return a.tras(a.almacenMemoria.GuardarFactura(f))
+}
+
+func (a *almacenFichero) GuardarVisita(vi *Visita) error {
+	return a.tras(a.almacenMemoria.GuardarVisita(vi))
+}
+
+func (a *almacenFichero) GuardarEstadoAvisos(e EstadoAvisos) error {
+	return a.tras(a.almacenMemoria.GuardarEstadoAvisos(e))
+}
+
+func (a *almacenFichero) GuardarFlotaFuera(fuera map[string]string) error {
+	return a.tras(a.almacenMemoria.GuardarFlotaFuera(fuera))
+}
+
+// Dentro de la transacción no se escribe nada; al confirmar se vuelca todo
+func (a *almacenFichero) Transaccion(fn func() error) error {
+	a.mu.Lock()
+	a.enTx++
+	a.mu.Unlock()
+
+	err := a.almacenMemoria.Transaccion(fn)
+
+	a.mu.Lock()
+	a.enTx--
+	a.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return a.persistir()
+}
+
+// Con las plazas compartidas se coge el turno para poder escribir
+func (a *almacenFichero) Sincronizar() error {
+	a.mu.Lock()
+	compartidas := a.plazasCompartidas
+	a.mu.Unlock()
+	if compartidas {
+		return a.t.conPlazas(a.persistir)
+	}
+	return a.persistir()
+}
@@ -0,0 +1,143 @@
+// almacen_test.go
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlmacenFicheroPersisteYRecarga(t *testing.T) {
+	dir := t.TempDir()
+
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo abrir el almacén: %v", err)
+	}
+	m := taller.newMecanico("Luis", "mecanica", 5)
+	c, _ := taller.newCliente("Ana", "600111222", "ana@correo.es", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	inc, err := taller.newIncidencia(v.Matricula, []*Mecanico{m}, "mecanica", "Alta", "Frenos")
+	if err != nil {
+		t.Fatalf("error creando incidencia: %v", err)
+	}
+	if err := taller.admitirCliente(c.ID, v, m.ID); err != nil {
+		t.Fatalf("error admitiendo vehículo: %v", err)
+	}
+
+	// Un taller nuevo sobre el mismo directorio debe ver los mismos datos
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo reabrir el almacén: %v", err)
+	}
+	c2 := recargado.getCliente(c.ID)
+	if c2 == nil || c2.Nombre != "Ana" || len(c2.Vehiculos) != 1 {
+		t.Fatalf("cliente no recuperado correctamente: %+v", c2)
+	}
+	v2 := recargado.getVehiculo("1234 BCD")
+	if v2 == nil || len(v2.Incidencias) != 1 || v2.Incidencias[0].ID != inc.ID {
+		t.Fatalf("vehículo no recuperado correctamente: %+v", v2)
+	}
+	if c2.Vehiculos[0] != v2 {
+		t.Errorf("el cliente y el taller deberían compartir el mismo vehículo")
+	}
+	if len(v2.Incidencias[0].Mecanicos) != 1 || v2.Incidencias[0].Mecanicos[0] != recargado.getMecanico(m.ID) {
+		t.Errorf("la incidencia no conserva su mecánico")
+	}
+	if len(recargado.plazasOcupadas()) != 1 {
+		t.Errorf("se esperaba 1 plaza ocupada, hay %d", len(recargado.plazasOcupadas()))
+	}
+
+	// Los contadores también se conservan para no repetir IDs
+	c3, _ := recargado.newCliente("Pedro", "", "", nil)
+	if c3.ID == c.ID {
+		t.Errorf("se ha repetido el ID de cliente %d", c3.ID)
+	}
+}
+
+func TestAlmacenTransaccionDeshaceCambios(t *testing.T) {
+	for _, tipo := range []string{"memoria", "fichero"} {
+		taller, err := nuevoTaller(tipo, t.TempDir())
+		if err != nil {
+			t.Fatalf("%s: no se pudo abrir el almacén: %v", tipo, err)
+		}
+		c, _ := taller.newCliente("Ana", "", "", nil)
+
+		errFallo := errors.New("fallo")
+		err = taller.datos().Transaccion(func() error {
+			c.Nombre = "Cambiado"
+			taller.newCliente("Temporal", "", "", nil)
+			return errFallo
+		})
+		if !errors.Is(err, errFallo) {
+			t.Fatalf("%s: se esperaba el error de la transacción, se obtuvo %v", tipo, err)
+		}
+		if c.Nombre != "Ana" {
+			t.Errorf("%s: el nombre debería volver a 'Ana', es %q", tipo, c.Nombre)
+		}
+		if n := len(taller.datos().Clientes()); n != 1 {
+			t.Errorf("%s: se esperaba 1 cliente tras deshacer, hay %d", tipo, n)
+		}
+	}
+}
+
+// Si el proceso se cae tras confirmar una escritura pero antes de renombrar
+// todos los ficheros, al abrir el almacén se termina
+func TestAlmacenFicheroTerminaEscrituraAMedias(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taller.newCliente("Ana", "", "", nil)
+	a := taller.almacen.(*almacenFichero)
+
+	cambios := map[string]string{}
+	for nombre, origen := range map[string]any{
+		ficheroClientes:   []registroCliente{{ID: 1, Nombre: "Ana", Vehiculos: []string{"1234 BCD"}}},
+		ficheroVehiculos:  []registroVehiculo{{Matricula: "1234 BCD", Marca: "Seat"}},
+		ficheroContadores: registroContadores{NextClienteID: 1},
+	} {
+		tmp, err := a.escribirTemporal(nombre, origen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cambios[filepath.Base(tmp)] = nombre
+	}
+	if err := a.escribir(prefijoConfirmar+".1.json", cambios); err != nil {
+		t.Fatal(err)
+	}
+	// Uno llegó a renombrarse antes de la caída
+	for tmp, nombre := range cambios {
+		if nombre == ficheroVehiculos {
+			os.Rename(filepath.Join(dir, tmp), filepath.Join(dir, nombre))
+		}
+	}
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := recargado.getCliente(1); c == nil || len(c.Vehiculos) != 1 || c.Vehiculos[0] != recargado.getVehiculo("1234 BCD") {
+		t.Fatalf("clientes y vehículos no cuadran tras recuperar: %+v", c)
+	}
+	if listas, _ := filepath.Glob(filepath.Join(dir, prefijoConfirmar+"*")); len(listas) > 0 {
+		t.Errorf("la lista de cambios sigue ahí: %v", listas)
+	}
+}
+
+// Guardar otra copia de un vehículo la sustituye, no la repite
+func TestAlmacenGuardarVehiculoPorMatricula(t *testing.T) {
+	taller := &Taller{}
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	copia := *v
+	copia.Marca = "Renault"
+	if err := taller.datos().GuardarVehiculo(&copia); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(taller.datos().Vehiculos()); n != 1 || taller.getVehiculo(v.Matricula).Marca != "Renault" {
+		t.Errorf("hay %d vehículos tras guardar la copia", n)
+	}
+}
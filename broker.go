@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ TEMAS DE LA SIMULACIÓN (PUBLICAR / SUSCRIBIRSE) ------------
+
+// Lo que pasa en la simulación se publica en el broker por temas y quien
+// quiera enterarse se suscribe: la pantalla muestra los resultados, los
+// avisos a clientes salen de las incidencias cerradas y las métricas lo
+// cuentan todo. Para añadir un consumidor nuevo basta con suscribirlo; los
+// mecánicos no saben quién escucha.
+//
+// Cada suscripción tiene su propio buffer acotado. Si se llena, según su
+// política se descarta el mensaje (y se cuenta) o quien publica espera a que
+// haya sitio, que es lo que hacía el antiguo canal de resultados. Los
+// consumidores que leen el taller (que no se puede tocar desde dos goroutines
+// a la vez) no tienen buffer: los atiende quien publica.
+
+// Temas
+const (
+	temaResultado              = "simulacion.resultado" // mensaje para la pantalla
+	temaVehiculoLlegada        = "vehiculo.llegada"
+	temaPlazaOcupada           = "plaza.ocupada"
+	temaPlazaLiberada          = "plaza.liberada"
+	temaIncidenciaEmpezada     = "incidencia.empezada"
+	temaIncidenciaInterrumpida = "incidencia.interrumpida"
+	temaIncidenciaCerrada      = "incidencia.cerrada"
+	temaTrabajoReclamado       = "trabajo.reclamado"
+)
+
+// Qué hacer cuando el buffer de una suscripción está lleno
+type PoliticaBuffer int
+
+const (
+	politicaDescartar PoliticaBuffer = iota // se pierde el mensaje nuevo
+	politicaBloquear                        // quien publica espera
+	politicaEnLinea                         // sin buffer: lo atiende quien publica
+)
+
+type Mensaje struct {
+	Tema         string
+	Fecha        time.Time
+	Texto        string
+	Matricula    string
+	IncidenciaID int
+	Tipo         Especialidad
+	MecanicoID   int
+	PlazaID      int
+	Horas        float64 // trabajadas en la incidencia en esta entrega
+}
+
+type Suscripcion struct {
+	C        <-chan Mensaje
+	c        chan Mensaje
+	patron   string
+	politica PoliticaBuffer
+	filtro   func(Mensaje) bool
+	atender  func(Mensaje) // con politicaEnLinea
+
+	fin         chan struct{} // se cierra al cancelar: desbloquea a quien publica
+	cancelada   sync.Once
+	envio       sync.Mutex // no se cierra c mientras alguien envía
+	cerrada     bool
+	descartados int
+}
+
+type Broker struct {
+	mu            sync.Mutex
+	suscripciones []*Suscripcion
+	publicados    map[string]int
+	cerrado       bool
+}
+
+func nuevoBroker() *Broker {
+	return &Broker{publicados: map[string]int{}}
+}
+
+// El patrón es un tema exacto, un prefijo terminado en ".*"
+// ("incidencia.*") o "*" para todos
+func coincideTema(patron, tema string) bool {
+	switch {
+	case patron == "*":
+		return true
+	case strings.HasSuffix(patron, ".*"):
+		return strings.HasPrefix(tema, strings.TrimSuffix(patron, "*"))
+	}
+	return patron == tema
+}
+
+// Se suscribe a los temas del patrón que además cumplan filtro (si no es
+// nil), con un buffer de capacidad mensajes. C se cierra al cancelar la
+// suscripción o cerrar el broker.
+func (b *Broker) suscribir(patron string, capacidad int, politica PoliticaBuffer, filtro func(Mensaje) bool) *Suscripcion {
+	c := make(chan Mensaje, max(capacidad, 1))
+	s := &Suscripcion{C: c, c: c, patron: patron, politica: politica, filtro: filtro, fin: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cerrado {
+		s.cancelar()
+		return s
+	}
+	b.suscripciones = append(b.suscripciones, s)
+	return s
+}
+
+// Se suscribe y atiende cada mensaje con fn en su propia goroutine hasta
+// que se cancela la suscripción. Con politicaEnLinea fn se llama desde quien
+// publica.
+func (b *Broker) consumir(patron string, capacidad int, politica PoliticaBuffer, filtro func(Mensaje) bool, fn func(Mensaje)) *Suscripcion {
+	if politica == politicaEnLinea {
+		s := b.suscribir(patron, 0, politica, filtro)
+		s.atender = fn
+		return s
+	}
+	s := b.suscribir(patron, capacidad, politica, filtro)
+	go func() {
+		for m := range s.C {
+			fn(m)
+		}
+	}()
+	return s
+}
+
+// Entrega el mensaje a las suscripciones de su tema. Con el broker cerrado
+// (o sin broker) no hace nada.
+func (b *Broker) publicar(m Mensaje) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.cerrado {
+		b.mu.Unlock()
+		return
+	}
+	b.publicados[m.Tema]++
+	var destinos []*Suscripcion
+	for _, s := range b.suscripciones {
+		if coincideTema(s.patron, m.Tema) && (s.filtro == nil || s.filtro(m)) {
+			destinos = append(destinos, s)
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range destinos {
+		s.entregar(m)
+	}
+}
+
+func (s *Suscripcion) entregar(m Mensaje) {
+	if s.politica == politicaEnLinea {
+		s.envio.Lock()
+		cerrada := s.cerrada
+		s.envio.Unlock()
+		if !cerrada && s.atender != nil {
+			s.atender(m)
+		}
+		return
+	}
+	s.envio.Lock()
+	defer s.envio.Unlock()
+	if s.cerrada {
+		return
+	}
+	if s.politica == politicaBloquear {
+		select {
+		case s.c <- m:
+		case <-s.fin:
+		}
+		return
+	}
+	select {
+	case s.c <- m:
+	default:
+		s.descartados++
+	}
+}
+
+// Deja de recibir mensajes. Lo que ya estaba en el buffer se puede leer.
+func (s *Suscripcion) cancelar() {
+	s.cancelada.Do(func() {
+		close(s.fin) // quien esté esperando para enviar lo deja
+		s.envio.Lock()
+		s.cerrada = true
+		close(s.c)
+		s.envio.Unlock()
+	})
+}
+
+// Mensajes perdidos por tener el buffer lleno
+func (s *Suscripcion) Descartados() int {
+	s.envio.Lock()
+	defer s.envio.Unlock()
+	return s.descartados
+}
+
+func (b *Broker) cancelar(s *Suscripcion) {
+	b.mu.Lock()
+	for i, otra := range b.suscripciones {
+		if otra == s {
+			b.suscripciones = append(b.suscripciones[:i], b.suscripciones[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	s.cancelar()
+}
+
+// No se publica nada más y se cancelan todas las suscripciones
+func (b *Broker) cerrar() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.cerrado = true
+	suscripciones := b.suscripciones
+	b.suscripciones = nil
+	b.mu.Unlock()
+	for _, s := range suscripciones {
+		s.cancelar()
+	}
+}
+
+// Mensajes publicados en cada tema
+func (b *Broker) Publicados() map[string]int {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cuenta := make(map[string]int, len(b.publicados))
+	for tema, n := range b.publicados {
+		cuenta[tema] = n
+	}
+	return cuenta
+}
+
+// Publica en el broker del taller con la hora del taller
+func (t *Taller) publicar(m Mensaje) {
+	if t.broker == nil {
+		return
+	}
+	if m.Fecha.IsZero() {
+		m.Fecha = t.ahora()
+	}
+	t.broker.publicar(m)
+}
+
+// Mensaje para la pantalla de la simulación
+func (t *Taller) resultado(formato string, args ...any) {
+	t.publicar(Mensaje{Tema: temaResultado, Texto: fmt.Sprintf(formato, args...)})
+}
+
+// Filtro de los mensajes que se muestran en pantalla
+func conTexto(m Mensaje) bool { return m.Texto != "" }
+
+// Mensaje de un trabajo, con el vehículo y la incidencia
+func mensajeTrabajo(tema string, m *Mecanico, tr Trabajo, texto string) Mensaje {
+	msg := Mensaje{Tema: tema, Texto: texto, Matricula: tr.Vehiculo.Matricula, IncidenciaID: tr.Incidencia.ID, Tipo: tr.Incidencia.Tipo}
+	if m != nil {
+		msg.MecanicoID = m.ID
+	}
+	return msg
+}
+
+// ------------ CONSUMIDORES ------------
+
+// Métricas de la simulación: cuántos mensajes de cada tema han llegado. Se
+// suscriben a todo con descarte, para no frenar nunca a la simulación.
+type Metricas struct {
+	mu     sync.Mutex
+	cuenta map[string]int
+	horas  map[Especialidad]float64 // horas de reparación por especialidad
+	s      *Suscripcion
+}
+
+const bufferMetricas = 256
+
+func nuevasMetricas(b *Broker) *Metricas {
+	me := &Metricas{cuenta: map[string]int{}, horas: map[Especialidad]float64{}}
+	me.s = b.consumir("*", bufferMetricas, politicaDescartar, nil, func(m Mensaje) {
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		me.cuenta[m.Tema]++
+		me.horas[m.Tipo] += m.Horas
+	})
+	return me
+}
+
+func (me *Metricas) Cuenta(tema string) int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.cuenta[tema]
+}
+
+func (me *Metricas) informe() string {
+	if me == nil {
+		return ""
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var temas []string
+	for tema := range me.cuenta {
+		temas = append(temas, tema)
+	}
+	sort.Strings(temas)
+	s := "Mensajes de la simulación por tema:\n"
+	for _, tema := range temas {
+		s += fmt.Sprintf("  %-24s %d\n", tema, me.cuenta[tema])
+	}
+	for _, esp := range []Especialidad{Mecanica, Electrica, Carroceria} {
+		if h := me.horas[esp]; h > 0 {
+			s += fmt.Sprintf("  horas de reparación de %s: %.1f\n", esp, h)
+		}
+	}
+	if n := me.s.Descartados(); n > 0 {
+		s += fmt.Sprintf("  (%d mensajes sin contar: buffer lleno)\n", n)
+	}
+	return s
+}
+
+// Los avisos a los clientes de lo que pasa en la simulación. Buscan el
+// vehículo y el cliente en el taller, así que se atienden en línea.
+func (t *Taller) suscribirAvisos(b *Broker) *Suscripcion {
+	return b.consumir(temaIncidenciaCerrada, 0, politicaEnLinea, nil, func(m Mensaje) {
+		if inc := t.getIncidencia(m.IncidenciaID); inc != nil {
+			t.avisarIncidenciaCerrada(inc)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+// broker_test.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lo que la simulación del taller muestra en pantalla, como lo recibía el
+// antiguo canal de resultados
+func resultadosDePrueba(taller *Taller) <-chan Mensaje {
+	taller.broker = nuevoBroker()
+	return taller.broker.suscribir("*", 100, politicaDescartar, conTexto).C
+}
+
+func TestBrokerTemasYFiltros(t *testing.T) {
+	b := nuevoBroker()
+	cerradas := b.suscribir(temaIncidenciaCerrada, 10, politicaDescartar, nil)
+	incidencias := b.suscribir("incidencia.*", 10, politicaDescartar, nil)
+	todo := b.suscribir("*", 10, politicaDescartar, nil)
+	deV01 := b.suscribir("*", 10, politicaDescartar, func(m Mensaje) bool { return m.Matricula == "V-01" })
+
+	b.publicar(Mensaje{Tema: temaIncidenciaCerrada, Matricula: "V-01"})
+	b.publicar(Mensaje{Tema: temaIncidenciaEmpezada, Matricula: "V-02"})
+	b.publicar(Mensaje{Tema: temaPlazaLiberada, Matricula: "V-01"})
+	b.cerrar()
+	b.publicar(Mensaje{Tema: temaIncidenciaCerrada, Matricula: "V-03"}) // ya no llega
+
+	temas := func(s *Suscripcion) string {
+		var ts []string
+		for m := range s.C {
+			ts = append(ts, m.Tema+"/"+m.Matricula)
+		}
+		return strings.Join(ts, " ")
+	}
+	casos := []struct {
+		s    *Suscripcion
+		want string
+	}{
+		{cerradas, "incidencia.cerrada/V-01"},
+		{incidencias, "incidencia.cerrada/V-01 incidencia.empezada/V-02"},
+		{todo, "incidencia.cerrada/V-01 incidencia.empezada/V-02 plaza.liberada/V-01"},
+		{deV01, "incidencia.cerrada/V-01 plaza.liberada/V-01"},
+	}
+	for _, c := range casos {
+		if got := temas(c.s); got != c.want {
+			t.Errorf("%s recibe %q, se esperaba %q", c.s.patron, got, c.want)
+		}
+	}
+	if n := b.Publicados()[temaIncidenciaCerrada]; n != 1 {
+		t.Errorf("publicados en %s: %d", temaIncidenciaCerrada, n)
+	}
+}
+
+// Con el buffer lleno la política de descarte pierde los mensajes nuevos sin
+// frenar a quien publica, y los cuenta
+func TestBrokerDescartaConElBufferLleno(t *testing.T) {
+	b := nuevoBroker()
+	s := b.suscribir("*", 2, politicaDescartar, nil)
+	for i := 0; i < 5; i++ {
+		b.publicar(Mensaje{Tema: temaResultado, IncidenciaID: i})
+	}
+	if s.Descartados() != 3 || len(s.C) != 2 {
+		t.Fatalf("descartados %d, en el buffer %d", s.Descartados(), len(s.C))
+	}
+	if m := <-s.C; m.IncidenciaID != 0 {
+		t.Errorf("se deberían conservar los primeros: %+v", m)
+	}
+}
+
+// Con la política de bloqueo quien publica espera a que haya sitio, y deja de
+// esperar si se cancela la suscripción
+func TestBrokerBloqueaConElBufferLleno(t *testing.T) {
+	b := nuevoBroker()
+	s := b.suscribir("*", 1, politicaBloquear, nil)
+	b.publicar(Mensaje{Tema: temaResultado, Texto: "uno"})
+
+	publicado := make(chan struct{})
+	go func() {
+		b.publicar(Mensaje{Tema: temaResultado, Texto: "dos"})
+		close(publicado)
+	}()
+	select {
+	case <-publicado:
+		t.Fatal("no debería poder publicar con el buffer lleno")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if m := <-s.C; m.Texto != "uno" {
+		t.Fatalf("mensaje inesperado: %+v", m)
+	}
+	<-publicado
+	if m := <-s.C; m.Texto != "dos" || s.Descartados() != 0 {
+		t.Fatalf("no debería perderse nada: %+v, %d descartados", m, s.Descartados())
+	}
+
+	b.publicar(Mensaje{Tema: temaResultado, Texto: "tres"})
+	publicado2 := make(chan struct{})
+	go func() {
+		b.publicar(Mensaje{Tema: temaResultado, Texto: "cuatro"})
+		close(publicado2)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	b.cancelar(s)
+	select {
+	case <-publicado2:
+	case <-time.After(time.Second):
+		t.Fatal("cancelar la suscripción debería soltar a quien publica")
+	}
+}
+
+// Un consumidor nuevo se entera de las incidencias cerradas sin tocar a los
+// mecánicos, y los avisos a los clientes salen de su suscripción
+func TestConsumidoresDeLasIncidenciasCerradas(t *testing.T) {
+	taller := &Taller{}
+	np := &notificadorPrueba{}
+	taller.avisos = nuevaBandejaSalida(np)
+	m := taller.newMecanico("Luis", "mecanica", 3)
+	cli, _ := taller.newCliente("Pepe", "600123123", "pepe@correo.es", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, m.ID); err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Baja", "Aceite")
+	inc.TiempoAcumulado = 1
+	p, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+	taller.responderPresupuesto(p.ID, true)
+
+	resultados := resultadosDePrueba(taller)
+	metricas := nuevasMetricas(taller.broker)
+	taller.suscribirAvisos(taller.broker)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	taller.colas.encolar(Trabajo{Vehiculo: v, Incidencia: inc})
+	go trabajoMecanico(m, taller)
+
+	select {
+	case msg := <-resultados:
+		if !strings.Contains(msg.Texto, "terminó incidencia del vehículo 1234 BCD") {
+			t.Errorf("resultado inesperado: %s", msg.Texto)
+		}
+	case <-time.After(3 * porcionReparacion):
+		t.Fatal("la incidencia no se cerró")
+	}
+	esperarQue(t, "las métricas cuentan la incidencia cerrada", func() bool {
+		return metricas.Cuenta(temaIncidenciaCerrada) == 1 && metricas.Cuenta(temaIncidenciaEmpezada) == 1
+	})
+	if !strings.Contains(metricas.informe(), "horas de reparación de mecanica") {
+		t.Errorf("las métricas deberían sumar las horas:\n%s", metricas.informe())
+	}
+
+	// El aviso se encola al cerrar la incidencia, antes del resultado
+	taller.avisos.procesar(time.Now())
+	var eventos []EventoAviso
+	for _, n := range np.enviados {
+		eventos = append(eventos, n.Evento)
+	}
+	if !strings.Contains(fmt.Sprint(eventos), string(AvisoIncidenciaCerrada)) {
+		t.Errorf("no se avisó de la incidencia cerrada: %v", eventos)
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------ CITAS ------------
+
+// Las citas se dan en franjas de una hora dentro del horario del taller
+// (de lunes a sábado). Una cita ocupa su plaza desde el inicio de la franja
+// durante la suma de los tiempos estimados de sus incidencias.
+const (
+	horaAperturaCitas = 8
+	horaCierreCitas   = 18
+	duracionFranja    = time.Hour
+)
+
+type EstadoCita int
+
+const (
+	CitaPendiente EstadoCita = iota
+	CitaAdmitida
+	CitaCancelada
+)
+
+func (e EstadoCita) String() string {
+	switch e {
+	case CitaPendiente:
+		return "Pendiente"
+	case CitaAdmitida:
+		return "Admitida"
+	case CitaCancelada:
+		return "Cancelada"
+	}
+	return "Desconocido"
+}
+
+type Cita struct {
+	ID        int
+	ClienteID int
+	Matricula string
+	Marca     string
+	Modelo    string
+	Inicio    time.Time
+	Tipos     []Especialidad // incidencias previstas
+	PlazaID   int            // plaza reservada
+	Estado    EstadoCita
+}
+
+// Momento en que se prevé que el vehículo deje libre la plaza
+func (c *Cita) Fin() time.Time {
+	return c.Inicio.Add(duracionCita(c.Tipos))
+}
+
+func duracionCita(tipos []Especialidad) time.Duration {
+	horas := 0
+	for _, esp := range tipos {
+		horas += tiempoEstimado(esp)
+	}
+	return time.Duration(max(horas, 1)) * duracionFranja
+}
+
+// No queda plaza o mecánico para la franja pedida
+var errSinDisponibilidad = errors.New("sin disponibilidad")
+
+// La franja debe empezar en punto, en horario del taller y en el futuro
+func validarFranja(inicio, ahora time.Time) error {
+	valor := formatearFecha(inicio)
+	switch {
+	case inicio.IsZero():
+		return errorValidacion("franja", "", "es obligatoria")
+	case !inicio.After(ahora):
+		return errorValidacion("franja", valor, "debe ser posterior al momento actual")
+	case inicio.Weekday() == time.Sunday:
+		return errorValidacion("franja", valor, "el taller no abre los domingos")
+	case inicio.Minute() != 0 || inicio.Second() != 0 || inicio.Nanosecond() != 0:
+		return errorValidacion("franja", valor, "las citas empiezan a en punto")
+	case inicio.Hour() < horaAperturaCitas || inicio.Hour() >= horaCierreCitas:
+		return errorValidacion("franja", valor,
+			fmt.Sprintf("el horario de citas es de %d:00 a %d:00", horaAperturaCitas, horaCierreCitas))
+	}
+	return nil
+}
+
+func validarTiposCita(tipos []Especialidad) error {
+	if len(tipos) == 0 {
+		return errorValidacion("incidencias previstas", "", "indique al menos una")
+	}
+	for _, esp := range tipos {
+		if esp != Mecanica && esp != Electrica && esp != Carroceria {
+			return errorValidacion("incidencias previstas", string(esp),
+				"debe ser 'mecanica', 'electrica' o 'carroceria'")
+		}
+	}
+	return nil
+}
+
+// Citas que ocupan plaza entre inicio y fin (sin contar la cita excluir)
+func (t *Taller) citasSolapadas(inicio, fin time.Time, excluir int) []*Cita {
+	var solapadas []*Cita
+	for _, c := range t.datos().Citas() {
+		if c.ID == excluir || c.Estado == CitaCancelada {
+			continue
+		}
+		if c.Inicio.Before(fin) && inicio.Before(c.Fin()) {
+			solapadas = append(solapadas, c)
+		}
+	}
+	return solapadas
+}
+
+// Comprueba que en cada franja de [inicio, inicio+duración) quedan plaza y
+// mecánicos de cada especialidad pedida, y devuelve la plaza a reservar.
+// Se supone que cada cita necesita un mecánico de cada uno de sus tipos
+// durante toda su estancia (una previsión prudente).
+func (t *Taller) comprobarDisponibilidad(inicio time.Time, tipos []Especialidad, excluir int) (int, error) {
+	plazas := t.datos().Plazas()
+	if len(plazas) == 0 {
+		return 0, fmt.Errorf("el taller no tiene plazas: %w", errSinDisponibilidad)
+	}
+	mecanicos := t.datos().Mecanicos()
+	necesita := map[Especialidad]bool{}
+	for _, esp := range tipos {
+		necesita[esp] = true
+	}
+
+	fin := inicio.Add(duracionCita(tipos))
+	solapadas := t.citasSolapadas(inicio, fin, excluir)
+	for f := inicio; f.Before(fin); f = f.Add(duracionFranja) {
+		ocupadas := 0
+		demanda := map[Especialidad]int{}
+		for _, c := range solapadas {
+			if c.Inicio.After(f) || !f.Before(c.Fin()) {
+				continue
+			}
+			ocupadas++
+			vistos := map[Especialidad]bool{}
+			for _, esp := range c.Tipos {
+				if !vistos[esp] {
+					vistos[esp] = true
+					demanda[esp]++
+				}
+			}
+		}
+		if ocupadas >= len(plazas) {
+			return 0, fmt.Errorf("todas las plazas están reservadas el %s: %w", formatearFecha(f), errSinDisponibilidad)
+		}
+		// Sólo cuentan los mecánicos a los que les toca trabajar a esa hora
+		capacidad := map[Especialidad]int{}
+		for _, m := range mecanicos {
+			if m.Activo && m.enTurno(f) {
+				capacidad[m.Especialidad]++
+			}
+		}
+		for _, esp := range []Especialidad{Mecanica, Electrica, Carroceria} {
+			// Al empezar tiene que haber alguien libre; después, si nadie está
+			// en turno el trabajo simplemente se para hasta que vuelvan
+			if !necesita[esp] || (!f.Equal(inicio) && capacidad[esp] == 0) {
+				continue
+			}
+			if demanda[esp] >= capacidad[esp] {
+				return 0, fmt.Errorf("no quedan mecánicos de %s el %s: %w", esp, formatearFecha(f), errSinDisponibilidad)
+			}
+		}
+	}
+
+	// Primera plaza que no tenga reservada ninguna cita solapada
+	reservadas := map[int]bool{}
+	for _, c := range solapadas {
+		reservadas[c.PlazaID] = true
+	}
+	for _, p := range plazas {
+		if !reservadas[p.ID] {
+			return p.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("no queda ninguna plaza libre toda la estancia: %w", errSinDisponibilidad)
+}
+
+// Reserva una franja para el vehículo de un cliente
+func (t *Taller) reservarCita(clienteID int, mat, marca, modelo string, inicio time.Time, tipos []Especialidad, ahora time.Time) (*Cita, error) {
+	if t.getCliente(clienteID) == nil {
+		return nil, fmt.Errorf("cliente con ID %d no encontrado", clienteID)
+	}
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return nil, err
+	}
+	if propietario := t.clienteDeVehiculo(mat); propietario != nil && propietario.ID != clienteID {
+		return nil, errorValidacion("matrícula", mat, "el vehículo es de otro cliente")
+	}
+	if err := validarTiposCita(tipos); err != nil {
+		return nil, err
+	}
+	if err := validarFranja(inicio, ahora); err != nil {
+		return nil, err
+	}
+	// Una cita pendiente que ya pasó sin que el vehículo viniera no cuenta
+	for _, c := range t.datos().Citas() {
+		if c.Matricula == mat && c.Estado == CitaPendiente && c.Inicio.After(ahora) {
+			return nil, fmt.Errorf("el vehículo %s ya tiene la cita %d", mat, c.ID)
+		}
+	}
+	plazaID, err := t.comprobarDisponibilidad(inicio, tipos, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cita{
+		ID:        t.nextCitaID,
+		ClienteID: clienteID,
+		Matricula: mat,
+		Marca:     marca,
+		Modelo:    modelo,
+		Inicio:    inicio,
+		Tipos:     append([]Especialidad(nil), tipos...),
+		PlazaID:   plazaID,
+		Estado:    CitaPendiente,
+	}
+	t.nextCitaID++
+	if err := t.datos().GuardarCita(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *Taller) citaPendiente(id int) (*Cita, error) {
+	c := t.datos().Cita(id)
+	if c == nil {
+		return nil, fmt.Errorf("cita con ID %d no encontrada", id)
+	}
+	if c.Estado != CitaPendiente {
+		return nil, fmt.Errorf("la cita %d está %s", id, strings.ToLower(c.Estado.String()))
+	}
+	return c, nil
+}
+
+// Cambia una cita pendiente a otra franja (puede cambiar también de plaza)
+func (t *Taller) moverCita(id int, inicio time.Time, ahora time.Time) error {
+	c, err := t.citaPendiente(id)
+	if err != nil {
+		return err
+	}
+	if err := validarFranja(inicio, ahora); err != nil {
+		return err
+	}
+	plazaID, err := t.comprobarDisponibilidad(inicio, c.Tipos, c.ID)
+	if err != nil {
+		return err
+	}
+	c.Inicio = inicio
+	c.PlazaID = plazaID
+	return t.datos().GuardarCita(c)
+}
+
+func (t *Taller) cancelarCita(id int) error {
+	c, err := t.citaPendiente(id)
+	if err != nil {
+		return err
+	}
+	c.Estado = CitaCancelada
+	return t.datos().GuardarCita(c)
+}
+
+// Citas ordenadas por fecha
+func (t *Taller) citasOrdenadas() []*Cita {
+	citas := append([]*Cita(nil), t.datos().Citas()...)
+	sort.Slice(citas, func(i, j int) bool { return citas[i].Inicio.Before(citas[j].Inicio) })
+	return citas
+}
+
+func mismoDia(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Da entrada a los vehículos con cita pendiente para el día de ahora: crea
+// el vehículo y sus incidencias y lo coloca en la plaza reservada (o en otra
+// libre si la reservada sigue ocupada).
+func (t *Taller) admitirCitasDelDia(ahora time.Time) ([]*Cita, []error) {
+	var admitidas []*Cita
+	var errs []error
+	for _, c := range t.citasOrdenadas() {
+		if c.Estado != CitaPendiente || !mismoDia(c.Inicio, ahora) {
+			continue
+		}
+		if err := t.admitirCita(c, ahora); err != nil {
+			errs = append(errs, fmt.Errorf("cita %d (%s): %w", c.ID, c.Matricula, err))
+			continue
+		}
+		admitidas = append(admitidas, c)
+	}
+	return admitidas, errs
+}
+
+// Durante la simulación, en cada hora del reloj del taller se admiten las
+// citas del día que aún no han entrado y sus incidencias pasan a las colas
+func (t *Taller) admisionCitas(parar <-chan struct{}) {
+	var hora time.Duration
+	t.conTaller(func() { hora = t.reloj.Real(time.Hour) })
+	tick := time.NewTicker(hora)
+	defer tick.Stop()
+	var ultima time.Time
+	for {
+		select {
+		case <-parar:
+			return
+		case <-tick.C:
+			t.conTaller(func() { ultima = t.admitirCitasSimuladas(t.ahora(), ultima) })
+		}
+	}
+}
+
+// Una vuelta de admisionCitas a la hora ahora; ultima es la de la anterior.
+// Las citas que no caben se vuelven a intentar en cada vuelta, pero sólo se
+// avisa de ellas en la primera del día. Se llama con el taller cogido.
+func (t *Taller) admitirCitasSimuladas(ahora, ultima time.Time) time.Time {
+	admitidas, errs := t.admitirCitasDelDia(ahora)
+	for _, c := range admitidas {
+		t.avisar("Vehículo %s con cita admitido en la plaza %d", c.Matricula, c.PlazaID)
+		v := t.getVehiculo(c.Matricula)
+		if v == nil {
+			continue
+		}
+		var abiertas []*Incidencia
+		for _, inc := range v.Incidencias {
+			if inc.Estado == 0 {
+				abiertas = append(abiertas, inc)
+			}
+		}
+		t.updateTiempoTotalVehiculo(v)
+		t.aReparar(v, abiertas, t.origenCitas())
+	}
+	if !mismoDia(ultima, ahora) {
+		for _, err := range errs {
+			t.avisar("No se pudo admitir: %v", err)
+		}
+	}
+	return ahora
+}
+
+func (t *Taller) admitirCita(c *Cita, ahora time.Time) error {
+	cliente := t.getCliente(c.ClienteID)
+	if cliente == nil {
+		return fmt.Errorf("cliente con ID %d no encontrado", c.ClienteID)
+	}
+	// El vehículo puede haberse asignado a otro cliente después de reservar
+	if propietario := t.clienteDeVehiculo(c.Matricula); propietario != nil && propietario != cliente {
+		return fmt.Errorf("el vehículo es del cliente %s", propietario.Nombre)
+	}
+
+	// La plaza se busca y se ocupa con el turno de las plazas
+	var plaza *Plaza
+	var v *Vehiculo
+	err := t.conPlazas(func() error {
+		if p := t.plazaDeVehiculo(c.Matricula); p != nil {
+			return fmt.Errorf("el vehículo ya está en la plaza %d", p.ID)
+		}
+
+		plaza = t.datos().Plaza(c.PlazaID)
+		if plaza == nil || plaza.Ocupada {
+			plaza = nil
+			for _, p := range t.datos().Plazas() {
+				if !p.Ocupada {
+					plaza = p
+					break
+				}
+			}
+		}
+		if plaza == nil {
+			return fmt.Errorf("no hay plazas libres: %w", errSinDisponibilidad)
+		}
+
+		return t.datos().Transaccion(func() error {
+			v = t.getVehiculo(c.Matricula)
+			if v == nil {
+				var err error
+				if v, err = t.newVehiculo(c.Matricula, c.Marca, c.Modelo, ahora, time.Time{}, nil); err != nil {
+					return err
+				}
+			}
+			for _, esp := range c.Tipos {
+				if _, err := t.newIncidencia(v.Matricula, nil, string(esp), "Media",
+					fmt.Sprintf("Cita %d: %s", c.ID, esp)); err != nil {
+					return err
+				}
+			}
+
+			tiene := false
+			for _, veh := range cliente.Vehiculos {
+				tiene = tiene || veh == v
+			}
+			if !tiene {
+				cliente.Vehiculos = append(cliente.Vehiculos, v)
+				if err := t.datos().GuardarCliente(cliente); err != nil {
+					return err
+				}
+			}
+			if _, err := t.abrirVisita(v, plaza.ID, ahora); err != nil {
+				return err
+			}
+
+			plaza.Ocupada = true
+			plaza.VehiculoMat = v.Matricula
+			if err := t.datos().GuardarPlaza(plaza); err != nil {
+				return err
+			}
+			c.PlazaID = plaza.ID
+			c.Estado = CitaAdmitida
+			return t.datos().GuardarCita(c)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	t.notificar(AvisoAdmision, v, datosAviso{Plaza: plaza.ID}, "")
+	return nil
+}
+
+func printCita(c *Cita) {
+	var tipos []string
+	for _, esp := range c.Tipos {
+		tipos = append(tipos, string(esp))
+	}
+	fmt.Printf("ID: %d\n", c.ID)
+	fmt.Printf("Cliente: %d\n", c.ClienteID)
+	fmt.Printf("Vehículo: %s (%s %s)\n", c.Matricula, c.Marca, c.Modelo)
+	fmt.Printf("Franja: %s (plaza ocupada hasta %s)\n", formatearFecha(c.Inicio), formatearFecha(c.Fin()))
+	fmt.Printf("Incidencias previstas: %s\n", strings.Join(tipos, ", "))
+	fmt.Printf("Plaza reservada: %d\n", c.PlazaID)
+	fmt.Printf("Estado: %s\n", c.Estado)
+}
+
+// Pide una o varias incidencias previstas
+func leerTiposCita(in *Entrada) ([]Especialidad, error) {
+	var tipos []Especialidad
+	for {
+		esp, err := in.Especialidad("Incidencia prevista", "")
+		if err != nil {
+			return nil, err
+		}
+		tipos = append(tipos, esp)
+		otra, err := in.SiNo("¿Otra incidencia?", false)
+		if err != nil || !otra {
+			return tipos, nil
+		}
+	}
+}
+
+func menuCitas(t *Taller, in *Entrada) {
+	for {
+		fmt.Println("\n--- CITAS ---")
+		fmt.Println("1. Reservar cita")
+		fmt.Println("2. Listar citas")
+		fmt.Println("3. Mover cita")
+		fmt.Println("4. Cancelar cita")
+		fmt.Println("5. Admitir las citas de hoy")
+		fmt.Println("0. Volver al menú principal")
+
+		op, err := in.Opcion("Seleccione", 0, 5)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case 1:
+			clienteID, err := in.Entero("ID de cliente")
+			if err != nil {
+				return
+			}
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				break
+			}
+			marca, err := in.Texto("Marca")
+			if err != nil {
+				return
+			}
+			modelo, err := in.Texto("Modelo")
+			if err != nil {
+				return
+			}
+			inicio, err := in.Fecha("Franja", time.Time{})
+			if err != nil {
+				return
+			}
+			tipos, err := leerTiposCita(in)
+			if err != nil {
+				break
+			}
+			c, err := t.reservarCita(clienteID, mat, marca, modelo, inicio, tipos, time.Now())
+			if err != nil {
+				fmt.Println("No se pudo reservar:", err)
+			} else {
+				fmt.Printf("Cita %d reservada el %s en la plaza %d.\n", c.ID, formatearFecha(c.Inicio), c.PlazaID)
+			}
+		case 2:
+			citas := t.citasOrdenadas()
+			if len(citas) == 0 {
+				fmt.Println("No hay citas registradas.")
+				break
+			}
+			for _, c := range citas {
+				printCita(c)
+				fmt.Println("-----------------------------")
+			}
+		case 3:
+			id, err := in.Entero("ID de cita")
+			if err != nil {
+				return
+			}
+			c := t.datos().Cita(id)
+			if c == nil {
+				fmt.Printf("Cita con ID %d no encontrada.\n", id)
+				break
+			}
+			inicio, err := in.Fecha("Nueva franja", c.Inicio)
+			if err != nil {
+				return
+			}
+			if err := t.moverCita(id, inicio, time.Now()); err != nil {
+				fmt.Println("No se pudo mover:", err)
+			} else {
+				fmt.Printf("Cita %d movida al %s (plaza %d).\n", c.ID, formatearFecha(c.Inicio), c.PlazaID)
+			}
+		case 4:
+			id, err := in.Entero("ID de cita")
+			if err != nil {
+				return
+			}
+			if err := t.cancelarCita(id); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Cita cancelada.")
+			}
+		case 5:
+			admitidas, errs := t.admitirCitasDelDia(time.Now())
+			for _, c := range admitidas {
+				fmt.Printf("Vehículo %s admitido en la plaza %d (cita %d).\n", c.Matricula, c.PlazaID, c.ID)
+			}
+			for _, err := range errs {
+				fmt.Println("No se pudo admitir:", err)
+			}
+			if len(admitidas) == 0 && len(errs) == 0 {
+				fmt.Println("No hay citas pendientes para hoy.")
+			}
+		case 0:
+			return
+		}
+	}
+}
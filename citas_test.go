@@ -0,0 +1,251 @@
+// citas_test.go
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Lunes 19 de octubre de 2026 a las 9:00
+var ahoraCitas = time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+
+func franja(dia, hora int) time.Time {
+	return time.Date(2026, 10, dia, hora, 0, 0, 0, time.Local)
+}
+
+func tallerConCitas(t *testing.T) (*Taller, *Cliente) {
+	t.Helper()
+	taller := &Taller{}
+	taller.newMecanico("Luis", "mecanica", 5)
+	taller.newMecanico("Ana", "electrica", 4)
+	c, err := taller.newCliente("Pepe", "", "", nil)
+	if err != nil {
+		t.Fatalf("error creando cliente: %v", err)
+	}
+	return taller, c
+}
+
+func TestValidarFranja(t *testing.T) {
+	casos := map[string]time.Time{
+		"pasada":         franja(18, 10),
+		"domingo":        franja(25, 10),
+		"no en punto":    time.Date(2026, 10, 20, 10, 30, 0, 0, time.Local),
+		"antes de abrir": franja(20, 7),
+		"al cerrar":      franja(20, horaCierreCitas),
+		"vacía":          {},
+	}
+	for nombre, inicio := range casos {
+		var ev *ErrorValidacion
+		if err := validarFranja(inicio, ahoraCitas); !errors.As(err, &ev) {
+			t.Errorf("%s: se esperaba un error de validación, se obtuvo %v", nombre, err)
+		}
+	}
+	if err := validarFranja(franja(20, 8), ahoraCitas); err != nil {
+		t.Errorf("franja válida rechazada: %v", err)
+	}
+}
+
+func TestCitasSinSobrerreserva(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+
+	c1, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatalf("primera reserva rechazada: %v", err)
+	}
+	// Sólo hay un mecánico de mecánica y la primera cita lo ocupa 5 horas
+	_, err = taller.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(20, 12), []Especialidad{Mecanica}, ahoraCitas)
+	if !errors.Is(err, errSinDisponibilidad) {
+		t.Fatalf("se esperaba falta de mecánicos, se obtuvo %v", err)
+	}
+	// Una eléctrica a la misma hora sí cabe, en otra plaza
+	c2, err := taller.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(20, 10), []Especialidad{Electrica}, ahoraCitas)
+	if err != nil {
+		t.Fatalf("reserva eléctrica rechazada: %v", err)
+	}
+	if c2.PlazaID == c1.PlazaID {
+		t.Errorf("dos citas solapadas comparten la plaza %d", c1.PlazaID)
+	}
+	// Y otra de mecánica en cuanto termina la primera
+	if _, err := taller.reservarCita(cli.ID, "M-3333", "Fiat", "500", c1.Fin(), []Especialidad{Mecanica}, ahoraCitas); err != nil {
+		t.Errorf("reserva tras la primera rechazada: %v", err)
+	}
+}
+
+func TestCitasSinPlazas(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	// Dejar una sola plaza
+	var sobran []int
+	for _, p := range taller.datos().Plazas()[1:] {
+		sobran = append(sobran, p.ID)
+	}
+	for _, id := range sobran {
+		taller.datos().BorrarPlaza(id)
+	}
+	if _, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas); err != nil {
+		t.Fatalf("primera reserva rechazada: %v", err)
+	}
+	_, err := taller.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(20, 11), []Especialidad{Electrica}, ahoraCitas)
+	if !errors.Is(err, errSinDisponibilidad) {
+		t.Fatalf("se esperaba falta de plazas, se obtuvo %v", err)
+	}
+}
+
+func TestMoverYCancelarCita(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	c1, _ := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas)
+	c2, err := taller.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(21, 10), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatalf("reserva rechazada: %v", err)
+	}
+
+	if err := taller.moverCita(c2.ID, franja(20, 11), ahoraCitas); !errors.Is(err, errSinDisponibilidad) {
+		t.Fatalf("no debería poder moverse encima de otra cita: %v", err)
+	}
+	if !c2.Inicio.Equal(franja(21, 10)) {
+		t.Errorf("un movimiento fallido no debe cambiar la cita: %s", formatearFecha(c2.Inicio))
+	}
+	// Moverla sobre sí misma (solapándose con su franja anterior) sí vale
+	if err := taller.moverCita(c2.ID, franja(21, 11), ahoraCitas); err != nil {
+		t.Fatalf("error moviendo la cita: %v", err)
+	}
+
+	if err := taller.cancelarCita(c1.ID); err != nil {
+		t.Fatalf("error cancelando: %v", err)
+	}
+	if err := taller.cancelarCita(c1.ID); err == nil {
+		t.Error("una cita cancelada no se puede volver a cancelar")
+	}
+	if err := taller.moverCita(c2.ID, franja(20, 10), ahoraCitas); err != nil {
+		t.Errorf("la franja cancelada debería quedar libre: %v", err)
+	}
+}
+
+func TestAdmitirCitasDelDia(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	manana, _ := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10),
+		[]Especialidad{Mecanica, Electrica}, ahoraCitas)
+	otroDia, _ := taller.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(22, 10),
+		[]Especialidad{Mecanica}, ahoraCitas)
+
+	admitidas, errs := taller.admitirCitasDelDia(franja(20, 8))
+	if len(errs) != 0 || len(admitidas) != 1 || admitidas[0] != manana {
+		t.Fatalf("se esperaba admitir sólo la cita del día: %v, errores %v", admitidas, errs)
+	}
+	if manana.Estado != CitaAdmitida || otroDia.Estado != CitaPendiente {
+		t.Errorf("estados incorrectos: %s y %s", manana.Estado, otroDia.Estado)
+	}
+	v := taller.getVehiculo("M-1111")
+	if v == nil || len(v.Incidencias) != 2 {
+		t.Fatalf("vehículo mal creado: %+v", v)
+	}
+	if p := taller.plazaDeVehiculo(v.Matricula); p == nil || p.ID != manana.PlazaID {
+		t.Errorf("el vehículo debería estar en la plaza reservada %d", manana.PlazaID)
+	}
+	if len(cli.Vehiculos) != 1 || cli.Vehiculos[0] != v {
+		t.Errorf("el vehículo no se ha asignado al cliente")
+	}
+
+	// Volver a admitir el mismo día no hace nada
+	if admitidas, _ := taller.admitirCitasDelDia(franja(20, 9)); len(admitidas) != 0 {
+		t.Errorf("no debería volver a admitir: %v", admitidas)
+	}
+}
+
+// Un cliente no puede reservar ni meter en el taller el vehículo de otro
+func TestCitasConVehiculoDeOtroCliente(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	otro, _ := taller.newCliente("Eva", "", "", nil)
+	c, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatalf("reserva rechazada: %v", err)
+	}
+
+	// Antes de que venga, el vehículo se asigna a otro cliente
+	v, _ := taller.newVehiculo("M-1111", "Seat", "Ibiza", ahoraCitas, time.Time{}, nil)
+	otro.Vehiculos = append(otro.Vehiculos, v)
+	if admitidas, errs := taller.admitirCitasDelDia(franja(20, 8)); len(admitidas) != 0 || len(errs) != 1 {
+		t.Fatalf("no debería admitirse el vehículo de otro cliente: %v, errores %v", admitidas, errs)
+	}
+	if c.Estado != CitaPendiente || len(cli.Vehiculos) != 0 {
+		t.Errorf("la cita no debería cambiar: %s, vehículos del cliente %d", c.Estado, len(cli.Vehiculos))
+	}
+
+	var ev *ErrorValidacion
+	_, err = taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(21, 10), []Especialidad{Mecanica}, ahoraCitas)
+	if !errors.As(err, &ev) || ev.Campo != "matrícula" {
+		t.Errorf("se esperaba un error de validación de la matrícula, se obtuvo %v", err)
+	}
+}
+
+// Una cita pendiente que ya pasó no impide reservar otra
+func TestCitaPasadaNoImpideReservar(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	if _, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas); err != nil {
+		t.Fatalf("reserva rechazada: %v", err)
+	}
+	if _, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(21, 10), []Especialidad{Mecanica}, ahoraCitas); err == nil {
+		t.Fatal("no debería admitir dos citas pendientes del mismo vehículo")
+	}
+	if _, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(22, 10), []Especialidad{Mecanica}, franja(21, 9)); err != nil {
+		t.Errorf("la cita que ya pasó no debería contar: %v", err)
+	}
+}
+
+func TestCitasSePersisten(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo abrir el almacén: %v", err)
+	}
+	taller.newMecanico("Luis", "mecanica", 5)
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	c, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatalf("reserva rechazada: %v", err)
+	}
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo reabrir el almacén: %v", err)
+	}
+	c2 := recargado.datos().Cita(c.ID)
+	if c2 == nil || c2.Matricula != "M-1111" || !c2.Inicio.Equal(c.Inicio) || len(c2.Tipos) != 1 {
+		t.Fatalf("cita no recuperada: %+v", c2)
+	}
+	if c3, err := recargado.reservarCita(cli.ID, "M-2222", "Seat", "León", franja(21, 10), []Especialidad{Mecanica}, ahoraCitas); err != nil || c3.ID == c.ID {
+		t.Errorf("el contador de citas no se ha recuperado: %v, %v", c3, err)
+	}
+}
+
+// Con la simulación en marcha las citas del día entran con el reloj del
+// taller, sin pasar por el menú, y sus incidencias van a las colas
+func TestCitasSeAdmitenConElRelojSimulado(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	c, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 10),
+		[]Especialidad{Mecanica, Electrica}, ahoraCitas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taller.reloj = nuevoRelojSimulado(franja(19, 23))
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	taller.entregas = nuevaTablaEntregas()
+
+	// A las 23:00 del día anterior no entra
+	taller.conTaller(func() { taller.admitirCitasSimuladas(taller.ahora(), time.Time{}) })
+	if c.Estado != CitaPendiente {
+		t.Fatalf("la cita no debería admitirse el día anterior")
+	}
+
+	parar := make(chan struct{})
+	defer close(parar)
+	go taller.admisionCitas(parar)
+	esperarQue(t, "que se admita la cita al pasar la medianoche", func() (admitida bool) {
+		taller.conTaller(func() { admitida = c.Estado == CitaAdmitida })
+		return admitida
+	})
+	if n := taller.colas.Total(); n != 2 {
+		t.Errorf("las dos incidencias deberían estar en cola, hay %d", n)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ------------ COLAS DE TRABAJOS POR ESPECIALIDAD ------------
+
+// Espera por defecto antes de que un mecánico de otra especialidad pueda
+// llevarse un trabajo que no es prioritario
+const esperaRoboPorDefecto = 10 * time.Second
+
+// Las reparaciones avanzan en porciones de un segundo (una hora simulada);
+// entre porción y porción se puede interrumpir al mecánico
+const porcionReparacion = time.Second
+
+// Trabajo esperando en una cola y desde cuándo
+type trabajoEnCola struct {
+	tr       Trabajo
+	encolado time.Time
+}
+
+// Reparación en curso que se puede interrumpir para atender un prioritario
+type reparacion struct {
+	m            *Mecanico
+	tr           Trabajo
+	inicio       time.Time
+	interrumpir  chan struct{} // se cierra para pedir al mecánico que pare
+	interrumpida bool
+}
+
+// ColasTrabajo guarda una cola por especialidad. Cada mecánico consume la
+// suya; si está vacía puede llevarse un trabajo de otra cola sólo cuando el
+// vehículo es prioritario o cuando el trabajo lleva esperando al menos
+// UmbralRobo. Encolar nunca bloquea: las colas no tienen límite.
+//
+// Si llega un vehículo prioritario y no hay ningún mecánico libre, se
+// interrumpe la reparación no prioritaria de un mecánico de su especialidad;
+// el trabajo interrumpido vuelve al principio de su cola con el tiempo que le
+// queda.
+type ColasTrabajo struct {
+	mu         sync.Mutex
+	colas      map[Especialidad][]trabajoEnCola
+	cambio     chan struct{} // se cierra (y se sustituye) cada vez que llega un trabajo
+	cerrada    bool
+	fin        chan struct{} // se cierra al cerrar las colas
+	UmbralRobo time.Duration
+	libres     int                  // mecánicos esperando trabajo en tomar
+	enCurso    map[int]*reparacion  // por ID de mecánico
+	aparcados  map[string][]Trabajo // esperan a que se acepte el presupuesto, por matrícula
+
+	robados        int           // trabajos atendidos por otra especialidad
+	expropiaciones int           // reparaciones interrumpidas por un prioritario
+	tiempoPerdido  time.Duration // porciones empezadas y no completadas al interrumpir
+}
+
+func nuevasColasTrabajo(umbralRobo time.Duration) *ColasTrabajo {
+	return &ColasTrabajo{
+		colas:      map[Especialidad][]trabajoEnCola{},
+		cambio:     make(chan struct{}),
+		fin:        make(chan struct{}),
+		UmbralRobo: umbralRobo,
+		enCurso:    map[int]*reparacion{},
+		aparcados:  map[string][]Trabajo{},
+	}
+}
+
+// Añade un trabajo al final de la cola de su especialidad
+func (c *ColasTrabajo) encolar(tr Trabajo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cerrada {
+		return
+	}
+	esp := tr.Incidencia.Tipo
+	c.colas[esp] = append(c.colas[esp], trabajoEnCola{tr: tr, encolado: time.Now()})
+	c.avisarCambio()
+
+	if tr.Vehiculo.Prioritario && c.libres == 0 {
+		c.expropiarPara(tr)
+	}
+}
+
+// Aparta un trabajo cuyo vehículo no tiene el presupuesto aceptado hasta que
+// se acepte (ver reanudar)
+func (c *ColasTrabajo) aparcar(tr Trabajo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cerrada {
+		return
+	}
+	mat := tr.Vehiculo.Matricula
+	c.aparcados[mat] = append(c.aparcados[mat], tr)
+}
+
+// Se ha aceptado el presupuesto del vehículo: sus trabajos apartados vuelven
+// a las colas. Devuelve cuántos había.
+func (c *ColasTrabajo) reanudar(mat string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	aparcados := c.aparcados[mat]
+	delete(c.aparcados, mat)
+	c.mu.Unlock()
+	for _, tr := range aparcados {
+		c.encolar(tr)
+	}
+	return len(aparcados)
+}
+
+// Devuelve un trabajo interrumpido al principio de la cola de su especialidad
+func (c *ColasTrabajo) devolverInterrumpido(tr Trabajo, perdido time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tiempoPerdido += perdido
+	if c.cerrada {
+		return
+	}
+	tr.porEspera = false
+	esp := tr.Incidencia.Tipo
+	c.colas[esp] = append([]trabajoEnCola{{tr: tr, encolado: time.Now()}}, c.colas[esp]...)
+	c.avisarCambio()
+}
+
+// Despierta a los mecánicos que esperan en tomar (con c.mu tomado)
+func (c *ColasTrabajo) avisarCambio() {
+	close(c.cambio)
+	c.cambio = make(chan struct{})
+}
+
+// Interrumpe la reparación no prioritaria más reciente de la especialidad de
+// tr (con c.mu tomado). Sólo se interrumpe a un mecánico por cada llegada.
+func (c *ColasTrabajo) expropiarPara(tr Trabajo) {
+	var victima *reparacion
+	for _, r := range c.enCurso {
+		if r.interrumpida || r.m.Especialidad != tr.Incidencia.Tipo || r.tr.Vehiculo.Prioritario {
+			continue
+		}
+		if victima == nil || r.inicio.After(victima.inicio) {
+			victima = r
+		}
+	}
+	if victima == nil {
+		return
+	}
+	victima.interrumpida = true
+	close(victima.interrumpir)
+	c.expropiaciones++
+}
+
+// El mecánico m empieza a reparar tr; el canal devuelto se cierra si hay que
+// interrumpir la reparación para atender a un prioritario
+func (c *ColasTrabajo) empezarReparacion(m *Mecanico, tr Trabajo) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &reparacion{m: m, tr: tr, inicio: time.Now(), interrumpir: make(chan struct{})}
+	c.enCurso[m.ID] = r
+	return r.interrumpir
+}
+
+// El mecánico m deja de reparar (ha terminado o lo han interrumpido)
+func (c *ColasTrabajo) terminarReparacion(m *Mecanico) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.enCurso, m.ID)
+}
+
+// Saca el siguiente trabajo para el mecánico m, esperando si no hay ninguno
+// que pueda hacer. Devuelve false si las colas se cierran o si se cierra
+// despido antes de conseguir trabajo.
+func (c *ColasTrabajo) tomar(m *Mecanico, despido <-chan struct{}) (Trabajo, bool) {
+	for {
+		c.mu.Lock()
+		if c.cerrada {
+			c.mu.Unlock()
+			return Trabajo{}, false
+		}
+		tr, ok, proximo := c.elegir(m.Especialidad, time.Now())
+		if ok {
+			c.mu.Unlock()
+			return tr, true
+		}
+
+		// Volver a mirar cuando llegue algo o cuando un trabajo de otra
+		// especialidad cumpla el tiempo de espera. Cuenta como libre desde
+		// que no encuentra nada, para que un prioritario que llegue entre
+		// medias no interrumpa a nadie.
+		cambio := c.cambio
+		c.libres++
+		c.mu.Unlock()
+		var plazo <-chan time.Time
+		var temporizador *time.Timer
+		if proximo > 0 {
+			temporizador = time.NewTimer(proximo)
+			plazo = temporizador.C
+		}
+		despedido := false
+		select {
+		case <-cambio:
+		case <-plazo:
+		case <-despido:
+			despedido = true
+		}
+		if temporizador != nil {
+			temporizador.Stop()
+		}
+		c.mu.Lock()
+		c.libres--
+		c.mu.Unlock()
+		if despedido {
+			return Trabajo{}, false
+		}
+	}
+}
+
+// Elige trabajo para un mecánico de la especialidad esp (con c.mu tomado).
+// Si no hay ninguno devuelve cuánto falta para que uno de otra cola se pueda
+// robar (0 si no hay nada que esperar).
+func (c *ColasTrabajo) elegir(esp Especialidad, ahora time.Time) (Trabajo, bool, time.Duration) {
+	// De su propia cola, primero los prioritarios y si no el primero
+	if cola := c.colas[esp]; len(cola) > 0 {
+		i := 0
+		for j, e := range cola {
+			if e.tr.Vehiculo.Prioritario {
+				i = j
+				break
+			}
+		}
+		c.colas[esp] = append(cola[:i:i], cola[i+1:]...)
+		return cola[i].tr, true, 0
+	}
+
+	// Robar de otra cola: primero los prioritarios, después el que más espera
+	var robar Especialidad
+	indice := -1
+	var proximo time.Duration
+	for _, otra := range []Especialidad{Mecanica, Electrica, Carroceria} {
+		if otra == esp {
+			continue
+		}
+		for i, e := range c.colas[otra] {
+			if e.tr.Vehiculo.Prioritario { // la regla de verificarAsignacionMecanico
+				c.colas[otra] = append(c.colas[otra][:i:i], c.colas[otra][i+1:]...)
+				c.robados++
+				return e.tr, true, 0
+			}
+			espera := ahora.Sub(e.encolado)
+			if espera >= c.UmbralRobo {
+				if indice < 0 || e.encolado.Before(c.colas[robar][indice].encolado) {
+					robar, indice = otra, i
+				}
+			} else if falta := c.UmbralRobo - espera; proximo == 0 || falta < proximo {
+				proximo = falta
+			}
+		}
+	}
+	if indice >= 0 {
+		e := c.colas[robar][indice]
+		c.colas[robar] = append(c.colas[robar][:indice:indice], c.colas[robar][indice+1:]...)
+		c.robados++
+		e.tr.porEspera = true
+		return e.tr, true, 0
+	}
+	return Trabajo{}, false, proximo
+}
+
+// Trabajos esperando en la cola de una especialidad
+func (c *ColasTrabajo) Longitud(esp Especialidad) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.colas[esp])
+}
+
+// Trabajos esperando en todas las colas
+func (c *ColasTrabajo) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, cola := range c.colas {
+		n += len(cola)
+	}
+	return n
+}
+
+// Trabajos que ha atendido un mecánico de otra especialidad
+func (c *ColasTrabajo) Robados() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.robados
+}
+
+// Reparaciones interrumpidas y tiempo de trabajo perdido al interrumpirlas
+func (c *ColasTrabajo) Expropiaciones() (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expropiaciones, c.tiempoPerdido
+}
+
+// Resumen de las interrupciones; el tiempo perdido se paga a salarioHora
+func (c *ColasTrabajo) informeExpropiaciones(salarioHora float64) string {
+	n, perdido := c.Expropiaciones()
+	horas := perdido.Seconds() * horasPorSegundoSimulado
+	return fmt.Sprintf("Reparaciones interrumpidas por prioritarios: %d (%.1f h perdidas, coste %.2f €)\n",
+		n, horas, horas*salarioHora)
+}
+
+// Despierta a los mecánicos que esperan para que terminen
+func (c *ColasTrabajo) cerrar() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cerrada {
+		c.cerrada = true
+		close(c.cambio)
+		close(c.fin)
+	}
+}
+
+// Canal que se cierra cuando se cierran las colas (fin de la simulación)
+func (c *ColasTrabajo) cerradas() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.fin
+}
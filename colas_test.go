@@ -0,0 +1,314 @@
+// colas_test.go
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func trabajoDe(t *Taller, mat string, tipo Especialidad, prioritario bool) Trabajo {
+	v, _ := t.newVehiculo(mat, "Marca", "Modelo", time.Now(), time.Time{}, nil)
+	v.Prioritario = prioritario
+	inc, _ := t.newIncidencia(v.Matricula, nil, string(tipo), "Alta", "Prueba")
+	inc.TiempoAcumulado = 0 // que la reparación sea instantánea en el test
+	if p, err := t.crearPresupuesto(v.Matricula, time.Now()); err == nil {
+		t.responderPresupuesto(p.ID, true)
+	}
+	return t.entregas.nuevo(v, inc)
+}
+
+// tomar con un límite de tiempo para que un fallo no cuelgue el test
+func tomarAntesDe(c *ColasTrabajo, m *Mecanico, limite time.Duration) (Trabajo, bool) {
+	parar := make(chan struct{})
+	temporizador := time.AfterFunc(limite, func() { close(parar) })
+	defer temporizador.Stop()
+	return c.tomar(m, parar)
+}
+
+// Estado de una incidencia que están tocando los mecánicos
+func estadoDe(taller *Taller, inc *Incidencia) (estado int) {
+	taller.conTaller(func() { estado = inc.Estado })
+	return estado
+}
+
+// Espera a que se cumpla la condición o falla tras unos segundos
+func esperarQue(t *testing.T, que string, cond func() bool) {
+	t.Helper()
+	limite := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(limite) {
+			t.Fatalf("no se ha cumplido a tiempo: %s", que)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Antes, con la cola llena, devolver un trabajo que no era de la especialidad
+// del mecánico lo dejaba bloqueado. Las colas no tienen límite: devolverlo es
+// inmediato por muchos trabajos que esperen, y el mecánico sigue con lo suyo.
+func TestReasignarConColaLlenaNoBloquea(t *testing.T) {
+	taller := &Taller{}
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	for i := 0; i < 100; i++ {
+		taller.colas.encolar(trabajoDe(taller, fmt.Sprintf("V-%02d", i), Mecanica, false))
+	}
+	propio := trabajoDe(taller, "V-100", Electrica, false)
+	taller.colas.encolar(propio)
+	elec := taller.newMecanico("Elec", "electrica", 1)
+	ajeno := trabajoDe(taller, "V-101", Mecanica, false)
+
+	empieza := true
+	hecho := make(chan struct{})
+	go func() {
+		taller.conTaller(func() { _, empieza = taller.empezarTrabajo(elec, &ajeno) })
+		close(hecho)
+	}()
+	select {
+	case <-hecho:
+	case <-time.After(time.Second):
+		t.Fatal("devolver el trabajo a la cola llena ha bloqueado al mecánico")
+	}
+	if empieza {
+		t.Fatal("un eléctrico no debería empezar un trabajo de mecánica")
+	}
+	if n := taller.colas.Longitud(Mecanica); n != 101 {
+		t.Errorf("el trabajo debería volver a la cola: hay %d, se esperaban 101", n)
+	}
+	tr, ok := tomarAntesDe(taller.colas, elec, time.Second)
+	if !ok || tr.Incidencia != propio.Incidencia {
+		t.Error("el eléctrico debería seguir con el trabajo de su cola")
+	}
+}
+
+func TestColasCadaMecanicoConsumeSuEspecialidad(t *testing.T) {
+	taller := &Taller{}
+	c := nuevasColasTrabajo(time.Hour)
+	c.encolar(trabajoDe(taller, "V-01", Electrica, false))
+	c.encolar(trabajoDe(taller, "V-02", Mecanica, false))
+	c.encolar(trabajoDe(taller, "V-03", Mecanica, false))
+
+	mec := &Mecanico{ID: 1, Nombre: "Mec", Especialidad: Mecanica}
+	for _, esperado := range []string{"V-02", "V-03"} {
+		tr, ok := tomarAntesDe(c, mec, time.Second)
+		if !ok || tr.Vehiculo.Matricula != esperado {
+			t.Fatalf("se esperaba %s, se obtuvo %v (ok=%v)", esperado, tr.Vehiculo, ok)
+		}
+	}
+
+	// El trabajo eléctrico no es prioritario ni ha esperado lo suficiente
+	if tr, ok := tomarAntesDe(c, mec, 100*time.Millisecond); ok {
+		t.Fatalf("no debería robar %s", tr.Vehiculo.Matricula)
+	}
+	if c.Longitud(Electrica) != 1 || c.Robados() != 0 {
+		t.Errorf("la cola eléctrica debería seguir intacta: %d en cola, %d robados", c.Longitud(Electrica), c.Robados())
+	}
+}
+
+func TestColasRobaPrioritarios(t *testing.T) {
+	taller := &Taller{}
+	c := nuevasColasTrabajo(time.Hour)
+	c.encolar(trabajoDe(taller, "V-01", Electrica, false))
+	c.encolar(trabajoDe(taller, "V-02", Carroceria, true))
+
+	mec := &Mecanico{ID: 1, Nombre: "Mec", Especialidad: Mecanica}
+	tr, ok := tomarAntesDe(c, mec, time.Second)
+	if !ok || tr.Vehiculo.Matricula != "V-02" {
+		t.Fatalf("debería robar el prioritario V-02, se obtuvo %v (ok=%v)", tr.Vehiculo, ok)
+	}
+	if tr.porEspera {
+		t.Error("un prioritario no se roba por tiempo de espera")
+	}
+	if c.Robados() != 1 {
+		t.Errorf("robados = %d, se esperaba 1", c.Robados())
+	}
+}
+
+func TestColasRobaTrasElUmbralDeEspera(t *testing.T) {
+	taller := &Taller{}
+	c := nuevasColasTrabajo(80 * time.Millisecond)
+	c.encolar(trabajoDe(taller, "V-01", Electrica, false))
+
+	// El mecánico se queda esperando y el temporizador lo despierta
+	mec := &Mecanico{ID: 1, Nombre: "Mec", Especialidad: Mecanica}
+	inicio := time.Now()
+	tr, ok := tomarAntesDe(c, mec, time.Second)
+	if !ok || tr.Vehiculo.Matricula != "V-01" || !tr.porEspera {
+		t.Fatalf("debería robar V-01 por espera, se obtuvo %v (ok=%v, porEspera=%v)", tr.Vehiculo, ok, tr.porEspera)
+	}
+	if espera := time.Since(inicio); espera < 70*time.Millisecond {
+		t.Errorf("robó antes del umbral: %s", espera)
+	}
+}
+
+func TestColasCerrarDespiertaALosMecanicos(t *testing.T) {
+	c := nuevasColasTrabajo(time.Hour)
+	mec := &Mecanico{ID: 1, Nombre: "Mec", Especialidad: Mecanica}
+	resultado := make(chan bool)
+	go func() {
+		_, ok := c.tomar(mec, nil)
+		resultado <- ok
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.cerrar()
+	select {
+	case ok := <-resultado:
+		if ok {
+			t.Error("tomar debería devolver false con las colas cerradas")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("el mecánico sigue esperando tras cerrar las colas")
+	}
+}
+
+// Sólo hay mecánicos de mecánica y llegan muchos trabajos eléctricos: encolar
+// no bloquea nunca y, pasado el umbral, los mecánicos los acaban atendiendo.
+func TestMecanicosAtiendenOtraEspecialidadTrasEsperar(t *testing.T) {
+	taller := &Taller{}
+	m1 := taller.newMecanico("Mec1", "mecanica", 1)
+	m2 := taller.newMecanico("Mec2", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(50 * time.Millisecond)
+	defer taller.colas.cerrar()
+
+	var trabajos []Trabajo
+	for i := 0; i < 30; i++ {
+		tr := trabajoDe(taller, fmt.Sprintf("V-%02d", i), Electrica, false)
+		trabajos = append(trabajos, tr)
+		taller.colas.encolar(tr)
+	}
+	go trabajoMecanico(m1, taller)
+	go trabajoMecanico(m2, taller)
+
+	limite := time.After(3 * time.Second)
+	for {
+		cerradas := 0
+		for _, tr := range trabajos {
+			if estadoDe(taller, tr.Incidencia) == 2 {
+				cerradas++
+			}
+		}
+		if cerradas == len(trabajos) {
+			break
+		}
+		select {
+		case <-limite:
+			t.Fatalf("sólo %d de %d incidencias cerradas", cerradas, len(trabajos))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if taller.colas.Robados() != len(trabajos) {
+		t.Errorf("robados = %d, se esperaban %d", taller.colas.Robados(), len(trabajos))
+	}
+}
+
+// Cada interrupción suma lo hecho a la incidencia sin tocar el tiempo estimado
+func TestInterrupcionesAcumulanLoHecho(t *testing.T) {
+	taller := &Taller{}
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	m := &Mecanico{ID: 1, Nombre: "Ana", Especialidad: Mecanica}
+
+	taller.trabajoInterrumpido(m, tr, 2, 0, true)
+	taller.trabajoInterrumpido(m, tr, 1, 0, false)
+	inc := tr.Incidencia
+	if inc.TiempoAcumulado != 5 || inc.Hecho != 3 || inc.pendiente() != 2 {
+		t.Errorf("estimado %d, hecho %d, pendiente %d; se esperaba 5, 3 y 2", inc.TiempoAcumulado, inc.Hecho, inc.pendiente())
+	}
+	if tr.Vehiculo.TiempoTotal != 2 {
+		t.Errorf("al vehículo le quedan %ds, se esperaban 2", tr.Vehiculo.TiempoTotal)
+	}
+	if taller.colas.Longitud(Mecanica) != 2 {
+		t.Errorf("cada devolución vuelve a la cola")
+	}
+}
+
+func TestRepararPorPorcionesSeInterrumpe(t *testing.T) {
+	interrumpir := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(interrumpir) })
+	restante, perdido := repararPorPorciones(5, interrumpir, nil)
+	if restante != 5 {
+		t.Errorf("restante = %d, se esperaba 5 (se interrumpe en la primera porción)", restante)
+	}
+	if perdido < 40*time.Millisecond || perdido >= porcionReparacion {
+		t.Errorf("tiempo perdido %s fuera de rango", perdido)
+	}
+
+	if restante, perdido := repararPorPorciones(0, nil, nil); restante != 0 || perdido != 0 {
+		t.Errorf("una reparación vacía no debería dejar nada pendiente: %d, %s", restante, perdido)
+	}
+}
+
+// Un único mecánico está con una reparación larga y llega un prioritario de
+// su especialidad: la deja a medias, atiende el prioritario y luego la retoma
+// sin perder la porción que ya había hecho.
+func TestPrioritarioInterrumpeReparacion(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+
+	largo := trabajoDe(taller, "V-01", Mecanica, false)
+	largo.Incidencia.TiempoAcumulado = 3
+	taller.colas.encolar(largo)
+	go trabajoMecanico(m, taller)
+
+	esperarQue(t, "que empiece la reparación larga", func() bool { return estadoDe(taller, largo.Incidencia) == 1 })
+	time.Sleep(porcionReparacion + 300*time.Millisecond) // a mitad de la segunda porción
+
+	var urgente Trabajo
+	taller.conTaller(func() { urgente = trabajoDe(taller, "V-02", Mecanica, true) })
+	taller.colas.encolar(urgente)
+
+	esperarQue(t, "que se repare el prioritario", func() bool { return estadoDe(taller, urgente.Incidencia) == 2 })
+	taller.conTaller(func() {
+		if largo.Incidencia.Estado == 2 {
+			t.Fatal("la reparación larga terminó antes que el prioritario")
+		}
+		inc := largo.Incidencia
+		if inc.TiempoAcumulado != 3 || inc.Hecho != 1 || inc.pendiente() != 2 {
+			t.Errorf("estimado %d, hecho %d, pendiente %d; se esperaba 3, 1 y 2", inc.TiempoAcumulado, inc.Hecho, inc.pendiente())
+		}
+	})
+
+	esperarQue(t, "que se retome la reparación larga", func() bool { return estadoDe(taller, largo.Incidencia) == 2 })
+	n, perdido := taller.colas.Expropiaciones()
+	if n != 1 || perdido <= 0 {
+		t.Errorf("expropiaciones = %d, perdido = %s; se esperaba 1 y algo de tiempo", n, perdido)
+	}
+}
+
+func TestNoSeInterrumpeSiHayMecanicoLibre(t *testing.T) {
+	taller := &Taller{}
+	ocupado := taller.newMecanico("Ocupado", "mecanica", 1)
+	libre := taller.newMecanico("Libre", "electrica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+
+	largo := trabajoDe(taller, "V-01", Mecanica, false)
+	largo.Incidencia.TiempoAcumulado = 1
+	taller.colas.encolar(largo)
+	go trabajoMecanico(ocupado, taller)
+	for estadoDe(taller, largo.Incidencia) != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	go trabajoMecanico(libre, taller)
+	time.Sleep(20 * time.Millisecond) // que el eléctrico quede esperando
+
+	// El eléctrico libre puede llevarse el prioritario: no hace falta interrumpir
+	var urgente Trabajo
+	taller.conTaller(func() { urgente = trabajoDe(taller, "V-02", Mecanica, true) })
+	taller.colas.encolar(urgente)
+	limite := time.Now().Add(time.Second)
+	for estadoDe(taller, urgente.Incidencia) != 2 && time.Now().Before(limite) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if estadoDe(taller, urgente.Incidencia) != 2 {
+		t.Fatal("el mecánico libre no atendió al prioritario")
+	}
+	if n, _ := taller.colas.Expropiaciones(); n != 0 {
+		t.Errorf("expropiaciones = %d, no debería haberse interrumpido nada", n)
+	}
+}
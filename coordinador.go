@@ -0,0 +1,523 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ------------ MECÁNICOS REMOTOS: COORDINADOR ------------
+
+// El coordinador publica por net/rpc los trabajos de las colas del taller
+// para que los hagan mecánicos que corren en otros procesos (ver
+// trabajador.go). Cada mecánico remoto se registra con su especialidad, pide
+// un trabajo, va informando de las porciones que lleva hechas y al final
+// informa de cuántas hizo; el estado del taller sólo lo toca el coordinador,
+// que atiende las peticiones de una en una en su bucle y con el taller
+// cogido.
+// Mientras trabaja el mecánico late (ver latidos.go): si deja de hacerlo se
+// le da por muerto y su trabajo vuelve a la cola.
+//
+// Los métodos exportados son los del servicio RPC "Taller".
+
+const (
+	metodoRegistrar    = "Taller.Registrar"
+	metodoPedirTrabajo = "Taller.PedirTrabajo"
+	metodoAvanzar      = "Taller.Avanzar"
+	metodoTerminar     = "Taller.Terminar"
+	metodoBaja         = "Taller.Baja"
+	metodoLatido       = "Taller.Latido"
+)
+
+// Lo más que el coordinador retiene una petición de trabajo sin responder
+const maxEsperaPeticion = 10 * time.Second
+
+var (
+	errNoRegistrado   = errors.New("mecánico no registrado")
+	errConcesionAjena = errors.New("el trabajo no está concedido a este mecánico")
+	errMecanicoRemoto = errors.New("el mecánico ya está conectado")
+)
+
+type PeticionRegistro struct {
+	Nombre       string
+	Especialidad Especialidad
+	AñosExp      int
+}
+
+type PeticionTrabajo struct {
+	MecanicoID int
+	Espera     time.Duration // cuánto esperar a que haya trabajo antes de responder
+}
+
+// Trabajo concedido a un mecánico remoto. Hay es false si no lo hay; Fin
+// indica además que la simulación ha terminado y no habrá más.
+type RespuestaTrabajo struct {
+	Hay          bool
+	Fin          bool
+	Concesion    int
+	IncidenciaID int
+	Matricula    string
+	Tipo         Especialidad
+	Porciones    int // porciones de reparación que faltan
+}
+
+// Porciones hechas hasta ahora de un trabajo concedido
+type InformeAvance struct {
+	MecanicoID int
+	Concesion  int
+	Hechas     int
+}
+
+type RespuestaAvance struct {
+	Parar bool // el coordinador pide dejarlo (llega un prioritario)
+}
+
+type Coordinador struct {
+	t          *Taller
+	mu         sync.Mutex
+	conectados map[int]*Mecanico // mecánicos remotos registrados, por ID
+	srv        *servidorRPC
+	ops        chan func()   // lo que las peticiones hacen en el taller
+	parar      chan struct{} // se cierra al cerrar el coordinador
+}
+
+// Los trabajos concedidos se apuntan en la tabla de concesiones del taller
+// (se crea si no la tiene)
+func nuevoCoordinador(t *Taller) *Coordinador {
+	if t.concesiones == nil {
+		t.concesiones = nuevaTablaConcesiones(t, plazoLatidoDefecto)
+	}
+	c := &Coordinador{
+		t:          t,
+		conectados: map[int]*Mecanico{},
+		ops:        make(chan func()),
+		parar:      make(chan struct{}),
+	}
+	go c.atender()
+	return c
+}
+
+// Bucle del coordinador: hace en orden lo que piden las peticiones, cada
+// cosa con el taller cogido, hasta que se cierra el coordinador
+func (c *Coordinador) atender() {
+	for {
+		select {
+		case op := <-c.ops:
+			c.t.conTaller(op)
+		case <-c.parar:
+			return
+		}
+	}
+}
+
+// Hace fn en el bucle del coordinador y espera a que termine. Cerrado el
+// coordinador, lo que quede se hace directamente con el taller cogido.
+func (c *Coordinador) enBucle(fn func()) {
+	hecho := make(chan struct{})
+	select {
+	case c.ops <- func() { defer close(hecho); fn() }:
+		<-hecho
+	case <-c.parar:
+		c.t.conTaller(fn)
+	}
+}
+
+// Empieza a aceptar mecánicos remotos en dir (host:puerto; con puerto 0 se
+// elige uno libre). Devuelve la dirección en la que escucha.
+func (c *Coordinador) escuchar(dir string) (net.Addr, error) {
+	srv, err := escucharRPC("Taller", c, dir)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.srv = srv
+	c.mu.Unlock()
+	return srv.ln.Addr(), nil
+}
+
+// Deja de aceptar mecánicos y corta las conexiones abiertas
+func (c *Coordinador) cerrar() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.srv.cerrar()
+	select {
+	case <-c.parar:
+	default:
+		close(c.parar)
+	}
+}
+
+// Servidor net/rpc con un único servicio que recuerda sus conexiones para
+// poder cortarlas al cerrar
+type servidorRPC struct {
+	mu         sync.Mutex
+	ln         net.Listener
+	conexiones map[net.Conn]bool
+}
+
+func escucharRPC(servicio string, rcvr any, dir string) (*servidorRPC, error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(servicio, rcvr); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &servidorRPC{ln: ln, conexiones: map[net.Conn]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conexiones[conn] = true
+			s.mu.Unlock()
+			go func() {
+				srv.ServeConn(conn)
+				s.mu.Lock()
+				delete(s.conexiones, conn)
+				s.mu.Unlock()
+			}()
+		}
+	}()
+	return s, nil
+}
+
+func (s *servidorRPC) cerrar() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ln.Close()
+	for conn := range s.conexiones {
+		conn.Close()
+	}
+}
+
+// El mecánico trabaja en otro proceso (la simulación no le lanza goroutine)
+func (c *Coordinador) conectado(id int) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conectados[id] != nil
+}
+
+func (c *Coordinador) mecanico(id int) (*Mecanico, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.conectados[id]
+	if m == nil {
+		return nil, fmt.Errorf("mecánico %d: %w", id, errNoRegistrado)
+	}
+	return m, nil
+}
+
+// Saca la concesión del mecánico (deja de estar entre sus trabajos)
+func (c *Coordinador) soltar(mecID, id int) (*concesion, error) {
+	cn, err := c.t.concesiones.buscar(mecID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.t.concesiones.soltar(cn) {
+		return nil, fmt.Errorf("trabajo %d, mecánico %d: %w", id, mecID, errConcesionAjena)
+	}
+	return cn, nil
+}
+
+// Desconecta al mecánico dado por muerto: para volver tendrá que registrarse
+func (c *Coordinador) olvidar(id int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conectados, id)
+}
+
+// Registrar da de alta un mecánico remoto y devuelve su ID. Si ya existe un
+// mecánico con ese nombre y especialidad que no está conectado, es él.
+func (c *Coordinador) Registrar(p PeticionRegistro, id *int) error {
+	if p.Especialidad != Mecanica && p.Especialidad != Electrica && p.Especialidad != Carroceria {
+		return fmt.Errorf("especialidad inválida (%s)", p.Especialidad)
+	}
+	var err error
+	c.enBucle(func() { err = c.registrar(p, id) })
+	return err
+}
+
+// Se llama en el bucle del coordinador
+func (c *Coordinador) registrar(p PeticionRegistro, id *int) error {
+	t := c.t
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var m *Mecanico
+	for _, existente := range t.datos().Mecanicos() {
+		if existente.Nombre == p.Nombre && existente.Especialidad == p.Especialidad {
+			if c.conectados[existente.ID] != nil {
+				return fmt.Errorf("%s: %w", p.Nombre, errMecanicoRemoto)
+			}
+			m = existente
+			break
+		}
+	}
+	if m == nil {
+		m = t.newMecanico(p.Nombre, string(p.Especialidad), p.AñosExp)
+	}
+	m.Activo = true
+	c.conectados[m.ID] = m
+	t.plantilla.registrar(m, time.Now())
+	t.avisar("Mecánico remoto %s (%s) conectado", m.Nombre, m.Especialidad)
+	*id = m.ID
+	return nil
+}
+
+// PedirTrabajo concede al mecánico el siguiente trabajo de las colas,
+// esperando como mucho p.Espera a que haya uno que pueda hacer
+func (c *Coordinador) PedirTrabajo(p PeticionTrabajo, r *RespuestaTrabajo) error {
+	t := c.t
+	m, err := c.mecanico(p.MecanicoID)
+	if err != nil {
+		return err
+	}
+	espera := min(max(p.Espera, 0), maxEsperaPeticion)
+	var colas *ColasTrabajo
+	t.conTaller(func() { colas = t.colas })
+	if colas == nil {
+		// Todavía no hay simulación en marcha
+		time.Sleep(espera)
+		return nil
+	}
+	limite := make(chan struct{})
+	temporizador := time.AfterFunc(espera, func() { close(limite) })
+	defer temporizador.Stop()
+
+	for {
+		trabajo, ok := colas.tomar(m, limite)
+		if !ok {
+			select {
+			case <-colas.cerradas():
+				r.Fin = true
+			default:
+			}
+			return nil
+		}
+		if c.conceder(m, trabajo, limite, r) {
+			return nil
+		}
+	}
+}
+
+// Decide si el mecánico remoto se queda el trabajo que ha sacado de la cola
+// y, si es así, se lo concede en r. Devuelve false si hay que sacar otro.
+// Cada paso se hace en el bucle del coordinador; la espera de piezas, fuera
+// de él para no parar las peticiones de los demás mecánicos.
+func (c *Coordinador) conceder(m *Mecanico, trabajo Trabajo, limite <-chan struct{}, r *RespuestaTrabajo) bool {
+	t := c.t
+	v, inc := trabajo.Vehiculo, trabajo.Incidencia
+	seguir, hecho, piezas := false, false, false
+	var inv *Inventario
+	c.enBucle(func() {
+		t.eventos.recibir(t.origenMecanico(m), trabajo.marca)
+		if inc.Estado == 2 || t.entregas.repetido(trabajo) {
+			return
+		}
+		if !t.puedeEmpezar(trabajo, func(err error) {
+			t.avisar("-> Mecánico %s no atiende el vehículo %s: %v", m.Nombre, v.Matricula, err)
+		}) {
+			return
+		}
+		// Si lo devuelve, responde sin trabajo y el mecánico vuelve a pedir
+		if !trabajo.porEspera && !t.verificarAsignacionMecanico(m, v, inc) {
+			t.devolverSinTocar(m, trabajo)
+			hecho = true
+			return
+		}
+		seguir = true
+		piezas = !inc.PiezasServidas && len(inc.Piezas) > 0
+		inv = t.inventario
+	})
+	if !seguir {
+		return hecho
+	}
+	if piezas && !c.sacarPiezas(inv, m, v, inc, limite) {
+		c.enBucle(func() { t.devolverSinTocar(m, trabajo) })
+		return true
+	}
+	c.enBucle(func() { hecho = c.empezar(m, trabajo, r) })
+	return hecho
+}
+
+// Concede en r el trabajo al mecánico remoto. Devuelve false si otro ya lo
+// ha empezado. Se llama en el bucle del coordinador.
+func (c *Coordinador) empezar(m *Mecanico, trabajo Trabajo, r *RespuestaTrabajo) bool {
+	t := c.t
+	v, inc := trabajo.Vehiculo, trabajo.Incidencia
+	if !t.entregas.empezar(trabajo, m) {
+		return false
+	}
+	m.Activo = false
+	inc.Estado = 1
+	t.plantilla.empezar(m, inc)
+	trabajo.marca = t.eventos.anotar(t.origenMecanico(m), eventoInicio, trabajo, m.Nombre+" (remoto)")
+	t.seguimiento.empezar(m, v, inc, t.plazaDeVehiculo(v.Matricula), time.Duration(inc.pendiente())*porcionReparacion)
+	cn := t.concesiones.conceder(m, trabajo, inc.pendiente(), t.colas.empezarReparacion(m, trabajo))
+	t.avisar("Mecánico remoto %s (%s) atendiendo vehículo %s [%s]", m.Nombre, m.Especialidad, v.Matricula, inc.Tipo)
+
+	*r = RespuestaTrabajo{
+		Hay:          true,
+		Concesion:    cn.ID,
+		IncidenciaID: inc.ID,
+		Matricula:    v.Matricula,
+		Tipo:         inc.Tipo,
+		Porciones:    cn.duracion,
+	}
+	return true
+}
+
+// Saca del almacén las piezas de la incidencia. Con el inventario de la
+// simulación se piden las que falten y se espera hasta limite, fuera del
+// bucle y sin el taller.
+func (c *Coordinador) sacarPiezas(inv *Inventario, m *Mecanico, v *Vehiculo, inc *Incidencia, limite <-chan struct{}) bool {
+	if inv == nil {
+		var err error
+		c.enBucle(func() { err = c.t.servirPiezas(inc) })
+		return err == nil
+	}
+	rs := &reservaPiezas{m: m, v: v, inc: inc}
+	for {
+		var hecha, ok bool
+		var cambio <-chan struct{}
+		c.enBucle(func() { hecha, ok, cambio = inv.intentar(rs) })
+		if hecha {
+			return ok
+		}
+		if !inv.esperarCambio(cambio, limite) {
+			inv.dejarDeEsperar(m, rs.inicio)
+			return false
+		}
+	}
+}
+
+// Avanzar apunta las porciones hechas (cuenta como latido) y dice al
+// mecánico si debe parar
+func (c *Coordinador) Avanzar(a InformeAvance, r *RespuestaAvance) error {
+	cn, err := c.t.concesiones.buscar(a.MecanicoID, a.Concesion)
+	if err != nil {
+		return err
+	}
+	c.t.concesiones.avance(cn, a.Hechas)
+	select {
+	case <-cn.interrumpir:
+		r.Parar = true
+	default:
+	}
+	return nil
+}
+
+// Terminar cierra el trabajo con las porciones que se hicieron. Si no se
+// completó, lo que falta vuelve a la cola. cerrada indica si se completó.
+// Repetirlo (p.ej. al reintentar tras cortarse la red) responde lo mismo que
+// la primera vez sin volver a cerrar nada.
+func (c *Coordinador) Terminar(a InformeAvance, cerrada *bool) error {
+	var err error
+	c.enBucle(func() { err = c.terminar(a, cerrada) })
+	return err
+}
+
+// Se llama en el bucle del coordinador
+func (c *Coordinador) terminar(a InformeAvance, cerrada *bool) error {
+	cn, err := c.soltar(a.MecanicoID, a.Concesion)
+	if err != nil {
+		if ya, ok := c.t.entregas.cierre(a.MecanicoID, a.Concesion); ok {
+			*cerrada = ya
+			return nil
+		}
+		return err
+	}
+	*cerrada = c.cerrarConcesion(cn, min(max(a.Hechas, 0), cn.duracion))
+	c.t.entregas.apuntarCierre(a.MecanicoID, a.Concesion, *cerrada)
+	return nil
+}
+
+// Latido renueva los trabajos del mecánico. Falla si ya no está conectado
+// (por ejemplo, porque se le dio por muerto).
+func (c *Coordinador) Latido(mecID int, ok *bool) error {
+	if _, err := c.mecanico(mecID); err != nil {
+		return err
+	}
+	c.t.concesiones.renovar(mecID)
+	*ok = true
+	return nil
+}
+
+// Baja desconecta al mecánico remoto. Lo que tuviera a medias vuelve a la
+// cola con el avance del que informó.
+func (c *Coordinador) Baja(mecID int, ok *bool) error {
+	m, err := c.mecanico(mecID)
+	if err != nil {
+		return err
+	}
+	c.enBucle(func() {
+		pendientes := c.t.concesiones.soltarTodas(mecID)
+		c.olvidar(mecID)
+		for _, cn := range pendientes {
+			c.cerrarConcesion(cn, c.t.concesiones.hechasDe(cn))
+		}
+		c.t.avisar("Mecánico remoto %s desconectado", m.Nombre)
+	})
+	*ok = true
+	return nil
+}
+
+// Apunta en el taller el resultado del trabajo: igual que cuando lo termina
+// (o lo deja) un mecánico local. Se llama en el bucle del coordinador.
+func (c *Coordinador) cerrarConcesion(cn *concesion, hechas int) bool {
+	t := c.t
+	m, v, inc := cn.m, cn.tr.Vehiculo, cn.tr.Incidencia
+	t.colas.terminarReparacion(m)
+	t.seguimiento.terminar(m)
+	t.plantilla.terminar(m)
+	pendiente := cn.duracion - hechas
+	if pendiente <= 0 && !t.entregas.terminar(cn.tr, m) {
+		// Ya lo terminó otro: no se cuenta ni se acredita dos veces
+		m.Activo = true
+		t.resultado("Mecánico remoto %s: la incidencia del vehículo %s (%s) ya estaba terminada",
+			m.Nombre, v.Matricula, inc.Tipo)
+		return false
+	}
+	t.registrarManoDeObra(inc, m, float64(hechas)*horasPorPorcion)
+
+	if pendiente > 0 {
+		urgencia := false
+		select {
+		case <-cn.interrumpir:
+			urgencia = true
+		default:
+		}
+		msg := fmt.Sprintf("Mecánico remoto %s deja la incidencia del vehículo %s (%s) tras %ds [quedan %ds]",
+			m.Nombre, v.Matricula, inc.Tipo, hechas, pendiente)
+		tr := cn.tr
+		tr.marca = t.eventos.anotar(t.origenMecanico(m), eventoInterrupcion, tr, msg)
+		t.trabajoInterrumpido(m, tr, hechas, 0, urgencia)
+		t.publicarInterrupcion(m, tr, hechas, msg)
+		return false
+	}
+
+	msg, reparado := t.trabajoTerminado(m, cn.tr, cn.duracion)
+	t.eventos.anotar(t.origenMecanico(m), eventoFin, cn.tr, msg)
+	if reparado {
+		t.liberarPlaza(v)
+	}
+	return true
+}
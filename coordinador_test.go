@@ -0,0 +1,195 @@
+// coordinador_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Taller con colas y un coordinador escuchando en un puerto libre de localhost
+func tallerConCoordinador(t *testing.T) (*Taller, string) {
+	t.Helper()
+	taller := &Taller{}
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	taller.coordinador = nuevoCoordinador(taller)
+	dir, err := taller.coordinador.escuchar("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se pudo arrancar el coordinador: %v", err)
+	}
+	t.Cleanup(func() {
+		taller.colas.cerrar()
+		taller.coordinador.cerrar()
+	})
+	return taller, dir.String()
+}
+
+func trabajadorDePrueba(t *testing.T, dir, nombre string, esp Especialidad) *Trabajador {
+	t.Helper()
+	w, err := conectarTrabajador(dir, nombre, esp, 0)
+	if err != nil {
+		t.Fatalf("no se pudo conectar %s: %v", nombre, err)
+	}
+	w.Porcion = 5 * time.Millisecond
+	w.Espera = 50 * time.Millisecond
+	return w
+}
+
+func TestTrabajadoresRemotosReparan(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	local := taller.newMecanico("Local", "carroceria", 0) // pone plazas en el taller
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.admitirCliente(cli.ID, v, local.ID)
+	frenos, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	luces, _ := taller.newIncidencia(v.Matricula, nil, "electrica", "Alta", "Luces")
+	frenos.TiempoAcumulado, luces.TiempoAcumulado = 3, 2
+	taller.updateTiempoTotalVehiculo(v)
+	p, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+	taller.responderPresupuesto(p.ID, true)
+
+	luis := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	ana := trabajadorDePrueba(t, dir, "Ana", Electrica)
+	if !taller.coordinador.conectado(luis.ID) || luis.ID == ana.ID {
+		t.Fatalf("registro incorrecto: %d y %d", luis.ID, ana.ID)
+	}
+	parar := make(chan struct{})
+	errs := make(chan error, 2)
+	for _, w := range []*Trabajador{luis, ana} {
+		go func(w *Trabajador) { errs <- w.ejecutar(parar) }(w)
+	}
+	taller.colas.encolar(Trabajo{Vehiculo: v, Incidencia: frenos})
+	taller.colas.encolar(Trabajo{Vehiculo: v, Incidencia: luces})
+
+	esperarQue(t, "el vehículo sale reparado", func() bool {
+		facturas := 0
+		taller.conTaller(func() { facturas = len(taller.datos().Facturas()) })
+		return facturas == 1
+	})
+	close(parar)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("el trabajador terminó con error: %v", err)
+		}
+	}
+
+	if luis.Terminados != 1 || ana.Terminados != 1 {
+		t.Errorf("terminados %d y %d, se esperaba uno cada uno", luis.Terminados, ana.Terminados)
+	}
+	if len(frenos.ManoDeObra) != 1 || frenos.ManoDeObra[0].Nombre != "Luis" || frenos.ManoDeObra[0].Horas != 3 {
+		t.Errorf("mano de obra de los frenos: %+v", frenos.ManoDeObra)
+	}
+	if len(luces.ManoDeObra) != 1 || luces.ManoDeObra[0].Nombre != "Ana" {
+		t.Errorf("mano de obra de las luces: %+v", luces.ManoDeObra)
+	}
+	if taller.plazaDeVehiculo(v.Matricula) != nil {
+		t.Error("la plaza debería quedar libre")
+	}
+}
+
+func TestTrabajoRemotoAMediasVuelveALaCola(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	taller.colas.encolar(tr)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	if err := w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r); err != nil || !r.Hay {
+		t.Fatalf("debería conceder el trabajo: %+v, %v", r, err)
+	}
+	if r.IncidenciaID != tr.Incidencia.ID || r.Porciones != 5 || tr.Incidencia.Estado != 1 {
+		t.Fatalf("concesión incorrecta: %+v", r)
+	}
+
+	// Otro mecánico no puede cerrar un trabajo que no es suyo
+	otro := trabajadorDePrueba(t, dir, "Pedro", Mecanica)
+	var cerrada bool
+	if err := otro.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: otro.ID, Concesion: r.Concesion, Hechas: 5}, &cerrada); err == nil {
+		t.Fatal("un mecánico no puede terminar el trabajo de otro")
+	}
+
+	if err := w.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 2}, &cerrada); err != nil {
+		t.Fatal(err)
+	}
+	if cerrada || tr.Incidencia.Estado != 0 || tr.Incidencia.pendiente() != 3 {
+		t.Errorf("el trabajo debería quedar abierto con 3 porciones: cerrada=%v %+v", cerrada, tr.Incidencia)
+	}
+	if taller.colas.Longitud(Mecanica) != 1 {
+		t.Errorf("el trabajo debería volver a la cola")
+	}
+	// La concesión ya no existe
+	if err := w.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 3}, &cerrada); err == nil {
+		t.Error("no se puede terminar dos veces el mismo trabajo")
+	}
+}
+
+func TestPrioritarioInterrumpeTrabajoRemoto(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	normal := trabajoDe(taller, "V-01", Mecanica, false)
+	normal.Incidencia.TiempoAcumulado = 10
+	taller.colas.encolar(normal)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r)
+	var av RespuestaAvance
+	if err := w.cliente.Call(metodoAvanzar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 1}, &av); err != nil || av.Parar {
+		t.Fatalf("sin prioritarios se sigue trabajando: %+v, %v", av, err)
+	}
+
+	taller.colas.encolar(trabajoDe(taller, "V-02", Mecanica, true))
+	if err := w.cliente.Call(metodoAvanzar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 2}, &av); err != nil || !av.Parar {
+		t.Fatalf("debería pedir que pare por el prioritario: %+v, %v", av, err)
+	}
+	var cerrada bool
+	w.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 2}, &cerrada)
+
+	// Lo siguiente que se le da es el prioritario
+	w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r)
+	if !r.Hay || r.Matricula != "V-02" {
+		t.Errorf("se esperaba el prioritario V-02: %+v", r)
+	}
+}
+
+func TestBajaDevuelveElTrabajo(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 4
+	taller.colas.encolar(tr)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r)
+	var av RespuestaAvance
+	w.cliente.Call(metodoAvanzar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 1}, &av)
+	if err := w.cerrar(); err != nil {
+		t.Fatal(err)
+	}
+	if taller.coordinador.conectado(w.ID) {
+		t.Error("el mecánico debería estar desconectado")
+	}
+	if tr.Incidencia.Estado != 0 || tr.Incidencia.pendiente() != 3 || taller.colas.Longitud(Mecanica) != 1 {
+		t.Errorf("el trabajo debería volver a la cola con 3 porciones: %+v", tr.Incidencia)
+	}
+
+	// Al volver a conectarse es el mismo mecánico
+	w2 := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	if w2.ID != w.ID {
+		t.Errorf("ID %d al reconectar, se esperaba %d", w2.ID, w.ID)
+	}
+}
+
+func TestRemotoSinRegistrar(t *testing.T) {
+	_, dir := tallerConCoordinador(t)
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	if err := w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID + 100}, &r); err == nil {
+		t.Error("un mecánico sin registrar no puede pedir trabajo")
+	}
+	if _, err := conectarTrabajador(dir, "Luis", Mecanica, 0); err == nil {
+		t.Error("el mismo mecánico no puede conectarse dos veces")
+	}
+	if _, err := conectarTrabajador(dir, "Pepe", "fontaneria", 0); err == nil {
+		t.Error("especialidad inválida aceptada")
+	}
+}
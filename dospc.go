@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ------------ COMPROMISO EN DOS FASES DE LOS TRASLADOS ------------
+
+// Un traslado entre sedes (federacion.go) es un compromiso en dos fases en el
+// que el origen coordina y el destino participa:
+//
+//	origen                               destino
+//	apunta "iniciado"
+//	Reservar ------------------------->  apunta "preparado", reserva la plaza
+//	apunta "confirmado" (decisión)       (o falla: el origen apunta "abortado")
+//	libera su plaza
+//	Confirmar ------------------------>  da entrada al vehículo, apunta "recibido"
+//	apunta "terminado"
+//
+// Cada sede guarda los apuntes en un registro de sólo añadir que se lleva a
+// disco (fsync) antes de seguir, así que tras caerse sabe en qué punto se
+// quedó cada traslado:
+//
+//   - Origen con un traslado "iniciado": no llegó a decidir, se aborta.
+//   - Origen con un traslado "confirmado": rehace su parte (liberar la plaza
+//     y apuntar el vehículo en la otra sede) y vuelve a confirmarlo.
+//   - Destino con un traslado "preparado": está en duda; conserva la plaza y
+//     pregunta al origen qué se decidió.
+//
+// Lo que el origen no tiene decidido se da por abortado: si el destino
+// pregunta por un traslado sin decidir, el origen lo aborta en ese momento.
+
+// Fases de un traslado en el registro
+const (
+	// En el origen
+	faseIniciado   = "iniciado"
+	faseConfirmado = "confirmado"
+	faseAbortado   = "abortado"
+	faseTerminado  = "terminado"
+	// En el destino
+	fasePreparado = "preparado"
+	faseRecibido  = "recibido"
+	faseCancelado = "cancelado"
+)
+
+// Puntos del protocolo en los que los tests pueden simular que la sede se cae
+const (
+	caidaTrasIniciar  = "tras iniciar"  // origen: antes de pedir la reserva
+	caidaTrasPreparar = "tras preparar" // origen: con la reserva hecha, antes de decidir
+	caidaTrasDecidir  = "tras decidir"  // origen: decisión apuntada, sin aplicar
+	caidaTrasAplicar  = "tras aplicar"  // origen: plaza liberada, sin confirmar
+	caidaAlPreparar   = "al preparar"   // destino: preparado apuntado, sin responder
+	caidaAlRecibir    = "al recibir"    // destino: vehículo dentro, sin apuntarlo
+)
+
+var errCaida = errors.New("sede caída")
+
+// Apunte del registro de traslados. Los apuntes que siguen al primero de un
+// traslado sólo llevan la fase.
+type ApunteTraslado struct {
+	ID       string
+	Fase     string
+	Fecha    time.Time
+	Peticion *PeticionTraslado `json:",omitempty"`
+	Destino  string            `json:",omitempty"` // dirección del destino (origen)
+	Sede     string            `json:",omitempty"` // nombre del destino (origen)
+	PlazaID  int               `json:",omitempty"` // plaza reservada (destino)
+}
+
+// Registro de traslados de una sede. Sin ruta sólo se guarda en memoria. Lo
+// protege el mutex de la federación.
+type RegistroTraslados struct {
+	fichero *os.File
+	estado  map[string]*ApunteTraslado // último estado de cada traslado
+	orden   []string                   // IDs por orden de llegada
+}
+
+// Abre el registro de ruta, recuperando los apuntes que ya tuviera
+func abrirRegistroTraslados(ruta string) (*RegistroTraslados, error) {
+	r := &RegistroTraslados{estado: map[string]*ApunteTraslado{}}
+	if ruta == "" {
+		return r, nil
+	}
+	f, err := os.OpenFile(ruta, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var a ApunteTraslado
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			// Una última línea a medias es un apunte que no llegó a hacerse
+			continue
+		}
+		r.anotar(a)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", ruta, err)
+	}
+	// Tras la línea a medias se empieza una nueva para no estropear la siguiente
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		ultimo := make([]byte, 1)
+		if _, err := f.ReadAt(ultimo, info.Size()-1); err == nil && ultimo[0] != '\n' {
+			f.Write([]byte{'\n'})
+		}
+	}
+	r.fichero = f
+	return r, nil
+}
+
+// Apunta la fase y no vuelve hasta que está en disco
+func (r *RegistroTraslados) apuntar(a ApunteTraslado) error {
+	if a.Fecha.IsZero() {
+		a.Fecha = time.Now()
+	}
+	if r.fichero != nil {
+		linea, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if _, err := r.fichero.Write(append(linea, '\n')); err != nil {
+			return err
+		}
+		if err := r.fichero.Sync(); err != nil {
+			return err
+		}
+	}
+	r.anotar(a)
+	return nil
+}
+
+func (r *RegistroTraslados) anotar(a ApunteTraslado) {
+	previo := r.estado[a.ID]
+	if previo == nil {
+		r.orden = append(r.orden, a.ID)
+		r.estado[a.ID] = &a
+		return
+	}
+	previo.Fase, previo.Fecha = a.Fase, a.Fecha
+	if a.Peticion != nil {
+		previo.Peticion = a.Peticion
+	}
+	if a.Destino != "" {
+		previo.Destino, previo.Sede = a.Destino, a.Sede
+	}
+	if a.PlazaID != 0 {
+		previo.PlazaID = a.PlazaID
+	}
+}
+
+// Fase en la que está el traslado ("" si no se conoce)
+func (r *RegistroTraslados) fase(id string) string {
+	if a := r.estado[id]; a != nil {
+		return a.Fase
+	}
+	return ""
+}
+
+// Traslados en la fase indicada, por orden de llegada
+func (r *RegistroTraslados) enFase(fase string) []ApunteTraslado {
+	var lista []ApunteTraslado
+	for _, id := range r.orden {
+		if a := r.estado[id]; a.Fase == fase {
+			lista = append(lista, *a)
+		}
+	}
+	return lista
+}
+
+func (r *RegistroTraslados) cerrar() error {
+	if r == nil || r.fichero == nil {
+		return nil
+	}
+	return r.fichero.Close()
+}
+
+// ------------ RECUPERACIÓN ------------
+
+// Federación de la sede con su registro de traslados en ruta. Lo que quedó a
+// medias antes de caerse se recupera según el registro.
+func abrirFederacion(t *Taller, nombre string, vecinas []string, ruta string) (*Federacion, error) {
+	r, err := abrirRegistroTraslados(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo abrir el registro de traslados: %w", err)
+	}
+	f := nuevaFederacion(t, nombre, vecinas)
+	f.registro = r
+	if err := f.recuperar(); err != nil {
+		r.cerrar()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Federacion) recuperar() error {
+	t := f.t
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Como origen: lo no decidido se aborta y lo decidido se rehace
+	for _, a := range f.registro.enFase(faseIniciado) {
+		if err := f.registro.apuntar(ApunteTraslado{ID: a.ID, Fase: faseAbortado}); err != nil {
+			return err
+		}
+		t.avisar("Traslado %s sin decidir al arrancar: abortado", a.ID)
+	}
+	for _, a := range f.registro.enFase(faseConfirmado) {
+		if err := f.aplicarTraslado(a); err != nil {
+			return fmt.Errorf("traslado %s: %w", a.ID, err)
+		}
+		f.sinConfirmar[a.ID] = a.Destino
+		t.avisar("Traslado %s decidido al arrancar: se vuelve a confirmar a %s", a.ID, a.Sede)
+	}
+
+	// Como destino: lo preparado queda en duda hasta preguntar al origen
+	for _, a := range f.registro.enFase(fasePreparado) {
+		if a.Peticion == nil {
+			continue
+		}
+		if pl := t.datos().Plaza(a.PlazaID); pl != nil && pl.VehiculoMat != a.Peticion.Matricula {
+			pl.Ocupada = true
+			pl.VehiculoMat = a.Peticion.Matricula
+			if err := t.datos().GuardarPlaza(pl); err != nil {
+				return err
+			}
+		}
+		f.reservas[a.ID] = &reserva{p: *a.Peticion, plazaID: a.PlazaID, vence: time.Now()}
+		t.avisar("Traslado %s en duda al arrancar: se preguntará a la sede de origen", a.ID)
+	}
+	return nil
+}
+
+// Parte del origen de un traslado decidido: el vehículo pasa a la sede
+// destino y deja libre su plaza. Hacerlo dos veces no cambia nada.
+func (f *Federacion) aplicarTraslado(a ApunteTraslado) error {
+	t := f.t
+	v := t.getVehiculo(a.Peticion.Matricula)
+	if v == nil {
+		return fmt.Errorf("vehículo con matrícula %s no encontrado", a.Peticion.Matricula)
+	}
+	if v.Sede == a.Sede {
+		return nil
+	}
+	return t.conPlazas(func() error {
+		return t.datos().Transaccion(func() error {
+			v.Sede = a.Sede
+			if p := t.plazaDeVehiculo(v.Matricula); p != nil {
+				if err := t.vaciarPlaza(p); err != nil {
+					return err
+				}
+			}
+			return t.cerrarVisita(v, nil, t.ahora())
+		})
+	})
+}
+
+// Simula una caída de la sede en ese punto del protocolo (sólo en los tests)
+func (f *Federacion) cae(punto string) bool {
+	return f.caida != nil && f.caida(punto)
+}
+
+// Traslados de la sede que no han terminado, para el menú
+func (f *Federacion) trasladosPendientes() []ApunteTraslado {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lista []ApunteTraslado
+	for _, fase := range []string{faseIniciado, faseConfirmado, fasePreparado} {
+		lista = append(lista, f.registro.enFase(fase)...)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Fecha.Before(lista[j].Fecha) })
+	return lista
+}
@@ -0,0 +1,247 @@
+// dospc_test.go
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Sede con el almacén y el registro de traslados en el directorio datos,
+// escuchando en dir. Para simular que vuelve a arrancar tras caerse se llama
+// otra vez con los mismos datos y la misma dirección (y sin mecánicos, que ya
+// están en el almacén).
+func sedeEnFichero(t *testing.T, nombre, datos, dir string, vecinas []string, esps ...Especialidad) (*Taller, *Federacion) {
+	t.Helper()
+	taller, err := nuevoTaller("fichero", datos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, esp := range esps {
+		taller.newMecanico("Mec "+string(esp), string(esp), 1)
+	}
+	f, err := abrirFederacion(taller, nombre, vecinas, filepath.Join(datos, "traslados.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.escuchar(dir); err != nil {
+		t.Fatalf("no se pudo arrancar la sede %s: %v", nombre, err)
+	}
+	taller.federacion = f
+	t.Cleanup(f.cerrar)
+	return taller, f
+}
+
+// El origen se cae con la decisión apuntada pero sin aplicarla: el destino
+// queda en duda y, al volver el origen, el traslado se completa
+func TestOrigenCaidoTrasDecidirCompletaElTraslado(t *testing.T) {
+	datos := t.TempDir()
+	origen, fo := sedeEnFichero(t, "Centro", datos, "127.0.0.1:0", nil, Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	mat := vehiculoEsperando(t, origen, "1234 BCD", Mecanica).Matricula
+
+	fo.caida = func(punto string) bool { return punto == caidaTrasDecidir }
+	if _, err := fo.trasladar(mat, "Norte"); !errors.Is(err, errCaida) {
+		t.Fatalf("el origen debería caerse: %v", err)
+	}
+	fo.cerrar()
+
+	// Sin el origen el destino no sabe qué se decidió y guarda la plaza
+	fd.resolverReservas(time.Now().Add(2 * plazoReserva))
+	if len(destino.plazasOcupadas()) != 1 || destino.getVehiculo(mat) != nil {
+		t.Fatal("el destino debería seguir con la plaza reservada y sin el vehículo")
+	}
+
+	origen, fo = sedeEnFichero(t, "Centro", datos, fo.dir, []string{fd.dir})
+	v := origen.getVehiculo(mat)
+	if v == nil || v.Sede != "Norte" || origen.plazaDeVehiculo(mat) != nil || origen.visitaAbierta(mat) != nil {
+		t.Fatalf("al arrancar el origen debería rehacer el traslado decidido: %+v", v)
+	}
+	esperarQue(t, "el origen confirma el traslado", func() bool {
+		fo.reintentarConfirmaciones()
+		return len(fo.sinConfirmar) == 0
+	})
+	if a := fo.registro.enFase(faseTerminado); len(a) != 1 {
+		t.Errorf("el traslado debería estar terminado: %+v", fo.registro.estado)
+	}
+	if destino.visitaAbierta(mat) == nil || len(destino.getVehiculo(mat).Incidencias) != 1 {
+		t.Error("el destino debería dar entrada al vehículo")
+	}
+}
+
+// El origen se cae con la reserva hecha pero sin decidir: al arrancar lo
+// aborta y el destino, al preguntar, libera la plaza
+func TestOrigenCaidoSinDecidirAborta(t *testing.T) {
+	datos := t.TempDir()
+	origen, fo := sedeEnFichero(t, "Centro", datos, "127.0.0.1:0", nil, Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	mat := vehiculoEsperando(t, origen, "1234 BCD", Mecanica).Matricula
+
+	fo.caida = func(punto string) bool { return punto == caidaTrasPreparar }
+	if _, err := fo.trasladar(mat, "Norte"); !errors.Is(err, errCaida) {
+		t.Fatalf("el origen debería caerse: %v", err)
+	}
+	fo.cerrar()
+
+	origen, fo = sedeEnFichero(t, "Centro", datos, fo.dir, []string{fd.dir})
+	if a := fo.registro.enFase(faseAbortado); len(a) != 1 {
+		t.Fatalf("el traslado sin decidir debería abortarse al arrancar: %+v", fo.registro.estado)
+	}
+	v := origen.getVehiculo(mat)
+	if v.Sede != "" || origen.plazaDeVehiculo(mat) == nil || origen.visitaAbierta(mat) == nil {
+		t.Errorf("el vehículo debería seguir en el origen: %+v", v)
+	}
+
+	esperarQue(t, "el destino cancela la reserva", func() bool {
+		fd.resolverReservas(time.Now().Add(2 * plazoReserva))
+		return len(destino.plazasOcupadas()) == 0
+	})
+	if destino.getVehiculo(mat) != nil {
+		t.Error("el destino no debería dar entrada al vehículo")
+	}
+}
+
+// El destino se cae tras preparar: al arrancar conserva la reserva y, al
+// preguntar al origen, da entrada al vehículo
+func TestDestinoCaidoEnDudaConoceLaDecision(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	datos := t.TempDir()
+	_, fd := sedeEnFichero(t, "Norte", datos, "127.0.0.1:0", nil, Mecanica)
+	vecinas(fo, fd)
+	mat := vehiculoEsperando(t, origen, "1234 BCD", Mecanica).Matricula
+
+	// Se cae justo cuando el origen ha decidido
+	fo.caida = func(punto string) bool {
+		if punto == caidaTrasDecidir {
+			fd.cerrar()
+		}
+		return false
+	}
+	if _, err := fo.trasladar(mat, "Norte"); err != nil {
+		t.Fatalf("el traslado está decidido aunque el destino no lo sepa: %v", err)
+	}
+	if len(fo.sinConfirmar) != 1 {
+		t.Fatal("la confirmación debería quedar pendiente")
+	}
+
+	destino, fd := sedeEnFichero(t, "Norte", datos, fd.dir, []string{fo.dir})
+	if len(destino.plazasOcupadas()) != 1 || destino.visitaAbierta(mat) != nil || len(fd.reservas) != 1 {
+		t.Fatal("al arrancar el destino debería seguir en duda con la plaza reservada")
+	}
+	fd.resolverReservas(time.Now().Add(time.Second))
+	if destino.visitaAbierta(mat) == nil || fd.registro.fase(fo.registro.orden[0]) != faseRecibido {
+		t.Fatal("el destino debería dar entrada al vehículo al saber la decisión")
+	}
+
+	// La confirmación que el origen tenía pendiente ya no cambia nada
+	esperarQue(t, "el origen termina el traslado", func() bool {
+		fo.reintentarConfirmaciones()
+		return len(fo.sinConfirmar) == 0
+	})
+	if n := len(destino.getVehiculo(mat).Incidencias); n != 1 {
+		t.Errorf("incidencias en el destino: %d, se esperaba 1", n)
+	}
+}
+
+// El destino se cae con el vehículo dentro pero sin apuntarlo: al volver a
+// confirmar no se duplican el vehículo ni sus incidencias
+func TestDestinoCaidoAlRecibirNoDuplica(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	datos := t.TempDir()
+	_, fd := sedeEnFichero(t, "Norte", datos, "127.0.0.1:0", nil, Mecanica)
+	vecinas(fo, fd)
+	mat := vehiculoEsperando(t, origen, "1234 BCD", Mecanica).Matricula
+
+	fd.caida = func(punto string) bool { return punto == caidaAlRecibir }
+	if _, err := fo.trasladar(mat, "Norte"); err != nil {
+		t.Fatal(err)
+	}
+	fd.cerrar()
+
+	destino, fd := sedeEnFichero(t, "Norte", datos, fd.dir, []string{fo.dir})
+	esperarQue(t, "el origen termina el traslado", func() bool {
+		fo.reintentarConfirmaciones()
+		return len(fo.sinConfirmar) == 0
+	})
+	if len(destino.datos().Vehiculos()) != 1 || len(destino.datos().Clientes()) != 1 {
+		t.Errorf("vehículos y clientes duplicados: %d, %d", len(destino.datos().Vehiculos()), len(destino.datos().Clientes()))
+	}
+	if n := len(destino.getVehiculo(mat).Incidencias); n != 1 || len(destino.datos().Incidencias()) != 1 {
+		t.Errorf("incidencias duplicadas en el destino: %d", n)
+	}
+	if len(fd.reservas) != 0 || len(destino.plazasOcupadas()) != 1 {
+		t.Error("el vehículo debería ocupar sólo su plaza")
+	}
+}
+
+// Si el destino tarda más de lo que el origen espera, el origen aborta y el
+// destino acaba soltando la plaza
+func TestReservaSinRespuestaAbortaPorPlazo(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+
+	fo.Espera = 50 * time.Millisecond
+	fd.caida = func(punto string) bool {
+		if punto == caidaAlPreparar {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	}
+	if _, err := fo.trasladar(v.Matricula, "Norte"); err == nil {
+		t.Fatal("el origen no debería esperar más de su plazo")
+	}
+	if fo.registro.fase("Centro-1") != faseAbortado || v.Sede != "" || origen.plazaDeVehiculo(v.Matricula) == nil {
+		t.Errorf("el traslado debería abortarse sin mover el vehículo: %+v", v)
+	}
+
+	fo.Espera = maxEsperaSede
+	esperarQue(t, "el destino suelta la plaza", func() bool {
+		fd.resolverReservas(time.Now().Add(2 * plazoReserva))
+		return len(destino.plazasOcupadas()) == 0
+	})
+	if destino.getVehiculo(v.Matricula) != nil {
+		t.Error("el destino no debería dar entrada al vehículo")
+	}
+}
+
+func TestRegistroTrasladosSobreviveAReabrir(t *testing.T) {
+	ruta := filepath.Join(t.TempDir(), "traslados.jsonl")
+	r, err := abrirRegistroTraslados(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PeticionTraslado{ID: "Centro-1", Matricula: "1234 BCD"}
+	r.apuntar(ApunteTraslado{ID: "Centro-1", Fase: faseIniciado, Peticion: p, Destino: "127.0.0.1:1", Sede: "Norte"})
+	r.apuntar(ApunteTraslado{ID: "Centro-2", Fase: faseIniciado, Peticion: p})
+	r.apuntar(ApunteTraslado{ID: "Centro-1", Fase: faseConfirmado})
+	r.cerrar()
+
+	// Un apunte que se quedó a medias al caerse no cuenta
+	f, _ := os.OpenFile(ruta, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"ID":"Centro-2","Fase":"term`)
+	f.Close()
+
+	r, err = abrirRegistroTraslados(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.apuntar(ApunteTraslado{ID: "Centro-2", Fase: faseAbortado})
+	r.cerrar()
+	if r, err = abrirRegistroTraslados(ruta); err != nil {
+		t.Fatal(err)
+	}
+	defer r.cerrar()
+	a := r.estado["Centro-1"]
+	if a == nil || a.Fase != faseConfirmado || a.Sede != "Norte" || a.Peticion == nil || a.Peticion.Matricula != "1234 BCD" {
+		t.Errorf("apunte recuperado incorrecto: %+v", a)
+	}
+	if r.fase("Centro-2") != faseAbortado || len(r.enFase(faseAbortado)) != 1 {
+		t.Errorf("el apunte a medias no debería estropear el siguiente: %s", r.fase("Centro-2"))
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ LECTURA DE DATOS POR TECLADO ------------
+
+// Entrada lee los datos de los menús línea a línea. Así los nombres con
+// espacios funcionan, los valores erróneos se vuelven a pedir y se puede
+// probar todo desde un io.Reader.
+type Entrada struct {
+	r   *bufio.Reader
+	w   io.Writer
+	fin bool // se llegó al final de la entrada
+
+	// Cerrojo que se suelta mientras se espera al usuario (el del taller en
+	// el menú principal, ver conTaller); nil si no hay ninguno
+	cerrojo sync.Locker
+}
+
+func nuevaEntrada(r io.Reader, w io.Writer) *Entrada {
+	return &Entrada{r: bufio.NewReader(r), w: w}
+}
+
+// Muestra el mensaje y lee una línea sin espacios a los lados.
+// Devuelve io.EOF cuando ya no queda nada que leer.
+func (e *Entrada) Linea(msg string) (string, error) {
+	fmt.Fprint(e.w, msg)
+	if e.fin {
+		return "", io.EOF
+	}
+	if e.cerrojo != nil {
+		e.cerrojo.Unlock()
+	}
+	linea, err := e.r.ReadString('\n')
+	if e.cerrojo != nil {
+		e.cerrojo.Lock()
+	}
+	if errors.Is(err, io.EOF) {
+		e.fin = true
+		if linea == "" {
+			fmt.Fprintln(e.w)
+			return "", io.EOF
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(linea), nil
+}
+
+// Pide un valor hasta que parse lo acepte. Si hay valor por defecto se
+// muestra entre corchetes y una línea vacía lo devuelve tal cual.
+func leerValor[T any](e *Entrada, etiqueta string, porDefecto *T, mostrar string, parse func(string) (T, error)) (T, error) {
+	msg := etiqueta + ": "
+	if porDefecto != nil {
+		msg = fmt.Sprintf("%s [%s]: ", etiqueta, mostrar)
+	}
+	for {
+		linea, err := e.Linea(msg)
+		if err != nil {
+			var cero T
+			return cero, err
+		}
+		if linea == "" && porDefecto != nil {
+			return *porDefecto, nil
+		}
+		v, err := parse(linea)
+		if err == nil {
+			return v, nil
+		}
+		fmt.Fprintf(e.w, "  Valor no válido: %v. Inténtelo de nuevo.\n", err)
+	}
+}
+
+// Texto obligatorio
+func (e *Entrada) Texto(etiqueta string) (string, error) {
+	return leerValor(e, etiqueta, nil, "", func(s string) (string, error) {
+		if s == "" {
+			return "", errors.New("no puede estar vacío")
+		}
+		return s, nil
+	})
+}
+
+// Texto con valor por defecto (o el actual, en las modificaciones)
+func (e *Entrada) TextoDefecto(etiqueta, porDefecto string) (string, error) {
+	if porDefecto == "" {
+		linea, err := e.Linea(etiqueta + ": ")
+		return linea, err
+	}
+	return leerValor(e, etiqueta, &porDefecto, porDefecto, func(s string) (string, error) { return s, nil })
+}
+
+func parsearEntero(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q no es un número entero", s)
+	}
+	return n, nil
+}
+
+// Número entero obligatorio
+func (e *Entrada) Entero(etiqueta string) (int, error) {
+	return leerValor(e, etiqueta, nil, "", parsearEntero)
+}
+
+// Número entero con valor por defecto
+func (e *Entrada) EnteroDefecto(etiqueta string, porDefecto int) (int, error) {
+	return leerValor(e, etiqueta, &porDefecto, strconv.Itoa(porDefecto), parsearEntero)
+}
+
+// Número entero entre min y max (ambos incluidos), por ejemplo una opción de menú
+func (e *Entrada) Opcion(etiqueta string, min, max int) (int, error) {
+	return leerValor(e, etiqueta, nil, "", func(s string) (int, error) {
+		n, err := parsearEntero(s)
+		if err != nil {
+			return 0, err
+		}
+		if n < min || n > max {
+			return 0, fmt.Errorf("debe estar entre %d y %d", min, max)
+		}
+		return n, nil
+	})
+}
+
+// Texto comprobado con una función de validación (matrícula, email...).
+// Con porDefecto vacío el campo es obligatorio.
+func (e *Entrada) Validado(etiqueta, porDefecto string, validar func(string) (string, error)) (string, error) {
+	if porDefecto == "" {
+		return leerValor(e, etiqueta, nil, "", validar)
+	}
+	return leerValor(e, etiqueta, &porDefecto, porDefecto, validar)
+}
+
+// Texto validado en una modificación: una línea vacía deja el valor actual,
+// aunque esté vacío
+func (e *Entrada) ValidadoDefecto(etiqueta, actual string, validar func(string) (string, error)) (string, error) {
+	if actual == "" {
+		return e.ValidadoOpcional(etiqueta, validar)
+	}
+	return leerValor(e, etiqueta, &actual, actual, validar)
+}
+
+// Texto validado que además puede dejarse vacío
+func (e *Entrada) ValidadoOpcional(etiqueta string, validar func(string) (string, error)) (string, error) {
+	return leerValor(e, etiqueta, nil, "", func(s string) (string, error) {
+		if s == "" {
+			return "", nil
+		}
+		return validar(s)
+	})
+}
+
+// Fecha; con porDefecto cero el campo puede dejarse vacío (fecha cero)
+func (e *Entrada) Fecha(etiqueta string, porDefecto time.Time) (time.Time, error) {
+	parse := func(s string) (time.Time, error) { return parsearFecha(strings.ToLower(etiqueta), s) }
+	if porDefecto.IsZero() {
+		return leerValor(e, etiqueta+" (AAAA-MM-DD HH:MM)", nil, "", parse)
+	}
+	return leerValor(e, etiqueta, &porDefecto, porDefecto.Format(formatoFecha), parse)
+}
+
+// Importe en euros (admite coma decimal) con valor por defecto
+func (e *Entrada) Importe(etiqueta string, porDefecto float64) (float64, error) {
+	return leerValor(e, etiqueta, &porDefecto, fmt.Sprintf("%.2f", porDefecto), func(s string) (float64, error) {
+		v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("%q no es un importe válido", s)
+		}
+		return v, nil
+	})
+}
+
+// Pregunta de sí o no
+func (e *Entrada) SiNo(etiqueta string, porDefecto bool) (bool, error) {
+	mostrar := "n"
+	if porDefecto {
+		mostrar = "s"
+	}
+	return leerValor(e, etiqueta+" (s/n)", &porDefecto, mostrar, func(s string) (bool, error) {
+		switch strings.ToLower(s) {
+		case "s", "si", "sí", "1":
+			return true, nil
+		case "n", "no", "0":
+			return false, nil
+		}
+		return false, errors.New("responda s o n")
+	})
+}
+
+// Especialidad de mecánico o tipo de incidencia
+func (e *Entrada) Especialidad(etiqueta string, porDefecto Especialidad) (Especialidad, error) {
+	parse := func(s string) (Especialidad, error) {
+		esp := Especialidad(strings.ToLower(s))
+		if esp != Mecanica && esp != Electrica && esp != Carroceria {
+			return "", errors.New("debe ser 'mecanica', 'electrica' o 'carroceria'")
+		}
+		return esp, nil
+	}
+	etiqueta += " (mecanica / electrica / carroceria)"
+	if porDefecto == "" {
+		return leerValor(e, etiqueta, nil, "", parse)
+	}
+	return leerValor(e, etiqueta, &porDefecto, string(porDefecto), parse)
+}
@@ -0,0 +1,123 @@
+// entrada_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func entradaDePrueba(texto string) (*Entrada, *strings.Builder) {
+	var salida strings.Builder
+	return nuevaEntrada(strings.NewReader(texto), &salida), &salida
+}
+
+func TestEntradaVuelveAPedirValoresErroneos(t *testing.T) {
+	in, salida := entradaDePrueba("abc\n9\n3\n")
+
+	op, err := in.Opcion("Seleccione", 0, 5)
+	if err != nil || op != 3 {
+		t.Fatalf("se esperaba la opción 3, se obtuvo %d (%v)", op, err)
+	}
+	if n := strings.Count(salida.String(), "Seleccione: "); n != 3 {
+		t.Errorf("se esperaba pedir la opción 3 veces, se pidió %d", n)
+	}
+}
+
+func TestEntradaValoresPorDefecto(t *testing.T) {
+	in, salida := entradaDePrueba("\nAna María López\n\n")
+
+	n, _ := in.EnteroDefecto("Vehículos", 5)
+	if n != 5 {
+		t.Errorf("una línea vacía debería devolver el valor por defecto, se obtuvo %d", n)
+	}
+	if !strings.Contains(salida.String(), "Vehículos [5]: ") {
+		t.Errorf("el valor por defecto debería mostrarse entre corchetes: %q", salida.String())
+	}
+
+	// Los nombres con espacios se leen completos
+	nombre, _ := in.TextoDefecto("Nombre", "Ana")
+	if nombre != "Ana María López" {
+		t.Errorf("se esperaba el nombre completo, se obtuvo %q", nombre)
+	}
+
+	// En una modificación, vacío mantiene el valor actual
+	actual := time.Date(2025, 5, 1, 10, 30, 0, 0, time.Local)
+	f, _ := in.Fecha("Fecha entrada", actual)
+	if !f.Equal(actual) {
+		t.Errorf("se esperaba mantener %v, se obtuvo %v", actual, f)
+	}
+}
+
+func TestEntradaFinDeFichero(t *testing.T) {
+	in, _ := entradaDePrueba("x\n")
+
+	// Sin más líneas válidas no puede quedarse pidiendo el número para siempre
+	if _, err := in.Entero("Número"); !errors.Is(err, io.EOF) {
+		t.Fatalf("se esperaba io.EOF, se obtuvo %v", err)
+	}
+	if _, err := in.Texto("Nombre"); !errors.Is(err, io.EOF) {
+		t.Errorf("tras el fin de la entrada debería seguir devolviendo io.EOF, se obtuvo %v", err)
+	}
+}
+
+func TestMenusConEntradaPorLineas(t *testing.T) {
+	taller := &Taller{}
+
+	// Alta de un cliente con nombre compuesto y teléfono erróneo la primera vez
+	in, _ := entradaDePrueba("1\nAna María López\n12\n600 111 222\nana@correo.es\n0\n")
+	menuClientes(taller, in)
+
+	c := taller.getCliente(0)
+	if c == nil || c.Nombre != "Ana María López" || c.Telefono != "+34600111222" {
+		t.Fatalf("cliente mal creado: %+v", c)
+	}
+
+	// Al modificarlo, las líneas vacías dejan lo que había, también el
+	// email de un cliente que no lo tiene; si la entrada se acaba a medias
+	// no se cambia nada
+	sinEmail, _ := taller.newCliente("Pepe", "600333444", "", nil)
+	in, _ = entradaDePrueba(fmt.Sprintf("3\n%d\n\n\n\n0\n", sinEmail.ID))
+	menuClientes(taller, in)
+	if sinEmail.Nombre != "Pepe" || sinEmail.Telefono != "+34600333444" || sinEmail.Email != "" {
+		t.Errorf("el cliente cambia sin tocar nada: %+v", sinEmail)
+	}
+	in, _ = entradaDePrueba(fmt.Sprintf("3\n%d\nJosé\n", sinEmail.ID))
+	menuClientes(taller, in)
+	if sinEmail.Nombre != "Pepe" {
+		t.Errorf("se guarda el cliente con la entrada a medias: %+v", sinEmail)
+	}
+
+	// Modificar un vehículo pide la fecha de salida una sola vez
+	entrada := time.Date(2025, 5, 1, 10, 0, 0, 0, time.Local)
+	if _, err := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", entrada, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	in, salida := entradaDePrueba("3\n1234bcd\n\nLeón\n\n2025-05-02 18:00\n0\n")
+	menuVehiculos(taller, in)
+
+	if n := strings.Count(salida.String(), "Fecha salida"); n != 1 {
+		t.Errorf("la fecha de salida debería pedirse una vez, se pidió %d", n)
+	}
+	v := taller.getVehiculo("1234 BCD")
+	if v.Marca != "Seat" || v.Modelo != "León" {
+		t.Errorf("se esperaba Seat León, se obtuvo %s %s", v.Marca, v.Modelo)
+	}
+	if v.FechaSalida.Format(formatoFecha) != "2025-05-02 18:00" {
+		t.Errorf("fecha de salida incorrecta: %v", v.FechaSalida)
+	}
+
+	// Un alta con la entrada a medias no crea nada
+	in, _ = entradaDePrueba("1\nLuis\nmecanica\n")
+	menuMecanicos(taller, in)
+	if n := len(taller.datos().Mecanicos()); n != 0 {
+		t.Errorf("se crea el mecánico con la entrada a medias (%d mecánicos)", n)
+	}
+
+	// Al acabarse la entrada el menú termina en lugar de repetir sin fin
+	in, _ = entradaDePrueba("")
+	menuMecanicos(taller, in)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ------------ ENTREGA DE TRABAJOS SIN DUPLICADOS ------------
+
+// Un mismo trabajo puede llegar más de una vez a los mecánicos: vuelve a la
+// cola al devolverlo sin tocar, al interrumpirlo o al reclamarlo el
+// vigilante, y un mecánico remoto puede repetir una llamada si se corta la
+// red. La entrega es "al menos una vez", así que cada trabajo lleva un ID y
+// la tabla de entregas apunta quién lo tiene y si ya se terminó:
+//
+//   - Sólo un mecánico a la vez puede empezarlo. Las copias que llegan
+//     mientras lo tiene otro (o ya terminado) se descartan.
+//   - Terminarlo otra vez no vuelve a contar su tiempo en el vehículo ni
+//     acredita la mano de obra a un segundo mecánico.
+//   - Repetir el Terminar de un mecánico remoto responde lo mismo que la
+//     primera vez.
+//
+// Los trabajos sin ID (ID 0) no se controlan.
+
+const sinMecanico = -1
+
+type entrega struct {
+	mecanico  int // quién lo tiene ahora (sinMecanico si está en la cola)
+	terminado bool
+	por       int // quién lo terminó
+}
+
+// Mecánico remoto y concesión de un Terminar
+type claveCierre struct {
+	mecanico, concesion int
+}
+
+type TablaEntregas struct {
+	mu         sync.Mutex
+	nextID     int
+	trabajos   map[int]*entrega
+	cierres    map[claveCierre]bool // lo que respondió cada Terminar
+	duplicados int
+}
+
+func nuevaTablaEntregas() *TablaEntregas {
+	return &TablaEntregas{
+		nextID:   1,
+		trabajos: map[int]*entrega{},
+		cierres:  map[claveCierre]bool{},
+	}
+}
+
+// Trabajo nuevo para la incidencia, con su ID (sin ID si no hay tabla)
+func (te *TablaEntregas) nuevo(v *Vehiculo, inc *Incidencia) Trabajo {
+	tr := Trabajo{Vehiculo: v, Incidencia: inc}
+	if te == nil {
+		return tr
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	tr.ID = te.nextID
+	te.nextID++
+	te.trabajos[tr.ID] = &entrega{mecanico: sinMecanico}
+	return tr
+}
+
+func (te *TablaEntregas) buscar(tr Trabajo) *entrega {
+	if te == nil || tr.ID == 0 {
+		return nil
+	}
+	return te.trabajos[tr.ID]
+}
+
+// El trabajo que acaba de salir de la cola es una copia repetida: lo tiene
+// otro mecánico o ya está terminado
+func (te *TablaEntregas) repetido(tr Trabajo) bool {
+	if te == nil {
+		return false
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	e := te.buscar(tr)
+	if e == nil || !e.terminado && e.mecanico == sinMecanico {
+		return false
+	}
+	te.duplicados++
+	return true
+}
+
+// El mecánico se queda con el trabajo para repararlo. Falla si lo tiene otro
+// o ya está terminado.
+func (te *TablaEntregas) empezar(tr Trabajo, m *Mecanico) bool {
+	if te == nil {
+		return true
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	e := te.buscar(tr)
+	if e == nil {
+		return true
+	}
+	if e.terminado || e.mecanico != sinMecanico && e.mecanico != m.ID {
+		te.duplicados++
+		return false
+	}
+	e.mecanico = m.ID
+	return true
+}
+
+// El trabajo vuelve a la cola: el mecánico ya no lo tiene
+func (te *TablaEntregas) soltar(tr Trabajo, m *Mecanico) {
+	if te == nil {
+		return
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	if e := te.buscar(tr); e != nil && e.mecanico == m.ID {
+		e.mecanico = sinMecanico
+	}
+}
+
+// El mecánico termina el trabajo. Sólo la primera vez, y sólo si lo tenía
+// él, devuelve true: entonces hay que cerrar la incidencia y acreditárselo.
+func (te *TablaEntregas) terminar(tr Trabajo, m *Mecanico) bool {
+	if te == nil {
+		return true
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	e := te.buscar(tr)
+	if e == nil {
+		return true
+	}
+	if e.terminado || e.mecanico != m.ID {
+		te.duplicados++
+		return false
+	}
+	e.terminado = true
+	e.por = m.ID
+	e.mecanico = sinMecanico
+	return true
+}
+
+// Quién terminó el trabajo (false si aún no se ha terminado)
+func (te *TablaEntregas) terminadoPor(tr Trabajo) (int, bool) {
+	if te == nil {
+		return 0, false
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	if e := te.buscar(tr); e != nil && e.terminado {
+		return e.por, true
+	}
+	return 0, false
+}
+
+// Apunta lo que se respondió al Terminar de la concesión del mecánico remoto
+func (te *TablaEntregas) apuntarCierre(mecID, concesion int, cerrada bool) {
+	if te == nil {
+		return
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	te.cierres[claveCierre{mecID, concesion}] = cerrada
+}
+
+// Lo que se respondió al Terminar de la concesión, si ya lo hubo
+func (te *TablaEntregas) cierre(mecID, concesion int) (cerrada, ok bool) {
+	if te == nil {
+		return false, false
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	cerrada, ok = te.cierres[claveCierre{mecID, concesion}]
+	if ok {
+		te.duplicados++
+	}
+	return cerrada, ok
+}
+
+func (te *TablaEntregas) Duplicados() int {
+	if te == nil {
+		return 0
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	return te.duplicados
+}
+
+func (te *TablaEntregas) informe() string {
+	if te == nil {
+		return ""
+	}
+	return fmt.Sprintf("Entregas repetidas de trabajos descartadas: %d\n", te.Duplicados())
+}
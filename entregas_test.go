@@ -0,0 +1,131 @@
+// entregas_test.go
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Varios mecánicos reciben a la vez copias del mismo trabajo: sólo uno lo
+// empieza y sólo a él se le acredita
+func TestSoloUnMecanicoSeQuedaConElTrabajo(t *testing.T) {
+	taller := &Taller{entregas: nuevaTablaEntregas()}
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	if tr.ID == 0 {
+		t.Fatal("el trabajo debería tener ID")
+	}
+	var mecanicos []*Mecanico
+	for i := 0; i < 8; i++ {
+		mecanicos = append(mecanicos, &Mecanico{ID: i})
+	}
+
+	var mu sync.Mutex
+	var empezados []*Mecanico
+	var wg sync.WaitGroup
+	for _, m := range mecanicos {
+		wg.Add(1)
+		go func(m *Mecanico) {
+			defer wg.Done()
+			if taller.entregas.empezar(tr, m) {
+				mu.Lock()
+				empezados = append(empezados, m)
+				mu.Unlock()
+			}
+		}(m)
+	}
+	wg.Wait()
+	if len(empezados) != 1 {
+		t.Fatalf("lo han empezado %d mecánicos", len(empezados))
+	}
+	dueño := empezados[0]
+	if !taller.entregas.repetido(tr) {
+		t.Error("mientras lo tiene uno, las copias son repetidas")
+	}
+
+	// El vigilante se lo quita y lo termina otro: el primero ya no cuenta
+	otro := mecanicos[(dueño.ID+1)%len(mecanicos)]
+	taller.entregas.soltar(tr, dueño)
+	if !taller.entregas.empezar(tr, otro) || !taller.entregas.terminar(tr, otro) {
+		t.Fatal("al volver a la cola otro mecánico debería poder terminarlo")
+	}
+	for _, m := range mecanicos {
+		if taller.entregas.terminar(tr, m) {
+			t.Errorf("el mecánico %d no debería poder terminarlo otra vez", m.ID)
+		}
+	}
+	if por, ok := taller.entregas.terminadoPor(tr); !ok || por != otro.ID {
+		t.Errorf("lo terminó el mecánico %d, no el %d", otro.ID, por)
+	}
+	// Los 7 que no lo empezaron, la copia repetida y los 8 que no lo terminaron
+	if n := taller.entregas.Duplicados(); n != 2*len(mecanicos) {
+		t.Errorf("entregas repetidas: %d", n)
+	}
+}
+
+// El mismo trabajo está tres veces en la cola: se repara una vez, el tiempo
+// del vehículo no se descuenta dos veces y la mano de obra es de uno solo
+func TestTrabajoRepetidoEnLaColaSeHaceUnaVez(t *testing.T) {
+	taller := &Taller{entregas: nuevaTablaEntregas()}
+	m1 := taller.newMecanico("Mec1", "mecanica", 1)
+	m2 := taller.newMecanico("Mec2", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	v, inc := tr.Vehiculo, tr.Incidencia
+	inc.TiempoAcumulado = 1
+	taller.updateTiempoTotalVehiculo(v)
+	for i := 0; i < 3; i++ {
+		taller.colas.encolar(tr)
+	}
+	go trabajoMecanico(m1, taller)
+	go trabajoMecanico(m2, taller)
+
+	esperarQue(t, "se descartan las copias y se cierra la incidencia", func() bool {
+		_, ok := taller.entregas.terminadoPor(tr)
+		return ok && taller.entregas.Duplicados() == 2 && taller.colas.Total() == 0
+	})
+	if inc.Estado != 2 || v.TiempoTotal != 0 {
+		t.Errorf("incidencia mal cerrada: estado %d, tiempo del vehículo %d", inc.Estado, v.TiempoTotal)
+	}
+	if len(inc.ManoDeObra) != 1 || inc.ManoDeObra[0].Horas != horasPorPorcion {
+		t.Errorf("mano de obra acreditada dos veces: %+v", inc.ManoDeObra)
+	}
+}
+
+// Un mecánico remoto repite el Terminar (p.ej. porque no le llegó la
+// respuesta): se le responde lo mismo y no se cierra otra vez
+func TestTerminarRemotoRepetidoRespondeLoMismo(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	taller.entregas = nuevaTablaEntregas()
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 2
+	taller.colas.encolar(tr)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	if err := w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r); err != nil || !r.Hay {
+		t.Fatalf("debería conceder el trabajo: %+v, %v", r, err)
+	}
+	fin := InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 2}
+	for i := 0; i < 3; i++ {
+		var cerrada bool
+		if err := w.cliente.Call(metodoTerminar, fin, &cerrada); err != nil || !cerrada {
+			t.Fatalf("intento %d: debería responder que se cerró: %v, %v", i+1, cerrada, err)
+		}
+	}
+	if n := taller.entregas.Duplicados(); n != 2 {
+		t.Errorf("entregas repetidas: %d, se esperaban 2", n)
+	}
+	if inc := tr.Incidencia; inc.Estado != 2 || len(inc.ManoDeObra) != 1 || inc.ManoDeObra[0].Horas != 2*horasPorPorcion {
+		t.Errorf("cierre repetido: %+v", inc)
+	}
+
+	// Quien no lo tenía sigue sin poder cerrarlo
+	otro := trabajadorDePrueba(t, dir, "Pedro", Mecanica)
+	var cerrada bool
+	if err := otro.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: otro.ID, Concesion: r.Concesion, Hechas: 2}, &cerrada); err == nil {
+		t.Error("un mecánico no puede terminar el trabajo de otro")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ------------ EXCLUSIÓN MUTUA ENTRE NODOS DE ADMISIÓN ------------
+
+// Varios procesos pueden admitir vehículos sobre el mismo directorio de
+// datos (p.ej. un mostrador con el menú y otro con la simulación). Buscar una
+// plaza libre y ocuparla tiene que hacerse de uno en uno o dos nodos ocupan
+// la misma. El turno se reparte con el algoritmo de Ricart–Agrawala:
+//
+//   - Quien quiere entrar pide permiso a todos los demás con una marca de
+//     Lamport y entra cuando todos han respondido.
+//   - Quien recibe una petición responde enseguida salvo que esté dentro, o
+//     que también la haya pedido con una marca anterior (con el ID del nodo
+//     para desempatar): entonces responde al salir.
+//
+// Con net/rpc la respuesta aplazada es la llamada Pedir que no vuelve hasta
+// que se puede responder. Las goroutines de un mismo nodo se turnan entre
+// ellas antes de pedir el turno a los demás.
+
+const esperaAdmision = 10 * time.Second // lo más que se espera el turno
+
+var errSinTurno = errors.New("no se consiguió el turno de las plazas")
+
+type PeticionAdmision struct {
+	Marca int // reloj de Lamport de quien pide
+	Nodo  int
+}
+
+type ExclusionMutua struct {
+	ID     int
+	pares  []string // direcciones de todos los nodos, éste incluido
+	Espera time.Duration
+
+	local sync.Mutex // turno entre las goroutines de este nodo
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	reloj    int
+	pidiendo bool
+	dentro   bool
+	marca    int // la de la petición en curso
+	detenido bool
+	srv      *servidorRPC
+	clientes []*rpc.Client
+}
+
+func nuevaExclusionMutua(id int, pares []string) *ExclusionMutua {
+	e := &ExclusionMutua{ID: id, pares: pares, Espera: esperaAdmision, clientes: make([]*rpc.Client, len(pares))}
+	e.cond = sync.NewCond(&e.mu)
+	return e
+}
+
+// Empieza a atender las peticiones de los demás nodos en su dirección
+func (e *ExclusionMutua) arrancar() error {
+	srv, err := escucharRPC("Admision", e, e.pares[e.ID])
+	if err != nil {
+		return err
+	}
+	e.srv = srv
+	return nil
+}
+
+func (e *ExclusionMutua) detener() {
+	e.mu.Lock()
+	e.detenido = true
+	clientes := e.clientes
+	e.clientes = make([]*rpc.Client, len(e.pares))
+	e.cond.Broadcast()
+	e.mu.Unlock()
+	e.srv.cerrar()
+	for _, c := range clientes {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// Pedir responde a la petición de turno de otro nodo cuando éste no lo
+// necesita o la petición va antes que la suya
+func (e *ExclusionMutua) Pedir(p PeticionAdmision, ok *bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reloj = max(e.reloj, p.Marca) + 1
+	for !e.detenido && (e.dentro || e.pidiendo && antesQue(e.marca, e.ID, p.Marca, p.Nodo)) {
+		e.cond.Wait()
+	}
+	*ok = !e.detenido
+	return nil
+}
+
+// La petición (m1, n1) va antes que (m2, n2)
+func antesQue(m1, n1, m2, n2 int) bool {
+	return m1 < m2 || m1 == m2 && n1 < n2
+}
+
+// Espera el turno de las plazas. Si algún nodo no responde a tiempo no se
+// entra; quien consigue entrar tiene que salir.
+func (e *ExclusionMutua) entrar() error {
+	e.local.Lock()
+	e.mu.Lock()
+	e.reloj++
+	e.marca = e.reloj
+	e.pidiendo = true
+	p := PeticionAdmision{Marca: e.marca, Nodo: e.ID}
+	e.mu.Unlock()
+
+	errs := make(chan error, len(e.pares))
+	for i := range e.pares {
+		if i == e.ID {
+			continue
+		}
+		go func(i int) {
+			var ok bool
+			err := e.llamar(i, "Admision.Pedir", p, &ok)
+			if err == nil && !ok {
+				err = fmt.Errorf("el nodo %d se ha detenido", i)
+			}
+			errs <- err
+		}(i)
+	}
+	var err error
+	for i := 0; i < len(e.pares)-1; i++ {
+		if e2 := <-errs; e2 != nil && err == nil {
+			err = e2
+		}
+	}
+
+	e.mu.Lock()
+	e.pidiendo = false
+	if err == nil {
+		e.dentro = true
+	}
+	e.cond.Broadcast()
+	e.mu.Unlock()
+	if err != nil {
+		e.local.Unlock()
+		return fmt.Errorf("%w: %v", errSinTurno, err)
+	}
+	return nil
+}
+
+// Deja el turno y responde a las peticiones aplazadas
+func (e *ExclusionMutua) salir() {
+	e.mu.Lock()
+	e.dentro = false
+	e.cond.Broadcast()
+	e.mu.Unlock()
+	e.local.Unlock()
+}
+
+func (e *ExclusionMutua) llamar(i int, metodo string, args, respuesta any) error {
+	e.mu.Lock()
+	cliente := e.clientes[i]
+	dir := e.pares[i]
+	e.mu.Unlock()
+	if cliente == nil {
+		conn, err := net.DialTimeout("tcp", dir, e.Espera)
+		if err != nil {
+			return err
+		}
+		cliente = rpc.NewClient(conn)
+		e.mu.Lock()
+		if e.detenido {
+			e.mu.Unlock()
+			cliente.Close()
+			return fmt.Errorf("el nodo %d se ha detenido", e.ID)
+		}
+		if e.clientes[i] != nil {
+			cliente.Close()
+			cliente = e.clientes[i]
+		} else {
+			e.clientes[i] = cliente
+		}
+		e.mu.Unlock()
+	}
+	var err error
+	llamada := cliente.Go(metodo, args, respuesta, make(chan *rpc.Call, 1))
+	select {
+	case <-llamada.Done:
+		err = llamada.Error
+	case <-time.After(e.Espera):
+		err = fmt.Errorf("el nodo %d no responde", i)
+	}
+	var errServidor rpc.ServerError
+	if err != nil && !errors.As(err, &errServidor) {
+		cliente.Close()
+		e.mu.Lock()
+		if e.clientes[i] == cliente {
+			e.clientes[i] = nil
+		}
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// ------------ PLAZAS COMPARTIDAS ------------
+
+// El taller pasa a ser el nodo id de los de admisión en pares: desde ahora
+// las plazas sólo se tocan con el turno
+func (t *Taller) unirAdmision(id int, pares []string) error {
+	if id < 0 || id >= len(pares) {
+		return fmt.Errorf("nodo %d fuera de las %d direcciones de admisión", id, len(pares))
+	}
+	e := nuevaExclusionMutua(id, pares)
+	if err := e.arrancar(); err != nil {
+		return err
+	}
+	if a, ok := t.almacen.(*almacenFichero); ok {
+		a.compartirPlazas()
+	}
+	t.exclusion = e
+	return nil
+}
+
+// Ejecuta fn, que busca, ocupa o libera plazas, con el turno de las plazas.
+// Con el almacén en fichero las plazas se vuelven a leer al entrar, por si
+// las ha cambiado otro nodo, y sólo se escriben mientras se tiene el turno.
+// Si fn abre una transacción tiene que cerrarla dentro.
+func (t *Taller) conPlazas(fn func() error) error {
+	if t.exclusion == nil {
+		return fn()
+	}
+	if err := t.exclusion.entrar(); err != nil {
+		return err
+	}
+	defer t.exclusion.salir()
+	if a, ok := t.almacen.(*almacenFichero); ok {
+		if err := a.tomarPlazas(); err != nil {
+			return err
+		}
+		defer a.soltarPlazas()
+	}
+	return fn()
+}
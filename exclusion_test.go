@@ -0,0 +1,288 @@
+// exclusion_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// n direcciones libres de localhost
+func direccionesLibres(t *testing.T, n int) []string {
+	t.Helper()
+	var dirs []string
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, ln.Addr().String())
+		ln.Close()
+	}
+	return dirs
+}
+
+func exclusionesDePrueba(t *testing.T, n int) []*ExclusionMutua {
+	t.Helper()
+	dirs := direccionesLibres(t, n)
+	var nodos []*ExclusionMutua
+	for i := range dirs {
+		e := nuevaExclusionMutua(i, dirs)
+		if err := e.arrancar(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(e.detener)
+		nodos = append(nodos, e)
+	}
+	return nodos
+}
+
+// Varias goroutines en cada nodo piden el turno a la vez: nunca hay dos
+// dentro y todas acaban entrando
+func TestExclusionMutuaUnoCadaVez(t *testing.T) {
+	nodos := exclusionesDePrueba(t, 3)
+	const porGoroutine = 15
+	var dentro, maximo, entradas atomic.Int32
+	var wg sync.WaitGroup
+	for _, e := range nodos {
+		for g := 0; g < 3; g++ {
+			wg.Add(1)
+			go func(e *ExclusionMutua) {
+				defer wg.Done()
+				for i := 0; i < porGoroutine; i++ {
+					if err := e.entrar(); err != nil {
+						t.Error(err)
+						return
+					}
+					n := dentro.Add(1)
+					if n > maximo.Load() {
+						maximo.Store(n)
+					}
+					time.Sleep(time.Millisecond)
+					dentro.Add(-1)
+					entradas.Add(1)
+					e.salir()
+				}
+			}(e)
+		}
+	}
+	terminado := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(terminado)
+	}()
+	select {
+	case <-terminado:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("no todos consiguen el turno: %d entradas", entradas.Load())
+	}
+	if maximo.Load() != 1 {
+		t.Errorf("ha habido %d nodos dentro a la vez", maximo.Load())
+	}
+	if n := entradas.Load(); n != int32(len(nodos)*3*porGoroutine) {
+		t.Errorf("entradas: %d, se esperaban %d", n, len(nodos)*3*porGoroutine)
+	}
+}
+
+// Quien pidió antes entra antes aunque el otro nodo pida mientras tanto
+func TestExclusionMutuaRespetaElOrden(t *testing.T) {
+	nodos := exclusionesDePrueba(t, 2)
+	if err := nodos[0].entrar(); err != nil {
+		t.Fatal(err)
+	}
+	orden := make(chan int, 2)
+	go func() {
+		if err := nodos[1].entrar(); err == nil {
+			orden <- 1
+			nodos[1].salir()
+		}
+	}()
+	esperarQue(t, "el nodo 1 pide el turno", func() bool {
+		nodos[1].mu.Lock()
+		defer nodos[1].mu.Unlock()
+		return nodos[1].pidiendo
+	})
+	select {
+	case <-orden:
+		t.Fatal("el nodo 1 no debería entrar mientras el 0 está dentro")
+	case <-time.After(50 * time.Millisecond):
+	}
+	orden <- 0
+	nodos[0].salir()
+	if a, b := <-orden, <-orden; a != 0 || b != 1 {
+		t.Errorf("orden de entrada incorrecto: %d, %d", a, b)
+	}
+}
+
+// Si un nodo no responde no se entra, y se puede volver a intentar
+func TestExclusionMutuaNodoCaidoNoDaElTurno(t *testing.T) {
+	nodos := exclusionesDePrueba(t, 3)
+	nodos[2].detener()
+	nodos[0].Espera = 100 * time.Millisecond
+	if err := nodos[0].entrar(); !errors.Is(err, errSinTurno) {
+		t.Fatalf("sin el nodo 2 no se debería entrar: %v", err)
+	}
+	nodos[0].mu.Lock()
+	pidiendo, dentro := nodos[0].pidiendo, nodos[0].dentro
+	nodos[0].mu.Unlock()
+	if pidiendo || dentro {
+		t.Fatal("al fallar no debería quedarse pidiendo ni dentro")
+	}
+
+	// El que falló no bloquea al resto
+	nodos[1].Espera = 100 * time.Millisecond
+	if err := nodos[1].entrar(); !errors.Is(err, errSinTurno) {
+		t.Fatalf("sin el nodo 2 no se debería entrar: %v", err)
+	}
+}
+
+// Tres nodos de admisión sobre el mismo directorio admiten vehículos a la
+// vez (cada uno desde su mostrador): ninguna plaza acaba con dos vehículos
+// ni se pierde ninguna admisión
+func TestAdmisionEnVariosNodosSinPlazasDobles(t *testing.T) {
+	datos := t.TempDir()
+	dirs := direccionesLibres(t, 3)
+	var talleres []*Taller
+	for i := range dirs {
+		taller, err := nuevoTaller("fichero", datos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := taller.unirAdmision(i, dirs); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(taller.exclusion.detener)
+		talleres = append(talleres, taller)
+	}
+	// Las plazas las crea el nodo 0 y los demás las ven al coger el turno
+	talleres[0].newMecanico("Mec1", "mecanica", 1)
+	talleres[0].newMecanico("Mec2", "electrica", 1)
+
+	const porNodo = 4
+	var admitidos atomic.Int32
+	var wg sync.WaitGroup
+	for i, taller := range talleres {
+		c, err := taller.newCliente(fmt.Sprintf("Cliente %d", i), "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vehiculos []*Vehiculo
+		for j := 0; j < porNodo; j++ {
+			v, err := taller.newVehiculo(fmt.Sprintf("%d%03d BCD", i+1, j), "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vehiculos = append(vehiculos, v)
+		}
+		wg.Add(1)
+		go func(taller *Taller) {
+			defer wg.Done()
+			for _, v := range vehiculos {
+				if err := taller.admitirCliente(c.ID, v, 0); err == nil {
+					admitidos.Add(1)
+				}
+			}
+		}(taller)
+	}
+	wg.Wait()
+
+	// Lo que queda en disco es lo que cuenta para todos los nodos
+	var plazas []*Plaza
+	a := &almacenFichero{dir: datos}
+	if _, err := a.leer(ficheroPlazas, &plazas); err != nil {
+		t.Fatal(err)
+	}
+	if len(plazas) != 4 {
+		t.Fatalf("plazas en disco: %d, se esperaban 4", len(plazas))
+	}
+	ocupadas := map[string]int{}
+	for _, p := range plazas {
+		if p.Ocupada {
+			if otra, ok := ocupadas[p.VehiculoMat]; ok {
+				t.Errorf("el vehículo %s ocupa las plazas %d y %d", p.VehiculoMat, otra, p.ID)
+			}
+			ocupadas[p.VehiculoMat] = p.ID
+		}
+	}
+	if int(admitidos.Load()) != len(ocupadas) || len(ocupadas) != 4 {
+		t.Errorf("admitidos %d, plazas ocupadas %d: se esperaban 4", admitidos.Load(), len(ocupadas))
+	}
+
+	// Un nodo que arranca después ve las mismas plazas
+	otro, err := nuevoTaller("fichero", filepath.Clean(datos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(otro.plazasOcupadas()); n != 4 {
+		t.Errorf("un nodo nuevo ve %d plazas ocupadas", n)
+	}
+}
+
+// Con el almacén compartido sólo se escribe con el turno, y al cogerlo se
+// añade lo que hayan escrito los demás nodos
+func TestTurnoFusionaLoDeOtrosNodos(t *testing.T) {
+	datos := t.TempDir()
+	dirs := direccionesLibres(t, 2)
+	var talleres []*Taller
+	for i := range dirs {
+		taller, err := nuevoTaller("fichero", datos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := taller.unirAdmision(i, dirs); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(taller.exclusion.detener)
+		talleres = append(talleres, taller)
+	}
+	uno, dos := talleres[0], talleres[1]
+
+	m := uno.newMecanico("Luis", "mecanica", 1)
+	c, _ := uno.newCliente("Ana", "", "", nil)
+	v, _ := uno.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	inc, _ := uno.newIncidencia(v.Matricula, []*Mecanico{m}, "mecanica", "Alta", "Frenos")
+	if err := uno.admitirCliente(c.ID, v, m.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lo que hace el nodo 2 sin el turno no llega a disco todavía
+	dos.newVehiculo("5678 FGH", "Opel", "Corsa", time.Now(), time.Time{}, nil)
+	var vehiculos []registroVehiculo
+	a := &almacenFichero{dir: datos}
+	if _, err := a.leer(ficheroVehiculos, &vehiculos); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range vehiculos {
+		if r.Matricula == "5678 FGH" {
+			t.Fatal("sin el turno no se escribe")
+		}
+	}
+
+	if err := dos.datos().Sincronizar(); err != nil {
+		t.Fatal(err)
+	}
+	v2 := dos.getVehiculo("1234 BCD")
+	if v2 == nil || len(v2.Incidencias) != 1 || v2.Incidencias[0].ID != inc.ID || len(v2.Incidencias[0].Mecanicos) != 1 {
+		t.Fatalf("el nodo 2 debería ver el vehículo del 1 con su incidencia: %+v", v2)
+	}
+	if c2 := dos.getCliente(c.ID); c2 == nil || len(c2.Vehiculos) != 1 || c2.Vehiculos[0] != v2 {
+		t.Errorf("el cliente del nodo 1 debería apuntar al mismo vehículo: %+v", c2)
+	}
+	if dos.getVehiculo("5678 FGH") == nil || dos.nextIncidenciaID <= inc.ID {
+		t.Errorf("se conservan los datos propios y los contadores no retroceden")
+	}
+
+	// Y lo escrito con el turno lo ve un nodo que arranca después
+	nuevo, err := nuevoTaller("fichero", datos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nuevo.getVehiculo("1234 BCD") == nil || nuevo.getVehiculo("5678 FGH") == nil {
+		t.Error("en disco faltan los vehículos de los dos nodos")
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ------------ TARIFAS Y COSTES ------------
+
+// Precio de la hora de mano de obra (sin IVA) de cada especialidad
+var tarifaHora = map[Especialidad]float64{
+	Mecanica:   40,
+	Electrica:  45,
+	Carroceria: 38,
+}
+
+// Cada año de experiencia encarece la hora un 2%, hasta un 30% como mucho
+const (
+	recargoPorAñoExp = 0.02
+	maxRecargoExp    = 0.30
+)
+
+const tipoIVA = 0.21
+
+// Horas que ha trabajado un mecánico en una incidencia y a qué precio
+type ParteTrabajo struct {
+	MecanicoID int
+	Nombre     string
+	Horas      float64
+	PrecioHora float64
+}
+
+// Línea de un presupuesto o de una factura
+type LineaFactura struct {
+	Concepto string
+	Cantidad float64
+	Precio   float64
+	Importe  float64
+}
+
+func nuevaLinea(concepto string, cantidad, precio float64) LineaFactura {
+	return LineaFactura{Concepto: concepto, Cantidad: cantidad, Precio: precio, Importe: redondear(cantidad * precio)}
+}
+
+// Redondea a céntimos
+func redondear(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// Precio de la hora de un mecánico según su especialidad y experiencia
+func precioHora(m *Mecanico) float64 {
+	recargo := min(float64(max(m.AñosExp, 0))*recargoPorAñoExp, maxRecargoExp)
+	return redondear(tarifaHora[m.Especialidad] * (1 + recargo))
+}
+
+// Apunta en la incidencia las horas que ha trabajado el mecánico en ella
+func (t *Taller) registrarManoDeObra(inc *Incidencia, m *Mecanico, horas float64) {
+	if horas <= 0 {
+		return
+	}
+	for i := range inc.ManoDeObra {
+		if inc.ManoDeObra[i].MecanicoID == m.ID {
+			inc.ManoDeObra[i].Horas += horas
+			return
+		}
+	}
+	inc.ManoDeObra = append(inc.ManoDeObra, ParteTrabajo{
+		MecanicoID: m.ID,
+		Nombre:     m.Nombre,
+		Horas:      horas,
+		PrecioHora: precioHora(m),
+	})
+}
+
+// Coste real de una incidencia: horas trabajadas y piezas que se han sacado
+func (t *Taller) lineasIncidencia(inc *Incidencia) []LineaFactura {
+	var lineas []LineaFactura
+	for _, pt := range inc.ManoDeObra {
+		lineas = append(lineas, nuevaLinea(
+			fmt.Sprintf("Mano de obra %s (%s)", inc.Tipo, pt.Nombre), pt.Horas, pt.PrecioHora))
+	}
+	if inc.PiezasServidas {
+		lineas = append(lineas, t.lineasPiezas(inc)...)
+	}
+	return lineas
+}
+
+// Previsión antes de empezar: el tiempo estimado que falta a la tarifa de la
+// especialidad y todas las piezas que necesita
+func (t *Taller) estimarIncidencia(inc *Incidencia) []LineaFactura {
+	horas := float64(inc.pendiente()) * horasPorPorcion
+	lineas := []LineaFactura{nuevaLinea(fmt.Sprintf("Mano de obra %s (estimada)", inc.Tipo), horas, tarifaHora[inc.Tipo])}
+	return append(lineas, t.lineasPiezas(inc)...)
+}
+
+func (t *Taller) lineasPiezas(inc *Incidencia) []LineaFactura {
+	var lineas []LineaFactura
+	for _, pn := range inc.Piezas {
+		if p := t.getPieza(pn.PiezaID); p != nil {
+			lineas = append(lineas, nuevaLinea(p.Referencia+" "+p.Nombre, float64(pn.Cantidad), p.Precio))
+		}
+	}
+	return lineas
+}
+
+// Base imponible, cuota de IVA y total de unas líneas
+func totales(lineas []LineaFactura) (base, iva, total float64) {
+	for _, l := range lineas {
+		base += l.Importe
+	}
+	base = redondear(base)
+	iva = redondear(base * tipoIVA)
+	return base, iva, redondear(base + iva)
+}
+
+// ------------ PRESUPUESTOS ------------
+
+type EstadoPresupuesto int
+
+const (
+	PresupuestoPendiente EstadoPresupuesto = iota
+	PresupuestoAceptado
+	PresupuestoRechazado
+)
+
+func (e EstadoPresupuesto) String() string {
+	switch e {
+	case PresupuestoPendiente:
+		return "Pendiente"
+	case PresupuestoAceptado:
+		return "Aceptado"
+	case PresupuestoRechazado:
+		return "Rechazado"
+	}
+	return "Desconocido"
+}
+
+// Presupuesto de las incidencias abiertas de un vehículo. Mientras el
+// cliente no lo acepte no se empieza a trabajar en el vehículo.
+type Presupuesto struct {
+	ID          int
+	Matricula   string
+	ClienteID   int // -1 si el vehículo no tiene cliente
+	Fecha       time.Time
+	Incidencias []int
+	Lineas      []LineaFactura
+	Estado      EstadoPresupuesto
+}
+
+// El vehículo está pendiente de que el cliente acepte su presupuesto
+var errPresupuestoSinAceptar = errors.New("presupuesto sin aceptar")
+
+// Último presupuesto del vehículo (nil si no tiene)
+func (t *Taller) ultimoPresupuesto(mat string) *Presupuesto {
+	var ultimo *Presupuesto
+	for _, p := range t.datos().Presupuestos() {
+		if p.Matricula == mat && (ultimo == nil || p.ID > ultimo.ID) {
+			ultimo = p
+		}
+	}
+	return ultimo
+}
+
+// Sólo se puede trabajar en un vehículo cuyo último presupuesto está
+// aceptado y cubre todas sus incidencias abiertas. Un vehículo trasladado a
+// otra sede ya no se repara aquí.
+func (t *Taller) puedeRepararse(v *Vehiculo) error {
+	if v.Sede != "" {
+		return fmt.Errorf("vehículo %s: sede %s: %w", v.Matricula, v.Sede, errEnOtraSede)
+	}
+	p := t.ultimoPresupuesto(v.Matricula)
+	if p == nil {
+		return fmt.Errorf("vehículo %s: sin presupuesto: %w", v.Matricula, errPresupuestoSinAceptar)
+	}
+	if p.Estado != PresupuestoAceptado {
+		return fmt.Errorf("vehículo %s: presupuesto %d %s: %w",
+			v.Matricula, p.ID, strings.ToLower(p.Estado.String()), errPresupuestoSinAceptar)
+	}
+	for _, inc := range v.Incidencias {
+		if inc.Estado != 2 && !slices.Contains(p.Incidencias, inc.ID) {
+			return fmt.Errorf("vehículo %s: la incidencia %d no está en el presupuesto %d: %w",
+				v.Matricula, inc.ID, p.ID, errPresupuestoSinAceptar)
+		}
+	}
+	return nil
+}
+
+func (t *Taller) crearPresupuesto(mat string, ahora time.Time) (*Presupuesto, error) {
+	v := t.getVehiculo(mat)
+	if v == nil {
+		return nil, fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+	}
+	if p := t.ultimoPresupuesto(mat); p != nil && p.Estado == PresupuestoPendiente {
+		return nil, fmt.Errorf("el vehículo %s ya tiene pendiente el presupuesto %d", mat, p.ID)
+	}
+	pr := &Presupuesto{ID: t.nextPresupuestoID, Matricula: mat, ClienteID: -1, Fecha: ahora}
+	if c := t.clienteDeVehiculo(mat); c != nil {
+		pr.ClienteID = c.ID
+	}
+	for _, inc := range v.Incidencias {
+		if inc.Estado != 2 {
+			pr.Incidencias = append(pr.Incidencias, inc.ID)
+			pr.Lineas = append(pr.Lineas, t.estimarIncidencia(inc)...)
+		}
+	}
+	if len(pr.Incidencias) == 0 {
+		return nil, fmt.Errorf("el vehículo %s no tiene incidencias abiertas", mat)
+	}
+	t.nextPresupuestoID++
+	if err := t.datos().GuardarPresupuesto(pr); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// El cliente acepta o rechaza un presupuesto pendiente
+func (t *Taller) responderPresupuesto(id int, aceptar bool) error {
+	p := t.datos().Presupuesto(id)
+	if p == nil {
+		return fmt.Errorf("presupuesto con ID %d no encontrado", id)
+	}
+	if p.Estado != PresupuestoPendiente {
+		return fmt.Errorf("el presupuesto %d ya está %s", id, strings.ToLower(p.Estado.String()))
+	}
+	p.Estado = PresupuestoRechazado
+	if aceptar {
+		p.Estado = PresupuestoAceptado
+	}
+	if err := t.datos().GuardarPresupuesto(p); err != nil {
+		return err
+	}
+	// Lo que los mecánicos apartaron esperando la respuesta vuelve a las colas
+	if aceptar {
+		t.colas.reanudar(p.Matricula)
+	}
+	return nil
+}
+
+// El cliente acepta en el acto el presupuesto de lo que falta por reparar
+// (en la simulación no se le pregunta). Si ya tenía uno pendiente se acepta
+// ése y sólo se hace otro si no cubre todas las incidencias abiertas.
+func (t *Taller) aceptarPresupuesto(v *Vehiculo) (*Presupuesto, error) {
+	if p := t.ultimoPresupuesto(v.Matricula); p != nil && p.Estado == PresupuestoPendiente {
+		if err := t.responderPresupuesto(p.ID, true); err != nil {
+			return nil, err
+		}
+		if t.puedeRepararse(v) == nil {
+			return p, nil
+		}
+	}
+	p, err := t.crearPresupuesto(v.Matricula, t.ahora())
+	if err != nil {
+		return nil, err
+	}
+	if err := t.responderPresupuesto(p.ID, true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ------------ FACTURAS ------------
+
+type Factura struct {
+	Numero      int
+	Fecha       time.Time
+	Matricula   string
+	ClienteID   int // -1 si el vehículo no tiene cliente
+	Cliente     string
+	Incidencias []int
+	Lineas      []LineaFactura
+	Base        float64
+	TipoIVA     float64
+	CuotaIVA    float64
+	Total       float64
+}
+
+func (f *Factura) Codigo() string {
+	return fmt.Sprintf("F-%04d", f.Numero)
+}
+
+// Factura las incidencias cerradas del vehículo que aún no lo estaban.
+// Devuelve nil si no hay nada que facturar.
+func (t *Taller) facturar(v *Vehiculo, ahora time.Time) (*Factura, error) {
+	var pendientes []*Incidencia
+	for _, inc := range v.Incidencias {
+		if inc.Estado == 2 && !inc.Facturada {
+			pendientes = append(pendientes, inc)
+		}
+	}
+	if len(pendientes) == 0 {
+		return nil, nil
+	}
+
+	f := &Factura{Matricula: v.Matricula, ClienteID: -1, Fecha: ahora, TipoIVA: tipoIVA}
+	if c := t.clienteDeVehiculo(v.Matricula); c != nil {
+		f.ClienteID = c.ID
+		f.Cliente = c.Nombre
+	}
+	for _, inc := range pendientes {
+		f.Incidencias = append(f.Incidencias, inc.ID)
+		f.Lineas = append(f.Lineas, t.lineasIncidencia(inc)...)
+	}
+	f.Base, f.CuotaIVA, f.Total = totales(f.Lineas)
+
+	err := t.datos().Transaccion(func() error {
+		t.nextFacturaNum++
+		f.Numero = t.nextFacturaNum
+		for _, inc := range pendientes {
+			inc.Facturada = true
+			if err := t.datos().GuardarIncidencia(inc); err != nil {
+				return err
+			}
+		}
+		return t.datos().GuardarFactura(f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Factura en texto plano, para la consola
+func textoFactura(f *Factura) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FACTURA %s  -  %s\n", f.Codigo(), formatearFecha(f.Fecha))
+	cliente := f.Cliente
+	if cliente == "" {
+		cliente = "(sin cliente)"
+	}
+	fmt.Fprintf(&b, "Cliente: %s   Vehículo: %s\n", cliente, f.Matricula)
+	fmt.Fprintf(&b, "%-40s %8s %10s %10s\n", "Concepto", "Cant.", "Precio", "Importe")
+	for _, l := range f.Lineas {
+		fmt.Fprintf(&b, "%-40s %8.2f %10.2f %10.2f\n", l.Concepto, l.Cantidad, l.Precio, l.Importe)
+	}
+	fmt.Fprintf(&b, "%60s %10.2f €\n", "Base imponible", f.Base)
+	fmt.Fprintf(&b, "%60s %10.2f €\n", fmt.Sprintf("IVA %.0f%%", f.TipoIVA*100), f.CuotaIVA)
+	fmt.Fprintf(&b, "%60s %10.2f €\n", "TOTAL", f.Total)
+	return b.String()
+}
+
+// Página HTML autónoma con la factura, lista para imprimir desde el navegador
+func generarHTMLFactura(f *Factura) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"es\">\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>Factura %s</title>\n", f.Codigo())
+	b.WriteString("<style>body{font-family:sans-serif;margin:20px} table{border-collapse:collapse;width:100%} " +
+		"td,th{border:1px solid #ccc;padding:3px 8px} td.num{text-align:right} tfoot td{font-weight:bold}</style>\n")
+	fmt.Fprintf(&b, "</head>\n<body>\n<h1>Factura %s</h1>\n", f.Codigo())
+	cliente := f.Cliente
+	if cliente == "" {
+		cliente = "(sin cliente)"
+	}
+	fmt.Fprintf(&b, "<p>Fecha: %s<br>Cliente: %s<br>Vehículo: %s</p>\n",
+		formatearFecha(f.Fecha), html.EscapeString(cliente), html.EscapeString(f.Matricula))
+	b.WriteString("<table>\n<tr><th>Concepto</th><th>Cantidad</th><th>Precio</th><th>Importe</th></tr>\n")
+	for _, l := range f.Lineas {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td class=\"num\">%.2f</td><td class=\"num\">%.2f €</td><td class=\"num\">%.2f €</td></tr>\n",
+			html.EscapeString(l.Concepto), l.Cantidad, l.Precio, l.Importe)
+	}
+	b.WriteString("<tfoot>\n")
+	fmt.Fprintf(&b, "<tr><td colspan=\"3\">Base imponible</td><td class=\"num\">%.2f €</td></tr>\n", f.Base)
+	fmt.Fprintf(&b, "<tr><td colspan=\"3\">IVA %.0f%%</td><td class=\"num\">%.2f €</td></tr>\n", f.TipoIVA*100, f.CuotaIVA)
+	fmt.Fprintf(&b, "<tr><td colspan=\"3\">Total</td><td class=\"num\">%.2f €</td></tr>\n", f.Total)
+	b.WriteString("</tfoot>\n</table>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// Escribe la factura como HTML en dir y devuelve la ruta
+func exportarFactura(f *Factura, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	ruta := filepath.Join(dir, "factura-"+f.Codigo()+".html")
+	if err := os.WriteFile(ruta, []byte(generarHTMLFactura(f)), 0o644); err != nil {
+		return "", err
+	}
+	return ruta, nil
+}
+
+// ------------ MOSTRAR Y MENÚ ------------
+
+func printPresupuesto(p *Presupuesto) {
+	base, iva, total := totales(p.Lineas)
+	fmt.Printf("Presupuesto %d - vehículo %s (%s) - %s\n", p.ID, p.Matricula, formatearFecha(p.Fecha), p.Estado)
+	for _, l := range p.Lineas {
+		fmt.Printf("  %-40s %6.2f x %8.2f = %9.2f\n", l.Concepto, l.Cantidad, l.Precio, l.Importe)
+	}
+	fmt.Printf("  Base %.2f € + IVA %.2f € = %.2f €\n", base, iva, total)
+}
+
+func menuFacturacion(t *Taller, in *Entrada) {
+	for {
+		fmt.Println("\n--- PRESUPUESTOS Y FACTURAS ---")
+		fmt.Println("1. Hacer presupuesto de un vehículo")
+		fmt.Println("2. Aceptar o rechazar presupuesto")
+		fmt.Println("3. Listar presupuestos")
+		fmt.Println("4. Coste actual de una incidencia")
+		fmt.Println("5. Listar facturas")
+		fmt.Println("6. Ver factura")
+		fmt.Println("7. Exportar factura a HTML")
+		fmt.Println("0. Volver al menú principal")
+
+		op, err := in.Opcion("Seleccione", 0, 7)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case 1:
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				return
+			}
+			if p, err := t.crearPresupuesto(mat, t.ahora()); err != nil {
+				fmt.Println(err)
+			} else {
+				printPresupuesto(p)
+			}
+		case 2:
+			id, err := in.Entero("ID presupuesto")
+			if err != nil {
+				return
+			}
+			aceptar, err := in.SiNo("¿Lo acepta el cliente?", true)
+			if err != nil {
+				return
+			}
+			if err := t.responderPresupuesto(id, aceptar); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Respuesta registrada.")
+			}
+		case 3:
+			if len(t.datos().Presupuestos()) == 0 {
+				fmt.Println("No hay presupuestos.")
+				break
+			}
+			for _, p := range t.datos().Presupuestos() {
+				printPresupuesto(p)
+				fmt.Println("-----------------------------")
+			}
+		case 4:
+			id, err := in.Entero("ID incidencia")
+			if err != nil {
+				return
+			}
+			inc := t.getIncidencia(id)
+			if inc == nil {
+				fmt.Println("Incidencia no encontrada.")
+				break
+			}
+			base, iva, total := totales(t.lineasIncidencia(inc))
+			fmt.Printf("Coste hasta ahora: %.2f € + IVA %.2f € = %.2f €\n", base, iva, total)
+		case 5:
+			if len(t.datos().Facturas()) == 0 {
+				fmt.Println("No hay facturas.")
+				break
+			}
+			for _, f := range t.datos().Facturas() {
+				fmt.Printf("%s  %s  %-10s %10.2f €\n", f.Codigo(), formatearFecha(f.Fecha), f.Matricula, f.Total)
+			}
+		case 6, 7:
+			num, err := in.Entero("Número de factura")
+			if err != nil {
+				return
+			}
+			f := t.datos().Factura(num)
+			if f == nil {
+				fmt.Printf("Factura %d no encontrada.\n", num)
+				break
+			}
+			if op == 6 {
+				fmt.Print(textoFactura(f))
+				break
+			}
+			dir, err := in.TextoDefecto("Directorio", "facturas")
+			if err != nil {
+				return
+			}
+			if ruta, err := exportarFactura(f, dir); err != nil {
+				fmt.Println("Error exportando la factura:", err)
+			} else {
+				fmt.Println("Factura guardada en", ruta)
+			}
+		case 0:
+			return
+		}
+	}
+}
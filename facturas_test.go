@@ -0,0 +1,281 @@
+// facturas_test.go
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrecioHora(t *testing.T) {
+	casos := []struct {
+		esp    Especialidad
+		años   int
+		precio float64
+	}{
+		{Mecanica, 0, 40},
+		{Mecanica, 5, 44},
+		{Electrica, 10, 54},
+		{Carroceria, 40, 49.4}, // el recargo se queda en el 30%
+	}
+	for _, c := range casos {
+		if p := precioHora(&Mecanico{Especialidad: c.esp, AñosExp: c.años}); math.Abs(p-c.precio) > 0.001 {
+			t.Errorf("%s con %d años: %.2f, se esperaba %.2f", c.esp, c.años, p, c.precio)
+		}
+	}
+}
+
+func TestPresupuestoAntesDeEmpezar(t *testing.T) {
+	taller := &Taller{}
+	filtro, _ := taller.newPieza("FIL", "Filtro", 12.5, 5, 0, 1, 1)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	taller.asignarPiezas(tr.Incidencia.ID, []PiezaNecesaria{{PiezaID: filtro.ID, Cantidad: 2}})
+
+	p, err := taller.crearPresupuesto("V-01", ahoraCitas)
+	if err != nil {
+		t.Fatalf("error haciendo el presupuesto: %v", err)
+	}
+	// 5h de mecánica a 40 € y dos filtros a 12,50 €
+	if base, iva, total := totales(p.Lineas); base != 225 || iva != 47.25 || total != 272.25 {
+		t.Errorf("totales %.2f + %.2f = %.2f; se esperaba 225 + 47,25 = 272,25", base, iva, total)
+	}
+	if _, err := taller.crearPresupuesto("V-01", ahoraCitas); err == nil {
+		t.Error("no debería haber dos presupuestos pendientes del mismo vehículo")
+	}
+
+	if err := taller.updateIncidencia(tr.Incidencia.ID, "", "", "", 1); !errors.Is(err, errPresupuestoSinAceptar) {
+		t.Fatalf("no se puede empezar sin aceptar el presupuesto: %v", err)
+	}
+	if err := taller.responderPresupuesto(p.ID, true); err != nil {
+		t.Fatalf("error aceptando: %v", err)
+	}
+	if err := taller.responderPresupuesto(p.ID, false); err == nil {
+		t.Error("un presupuesto ya aceptado no se puede volver a responder")
+	}
+	if err := taller.updateIncidencia(tr.Incidencia.ID, "", "", "", 1); err != nil {
+		t.Errorf("con el presupuesto aceptado se puede empezar: %v", err)
+	}
+}
+
+func TestFacturaAlLiberarPlaza(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Luis", "electrica", 10) // 45 € + 20% = 54 €/h
+	bateria, _ := taller.newPieza("BAT", "Batería", 95, 1, 0, 1, 1)
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	v, _ := taller.newVehiculo("M-1111", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.admitirCliente(cli.ID, v, m.ID)
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "electrica", "Alta", "No arranca")
+	taller.asignarPiezas(inc.ID, []PiezaNecesaria{{PiezaID: bateria.ID, Cantidad: 1}})
+	taller.servirPiezas(inc)
+	taller.registrarManoDeObra(inc, m, 1.5)
+	taller.registrarManoDeObra(inc, m, 0.5)
+	for _, i := range v.Incidencias {
+		i.Estado = 2
+	}
+
+	taller.liberarPlaza(v)
+	facturas := taller.datos().Facturas()
+	if len(facturas) != 1 {
+		t.Fatalf("se esperaba una factura, hay %d", len(facturas))
+	}
+	f := facturas[0]
+	if f.Numero != 1 || f.Cliente != "Pepe" || len(f.Lineas) != 2 {
+		t.Errorf("factura mal formada: %+v", f)
+	}
+	// 2h a 54 € más la batería
+	if f.Base != 203 || f.CuotaIVA != 42.63 || f.Total != 245.63 {
+		t.Errorf("totales %.2f + %.2f = %.2f; se esperaba 203 + 42,63 = 245,63", f.Base, f.CuotaIVA, f.Total)
+	}
+	if !inc.Facturada {
+		t.Error("la incidencia debería quedar facturada")
+	}
+	if p := taller.plazaDeVehiculo(v.Matricula); p != nil {
+		t.Errorf("la plaza %d debería estar libre", p.ID)
+	}
+
+	// Volver a liberar no factura dos veces lo mismo
+	taller.liberarPlaza(v)
+	if len(taller.datos().Facturas()) != 1 {
+		t.Error("no se debe facturar dos veces la misma incidencia")
+	}
+}
+
+// Un mecánico de la simulación apunta sus horas y al terminar se factura
+func TestSimulacionFacturaLasHorasTrabajadas(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 5)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 1
+	taller.updateTiempoTotalVehiculo(tr.Vehiculo)
+	taller.colas.encolar(tr)
+	go trabajoMecanico(m, taller)
+
+	// La factura se emite justo después de avisar de que ha terminado
+	var facturas []*Factura
+	esperarQue(t, "que se emita la factura", func() bool {
+		taller.conTaller(func() { facturas = taller.datos().Facturas() })
+		return len(facturas) > 0
+	})
+	if l := facturas[0].Lineas; len(l) != 1 || l[0].Cantidad != 1 || l[0].Precio != precioHora(m) {
+		t.Errorf("líneas incorrectas: %+v", l)
+	}
+}
+
+func TestSinPresupuestoAceptadoNoSeRepara(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	resultados := resultadosDePrueba(taller)
+
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	p, _ := taller.crearPresupuesto("V-01", time.Now())
+	taller.colas.encolar(tr)
+	go trabajoMecanico(m, taller)
+
+	select {
+	case msg := <-resultados:
+		if !strings.Contains(msg.Texto, "no atiende") {
+			t.Errorf("mensaje inesperado: %s", msg.Texto)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("el mecánico no rechazó el trabajo")
+	}
+	if estadoDe(taller, tr.Incidencia) != 0 || taller.colas.Total() != 0 {
+		t.Errorf("la incidencia no debería haberse tocado ni volver a la cola")
+	}
+
+	// El trabajo queda apartado hasta que el cliente acepta
+	taller.conTaller(func() {
+		if err := taller.responderPresupuesto(p.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	esperarQue(t, "que se repare al aceptar el presupuesto", func() bool { return estadoDe(taller, tr.Incidencia) == 2 })
+}
+
+func TestPresupuestoCubreLasIncidenciasAbiertas(t *testing.T) {
+	taller := &Taller{}
+	v, _ := taller.newVehiculo("V-01", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	if err := taller.puedeRepararse(v); !errors.Is(err, errPresupuestoSinAceptar) {
+		t.Fatalf("sin presupuesto no se repara: %v", err)
+	}
+	p, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+	taller.responderPresupuesto(p.ID, true)
+	if err := taller.puedeRepararse(v); err != nil {
+		t.Fatalf("con el presupuesto aceptado se repara: %v", err)
+	}
+
+	// Una incidencia nueva necesita otro presupuesto
+	taller.newIncidencia(v.Matricula, nil, "electrica", "Alta", "Luces")
+	if err := taller.puedeRepararse(v); !errors.Is(err, errPresupuestoSinAceptar) {
+		t.Errorf("la incidencia nueva no está presupuestada: %v", err)
+	}
+}
+
+// Un vehículo que vuelve con un presupuesto pendiente no se queda parado:
+// se acepta ése y otro para las incidencias nuevas
+func TestARepararConPresupuestoPendiente(t *testing.T) {
+	taller := &Taller{}
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	v, _ := taller.newVehiculo("V-01", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	pendiente, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "electrica", "Alta", "Luces")
+	taller.aReparar(v, []*Incidencia{inc}, taller.origenGenerador())
+	if pendiente.Estado != PresupuestoAceptado {
+		t.Errorf("el presupuesto pendiente está %s", pendiente.Estado)
+	}
+	if err := taller.puedeRepararse(v); err != nil {
+		t.Errorf("el vehículo debería poder repararse: %v", err)
+	}
+	if taller.colas.Longitud(Electrica) != 1 {
+		t.Errorf("la incidencia nueva debería estar en la cola")
+	}
+
+	// Si no se puede presupuestar no se encola nada
+	otro, _ := taller.newVehiculo("V-02", "Seat", "León", time.Now(), time.Time{}, nil)
+	taller.aReparar(otro, nil, taller.origenGenerador())
+	if taller.colas.Total() != 1 {
+		t.Errorf("no debería encolarse nada sin presupuesto")
+	}
+}
+
+func TestExportarFactura(t *testing.T) {
+	f := &Factura{
+		Numero:    7,
+		Fecha:     ahoraCitas,
+		Matricula: "M-1111",
+		Cliente:   "Ana <Pérez>",
+		Lineas:    []LineaFactura{nuevaLinea("Mano de obra", 2, 40)},
+		TipoIVA:   tipoIVA,
+	}
+	f.Base, f.CuotaIVA, f.Total = totales(f.Lineas)
+	if txt := textoFactura(f); !strings.Contains(txt, "F-0007") || !strings.Contains(txt, "96.80") {
+		t.Errorf("texto de la factura incompleto:\n%s", txt)
+	}
+
+	ruta, err := exportarFactura(f, t.TempDir())
+	if err != nil {
+		t.Fatalf("error exportando: %v", err)
+	}
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		t.Fatalf("no se pudo leer la factura: %v", err)
+	}
+	contenido := string(datos)
+	if !strings.Contains(contenido, "Ana &lt;Pérez&gt;") || !strings.Contains(contenido, "96.80 €") {
+		t.Errorf("HTML sin escapar o sin total:\n%s", contenido)
+	}
+}
+
+func TestFacturasSePersisten(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo abrir el almacén: %v", err)
+	}
+	m := taller.newMecanico("Luis", "mecanica", 0)
+	v, _ := taller.newVehiculo("M-1111", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Revisión")
+	p, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+	taller.responderPresupuesto(p.ID, true)
+	taller.registrarManoDeObra(inc, m, 3)
+	inc.Estado = 2
+	f, err := taller.facturar(v, time.Now())
+	if err != nil || f == nil {
+		t.Fatalf("error facturando: %v", err)
+	}
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo reabrir el almacén: %v", err)
+	}
+	f2 := recargado.datos().Factura(f.Numero)
+	if f2 == nil || f2.Total != f.Total || len(f2.Lineas) != 1 {
+		t.Fatalf("factura no recuperada: %+v", f2)
+	}
+	if p2 := recargado.datos().Presupuesto(p.ID); p2 == nil || p2.Estado != PresupuestoAceptado {
+		t.Errorf("presupuesto no recuperado: %+v", p2)
+	}
+	inc2 := recargado.getIncidencia(inc.ID)
+	if inc2 == nil || !inc2.Facturada || len(inc2.ManoDeObra) != 1 || inc2.ManoDeObra[0].Horas != 3 {
+		t.Errorf("mano de obra no recuperada: %+v", inc2)
+	}
+	v2 := recargado.getVehiculo(v.Matricula)
+	inc3, _ := recargado.newIncidencia(v2.Matricula, nil, "mecanica", "Alta", "Otra")
+	inc3.Estado = 2
+	if f3, _ := recargado.facturar(v2, time.Now()); f3 == nil || f3.Numero != f.Numero+1 {
+		t.Errorf("la numeración de facturas no continúa: %+v", f3)
+	}
+}
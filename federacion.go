@@ -0,0 +1,940 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ FEDERACIÓN DE TALLERES ------------
+
+// Los talleres de la empresa (sedes) se conocen por su dirección y se pasan
+// por net/rpc su carga: plazas libres, mecánicos y horas de trabajo abierto
+// de cada especialidad. Un vehículo que espera en su plaza sin que nadie
+// trabaje en él puede trasladarse con sus incidencias abiertas a otra sede
+// con sitio. El traslado es un compromiso en dos fases (ver dospc.go) para
+// que el vehículo nunca sea de dos sedes ni se pierda. Si una reserva lleva
+// más de plazoReserva sin confirmar, el destino pregunta al origen qué
+// decidió (Decision).
+//
+// Los métodos exportados son los del servicio RPC "Sede".
+
+const (
+	metodoCarga     = "Sede.Carga"
+	metodoReservar  = "Sede.Reservar"
+	metodoConfirmar = "Sede.Confirmar"
+	metodoCancelar  = "Sede.Cancelar"
+	metodoDecision  = "Sede.Decision"
+)
+
+const (
+	intervaloCarga = 2 * time.Second  // cada cuánto se pregunta la carga a las vecinas
+	plazoReserva   = 10 * time.Second // lo que espera el destino antes de preguntar al origen
+	maxEsperaSede  = 5 * time.Second  // lo más que se espera por defecto la respuesta de otra sede
+)
+
+// Respuestas de Decision
+const (
+	trasladoConfirmado = "confirmado"
+	trasladoCancelado  = "cancelado"
+)
+
+var (
+	errEnOtraSede        = errors.New("el vehículo está en otra sede")
+	errSinSitio          = errors.New("no hay sitio para el vehículo")
+	errNoEnEspera        = errors.New("el vehículo no está esperando en el taller")
+	errSedeDesconocida   = errors.New("sede desconocida")
+	errTrasladoCancelado = errors.New("el traslado se ha cancelado")
+)
+
+// Carga de una sede
+type Carga struct {
+	Sede         string
+	Direccion    string
+	PlazasLibres int
+	Mecanicos    map[Especialidad]int // mecánicos de cada especialidad
+	Pendiente    map[Especialidad]int // horas de incidencias abiertas de cada especialidad
+}
+
+// Lo que viaja con el vehículo de una sede a otra
+type PeticionTraslado struct {
+	ID          string
+	Origen      string // dirección de la sede de origen
+	Matricula   string
+	Marca       string
+	Modelo      string
+	Cliente     ClienteTrasladado // sin nombre si el vehículo no tiene cliente
+	Incidencias []IncidenciaTrasladada
+}
+
+type ClienteTrasladado struct {
+	Nombre   string
+	Telefono string
+	Email    string
+}
+
+type IncidenciaTrasladada struct {
+	Tipo            Especialidad
+	Prioridad       string
+	Descripcion     string
+	TiempoAcumulado int // lo que falta de la reparación
+}
+
+// Pregunta del destino al origen por un traslado sin confirmar
+type ConsultaDecision struct {
+	ID        string
+	Matricula string
+	Destino   string // nombre de la sede que pregunta
+}
+
+// Otra sede de la federación y lo último que se sabe de ella
+type vecina struct {
+	dir         string
+	cliente     *rpc.Client
+	carga       Carga
+	actualizada time.Time
+}
+
+// Plaza reservada para un vehículo que llega de otra sede
+type reserva struct {
+	p       PeticionTraslado
+	plazaID int
+	vence   time.Time
+}
+
+type Federacion struct {
+	t            *Taller
+	Nombre       string
+	mu           sync.Mutex
+	Espera       time.Duration // lo más que se espera la respuesta de otra sede
+	dir          string        // dirección en la que escucha
+	srv          *servidorRPC
+	vecinas      []*vecina
+	nextTraslado int
+	registro     *RegistroTraslados
+	caida        func(punto string) bool // los tests simulan caídas (ver dospc.go)
+
+	// Traslados que salen de esta sede
+	enCurso      map[string]string // matrícula -> ID del traslado sin decidir
+	sinConfirmar map[string]string // decididos que el destino aún no sabe: ID -> dirección
+
+	// Traslados que llegan a esta sede, sin confirmar
+	reservas map[string]*reserva
+}
+
+func nuevaFederacion(t *Taller, nombre string, vecinas []string) *Federacion {
+	f := &Federacion{
+		t:            t,
+		Nombre:       nombre,
+		Espera:       maxEsperaSede,
+		registro:     &RegistroTraslados{estado: map[string]*ApunteTraslado{}},
+		enCurso:      map[string]string{},
+		sinConfirmar: map[string]string{},
+		reservas:     map[string]*reserva{},
+	}
+	for _, dir := range vecinas {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			f.vecinas = append(f.vecinas, &vecina{dir: dir})
+		}
+	}
+	return f
+}
+
+// Empieza a atender a las otras sedes en dir. Devuelve la dirección en la
+// que escucha, que es la que se da a las vecinas.
+func (f *Federacion) escuchar(dir string) (net.Addr, error) {
+	srv, err := escucharRPC("Sede", f, dir)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.srv = srv
+	f.dir = srv.ln.Addr().String()
+	f.mu.Unlock()
+	return srv.ln.Addr(), nil
+}
+
+func (f *Federacion) cerrar() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.srv.cerrar()
+	f.registro.cerrar()
+	for _, vc := range f.vecinas {
+		if vc.cliente != nil {
+			vc.cliente.Close()
+			vc.cliente = nil
+		}
+	}
+}
+
+// Cada intervaloCarga pregunta la carga a las vecinas, resuelve las reservas
+// vencidas y reintenta las confirmaciones pendientes
+func (f *Federacion) ejecutar(parar <-chan struct{}) {
+	if f == nil {
+		return
+	}
+	tic := time.NewTicker(intervaloCarga)
+	defer tic.Stop()
+	for {
+		f.actualizarCargas()
+		select {
+		case ahora := <-tic.C:
+			f.resolverReservas(ahora)
+			f.reintentarConfirmaciones()
+		case <-parar:
+			return
+		}
+	}
+}
+
+// ------------ CARGA ------------
+
+func (t *Taller) carga() Carga {
+	c := Carga{Mecanicos: map[Especialidad]int{}, Pendiente: map[Especialidad]int{}}
+	for _, m := range t.datos().Mecanicos() {
+		c.Mecanicos[m.Especialidad]++
+	}
+	for _, p := range t.datos().Plazas() {
+		if !p.Ocupada {
+			c.PlazasLibres++
+			continue
+		}
+		if v := t.getVehiculo(p.VehiculoMat); v != nil {
+			for _, inc := range v.Incidencias {
+				if inc.Estado != 2 {
+					c.Pendiente[inc.Tipo] += inc.pendiente()
+				}
+			}
+		}
+	}
+	return c
+}
+
+// Carga devuelve la carga de esta sede
+func (f *Federacion) Carga(_ struct{}, c *Carga) error {
+	f.t.conTaller(func() { *c = f.t.carga() })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.Sede, c.Direccion = f.Nombre, f.dir
+	return nil
+}
+
+// Pregunta la carga a todas las vecinas (las que no responden se quedan con
+// la última que se supo)
+func (f *Federacion) actualizarCargas() {
+	f.mu.Lock()
+	vecinas := append([]*vecina(nil), f.vecinas...)
+	f.mu.Unlock()
+	for _, vc := range vecinas {
+		var c Carga
+		if err := f.llamar(vc, metodoCarga, struct{}{}, &c); err != nil {
+			continue
+		}
+		f.mu.Lock()
+		vc.carga, vc.actualizada = c, time.Now()
+		f.mu.Unlock()
+	}
+}
+
+// Vecina por nombre de sede o por dirección
+func (f *Federacion) vecina(sede string) *vecina {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, vc := range f.vecinas {
+		if vc.carga.Sede == sede || vc.dir == sede {
+			return vc
+		}
+	}
+	return nil
+}
+
+// Vecina en dir; si no se conocía pasa a serlo
+func (f *Federacion) vecinaEn(dir string) *vecina {
+	if vc := f.vecina(dir); vc != nil {
+		return vc
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vc := &vecina{dir: dir}
+	f.vecinas = append(f.vecinas, vc)
+	return vc
+}
+
+// Horas de trabajo abierto de cada especialidad del vehículo
+func trabajoAbierto(v *Vehiculo) map[Especialidad]int {
+	horas := map[Especialidad]int{}
+	for _, inc := range v.Incidencias {
+		if inc.Estado != 2 {
+			horas[inc.Tipo] += inc.pendiente()
+		}
+	}
+	return horas
+}
+
+// La vecina con sitio para el vehículo que menos tardaría en repararlo
+// (horas pendientes por mecánico de las especialidades que necesita)
+func (f *Federacion) elegirSede(mat string, necesita map[Especialidad]int) (*vecina, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var mejor *vecina
+	mejorEspera := 0.0
+	for _, vc := range f.vecinas {
+		c := vc.carga
+		if time.Since(vc.actualizada) > 3*intervaloCarga || c.PlazasLibres == 0 {
+			continue
+		}
+		espera, sirve := 0.0, true
+		for esp, horas := range necesita {
+			if c.Mecanicos[esp] == 0 {
+				sirve = false
+				break
+			}
+			espera += float64(c.Pendiente[esp]+horas) / float64(c.Mecanicos[esp])
+		}
+		if sirve && (mejor == nil || espera < mejorEspera) {
+			mejor, mejorEspera = vc, espera
+		}
+	}
+	if mejor == nil {
+		return nil, fmt.Errorf("vehículo %s: ninguna sede: %w", mat, errSinSitio)
+	}
+	return mejor, nil
+}
+
+// Llama a la vecina; si no responde o se cae la conexión la cierra para
+// volver a conectar en la siguiente llamada
+func (f *Federacion) llamar(vc *vecina, metodo string, args, respuesta any) error {
+	f.mu.Lock()
+	cliente := vc.cliente
+	f.mu.Unlock()
+	if cliente == nil {
+		conn, err := net.DialTimeout("tcp", vc.dir, f.Espera)
+		if err != nil {
+			return fmt.Errorf("no se pudo conectar con la sede %s: %w", vc.dir, err)
+		}
+		cliente = rpc.NewClient(conn)
+		f.mu.Lock()
+		vc.cliente = cliente
+		f.mu.Unlock()
+	}
+
+	var err error
+	llamada := cliente.Go(metodo, args, respuesta, make(chan *rpc.Call, 1))
+	select {
+	case <-llamada.Done:
+		err = llamada.Error
+	case <-time.After(f.Espera):
+		err = fmt.Errorf("la sede %s no responde", vc.dir)
+	}
+	var errServidor rpc.ServerError
+	if err != nil && !errors.As(err, &errServidor) {
+		cliente.Close()
+		f.mu.Lock()
+		if vc.cliente == cliente {
+			vc.cliente = nil
+		}
+		f.mu.Unlock()
+	}
+	return err
+}
+
+// ------------ TRASLADOS SALIENTES ------------
+
+// Vehículo en una plaza de esta sede con incidencias abiertas en las que no
+// trabaja nadie. Se llama con el taller cogido.
+func (t *Taller) esperaEnSede(v *Vehiculo) error {
+	if v.Sede != "" {
+		return fmt.Errorf("vehículo %s: sede %s: %w", v.Matricula, v.Sede, errEnOtraSede)
+	}
+	if t.plazaDeVehiculo(v.Matricula) == nil {
+		return fmt.Errorf("vehículo %s: no está en ninguna plaza: %w", v.Matricula, errNoEnEspera)
+	}
+	abiertas := 0
+	for _, inc := range v.Incidencias {
+		switch inc.Estado {
+		case 1:
+			return fmt.Errorf("vehículo %s: se está reparando: %w", v.Matricula, errNoEnEspera)
+		case 0:
+			abiertas++
+		}
+	}
+	if abiertas == 0 {
+		return fmt.Errorf("vehículo %s: no tiene incidencias abiertas: %w", v.Matricula, errNoEnEspera)
+	}
+	return nil
+}
+
+// Vehículos que podrían trasladarse a otra sede. Se llama con el taller
+// cogido.
+func (f *Federacion) vehiculosEnEspera() []*Vehiculo {
+	var lista []*Vehiculo
+	for _, v := range f.t.datos().Vehiculos() {
+		if f.t.esperaEnSede(v) == nil {
+			lista = append(lista, v)
+		}
+	}
+	return lista
+}
+
+func (f *Federacion) peticionTraslado(id string, v *Vehiculo) PeticionTraslado {
+	p := PeticionTraslado{ID: id, Origen: f.dir, Matricula: v.Matricula, Marca: v.Marca, Modelo: v.Modelo}
+	if c := f.t.clienteDeVehiculo(v.Matricula); c != nil {
+		p.Cliente = ClienteTrasladado{Nombre: c.Nombre, Telefono: c.Telefono, Email: c.Email}
+	}
+	for _, inc := range v.Incidencias {
+		if inc.Estado == 0 {
+			p.Incidencias = append(p.Incidencias, IncidenciaTrasladada{
+				Tipo:            inc.Tipo,
+				Prioridad:       inc.Prioridad,
+				Descripcion:     inc.Descripcion,
+				TiempoAcumulado: inc.pendiente(),
+			})
+		}
+	}
+	return p
+}
+
+// Traslada el vehículo a la sede indicada (por nombre o dirección) o, si no
+// se indica, a la que menos tardaría en repararlo. Devuelve el nombre de la
+// sede de destino. Ver dospc.go para el protocolo. El taller se coge sólo
+// para lo que se hace en esta sede, nunca mientras se espera a otra.
+func (f *Federacion) trasladar(mat, sede string) (string, error) {
+	t := f.t
+	var v *Vehiculo
+	var necesita map[Especialidad]int
+	var err error
+	t.conTaller(func() {
+		if v = t.getVehiculo(mat); v == nil {
+			err = fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+		} else if err = t.esperaEnSede(v); err == nil {
+			necesita = trabajoAbierto(v)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	f.actualizarCargas()
+	var vc *vecina
+	if sede == "" {
+		if vc, err = f.elegirSede(mat, necesita); err != nil {
+			return "", err
+		}
+	} else if vc = f.vecina(sede); vc == nil || vc.carga.Sede == "" {
+		return "", fmt.Errorf("%s: %w", sede, errSedeDesconocida)
+	}
+
+	var p PeticionTraslado
+	var apunte ApunteTraslado
+	t.conTaller(func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if err = t.esperaEnSede(v); err != nil {
+			return
+		}
+		if f.enCurso[v.Matricula] != "" {
+			err = fmt.Errorf("vehículo %s: ya se está trasladando", v.Matricula)
+			return
+		}
+		f.nextTraslado++
+		p = f.peticionTraslado(fmt.Sprintf("%s-%d", f.Nombre, f.nextTraslado), v)
+		for f.registro.fase(p.ID) != "" {
+			// Tras reiniciar el contador vuelve a empezar
+			f.nextTraslado++
+			p.ID = fmt.Sprintf("%s-%d", f.Nombre, f.nextTraslado)
+		}
+		apunte = ApunteTraslado{ID: p.ID, Fase: faseIniciado, Peticion: &p, Destino: vc.dir, Sede: vc.carga.Sede}
+		if err = f.registro.apuntar(apunte); err != nil {
+			return
+		}
+		f.enCurso[v.Matricula] = p.ID
+	})
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		f.mu.Lock()
+		delete(f.enCurso, v.Matricula)
+		f.mu.Unlock()
+	}()
+	if f.cae(caidaTrasIniciar) {
+		return "", errCaida
+	}
+
+	// Primera fase: el destino reserva la plaza
+	var ok bool
+	if err := f.llamar(vc, metodoReservar, p, &ok); err != nil {
+		f.abortar(vc, p.ID)
+		return "", fmt.Errorf("la sede %s no acepta el vehículo %s: %w", apunte.Sede, v.Matricula, err)
+	}
+	if f.cae(caidaTrasPreparar) {
+		return "", errCaida
+	}
+
+	// Decisión: a partir de aquí el vehículo es del destino. Se decide y se
+	// aplica con el taller cogido para que nadie empiece a repararlo entre
+	// medias.
+	caido := false
+	t.conTaller(func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if err = f.decidir(apunte, v); err != nil {
+			return
+		}
+		if caido = f.cae(caidaTrasDecidir); caido {
+			return
+		}
+		if err := f.aplicarTraslado(apunte); err != nil {
+			// La decisión ya está tomada: se rehará al volver a arrancar
+			t.avisar("Error aplicando el traslado %s: %v", p.ID, err)
+		}
+		t.avisar("Vehículo %s trasladado a la sede %s (traslado %s)", v.Matricula, apunte.Sede, p.ID)
+	})
+	if err != nil {
+		f.abortar(vc, p.ID)
+		return "", err
+	}
+	if caido {
+		return "", errCaida
+	}
+	if f.cae(caidaTrasAplicar) {
+		return "", errCaida
+	}
+
+	// Segunda fase: el destino da entrada al vehículo
+	if err := f.confirmar(vc, p.ID); err != nil {
+		f.mu.Lock()
+		f.sinConfirmar[p.ID] = vc.dir
+		f.mu.Unlock()
+		t.avisar("La sede %s no ha confirmado el traslado %s: se reintentará (%v)", apunte.Sede, p.ID, err)
+	}
+	return apunte.Sede, nil
+}
+
+// Apunta la decisión de confirmar el traslado, salvo que el destino lo haya
+// abortado ya preguntando por él o alguien haya empezado a reparar el
+// vehículo. Se llama con el taller y f.mu cogidos.
+func (f *Federacion) decidir(a ApunteTraslado, v *Vehiculo) error {
+	if f.registro.fase(a.ID) == faseAbortado {
+		return fmt.Errorf("traslado %s: %w", a.ID, errTrasladoCancelado)
+	}
+	if err := f.t.esperaEnSede(v); err != nil {
+		return err
+	}
+	return f.registro.apuntar(ApunteTraslado{ID: a.ID, Fase: faseConfirmado})
+}
+
+// Aborta el traslado y se lo dice al destino. Si no se entera ya preguntará.
+func (f *Federacion) abortar(vc *vecina, id string) {
+	f.mu.Lock()
+	if f.registro.fase(id) == faseIniciado {
+		if err := f.registro.apuntar(ApunteTraslado{ID: id, Fase: faseAbortado}); err != nil {
+			f.t.avisar("Error apuntando el traslado %s: %v", id, err)
+		}
+	}
+	f.mu.Unlock()
+	var ok bool
+	f.llamar(vc, metodoCancelar, id, &ok)
+}
+
+// Confirma el traslado al destino y, si lo recibe, lo da por terminado
+func (f *Federacion) confirmar(vc *vecina, id string) error {
+	var ok bool
+	if err := f.llamar(vc, metodoConfirmar, id, &ok); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sinConfirmar, id)
+	return f.registro.apuntar(ApunteTraslado{ID: id, Fase: faseTerminado})
+}
+
+// Decision dice al destino si el traslado se confirmó. Lo que no está
+// decidido se aborta en ese momento; lo que no se conoce, también.
+func (f *Federacion) Decision(c ConsultaDecision, r *string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch f.registro.fase(c.ID) {
+	case faseConfirmado, faseTerminado:
+		*r = trasladoConfirmado
+		return nil
+	case faseIniciado:
+		if err := f.registro.apuntar(ApunteTraslado{ID: c.ID, Fase: faseAbortado}); err != nil {
+			return err
+		}
+	}
+	*r = trasladoCancelado
+	return nil
+}
+
+// Vuelve a confirmar los traslados decididos que el destino no sabe
+func (f *Federacion) reintentarConfirmaciones() {
+	f.mu.Lock()
+	pendientes := map[string]string{}
+	for id, dir := range f.sinConfirmar {
+		pendientes[id] = dir
+	}
+	f.mu.Unlock()
+	for id, dir := range pendientes {
+		if err := f.confirmar(f.vecinaEn(dir), id); err != nil {
+			continue
+		}
+	}
+}
+
+// ------------ TRASLADOS ENTRANTES ------------
+
+// Comprueba que esta sede puede quedarse con el vehículo y devuelve la plaza
+// libre que le tocaría
+func (t *Taller) puedeRecibir(p PeticionTraslado) (*Plaza, error) {
+	mat, err := validarMatricula(p.Matricula)
+	if err != nil {
+		return nil, err
+	}
+	if v := t.getVehiculo(mat); v != nil {
+		if t.plazaDeVehiculo(mat) != nil {
+			return nil, fmt.Errorf("el vehículo %s ya está en el taller", mat)
+		}
+		if c := t.clienteDeVehiculo(mat); c != nil && c.Nombre != p.Cliente.Nombre {
+			return nil, fmt.Errorf("el vehículo %s ya está asignado al cliente %s", mat, c.Nombre)
+		}
+	}
+	c := t.carga()
+	for _, inc := range p.Incidencias {
+		if c.Mecanicos[inc.Tipo] == 0 {
+			return nil, fmt.Errorf("vehículo %s: no hay mecánicos de %s: %w", mat, inc.Tipo, errSinSitio)
+		}
+	}
+	for _, pl := range t.datos().Plazas() {
+		if !pl.Ocupada {
+			return pl, nil
+		}
+	}
+	return nil, fmt.Errorf("vehículo %s: no hay plazas libres: %w", mat, errSinSitio)
+}
+
+// Reservar prepara el traslado: aparta una plaza para el vehículo y lo apunta
+// antes de responder
+func (f *Federacion) Reservar(p PeticionTraslado, ok *bool) error {
+	var err error
+	f.t.conTaller(func() { err = f.reservar(p, ok) })
+	return err
+}
+
+// Se llama con el taller cogido
+func (f *Federacion) reservar(p PeticionTraslado, ok *bool) error {
+	t := f.t
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch f.registro.fase(p.ID) {
+	case fasePreparado, faseRecibido:
+		*ok = true
+		return nil
+	case faseCancelado:
+		return fmt.Errorf("traslado %s: %w", p.ID, errTrasladoCancelado)
+	}
+	var plaza *Plaza
+	err := t.conPlazas(func() error {
+		var err error
+		if plaza, err = t.puedeRecibir(p); err != nil {
+			return err
+		}
+		if err := f.registro.apuntar(ApunteTraslado{ID: p.ID, Fase: fasePreparado, Peticion: &p, PlazaID: plaza.ID}); err != nil {
+			return err
+		}
+		plaza.Ocupada = true
+		plaza.VehiculoMat = p.Matricula
+		return t.datos().GuardarPlaza(plaza)
+	})
+	if err != nil {
+		return err
+	}
+	f.reservas[p.ID] = &reserva{p: p, plazaID: plaza.ID, vence: time.Now().Add(plazoReserva)}
+	if f.cae(caidaAlPreparar) {
+		return errCaida
+	}
+	t.avisar("Plaza %d reservada para el vehículo %s que llega de %s (traslado %s)", plaza.ID, p.Matricula, p.Origen, p.ID)
+	*ok = true
+	return nil
+}
+
+// Confirmar da entrada al vehículo reservado con sus incidencias. Confirmar
+// dos veces el mismo traslado no hace nada.
+func (f *Federacion) Confirmar(id string, ok *bool) error {
+	var err error
+	f.t.conTaller(func() { err = f.confirmarReserva(id, ok) })
+	return err
+}
+
+// Se llama con el taller cogido
+func (f *Federacion) confirmarReserva(id string, ok *bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.registro.fase(id) == faseRecibido {
+		*ok = true
+		return nil
+	}
+	r := f.reservas[id]
+	if r == nil {
+		return fmt.Errorf("traslado %s: no hay reserva", id)
+	}
+	if err := f.recibir(r); err != nil {
+		return err
+	}
+	if f.cae(caidaAlRecibir) {
+		return errCaida
+	}
+	if err := f.registro.apuntar(ApunteTraslado{ID: id, Fase: faseRecibido}); err != nil {
+		return err
+	}
+	delete(f.reservas, id)
+	*ok = true
+	return nil
+}
+
+// Cancelar libera la plaza reservada para el traslado
+func (f *Federacion) Cancelar(id string, ok *bool) error {
+	var err error
+	f.t.conTaller(func() { err = f.cancelarReserva(id, ok) })
+	return err
+}
+
+// Se llama con el taller cogido
+func (f *Federacion) cancelarReserva(id string, ok *bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.registro.fase(id) == faseRecibido {
+		return fmt.Errorf("traslado %s: ya se recibió", id)
+	}
+	*ok = true
+	r := f.reservas[id]
+	if r == nil {
+		return nil
+	}
+	if err := f.registro.apuntar(ApunteTraslado{ID: id, Fase: faseCancelado}); err != nil {
+		return err
+	}
+	delete(f.reservas, id)
+	err := f.t.conPlazas(func() error {
+		if pl := f.t.datos().Plaza(r.plazaID); pl != nil && pl.VehiculoMat == r.p.Matricula {
+			return f.t.vaciarPlaza(pl)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.t.avisar("Traslado %s cancelado: plaza %d libre", id, r.plazaID)
+	return nil
+}
+
+// Da entrada en la plaza reservada al vehículo que llega, con su cliente y
+// sus incidencias. Se llama con el taller y f.mu cogidos.
+func (f *Federacion) recibir(r *reserva) error {
+	t := f.t
+	p := r.p
+	if vi := t.visitaAbierta(p.Matricula); vi != nil && vi.PlazaID == r.plazaID {
+		return nil // ya entró: la sede se cayó antes de apuntarlo
+	}
+	var v *Vehiculo
+	var nuevas []*Incidencia
+	err := t.datos().Transaccion(func() error {
+		var cli *Cliente
+		if p.Cliente.Nombre != "" {
+			for _, c := range t.datos().Clientes() {
+				if c.Nombre == p.Cliente.Nombre && c.Email == p.Cliente.Email {
+					cli = c
+					break
+				}
+			}
+			if cli == nil {
+				var err error
+				if cli, err = t.newCliente(p.Cliente.Nombre, p.Cliente.Telefono, p.Cliente.Email, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		if v = t.getVehiculo(p.Matricula); v == nil {
+			var err error
+			if v, err = t.newVehiculo(p.Matricula, p.Marca, p.Modelo, t.ahora(), time.Time{}, nil); err != nil {
+				return err
+			}
+		}
+		if cli != nil && t.clienteDeVehiculo(v.Matricula) == nil {
+			cli.Vehiculos = append(cli.Vehiculos, v)
+			if err := t.datos().GuardarCliente(cli); err != nil {
+				return err
+			}
+		}
+		if _, err := t.abrirVisita(v, r.plazaID, t.ahora()); err != nil {
+			return err
+		}
+
+		for _, it := range p.Incidencias {
+			inc, err := t.newIncidencia(v.Matricula, nil, string(it.Tipo), it.Prioridad, it.Descripcion)
+			if err != nil {
+				return err
+			}
+			inc.TiempoAcumulado = it.TiempoAcumulado
+			if err := t.datos().GuardarIncidencia(inc); err != nil {
+				return err
+			}
+			nuevas = append(nuevas, inc)
+		}
+		t.updateTiempoTotalVehiculo(v)
+		return t.datos().GuardarVehiculo(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	// El cliente aceptó el presupuesto en la sede de origen: aquí se rehace
+	// con lo que falta y se da por aceptado
+	if _, err := t.aceptarPresupuesto(v); err != nil {
+		return err
+	}
+	if t.colas != nil {
+		for _, inc := range nuevas {
+			t.plantilla.encolado(inc)
+			t.colas.encolar(t.entregas.nuevo(v, inc))
+		}
+	}
+	t.avisar("Llega de %s el vehículo %s con %d incidencias (plaza %d)", p.Origen, v.Matricula, len(nuevas), r.plazaID)
+	return nil
+}
+
+// Pregunta al origen qué decidió de las reservas vencidas. Mientras no
+// responde la reserva sigue (el traslado está en duda). El taller sólo se
+// coge al confirmar o cancelar, no mientras se pregunta.
+func (f *Federacion) resolverReservas(ahora time.Time) {
+	f.mu.Lock()
+	var vencidas []*reserva
+	for _, r := range f.reservas {
+		if ahora.After(r.vence) {
+			vencidas = append(vencidas, r)
+		}
+	}
+	f.mu.Unlock()
+	sort.Slice(vencidas, func(i, j int) bool { return vencidas[i].p.ID < vencidas[j].p.ID })
+
+	for _, r := range vencidas {
+		var decision string
+		var ok bool
+		c := ConsultaDecision{ID: r.p.ID, Matricula: r.p.Matricula, Destino: f.Nombre}
+		err := f.llamar(f.vecinaEn(r.p.Origen), metodoDecision, c, &decision)
+		switch {
+		case err != nil:
+			f.mu.Lock()
+			r.vence = ahora.Add(plazoReserva)
+			f.mu.Unlock()
+			f.t.avisar("Traslado %s en duda: la sede de origen no responde (%v)", r.p.ID, err)
+		case decision == trasladoConfirmado:
+			err = f.Confirmar(r.p.ID, &ok)
+		default:
+			err = f.Cancelar(r.p.ID, &ok)
+		}
+		if err != nil {
+			f.t.avisar("Error resolviendo el traslado %s: %v", r.p.ID, err)
+		}
+	}
+}
+
+// ------------ MENÚ ------------
+
+func printCarga(c Carga) {
+	fmt.Printf("Sede %s (%s): %d plazas libres\n", c.Sede, c.Direccion, c.PlazasLibres)
+	for _, esp := range []Especialidad{Mecanica, Electrica, Carroceria} {
+		fmt.Printf("  %-10s %d mecánicos, %d horas pendientes\n", esp, c.Mecanicos[esp], c.Pendiente[esp])
+	}
+}
+
+// Se llama sin el taller: cada opción lo coge para lo que hace en esta sede
+func menuSedes(t *Taller, in *Entrada) {
+	f := t.federacion
+	if f == nil {
+		fmt.Println("Este taller no forma parte de ninguna federación (arranca con -sede y -federacion).")
+		return
+	}
+	for {
+		fmt.Println("\n--- SEDES ---")
+		fmt.Println("1. Carga de las sedes")
+		fmt.Println("2. Vehículos que pueden trasladarse")
+		fmt.Println("3. Trasladar un vehículo")
+		fmt.Println("4. Traslados sin terminar")
+		fmt.Println("0. Volver al menú principal")
+
+		op, err := in.Opcion("Seleccione", 0, 4)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case 1:
+			var propia Carga
+			f.Carga(struct{}{}, &propia)
+			printCarga(propia)
+			f.actualizarCargas()
+			f.mu.Lock()
+			for _, vc := range f.vecinas {
+				if vc.actualizada.IsZero() {
+					fmt.Printf("Sede %s: sin respuesta\n", vc.dir)
+					continue
+				}
+				printCarga(vc.carga)
+				if time.Since(vc.actualizada) > 3*intervaloCarga {
+					fmt.Printf("  (sin respuesta desde las %s)\n", vc.actualizada.Format("15:04:05"))
+				}
+			}
+			f.mu.Unlock()
+		case 2:
+			t.conTaller(func() {
+				lista := f.vehiculosEnEspera()
+				if len(lista) == 0 {
+					fmt.Println("No hay vehículos esperando.")
+					return
+				}
+				for _, v := range lista {
+					fmt.Printf("%s: %d horas pendientes\n", v.Matricula, v.TiempoTotal)
+				}
+			})
+		case 3:
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				break
+			}
+			sede, err := in.TextoDefecto("Sede (vacío = la más desocupada)", "")
+			if err != nil {
+				break
+			}
+			destino, err := f.trasladar(mat, sede)
+			if err != nil {
+				fmt.Println("No se pudo trasladar:", err)
+			} else {
+				fmt.Printf("Vehículo %s trasladado a la sede %s.\n", mat, destino)
+			}
+		case 4:
+			lista := f.trasladosPendientes()
+			if len(lista) == 0 {
+				fmt.Println("No hay traslados sin terminar.")
+				break
+			}
+			for _, a := range lista {
+				mat := ""
+				if a.Peticion != nil {
+					mat = a.Peticion.Matricula
+				}
+				fmt.Printf("%s [%s] %s desde %s\n", a.ID, a.Fase, mat, a.Fecha.Format("02/01/2006 15:04:05"))
+			}
+		case 0:
+			return
+		}
+	}
+}
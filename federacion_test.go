@@ -0,0 +1,225 @@
+// federacion_test.go
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Sede de la federación escuchando en un puerto libre de localhost, con un
+// mecánico de cada especialidad indicada
+func sedeDePrueba(t *testing.T, nombre string, esps ...Especialidad) (*Taller, *Federacion) {
+	t.Helper()
+	taller := &Taller{}
+	for _, esp := range esps {
+		taller.newMecanico("Mec "+string(esp), string(esp), 1)
+	}
+	f := nuevaFederacion(taller, nombre, nil)
+	if _, err := f.escuchar("127.0.0.1:0"); err != nil {
+		t.Fatalf("no se pudo arrancar la sede %s: %v", nombre, err)
+	}
+	taller.federacion = f
+	t.Cleanup(f.cerrar)
+	return taller, f
+}
+
+// Las dos sedes se conocen
+func vecinas(a, b *Federacion) {
+	a.vecinas = append(a.vecinas, &vecina{dir: b.dir})
+	b.vecinas = append(b.vecinas, &vecina{dir: a.dir})
+}
+
+// Vehículo de un cliente esperando en su plaza con una incidencia abierta
+func vehiculoEsperando(t *testing.T, taller *Taller, mat string, tipo Especialidad) *Vehiculo {
+	t.Helper()
+	cli, _ := taller.newCliente("Pepe", "+34600111222", "pepe@example.com", nil)
+	v, _ := taller.newVehiculo(mat, "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, 0); err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := taller.newIncidencia(mat, nil, string(tipo), "Alta", "Frenos")
+	inc.TiempoAcumulado = 3 // ya se hizo parte
+	taller.updateTiempoTotalVehiculo(v)
+	return v
+}
+
+func TestTrasladoDeVehiculoEnEspera(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	sinMecanica, fs := sedeDePrueba(t, "Sur", Electrica)
+	vecinas(fo, fd)
+	vecinas(fo, fs)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+
+	sede, err := fo.trasladar(v.Matricula, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sede != "Norte" {
+		t.Fatalf("se esperaba la sede Norte, la única con mecánicos de mecánica: %s", sede)
+	}
+
+	// En el origen ya no está
+	if v.Sede != "Norte" || origen.plazaDeVehiculo(v.Matricula) != nil || origen.visitaAbierta(v.Matricula) != nil {
+		t.Errorf("el vehículo sigue en el origen: %+v", v)
+	}
+	if err := origen.puedeRepararse(v); !errors.Is(err, errEnOtraSede) {
+		t.Errorf("el origen no debería repararlo: %v", err)
+	}
+
+	// En el destino está con su cliente y lo que faltaba de la incidencia
+	llegado := destino.getVehiculo(v.Matricula)
+	if llegado == nil || destino.plazaDeVehiculo(v.Matricula) == nil {
+		t.Fatal("el vehículo no ha llegado al destino")
+	}
+	vi := destino.visitaAbierta(v.Matricula)
+	if vi == nil || len(llegado.Incidencias) != 1 || llegado.Incidencias[0].TiempoAcumulado != 3 || llegado.TiempoTotal != 3 {
+		t.Errorf("incidencias trasladadas incorrectas: %+v, visita %+v", llegado.Incidencias, vi)
+	}
+	if c := destino.clienteDeVehiculo(v.Matricula); c == nil || c.Nombre != "Pepe" || c.Email != "pepe@example.com" {
+		t.Errorf("el cliente no ha llegado: %+v", c)
+	}
+	if len(sinMecanica.datos().Vehiculos()) != 0 {
+		t.Error("la sede sin mecánicos de mecánica no debería recibir nada")
+	}
+
+	// Confirmar de nuevo (un reintento) no duplica nada
+	var ok bool
+	if err := fd.Confirmar("Centro-99", &ok); err == nil {
+		t.Error("un traslado desconocido no se puede confirmar")
+	}
+	for _, a := range fo.registro.enFase(faseTerminado) {
+		if err := fd.Confirmar(a.ID, &ok); err != nil || len(llegado.Incidencias) != 1 {
+			t.Errorf("la confirmación repetida no debería cambiar nada: %v, %+v", err, llegado.Incidencias)
+		}
+	}
+
+	// No se puede volver a trasladar desde el origen
+	if _, err := fo.trasladar(v.Matricula, "Sur"); !errors.Is(err, errEnOtraSede) {
+		t.Errorf("el origen ya no tiene el vehículo: %v", err)
+	}
+}
+
+func TestTrasladoSinSitioNoMueveNada(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	destino, fd := sedeDePrueba(t, "Sur", Electrica)
+	vecinas(fo, fd)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+
+	if _, err := fo.trasladar(v.Matricula, ""); !errors.Is(err, errSinSitio) {
+		t.Errorf("ninguna sede puede repararlo: %v", err)
+	}
+	if _, err := fo.trasladar(v.Matricula, "Sur"); err == nil {
+		t.Error("la sede Sur no tiene mecánicos de mecánica")
+	}
+	if v.Sede != "" || origen.plazaDeVehiculo(v.Matricula) == nil || origen.visitaAbierta(v.Matricula) == nil {
+		t.Errorf("el vehículo debería seguir en el origen: %+v", v)
+	}
+	if len(destino.plazasOcupadas()) != 0 || len(fd.reservas) != 0 {
+		t.Error("el destino no debería haber reservado nada")
+	}
+
+	// Un vehículo en reparación tampoco se traslada
+	v.Incidencias[0].Estado = 1
+	if _, err := fo.trasladar(v.Matricula, ""); !errors.Is(err, errNoEnEspera) {
+		t.Errorf("se está reparando: %v", err)
+	}
+}
+
+// El destino reservó pero el origen nunca decidió (p.ej. se cayó): al vencer
+// la reserva el origen dice que se cancela y ya no puede decidirlo
+func TestReservaSinDecidirSeCancela(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+
+	p := fo.peticionTraslado("Centro-1", v)
+	a := ApunteTraslado{ID: p.ID, Fase: faseIniciado, Peticion: &p, Destino: fd.dir, Sede: "Norte"}
+	fo.registro.apuntar(a)
+	var ok bool
+	if err := fd.Reservar(p, &ok); err != nil {
+		t.Fatal(err)
+	}
+	if len(destino.plazasOcupadas()) != 1 {
+		t.Fatal("la plaza debería quedar reservada")
+	}
+
+	fd.resolverReservas(time.Now().Add(2 * plazoReserva))
+	if len(destino.plazasOcupadas()) != 0 || destino.getVehiculo(v.Matricula) != nil {
+		t.Error("la reserva debería cancelarse sin dar entrada al vehículo")
+	}
+	fo.mu.Lock()
+	err := fo.decidir(a, v)
+	fo.mu.Unlock()
+	if !errors.Is(err, errTrasladoCancelado) || v.Sede != "" {
+		t.Errorf("el origen no puede decidir un traslado cancelado: %v", err)
+	}
+}
+
+// El origen decidió pero la confirmación no llegó: al vencer la reserva el
+// destino pregunta y da entrada al vehículo
+func TestTrasladoDecididoSinConfirmarSeCompleta(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	destino, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+
+	p := fo.peticionTraslado("Centro-1", v)
+	a := ApunteTraslado{ID: p.ID, Fase: faseIniciado, Peticion: &p, Destino: fd.dir, Sede: "Norte"}
+	fo.registro.apuntar(a)
+	var ok bool
+	if err := fd.Reservar(p, &ok); err != nil {
+		t.Fatal(err)
+	}
+	fo.mu.Lock()
+	if err := fo.decidir(a, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := fo.aplicarTraslado(a); err != nil {
+		t.Fatal(err)
+	}
+	fo.sinConfirmar[p.ID] = fd.dir
+	fo.mu.Unlock()
+
+	fd.resolverReservas(time.Now().Add(2 * plazoReserva))
+	if destino.visitaAbierta(v.Matricula) == nil {
+		t.Fatal("el destino debería dar entrada al vehículo")
+	}
+	fo.reintentarConfirmaciones()
+	if len(fo.sinConfirmar) != 0 || fo.registro.fase(p.ID) != faseTerminado {
+		t.Error("la confirmación pendiente debería darse por hecha")
+	}
+	if n := len(destino.getVehiculo(v.Matricula).Incidencias); n != 1 {
+		t.Errorf("incidencias en el destino: %d, se esperaba 1", n)
+	}
+}
+
+func TestCargaDeLaSede(t *testing.T) {
+	taller, f := sedeDePrueba(t, "Centro", Mecanica, Mecanica, Electrica)
+	vehiculoEsperando(t, taller, "1234 BCD", Electrica)
+	var c Carga
+	f.Carga(struct{}{}, &c)
+	if c.Sede != "Centro" || c.PlazasLibres != 5 || c.Mecanicos[Mecanica] != 2 || c.Pendiente[Electrica] != 3 {
+		t.Errorf("carga incorrecta: %+v", c)
+	}
+}
+
+// Un vehículo trasladado que vuelve a la sede de origen vuelve a ser suyo
+func TestVehiculoTrasladadoQueVuelve(t *testing.T) {
+	origen, fo := sedeDePrueba(t, "Centro", Mecanica)
+	_, fd := sedeDePrueba(t, "Norte", Mecanica)
+	vecinas(fo, fd)
+	v := vehiculoEsperando(t, origen, "1234 BCD", Mecanica)
+	if _, err := fo.trasladar(v.Matricula, "Norte"); err != nil {
+		t.Fatal(err)
+	}
+	cli := origen.clienteDeVehiculo(v.Matricula)
+	if err := origen.admitirCliente(cli.ID, v, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v.Sede != "" || errors.Is(origen.puedeRepararse(v), errEnOtraSede) {
+		t.Errorf("el vehículo debería volver a ser de esta sede: %+v", v)
+	}
+}
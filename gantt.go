@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------ DIAGRAMA DE GANTT DE LA SIMULACIÓN ------------
+
+// Color de relleno de cada tipo de incidencia en el SVG
+var colorGantt = map[Especialidad]string{
+	Mecanica:   "#1f77b4",
+	Electrica:  "#e6a700",
+	Carroceria: "#b03fc4",
+}
+
+// Medidas del dibujo (en píxeles)
+const (
+	ganttAnchoEtiqueta = 170
+	ganttAltoCalle     = 28
+	ganttAltoBarra     = 20
+	ganttPixelsSegundo = 12
+	ganttMargenSup     = 30
+)
+
+// Una calle del diagrama: un mecánico o una plaza, con sus barras
+type calleGantt struct {
+	titulo string
+	tramos []Tramo
+}
+
+// Reparte los tramos en una calle por mecánico y otra por plaza
+func callesGantt(tramos []Tramo) []calleGantt {
+	porMecanico := map[int]*calleGantt{}
+	porPlaza := map[int]*calleGantt{}
+	for _, tr := range tramos {
+		cm, ok := porMecanico[tr.MecanicoID]
+		if !ok {
+			cm = &calleGantt{titulo: fmt.Sprintf("Mecánico %s", tr.Mecanico)}
+			porMecanico[tr.MecanicoID] = cm
+		}
+		cm.tramos = append(cm.tramos, tr)
+
+		if tr.PlazaID == 0 {
+			continue
+		}
+		cp, ok := porPlaza[tr.PlazaID]
+		if !ok {
+			cp = &calleGantt{titulo: fmt.Sprintf("Plaza %d", tr.PlazaID)}
+			porPlaza[tr.PlazaID] = cp
+		}
+		cp.tramos = append(cp.tramos, tr)
+	}
+
+	// Primero los mecánicos y después las plazas, cada grupo ordenado por ID
+	var calles []calleGantt
+	for _, id := range clavesOrdenadas(porMecanico) {
+		calles = append(calles, *porMecanico[id])
+	}
+	for _, id := range clavesOrdenadas(porPlaza) {
+		calles = append(calles, *porPlaza[id])
+	}
+	return calles
+}
+
+func clavesOrdenadas(m map[int]*calleGantt) []int {
+	var ids []int
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Genera el diagrama de Gantt como documento SVG independiente
+func generarSVGGantt(tramos []Tramo) string {
+	if len(tramos) == 0 {
+		return `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="40">` +
+			`<text x="10" y="25" font-family="sans-serif" font-size="14">Sin trabajos registrados</text></svg>`
+	}
+
+	origen, fin := tramos[0].Inicio, tramos[0].Fin
+	for _, tr := range tramos {
+		if tr.Inicio.Before(origen) {
+			origen = tr.Inicio
+		}
+		if tr.Fin.After(fin) {
+			fin = tr.Fin
+		}
+	}
+	segundos := int(fin.Sub(origen).Seconds()) + 1
+	calles := callesGantt(tramos)
+	ancho := ganttAnchoEtiqueta + segundos*ganttPixelsSegundo + 20
+	alto := ganttMargenSup + len(calles)*ganttAltoCalle + 10
+	x := func(f time.Time) float64 {
+		return ganttAnchoEtiqueta + f.Sub(origen).Seconds()*ganttPixelsSegundo
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", ancho, alto)
+
+	// Eje de tiempos, una marca cada 5 segundos
+	for s := 0; s <= segundos; s += 5 {
+		px := ganttAnchoEtiqueta + s*ganttPixelsSegundo
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#ddd"/>`+"\n", px, ganttMargenSup-5, px, alto-10)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#555">%ds</text>`+"\n", px+2, ganttMargenSup-10, s)
+	}
+
+	for i, c := range calles {
+		y := ganttMargenSup + i*ganttAltoCalle
+		fmt.Fprintf(&b, `<text x="5" y="%d" font-weight="bold">%s</text>`+"\n", y+ganttAltoBarra-5, html.EscapeString(c.titulo))
+		for _, tr := range c.tramos {
+			x0, x1 := x(tr.Inicio), x(tr.Fin)
+			etiqueta := fmt.Sprintf("%s %s", tr.Matricula, tr.Tipo)
+			fmt.Fprintf(&b, `<g><title>%s (incidencia %d): %.1fs - %.1fs</title>`,
+				html.EscapeString(etiqueta), tr.IncidenciaID, tr.Inicio.Sub(origen).Seconds(), tr.Fin.Sub(origen).Seconds())
+			fmt.Fprintf(&b, `<rect class="barra" x="%.1f" y="%d" width="%.1f" height="%d" rx="3" fill="%s"/>`,
+				x0, y, max(x1-x0, 1), ganttAltoBarra, colorGantt[tr.Tipo])
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="#fff">%s</text></g>`+"\n",
+				x0+3, y+ganttAltoBarra-6, html.EscapeString(etiqueta))
+		}
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// Página HTML con el diagrama, la leyenda de colores y la tabla de trabajos
+func generarHTMLGantt(tramos []Tramo) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"es\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>Diagrama de Gantt de la simulación</title>\n")
+	b.WriteString("<style>body{font-family:sans-serif;margin:20px} table{border-collapse:collapse} " +
+		"td,th{border:1px solid #ccc;padding:3px 8px} .muestra{display:inline-block;width:12px;height:12px;margin:0 4px 0 12px}</style>\n")
+	b.WriteString("</head>\n<body>\n<h1>Diagrama de Gantt de la simulación</h1>\n<p>")
+	for _, esp := range []Especialidad{Mecanica, Electrica, Carroceria} {
+		fmt.Fprintf(&b, `<span class="muestra" style="background:%s"></span>%s`, colorGantt[esp], esp)
+	}
+	b.WriteString("</p>\n")
+	b.WriteString(generarSVGGantt(tramos))
+
+	b.WriteString("<h2>Trabajos</h2>\n<table>\n<tr><th>Mecánico</th><th>Plaza</th><th>Matrícula</th><th>Incidencia</th><th>Tipo</th><th>Inicio</th><th>Fin</th><th>Duración</th></tr>\n")
+	ordenados := append([]Tramo(nil), tramos...)
+	sort.Slice(ordenados, func(i, j int) bool { return ordenados[i].Inicio.Before(ordenados[j].Inicio) })
+	for _, tr := range ordenados {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td><td>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%s</td><td>%.1fs</td></tr>\n",
+			html.EscapeString(tr.Mecanico), tr.PlazaID, html.EscapeString(tr.Matricula), tr.IncidenciaID, tr.Tipo,
+			tr.Inicio.Format("15:04:05"), tr.Fin.Format("15:04:05"), tr.Fin.Sub(tr.Inicio).Seconds())
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// Escribe gantt.svg y gantt.html en dir y devuelve sus rutas
+func exportarGantt(tramos []Tramo, dir string) (string, string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+	rutaSVG := filepath.Join(dir, "gantt.svg")
+	rutaHTML := filepath.Join(dir, "gantt.html")
+	if err := os.WriteFile(rutaSVG, []byte(generarSVGGantt(tramos)), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(rutaHTML, []byte(generarHTMLGantt(tramos)), 0o644); err != nil {
+		return "", "", err
+	}
+	return rutaSVG, rutaHTML, nil
+}
@@ -0,0 +1,66 @@
+// gantt_test.go
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGanttUnaCallePorMecanicoYPlaza(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	tramos := []Tramo{
+		{MecanicoID: 0, Mecanico: "Luis", PlazaID: 1, Matricula: "1234 BCD", Tipo: Mecanica, IncidenciaID: 0,
+			Inicio: t0, Fin: t0.Add(5 * time.Second)},
+		{MecanicoID: 1, Mecanico: "Ana", PlazaID: 1, Matricula: "1234 BCD", Tipo: Electrica, IncidenciaID: 1,
+			Inicio: t0.Add(5 * time.Second), Fin: t0.Add(12 * time.Second)},
+		{MecanicoID: 0, Mecanico: "Luis", PlazaID: 3, Matricula: "M-1234-AB", Tipo: Carroceria, IncidenciaID: 2,
+			Inicio: t0.Add(6 * time.Second), Fin: t0.Add(17 * time.Second)},
+	}
+
+	calles := callesGantt(tramos)
+	titulos := []string{}
+	for _, c := range calles {
+		titulos = append(titulos, c.titulo)
+	}
+	if got := strings.Join(titulos, ","); got != "Mecánico Luis,Mecánico Ana,Plaza 1,Plaza 3" {
+		t.Errorf("calles inesperadas: %s", got)
+	}
+
+	svg := generarSVGGantt(tramos)
+	// Debe ser XML válido para poder abrirse como fichero independiente
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("SVG mal formado: %v", err)
+			}
+			break
+		}
+	}
+	// Cada tramo aparece en la calle de su mecánico y en la de su plaza
+	if n := strings.Count(svg, `class="barra"`); n != 2*len(tramos) {
+		t.Errorf("se esperaban %d barras, hay %d", 2*len(tramos), n)
+	}
+	if !strings.Contains(svg, colorGantt[Carroceria]) || !strings.Contains(svg, "M-1234-AB carroceria") {
+		t.Errorf("falta la barra de carrocería con su color y etiqueta")
+	}
+
+	dir := t.TempDir()
+	rutaSVG, rutaHTML, err := exportarGantt(tramos, dir)
+	if err != nil {
+		t.Fatalf("error exportando: %v", err)
+	}
+	for _, ruta := range []string{rutaSVG, rutaHTML} {
+		if _, err := os.Stat(ruta); err != nil {
+			t.Errorf("no se creó %s: %v", ruta, err)
+		}
+	}
+	contenido, _ := os.ReadFile(rutaHTML)
+	if !strings.Contains(string(contenido), "<svg") || !strings.Contains(string(contenido), "<td>Ana</td>") {
+		t.Errorf("el HTML debería incluir el diagrama y la tabla de trabajos")
+	}
+}
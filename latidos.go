@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ------------ LATIDOS Y DETECCIÓN DE FALLOS ------------
+
+// Cada reparación en curso, la haga un mecánico local o uno remoto, es una
+// concesión con plazo que el mecánico renueva con sus latidos (uno por
+// porción hecha y, los remotos, también cada cierto tiempo). Si un mecánico
+// deja de latir más de Plazo se le da por muerto: lo que le quedaba del
+// trabajo vuelve a la cola y el fallo queda apuntado. Un mecánico dado por
+// muerto ya no puede cerrar su concesión aunque vuelva a dar señales de vida.
+
+// Sin latir durante tres porciones el mecánico se da por muerto
+const plazoLatidoDefecto = 3 * porcionReparacion
+
+// Trabajo concedido a un mecánico y hasta cuándo
+type concesion struct {
+	ID          int
+	m           *Mecanico
+	tr          Trabajo
+	duracion    int // porciones que le quedaban a la incidencia al concederla
+	hechas      int // porciones de las que ha informado
+	interrumpir <-chan struct{}
+	vence       time.Time
+}
+
+// Mecánico dado por muerto con un trabajo entre manos
+type FalloMecanico struct {
+	Fecha        time.Time
+	MecanicoID   int
+	Nombre       string
+	IncidenciaID int
+	Matricula    string
+	Hechas       int // porciones que llegó a hacer
+	Pendiente    int // porciones que vuelven a la cola
+}
+
+type TablaConcesiones struct {
+	t             *Taller
+	mu            sync.Mutex
+	Plazo         time.Duration
+	concesiones   map[int]*concesion // por ID
+	nextConcesion int
+	fallos        []FalloMecanico
+}
+
+func nuevaTablaConcesiones(t *Taller, plazo time.Duration) *TablaConcesiones {
+	return &TablaConcesiones{
+		t:             t,
+		Plazo:         plazo,
+		concesiones:   map[int]*concesion{},
+		nextConcesion: 1,
+	}
+}
+
+// Concede el trabajo al mecánico (nil si no se vigilan los trabajos)
+func (tc *TablaConcesiones) conceder(m *Mecanico, tr Trabajo, duracion int, interrumpir <-chan struct{}) *concesion {
+	if tc == nil {
+		return nil
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	cn := &concesion{
+		ID:          tc.nextConcesion,
+		m:           m,
+		tr:          tr,
+		duracion:    duracion,
+		interrumpir: interrumpir,
+		vence:       time.Now().Add(tc.Plazo),
+	}
+	tc.nextConcesion++
+	tc.concesiones[cn.ID] = cn
+	return cn
+}
+
+// Concesión id del mecánico mecID (error si no la tiene: ya terminó, no es
+// suya o se le dio por muerto)
+func (tc *TablaConcesiones) buscar(mecID, id int) (*concesion, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	cn := tc.concesiones[id]
+	if cn == nil || cn.m.ID != mecID {
+		return nil, fmt.Errorf("trabajo %d, mecánico %d: %w", id, mecID, errConcesionAjena)
+	}
+	return cn, nil
+}
+
+// Latido con avance: el mecánico lleva hechas porciones del trabajo
+func (tc *TablaConcesiones) avance(cn *concesion, hechas int) {
+	if tc == nil || cn == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	cn.hechas = min(max(hechas, cn.hechas), cn.duracion)
+	cn.vence = time.Now().Add(tc.Plazo)
+}
+
+// Porciones de las que ha informado el mecánico de la concesión
+func (tc *TablaConcesiones) hechasDe(cn *concesion) int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return cn.hechas
+}
+
+// Latido sin avance: renueva todas las concesiones del mecánico
+func (tc *TablaConcesiones) renovar(mecID int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	vence := time.Now().Add(tc.Plazo)
+	for _, cn := range tc.concesiones {
+		if cn.m.ID == mecID {
+			cn.vence = vence
+		}
+	}
+}
+
+// El mecánico termina (o deja) el trabajo. Devuelve false si ya no era suyo
+// porque se le dio por muerto: entonces no debe apuntar nada.
+func (tc *TablaConcesiones) soltar(cn *concesion) bool {
+	if tc == nil || cn == nil {
+		return true
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.concesiones[cn.ID] != cn {
+		return false
+	}
+	delete(tc.concesiones, cn.ID)
+	return true
+}
+
+// Quita y devuelve todas las concesiones del mecánico
+func (tc *TablaConcesiones) soltarTodas(mecID int) []*concesion {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	var lista []*concesion
+	for id, cn := range tc.concesiones {
+		if cn.m.ID == mecID {
+			lista = append(lista, cn)
+			delete(tc.concesiones, id)
+		}
+	}
+	return lista
+}
+
+// Da por muertos a los mecánicos cuyas concesiones han vencido y devuelve
+// sus trabajos a la cola. Devuelve los fallos detectados. Coge el taller.
+func (tc *TablaConcesiones) revisar(ahora time.Time) []FalloMecanico {
+	var fallos []FalloMecanico
+	tc.t.conTaller(func() {
+		for _, cn := range tc.vencidas(ahora) {
+			fallos = append(fallos, tc.reclamar(cn))
+		}
+	})
+	tc.mu.Lock()
+	tc.fallos = append(tc.fallos, fallos...)
+	tc.mu.Unlock()
+	return fallos
+}
+
+// Quita y devuelve, por orden, las concesiones de los mecánicos con alguna
+// vencida
+func (tc *TablaConcesiones) vencidas(ahora time.Time) []*concesion {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	muertos := map[int]bool{}
+	for _, cn := range tc.concesiones {
+		if ahora.After(cn.vence) {
+			muertos[cn.m.ID] = true
+		}
+	}
+	var vencidas []*concesion
+	for id, cn := range tc.concesiones {
+		if muertos[cn.m.ID] {
+			vencidas = append(vencidas, cn)
+			delete(tc.concesiones, id)
+		}
+	}
+	sort.Slice(vencidas, func(i, j int) bool { return vencidas[i].ID < vencidas[j].ID })
+	return vencidas
+}
+
+// Recupera el trabajo de un mecánico muerto con el tiempo que le falta. Se
+// llama con el taller cogido.
+func (tc *TablaConcesiones) reclamar(cn *concesion) FalloMecanico {
+	t := tc.t
+	m, v, inc := cn.m, cn.tr.Vehiculo, cn.tr.Incidencia
+	hechas := tc.hechasDe(cn)
+	t.colas.terminarReparacion(m)
+	t.seguimiento.terminar(m)
+	t.plantilla.terminar(m)
+	t.registrarManoDeObra(inc, m, float64(hechas)*horasPorPorcion)
+	m.Activo = false
+	t.coordinador.olvidar(m.ID)
+
+	pendiente := cn.duracion - hechas
+	msg := fmt.Sprintf("Mecánico %s no da señales de vida: la incidencia del vehículo %s (%s) vuelve a la cola [quedan %ds]",
+		m.Nombre, v.Matricula, inc.Tipo, pendiente)
+	tr := cn.tr
+	t.eventos.recibir(t.origenVigilante(), tr.marca)
+	tr.marca = t.eventos.anotar(t.origenVigilante(), eventoReclamado, tr, msg)
+	t.entregas.soltar(tr, m)
+	t.devolverTrabajo(tr, hechas, 0)
+	t.avisar("%s", msg)
+	reclamado := mensajeTrabajo(temaTrabajoReclamado, m, tr, "")
+	reclamado.Horas = float64(hechas) * horasPorPorcion
+	t.publicar(reclamado)
+	return FalloMecanico{
+		Fecha:        t.ahora(),
+		MecanicoID:   m.ID,
+		Nombre:       m.Nombre,
+		IncidenciaID: inc.ID,
+		Matricula:    v.Matricula,
+		Hechas:       hechas,
+		Pendiente:    pendiente,
+	}
+}
+
+// Revisa las concesiones cada tercio del plazo hasta que se cierra parar
+func (tc *TablaConcesiones) vigilar(parar <-chan struct{}) {
+	if tc == nil {
+		return
+	}
+	tic := time.NewTicker(max(tc.Plazo/3, time.Millisecond))
+	defer tic.Stop()
+	for {
+		select {
+		case ahora := <-tic.C:
+			tc.revisar(ahora)
+		case <-parar:
+			return
+		}
+	}
+}
+
+// Fallos detectados hasta ahora
+func (tc *TablaConcesiones) Fallos() []FalloMecanico {
+	if tc == nil {
+		return nil
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return append([]FalloMecanico(nil), tc.fallos...)
+}
+
+func (tc *TablaConcesiones) informe() string {
+	fallos := tc.Fallos()
+	if len(fallos) == 0 {
+		return "Mecánicos caídos: 0\n"
+	}
+	s := fmt.Sprintf("Mecánicos caídos: %d\n", len(fallos))
+	for _, f := range fallos {
+		s += fmt.Sprintf("  %s %s dejó el vehículo %s tras %ds (quedaban %ds)\n",
+			f.Fecha.Format("15:04"), f.Nombre, f.Matricula, f.Hechas, f.Pendiente)
+	}
+	return s
+}
+
+// Ejecuta el trabajo de un mecánico local sin que un pánico tire el
+// programa: el mecánico deja de trabajar (y de latir) y el vigilante
+// recupera lo que tuviera entre manos
+func (t *Taller) sinPanico(m *Mecanico, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.avisar("El mecánico %s ha fallado: %v", m.Nombre, r)
+		}
+	}()
+	fn()
+}
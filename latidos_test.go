@@ -0,0 +1,188 @@
+// latidos_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Vigila las concesiones del taller con un plazo corto mientras dura el test
+func vigilarConPlazo(t *testing.T, taller *Taller, plazo time.Duration) {
+	t.Helper()
+	if taller.concesiones == nil {
+		taller.concesiones = nuevaTablaConcesiones(taller, plazo)
+	}
+	taller.concesiones.Plazo = plazo
+	parar := make(chan struct{})
+	go taller.concesiones.vigilar(parar)
+	t.Cleanup(func() { close(parar) })
+}
+
+// Un trabajador remoto muere (se corta su conexión sin darse de baja) con un
+// trabajo a medias: al vencer el plazo el trabajo vuelve a la cola con lo
+// que faltaba y el fallo queda apuntado
+func TestTrabajadorMuertoDevuelveSuTrabajo(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	vigilarConPlazo(t, taller, 100*time.Millisecond)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	taller.colas.encolar(tr)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	var r RespuestaTrabajo
+	if err := w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r); err != nil || !r.Hay {
+		t.Fatalf("debería conceder el trabajo: %+v, %v", r, err)
+	}
+	var av RespuestaAvance
+	w.cliente.Call(metodoAvanzar, InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: 2}, &av)
+	w.cliente.Close() // el proceso muere
+
+	esperarQue(t, "el trabajo vuelve a la cola", func() bool { return taller.colas.Longitud(Mecanica) == 1 })
+	inc := tr.Incidencia
+	if inc.Estado != 0 || inc.pendiente() != 3 {
+		t.Errorf("el trabajo debería quedar abierto con 3 porciones: %+v", inc)
+	}
+	if len(inc.ManoDeObra) != 1 || inc.ManoDeObra[0].Horas != 2 {
+		t.Errorf("deberían contar las 2 horas hechas: %+v", inc.ManoDeObra)
+	}
+	fallos := taller.concesiones.Fallos()
+	if len(fallos) != 1 || fallos[0].MecanicoID != w.ID || fallos[0].IncidenciaID != inc.ID ||
+		fallos[0].Hechas != 2 || fallos[0].Pendiente != 3 {
+		t.Fatalf("fallo mal apuntado: %+v", fallos)
+	}
+	if taller.coordinador.conectado(w.ID) {
+		t.Error("el mecánico muerto debería quedar desconectado")
+	}
+
+	// Vuelve a conectarse: es el mismo mecánico, pero el trabajo viejo ya no
+	// es suyo
+	w2 := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	if w2.ID != w.ID {
+		t.Errorf("ID %d al reconectar, se esperaba %d", w2.ID, w.ID)
+	}
+	var cerrada bool
+	if err := w2.cliente.Call(metodoTerminar, InformeAvance{MecanicoID: w2.ID, Concesion: r.Concesion, Hechas: 5}, &cerrada); err == nil {
+		t.Error("no se puede cerrar un trabajo ya recuperado")
+	}
+	if inc.Estado != 0 || inc.pendiente() != 3 {
+		t.Errorf("el cierre tardío no debería tocar la incidencia: %+v", inc)
+	}
+}
+
+// Mientras el trabajador late no se le da por muerto aunque no avance
+func TestLatidosMantienenVivoAlTrabajador(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	vigilarConPlazo(t, taller, 100*time.Millisecond)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	taller.colas.encolar(tr)
+
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	w.Latido = 20 * time.Millisecond
+	var r RespuestaTrabajo
+	w.cliente.Call(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: time.Second}, &r)
+	fin := make(chan struct{})
+	caido := make(chan error, 1)
+	go w.latir(fin, caido)
+
+	time.Sleep(400 * time.Millisecond)
+	if n := len(taller.concesiones.Fallos()); n != 0 || tr.Incidencia.Estado != 1 {
+		t.Fatalf("un mecánico que late no debería darse por muerto: %d fallos, %+v", n, tr.Incidencia)
+	}
+
+	close(fin) // deja de latir (se cuelga)
+	esperarQue(t, "se le da por muerto", func() bool { return len(taller.concesiones.Fallos()) == 1 })
+	if tr.Incidencia.Estado != 0 || tr.Incidencia.TiempoAcumulado != 5 {
+		t.Errorf("el trabajo debería volver entero: %+v", tr.Incidencia)
+	}
+}
+
+// A un trabajador dado por muerto se le rechazan los latidos: deja el
+// trabajo y termina con error
+func TestTrabajadorDadoPorMuertoLoDeja(t *testing.T) {
+	taller, dir := tallerConCoordinador(t)
+	w := trabajadorDePrueba(t, dir, "Luis", Mecanica)
+	w.Latido = 10 * time.Millisecond
+	w.Porcion = time.Second
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 5
+	taller.colas.encolar(tr)
+
+	errs := make(chan error, 1)
+	go func() { errs <- w.ejecutar(nil) }()
+	esperarQue(t, "empieza el trabajo", func() bool { return estadoDe(taller, tr.Incidencia) == 1 })
+	taller.concesiones.revisar(time.Now().Add(time.Hour))
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("debería terminar con error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("el trabajador sigue trabajando en un trabajo que ya no es suyo")
+	}
+	if estadoDe(taller, tr.Incidencia) != 0 || taller.colas.Longitud(Mecanica) != 1 {
+		t.Errorf("el trabajo debería estar en la cola")
+	}
+}
+
+// La goroutine de un mecánico local falla con el trabajo entre manos: el
+// programa sigue y el vigilante recupera el trabajo
+func TestMecanicoLocalQueFallaPierdeSuTrabajo(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	vigilarConPlazo(t, taller, 50*time.Millisecond)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 4
+	taller.colas.encolar(tr)
+
+	go taller.sinPanico(m, func() {
+		trabajo, _ := tomarAntesDe(taller.colas, m, time.Second)
+		trabajo.Incidencia.Estado = 1
+		cn := taller.concesiones.conceder(m, trabajo, 4, taller.colas.empezarReparacion(m, trabajo))
+		taller.concesiones.avance(cn, 1)
+		panic("se rompe el elevador")
+	})
+
+	esperarQue(t, "se recupera el trabajo", func() bool { return len(taller.concesiones.Fallos()) == 1 })
+	if tr.Incidencia.Estado != 0 || tr.Incidencia.pendiente() != 3 || taller.colas.Longitud(Mecanica) != 1 {
+		t.Errorf("el trabajo debería volver a la cola con 3 porciones: %+v", tr.Incidencia)
+	}
+	if m.Activo {
+		t.Error("el mecánico caído no debería seguir activo")
+	}
+}
+
+// Un mecánico local que se da por muerto mientras repara (tarda más que el
+// plazo en dar señales) no apunta nada al terminar y deja de trabajar
+func TestMecanicoLentoNoApuntaTrasDarsePorMuerto(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	vigilarConPlazo(t, taller, porcionReparacion/4)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 1
+	taller.colas.encolar(tr)
+	resultados := resultadosDePrueba(taller)
+
+	hecho := make(chan struct{})
+	go func() {
+		trabajoMecanico(m, taller)
+		close(hecho)
+	}()
+	esperarQue(t, "se le da por muerto", func() bool { return len(taller.concesiones.Fallos()) == 1 })
+	select {
+	case <-hecho:
+	case <-time.After(3 * porcionReparacion):
+		t.Fatal("el mecánico dado por muerto sigue trabajando")
+	}
+	if tr.Incidencia.Estado != 0 || tr.Incidencia.TiempoAcumulado != 1 || len(tr.Incidencia.ManoDeObra) != 0 {
+		t.Errorf("no debería apuntarse el trabajo del mecánico muerto: %+v", tr.Incidencia)
+	}
+	if len(resultados) != 0 {
+		t.Errorf("resultado inesperado: %s", (<-resultados).Texto)
+	}
+}
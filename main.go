@@ -1,12 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 const MAX_PLAZAS = 8 // número máximo total de plazas en el taller
@@ -26,7 +30,7 @@ const (
 type Cliente struct {
 	ID        int
 	Nombre    string
-	Telefono  int
+	Telefono  string // formato internacional, p.ej. +34600111222
 	Email     string
 	Vehiculos []*Vehiculo
 }
@@ -35,11 +39,12 @@ type Vehiculo struct {
 	Matricula    string
 	Marca        string
 	Modelo       string
-	FechaEntrada string
-	FechaSalida  string
+	FechaEntrada time.Time
+	FechaSalida  time.Time // cero mientras el vehículo siga en el taller
 	Incidencias  []*Incidencia
 	TiempoTotal  int
 	Prioritario  bool
+	Sede         string // sede de la federación a la que se trasladó ("" = está aquí)
 }
 
 type Incidencia struct {
@@ -50,6 +55,11 @@ type Incidencia struct {
 	Descripcion     string
 	Estado          int // 0 abierta, 1 en proceso, 2 cerrada
 	TiempoAcumulado int
+	Hecho           int              // porciones ya reparadas, sumando las de cada interrupción
+	Piezas          []PiezaNecesaria // material que hace falta para repararla
+	PiezasServidas  bool             // ya se ha sacado del almacén
+	ManoDeObra      []ParteTrabajo   // horas trabajadas por cada mecánico
+	Facturada       bool
 }
 
 type Mecanico struct {
@@ -58,6 +68,8 @@ type Mecanico struct {
 	Especialidad Especialidad
 	AñosExp      int
 	Activo       bool
+	Turnos       []Turno    // horario semanal; sin turnos trabaja siempre
+	Ausencias    []Ausencia // vacaciones y bajas
 }
 
 type Plaza struct {
@@ -65,22 +77,78 @@ type Plaza struct {
 	Ocupada     bool
 	VehiculoMat string
 	MecanicoID  int
+	Retirar     bool // era de un temporal que ya no está: se quita al quedar libre
 }
 
 type Taller struct {
-	Clientes         []*Cliente
-	Vehiculos        []*Vehiculo
-	Mecanicos        []*Mecanico
-	Incidencias      []*Incidencia
-	Plazas           []*Plaza
-	nextClienteID    int // para que sea incremental y no al azar.
-	nextIncidenciaID int
-	nextMecanicoID   int
+	Clientes          []*Cliente
+	Vehiculos         []*Vehiculo
+	Mecanicos         []*Mecanico
+	Incidencias       []*Incidencia
+	Plazas            []*Plaza
+	Citas             []*Cita
+	Piezas            []*Pieza
+	Movimientos       []MovimientoStock
+	Presupuestos      []*Presupuesto
+	Facturas          []*Factura
+	Visitas           []*Visita
+	Avisos            EstadoAvisos      // lo que queda en la bandeja de salida
+	FlotaFuera        map[string]string // vehículos repartidos que siguen fuera de su dueño (ver Enrutador)
+	nextClienteID     int               // para que sea incremental y no al azar.
+	nextIncidenciaID  int
+	nextMecanicoID    int
+	nextCitaID        int
+	nextPiezaID       int
+	nextPresupuestoID int
+	nextFacturaNum    int // número de la última factura emitida
+	nextVisitaID      int
+	almacen           Almacen               // dónde se guardan los datos (nil = sólo memoria)
+	seguimiento       *Seguimiento          // estado en vivo de la última simulación
+	plantilla         *ControladorPlantilla // contrataciones durante la simulación
+	colas             *ColasTrabajo         // trabajos pendientes, una cola por especialidad
+	reloj             *RelojSimulado        // hora del taller durante la simulación (nil = hora real)
+	inventario        *Inventario           // reservas y pedidos de piezas durante la simulación
+	avisos            *BandejaSalida        // avisos pendientes a los clientes (nil = no se avisa)
+	coordinador       *Coordinador          // mecánicos remotos conectados por net/rpc (nil = sólo locales)
+	concesiones       *TablaConcesiones     // trabajos en curso y sus latidos (nil = no se vigilan)
+	federacion        *Federacion           // otras sedes de la empresa (nil = taller aislado)
+	eventos           *RegistroEventos      // orden causal de la simulación (nil = no se apunta)
+	exclusion         *ExclusionMutua       // turno de las plazas entre nodos de admisión (nil = nodo único)
+	entregas          *TablaEntregas        // quién tiene y quién terminó cada trabajo (nil = no se controla)
+	broker            *Broker               // temas de la simulación (nil = no se publica)
+	fragmento         *Fragmento            // parte de la flota repartida que guarda este nodo (nil = no se reparte)
+	flota             *Enrutador            // reparto de la flota por matrícula entre nodos (nil = no se reparte)
+
+	mu sync.Mutex // lo tiene quien lee o cambia el taller (ver conTaller)
 }
 
 // ------------ FUNCIONES DE CREACIÓN ------------
 
-func (t *Taller) newCliente(nombre string, tlf int, email string, vs []*Vehiculo) *Cliente {
+// Valida los datos de contacto de un cliente. Teléfono y email son opcionales,
+// pero si se indican deben ser correctos; se devuelven normalizados.
+func validarCliente(nombre, tlf, email string) (string, string, error) {
+	if strings.TrimSpace(nombre) == "" {
+		return "", "", errorValidacion("nombre", nombre, "no puede estar vacío")
+	}
+	var err error
+	if tlf != "" {
+		if tlf, err = validarTelefono(tlf); err != nil {
+			return "", "", err
+		}
+	}
+	if email != "" {
+		if email, err = validarEmail(email); err != nil {
+			return "", "", err
+		}
+	}
+	return tlf, email, nil
+}
+
+func (t *Taller) newCliente(nombre string, tlf string, email string, vs []*Vehiculo) (*Cliente, error) {
+	tlf, email, err := validarCliente(nombre, tlf, email)
+	if err != nil {
+		return nil, err
+	}
 	c := &Cliente{
 		ID:        t.nextClienteID,
 		Nombre:    nombre,
@@ -89,11 +157,23 @@ func (t *Taller) newCliente(nombre string, tlf int, email string, vs []*Vehiculo
 		Vehiculos: vs,
 	}
 	t.nextClienteID++
-	t.Clientes = append(t.Clientes, c)
-	return c
+	if err := t.datos().GuardarCliente(c); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func (t *Taller) newVehiculo(mat string, mar string, mod string, fentrada string, fsalida string, ins []*Incidencia) *Vehiculo {
+func (t *Taller) newVehiculo(mat string, mar string, mod string, fentrada time.Time, fsalida time.Time, ins []*Incidencia) (*Vehiculo, error) {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return nil, err
+	}
+	if t.getVehiculo(mat) != nil {
+		return nil, errorValidacion("matrícula", mat, "ya hay un vehículo con esa matrícula")
+	}
+	if err := validarFechas(fentrada, fsalida); err != nil {
+		return nil, err
+	}
 	v := &Vehiculo{
 		Matricula:    mat,
 		Marca:        mar,
@@ -104,8 +184,10 @@ func (t *Taller) newVehiculo(mat string, mar string, mod string, fentrada string
 		TiempoTotal:  0,
 		Prioritario:  false,
 	}
-	t.Vehiculos = append(t.Vehiculos, v)
-	return v
+	if err := t.datos().GuardarVehiculo(v); err != nil {
+		return nil, err
+	}
+	return v, nil
 }
 
 func (t *Taller) newIncidencia(mat string, mecs []*Mecanico, tip string, p string, d string) (*Incidencia, error) {
@@ -131,20 +213,37 @@ func (t *Taller) newIncidencia(mat string, mecs []*Mecanico, tip string, p strin
 		TiempoAcumulado: 0,
 	}
 	t.nextIncidenciaID++
+	inc.TiempoAcumulado = tiempoEstimado(esp)
+
+	err := t.datos().Transaccion(func() error {
+		v.Incidencias = append(v.Incidencias, inc)
+		t.updateTiempoTotalVehiculo(v)
+		if err := t.datos().GuardarIncidencia(inc); err != nil {
+			return err
+		}
+		if err := t.añadirAVisita(v.Matricula, inc); err != nil {
+			return err
+		}
+		return t.datos().GuardarVehiculo(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inc, nil
+}
 
+// Tiempo estimado de reparación de cada tipo de incidencia (en segundos de
+// simulación, que equivalen a horas de taller)
+func tiempoEstimado(esp Especialidad) int {
 	switch esp {
 	case Mecanica:
-		inc.TiempoAcumulado = 5
+		return 5
 	case Electrica:
-		inc.TiempoAcumulado = 7
+		return 7
 	case Carroceria:
-		inc.TiempoAcumulado = 11
+		return 11
 	}
-
-	t.Incidencias = append(t.Incidencias, inc)
-	v.Incidencias = append(v.Incidencias, inc)
-	t.updateTiempoTotalVehiculo(v)
-	return inc, nil
+	return 0
 }
 
 func (t *Taller) newMecanico(n string, e string, a int) *Mecanico {
@@ -163,12 +262,14 @@ func (t *Taller) newMecanico(n string, e string, a int) *Mecanico {
 		Activo:       true,
 	}
 	t.nextMecanicoID++
-	t.Mecanicos = append(t.Mecanicos, m)
+	if err := t.datos().GuardarMecanico(m); err != nil {
+		fmt.Println("Error guardando mecánico:", err)
+	}
 
 	// Control del máximo de plazas
-	plazasDisponibles := MAX_PLAZAS - len(t.Plazas)
+	plazasDisponibles := MAX_PLAZAS - len(t.datos().Plazas())
 	if plazasDisponibles <= 0 {
-		fmt.Printf("No se pueden crear nuevas plazas: límite máximo (%d) alcanzado\n", MAX_PLAZAS)
+		t.avisar("No se pueden crear nuevas plazas: límite máximo (%d) alcanzado", MAX_PLAZAS)
 		return m
 	}
 
@@ -177,18 +278,31 @@ func (t *Taller) newMecanico(n string, e string, a int) *Mecanico {
 		plazasACrear = plazasDisponibles
 	}
 
-	for i := 0; i < plazasACrear; i++ {
-		plazaID := len(t.Plazas) + 1
-		p := &Plaza{
-			ID:         plazaID,
-			Ocupada:    false,
-			MecanicoID: m.ID,
+	err := t.conPlazas(func() error {
+		for i := 0; i < plazasACrear; i++ {
+			// Las plazas de los temporales se quitan: el número de plazas
+			// no sirve como ID
+			plazaID := 1
+			for _, p := range t.datos().Plazas() {
+				plazaID = max(plazaID, p.ID+1)
+			}
+			p := &Plaza{
+				ID:         plazaID,
+				Ocupada:    false,
+				MecanicoID: m.ID,
+			}
+			if err := t.datos().GuardarPlaza(p); err != nil {
+				return err
+			}
 		}
-		t.Plazas = append(t.Plazas, p)
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error guardando plaza:", err)
 	}
 
-	fmt.Printf("Mecánico %s creado (%s) — se añaden %d plazas (total: %d/%d)\n",
-		m.Nombre, e, plazasACrear, len(t.Plazas), MAX_PLAZAS)
+	t.avisar("Mecánico %s creado (%s) — se añaden %d plazas (total: %d/%d)",
+		m.Nombre, e, plazasACrear, len(t.datos().Plazas()), MAX_PLAZAS)
 
 	return m
 }
@@ -196,85 +310,115 @@ func (t *Taller) newMecanico(n string, e string, a int) *Mecanico {
 // ------------ FUNCIONES DE OBTENCIÓN ------------
 
 func (t *Taller) getCliente(id int) *Cliente {
-	for _, c := range t.Clientes {
-		if c.ID == id {
-			return c
-		}
-	}
-	return nil
+	return t.datos().Cliente(id)
 }
 
 func (t *Taller) getVehiculo(mat string) *Vehiculo {
-	for _, v := range t.Vehiculos {
-		if v.Matricula == mat {
-			return v
+	// Se admite la matrícula escrita de cualquier forma válida (1234bcd, 1234-BCD...)
+	if normalizada, err := validarMatricula(mat); err == nil {
+		mat = normalizada
+	}
+	return t.datos().Vehiculo(mat)
+}
+
+// Cliente al que está asignado el vehículo (nil si no es de ninguno)
+func (t *Taller) clienteDeVehiculo(mat string) *Cliente {
+	for _, c := range t.datos().Clientes() {
+		for _, v := range c.Vehiculos {
+			if v.Matricula == mat {
+				return c
+			}
 		}
 	}
 	return nil
 }
 
 func (t *Taller) getIncidencia(id int) *Incidencia {
-	for _, inc := range t.Incidencias {
-		if inc.ID == id {
-			return inc
+	return t.datos().Incidencia(id)
+}
+
+func (t *Taller) vehiculoDeIncidencia(id int) *Vehiculo {
+	for _, v := range t.datos().Vehiculos() {
+		for _, inc := range v.Incidencias {
+			if inc.ID == id {
+				return v
+			}
 		}
 	}
 	return nil
 }
 
 func (t *Taller) getMecanico(id int) *Mecanico {
-	for _, m := range t.Mecanicos {
-		if m.ID == id {
-			return m
-		}
-	}
-	return nil
+	return t.datos().Mecanico(id)
 }
 
 // ------------ FUNCIONES DE MODIFICACIÓN ------------
 
-func (t *Taller) updateCliente(id int, nombre string, tlf int, email string) error {
+func (t *Taller) updateCliente(id int, nombre string, tlf string, email string) error {
 	c := t.getCliente(id)
 	if c == nil {
 		return fmt.Errorf("cliente con ID %d no encontrado", id)
 	}
+	var err error
+	if tlf != "" {
+		if tlf, err = validarTelefono(tlf); err != nil {
+			return err
+		}
+	}
+	if email != "" {
+		if email, err = validarEmail(email); err != nil {
+			return err
+		}
+	}
 	if nombre != "" {
 		c.Nombre = nombre
 	}
-	if tlf != 0 {
+	if tlf != "" {
 		c.Telefono = tlf
 	}
 	if email != "" {
 		c.Email = email
 	}
-	return nil
+	return t.datos().GuardarCliente(c)
 }
 
-func (t *Taller) updateVehiculo(mat, marca, modelo, fEntrada, fSalida string) error {
+// Las fechas a cero (y los textos vacíos) dejan el valor actual
+func (t *Taller) updateVehiculo(mat, marca, modelo string, fEntrada, fSalida time.Time) error {
 	v := t.getVehiculo(mat)
 	if v == nil {
 		return fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
 	}
+	entrada, salida := v.FechaEntrada, v.FechaSalida
+	if !fEntrada.IsZero() {
+		entrada = fEntrada
+	}
+	if !fSalida.IsZero() {
+		salida = fSalida
+	}
+	if err := validarFechas(entrada, salida); err != nil {
+		return err
+	}
 	if marca != "" {
 		v.Marca = marca
 	}
 	if modelo != "" {
 		v.Modelo = modelo
 	}
-	if fEntrada != "" {
-		v.FechaEntrada = fEntrada
-	}
-	if fSalida != "" {
-		v.FechaSalida = fSalida
-	}
-	return nil
+	v.FechaEntrada = entrada
+	v.FechaSalida = salida
+	return t.datos().GuardarVehiculo(v)
+}
+
+// Lo que falta para terminar la incidencia
+func (inc *Incidencia) pendiente() int {
+	return max(inc.TiempoAcumulado-inc.Hecho, 0)
 }
 
 func (t *Taller) updateTiempoTotalVehiculo(v *Vehiculo) {
 	total := 0
 	for _, inc := range v.Incidencias {
 		if inc.Estado != 2 {
-			total += inc.TiempoAcumulado
+			total += inc.pendiente()
 		}
 	}
 	v.TiempoTotal = total
@@ -304,7 +448,7 @@ func (t *Taller) updateMecanico(id int, nombre, especialidad string, a int, acti
 	}
 
 	if !activo {
-		for _, inc := range t.Incidencias {
+		for _, inc := range t.datos().Incidencias() {
 			for _, mec := range inc.Mecanicos {
 				if mec.ID == id && inc.Estado == 0 {
 					return fmt.Errorf(
@@ -317,7 +461,7 @@ func (t *Taller) updateMecanico(id int, nombre, especialidad string, a int, acti
 	}
 
 	m.Activo = activo
-	return nil
+	return t.datos().GuardarMecanico(m)
 }
 
 func (t *Taller) updateIncidencia(id int, tipo, prioridad, desc string, estado int) error {
@@ -339,52 +483,48 @@ func (t *Taller) updateIncidencia(id int, tipo, prioridad, desc string, estado i
 		inc.Descripcion = desc
 	}
 	if estado >= 0 && estado <= 2 {
+		if estado == 1 && inc.Estado == 0 {
+			if v := t.vehiculoDeIncidencia(id); v != nil {
+				if err := t.puedeRepararse(v); err != nil {
+					return err
+				}
+			}
+		}
+		if estado == 2 && inc.Estado != 2 {
+			defer t.avisarIncidenciaCerrada(inc)
+		}
 		inc.Estado = estado
 	}
-	return nil
+	return t.datos().GuardarIncidencia(inc)
 }
 
 // ------------ FUNCIONES DE ELIMINACIÓN ------------
 
-func (t *Taller) deleteCliente(id int) {
-	for i, c := range t.Clientes {
-		if c.ID == id {
-			t.Clientes = append(t.Clientes[:i], t.Clientes[i+1:]...)
-			break
-		}
-	}
+func (t *Taller) deleteCliente(id int) error {
+	return t.datos().BorrarCliente(id)
 }
 
-func (t *Taller) deleteVehiculo(mat string) {
-	for i, v := range t.Vehiculos {
-		if v.Matricula == mat {
-			t.Vehiculos = append(t.Vehiculos[:i], t.Vehiculos[i+1:]...)
-			break
-		}
-	}
+func (t *Taller) deleteVehiculo(mat string) error {
+	return t.datos().BorrarVehiculo(mat)
 }
 
-func (t *Taller) deleteIncidencia(id int) {
-	for _, v := range t.Vehiculos {
-		newIncs := []*Incidencia{}
-		for _, inc := range v.Incidencias {
-			if inc.ID != id {
-				newIncs = append(newIncs, inc)
+func (t *Taller) deleteIncidencia(id int) error {
+	return t.datos().Transaccion(func() error {
+		for _, v := range t.datos().Vehiculos() {
+			newIncs := []*Incidencia{}
+			for _, inc := range v.Incidencias {
+				if inc.ID != id {
+					newIncs = append(newIncs, inc)
+				}
 			}
+			v.Incidencias = newIncs
 		}
-		v.Incidencias = newIncs
-	}
-
-	for i, inc := range t.Incidencias {
-		if inc.ID == id {
-			t.Incidencias = append(t.Incidencias[:i], t.Incidencias[i+1:]...)
-			break
-		}
-	}
+		return t.datos().BorrarIncidencia(id)
+	})
 }
 
 func (t *Taller) deleteMecanico(id int) error {
-	for _, inc := range t.Incidencias {
+	for _, inc := range t.datos().Incidencias() {
 		for _, mec := range inc.Mecanicos {
 			if mec.ID == id {
 				return fmt.Errorf("no se puede eliminar el mecánico ID %d: está asignado a una incidencia ID %d", id, inc.ID)
@@ -392,13 +532,7 @@ func (t *Taller) deleteMecanico(id int) error {
 		}
 	}
 
-	for i, m := range t.Mecanicos {
-		if m.ID == id {
-			t.Mecanicos = append(t.Mecanicos[:i], t.Mecanicos[i+1:]...)
-			break
-		}
-	}
-	return nil
+	return t.datos().BorrarMecanico(id)
 }
 
 // ---------- FUNCIONES DE MOSTRAR DATOS ----------
@@ -413,7 +547,7 @@ func clearScreen() {
 }
 
 func printCliente(c *Cliente) {
-	fmt.Printf("Cliente %d - %s (%s)\n", c.ID, c.Nombre, c.Email)
+	fmt.Printf("Cliente %d - %s (%s, %s)\n", c.ID, c.Nombre, c.Email, c.Telefono)
 	if len(c.Vehiculos) == 0 {
 		fmt.Println("  Sin vehículos registrados")
 		return
@@ -425,7 +559,7 @@ func printCliente(c *Cliente) {
 }
 
 func (t *Taller) showVehiculosCliente(id int) {
-	for _, c := range t.Clientes {
+	for _, c := range t.datos().Clientes() {
 		if c.ID == id {
 			fmt.Printf("Vehículos del Cliente %s (ID %d):\n", c.Nombre, c.ID)
 			if len(c.Vehiculos) == 0 {
@@ -443,6 +577,10 @@ func (t *Taller) showVehiculosCliente(id int) {
 
 func printVehiculo(v *Vehiculo) {
 	fmt.Printf("Vehículo %s: %s %s (Tiempo estimado en reparar incidencias %d s)\n", v.Matricula, v.Marca, v.Modelo, v.TiempoTotal)
+	fmt.Printf("  Entrada: %s | Salida: %s\n", formatearFecha(v.FechaEntrada), formatearFecha(v.FechaSalida))
+	if v.Sede != "" {
+		fmt.Printf("  Trasladado a la sede %s\n", v.Sede)
+	}
 	if len(v.Incidencias) == 0 {
 		fmt.Println("  Sin incidencias registradas")
 		return
@@ -454,7 +592,7 @@ func printVehiculo(v *Vehiculo) {
 }
 
 func (t *Taller) showIncidenciasVehiculo(mat string) {
-	for _, v := range t.Vehiculos {
+	for _, v := range t.datos().Vehiculos() {
 		if v.Matricula == mat {
 			fmt.Printf("Vehículo: %s\n", v.Matricula)
 			if len(v.Incidencias) == 0 {
@@ -496,6 +634,18 @@ func printIncidencia(i *Incidencia) {
 	}
 	fmt.Printf("Estado: %s\n", estadoStr)
 
+	if len(i.Piezas) > 0 {
+		var lineas []string
+		for _, pn := range i.Piezas {
+			lineas = append(lineas, fmt.Sprintf("%d x pieza %d", pn.Cantidad, pn.PiezaID))
+		}
+		servidas := "pendientes"
+		if i.PiezasServidas {
+			servidas = "servidas"
+		}
+		fmt.Printf("Piezas (%s): %s\n", servidas, strings.Join(lineas, ", "))
+	}
+
 	if len(i.Mecanicos) > 0 {
 		fmt.Println("Mecánicos asignados:")
 		for j, m := range i.Mecanicos {
@@ -523,7 +673,7 @@ func estadoToString(est int) string {
 func (t *Taller) showIncidenciasMecanico(id int) {
 	fmt.Printf("Incidencias del Mecánico ID %d:\n", id)
 	hay := false
-	for _, inc := range t.Incidencias {
+	for _, inc := range t.datos().Incidencias() {
 		for _, mec := range inc.Mecanicos {
 			if mec.ID == id {
 				fmt.Printf("  ID: %d | Tipo: %s | Prioridad: %s | Estado: %s\n",
@@ -554,10 +704,10 @@ func (t *Taller) showMecanicosActivos() {
 	fmt.Println("Mecánicos activos (sin incidencias asignadas):")
 	hay := false
 
-	for _, m := range t.Mecanicos {
+	for _, m := range t.datos().Mecanicos() {
 		asignado := false
 
-		for _, inc := range t.Incidencias {
+		for _, inc := range t.datos().Incidencias() {
 			for _, mec := range inc.Mecanicos {
 				if mec.ID == m.ID {
 					asignado = true
@@ -597,7 +747,7 @@ func printPlaza(p *Plaza) {
 
 func (t *Taller) plazasOcupadas() []*Plaza {
 	var ocupadas []*Plaza
-	for _, p := range t.Plazas {
+	for _, p := range t.datos().Plazas() {
 		if p.Ocupada {
 			ocupadas = append(ocupadas, p)
 		}
@@ -605,6 +755,50 @@ func (t *Taller) plazasOcupadas() []*Plaza {
 	return ocupadas
 }
 
+// Devuelve la plaza que ocupa el vehículo, o nil si no está en ninguna
+func (t *Taller) plazaDeVehiculo(mat string) *Plaza {
+	for _, p := range t.datos().Plazas() {
+		if p.Ocupada && p.VehiculoMat == mat {
+			return p
+		}
+	}
+	return nil
+}
+
+// Deja la plaza libre; si era de un temporal que ya no está, la quita. Se
+// llama con el turno de las plazas (ver conPlazas).
+func (t *Taller) vaciarPlaza(p *Plaza) error {
+	p.Ocupada = false
+	p.VehiculoMat = ""
+	if p.Retirar {
+		return t.datos().BorrarPlaza(p.ID)
+	}
+	return t.datos().GuardarPlaza(p)
+}
+
+// Al acabar el contrato de un temporal se quitan sus plazas. Las que tienen
+// un vehículo se quedan hasta que sale (ver vaciarPlaza).
+func (t *Taller) retirarPlazas(m *Mecanico) error {
+	return t.conPlazas(func() error {
+		for _, p := range slices.Clone(t.datos().Plazas()) {
+			if p.MecanicoID != m.ID {
+				continue
+			}
+			var err error
+			if p.Ocupada {
+				p.Retirar = true
+				err = t.datos().GuardarPlaza(p)
+			} else {
+				err = t.datos().BorrarPlaza(p.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // Verifica si un vehículo ha terminado todas sus incidencias y libera su plaza si corresponde
 func (t *Taller) liberarPlaza(v *Vehiculo) {
 	reparado := true
@@ -619,15 +813,38 @@ func (t *Taller) liberarPlaza(v *Vehiculo) {
 		return
 	}
 
-	for _, p := range t.Plazas {
-		if p.VehiculoMat == v.Matricula {
-			p.Ocupada = false
-			p.VehiculoMat = ""
-			fmt.Printf("Vehículo %s finalizó todas las incidencias. Plaza %d liberada (%d/%d ocupadas)\n",
-				v.Matricula, p.ID, len(t.plazasOcupadas()), len(t.Plazas))
-			break
+	err := t.conPlazas(func() error {
+		for _, p := range t.datos().Plazas() {
+			if p.VehiculoMat == v.Matricula {
+				if err := t.vaciarPlaza(p); err != nil {
+					return err
+				}
+				t.avisar("Vehículo %s finalizó todas las incidencias. Plaza %d liberada (%d/%d ocupadas)",
+					v.Matricula, p.ID, len(t.plazasOcupadas()), len(t.datos().Plazas()))
+				t.publicar(Mensaje{Tema: temaPlazaLiberada, Matricula: v.Matricula, PlazaID: p.ID})
+				return nil
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error guardando plaza:", err)
+	}
+
+	// El vehículo sale reparado: se factura lo que se le ha hecho, se cierra
+	// su visita y se avisa al cliente de que puede recogerlo
+	d := datosAviso{}
+	f, err := t.facturar(v, t.ahora())
+	if err != nil {
+		t.avisar("Error facturando el vehículo %s: %v", v.Matricula, err)
+	} else if f != nil {
+		t.avisar("Factura %s del vehículo %s: %.2f € (IVA incluido)", f.Codigo(), v.Matricula, f.Total)
+		d.Total = f.Total
+	}
+	if err := t.cerrarVisita(v, f, t.ahora()); err != nil {
+		t.avisar("Error cerrando la visita del vehículo %s: %v", v.Matricula, err)
 	}
+	t.notificar(AvisoVehiculoListo, v, d, "")
 }
 
 func printTaller(t *Taller) {
@@ -637,11 +854,12 @@ func printTaller(t *Taller) {
 	}
 
 	fmt.Println("=== Taller ===")
+	d := t.datos()
 
 	// ---- CLIENTES ----
-	fmt.Printf("Clientes (%d):\n", len(t.Clientes))
-	if len(t.Clientes) > 0 {
-		for i, c := range t.Clientes {
+	fmt.Printf("Clientes (%d):\n", len(d.Clientes()))
+	if len(d.Clientes()) > 0 {
+		for i, c := range d.Clientes() {
 			fmt.Printf("  Cliente %d:\n", i+1)
 			printCliente(c)
 			fmt.Println()
@@ -651,9 +869,9 @@ func printTaller(t *Taller) {
 	}
 
 	// ---- VEHÍCULOS ----
-	fmt.Printf("\nVehículos (%d):\n", len(t.Vehiculos))
-	if len(t.Vehiculos) > 0 {
-		for i, v := range t.Vehiculos {
+	fmt.Printf("\nVehículos (%d):\n", len(d.Vehiculos()))
+	if len(d.Vehiculos()) > 0 {
+		for i, v := range d.Vehiculos() {
 			fmt.Printf("  Vehículo %d:\n", i+1)
 			printVehiculo(v)
 			fmt.Println()
@@ -663,9 +881,9 @@ func printTaller(t *Taller) {
 	}
 
 	// ---- MECÁNICOS ----
-	fmt.Printf("\nMecánicos (%d):\n", len(t.Mecanicos))
-	if len(t.Mecanicos) > 0 {
-		for i, m := range t.Mecanicos {
+	fmt.Printf("\nMecánicos (%d):\n", len(d.Mecanicos()))
+	if len(d.Mecanicos()) > 0 {
+		for i, m := range d.Mecanicos() {
 			fmt.Printf("  Mecánico %d:\n", i+1)
 			printMecanico(m)
 			fmt.Println()
@@ -675,9 +893,9 @@ func printTaller(t *Taller) {
 	}
 
 	// ---- INCIDENCIAS ----
-	fmt.Printf("\nIncidencias (%d):\n", len(t.Incidencias))
-	if len(t.Incidencias) > 0 {
-		for i, inc := range t.Incidencias {
+	fmt.Printf("\nIncidencias (%d):\n", len(d.Incidencias()))
+	if len(d.Incidencias()) > 0 {
+		for i, inc := range d.Incidencias() {
 			fmt.Printf("  Incidencia %d:\n", i+1)
 			printIncidencia(inc)
 			fmt.Println()
@@ -687,9 +905,9 @@ func printTaller(t *Taller) {
 	}
 
 	// ---- PLAZAS ----
-	fmt.Printf("\nPlazas (%d):\n", len(t.Plazas))
-	if len(t.Plazas) > 0 {
-		for i, p := range t.Plazas {
+	fmt.Printf("\nPlazas (%d):\n", len(d.Plazas()))
+	if len(d.Plazas()) > 0 {
+		for i, p := range d.Plazas() {
 			fmt.Printf("  Plaza %d:\n", i+1)
 			printPlaza(p)
 			fmt.Println()
@@ -704,61 +922,94 @@ func printTaller(t *Taller) {
 }
 
 func (t *Taller) admitirCliente(clienteID int, v *Vehiculo, mecanicoID int) error {
+	cliente, plaza, err := t.admitirEn(clienteID, v, mecanicoID, t.ahora())
+	if err != nil {
+		return err
+	}
+	t.avisarAdmision(cliente, v, plaza, mecanicoID)
+	return nil
+}
+
+// Da entrada al vehículo del cliente en una plaza libre con fecha ahora. No
+// avisa a nadie: eso lo hace avisarAdmision.
+func (t *Taller) admitirEn(clienteID int, v *Vehiculo, mecanicoID int, ahora time.Time) (*Cliente, *Plaza, error) {
 	// Verificar si el cliente existe
 	cliente := t.getCliente(clienteID)
 	if cliente == nil {
-		return fmt.Errorf("cliente con ID %d no encontrado", clienteID)
+		return nil, nil, fmt.Errorf("cliente con ID %d no encontrado", clienteID)
 	}
 
-	// Verificar si el vehículo ya está asignado a alguna plaza
-	for _, p := range t.Plazas {
-		if p.VehiculoMat == v.Matricula {
-			return fmt.Errorf("el vehículo %s ya está asignado a la plaza %d", v.Matricula, p.ID)
+	// Buscar y ocupar la plaza con el turno de las plazas, para que otro
+	// nodo de admisión no se quede con la misma
+	var plazaLibre *Plaza
+	err := t.conPlazas(func() error {
+		// Verificar si el vehículo ya está asignado a alguna plaza
+		for _, p := range t.datos().Plazas() {
+			if p.VehiculoMat == v.Matricula {
+				return fmt.Errorf("el vehículo %s ya está asignado a la plaza %d", v.Matricula, p.ID)
+			}
 		}
-	}
 
-	// Buscar una plaza libre
-	plazaLibre := -1
-	for i, p := range t.Plazas {
-		if !p.Ocupada {
-			plazaLibre = i
-			break
+		// Buscar una plaza libre
+		for _, p := range t.datos().Plazas() {
+			if !p.Ocupada {
+				plazaLibre = p
+				break
+			}
+		}
+		if plazaLibre == nil {
+			return fmt.Errorf("no hay plazas disponibles para el vehículo %s", v.Matricula)
 		}
-	}
-	if plazaLibre == -1 {
-		return fmt.Errorf("no hay plazas disponibles para el vehículo %s", v.Matricula)
-	}
-
-	// Asegurar que el vehículo esté en el registro del taller
-	existente := t.getVehiculo(v.Matricula)
-	if existente == nil {
-		t.Vehiculos = append(t.Vehiculos, v)
-	}
 
-	// Verificar si el cliente ya tiene el vehículo asignado
-	for _, veh := range cliente.Vehiculos {
-		if veh.Matricula == v.Matricula {
-			return fmt.Errorf("el vehículo %s ya está asignado al cliente %s", v.Matricula, cliente.Nombre)
+		// El vehículo no puede ser de otro cliente; si ya es de este, vuelve
+		// al taller en una visita nueva
+		if otro := t.clienteDeVehiculo(v.Matricula); otro != nil && otro.ID != cliente.ID {
+			return fmt.Errorf("el vehículo %s ya está asignado al cliente %s", v.Matricula, otro.Nombre)
 		}
-	}
 
-	// Asignar el vehículo al cliente
-	cliente.Vehiculos = append(cliente.Vehiculos, v)
+		return t.datos().Transaccion(func() error {
+			// Asegurar que el vehículo esté en el registro del taller
+			if t.getVehiculo(v.Matricula) == nil {
+				if err := t.datos().GuardarVehiculo(v); err != nil {
+					return err
+				}
+			}
 
-	// Asignar el vehículo a la plaza libre
-	t.Plazas[plazaLibre].Ocupada = true
-	t.Plazas[plazaLibre].VehiculoMat = v.Matricula
-	t.Plazas[plazaLibre].MecanicoID = mecanicoID
+			// Asignar el vehículo al cliente
+			if t.clienteDeVehiculo(v.Matricula) == nil {
+				cliente.Vehiculos = append(cliente.Vehiculos, v)
+				if err := t.datos().GuardarCliente(cliente); err != nil {
+					return err
+				}
+			}
 
-	fmt.Printf("Vehículo %s asignado correctamente al cliente %s (plaza %d, mecánico %d)\n",
-		v.Matricula, cliente.Nombre, t.Plazas[plazaLibre].ID, mecanicoID)
+			// Asignar el vehículo a la plaza libre
+			plazaLibre.Ocupada = true
+			plazaLibre.VehiculoMat = v.Matricula
+			plazaLibre.MecanicoID = mecanicoID
+			if err := t.datos().GuardarPlaza(plazaLibre); err != nil {
+				return err
+			}
+			_, err := t.abrirVisita(v, plazaLibre.ID, ahora)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cliente, plazaLibre, nil
+}
 
-	return nil
+// Cuenta que el vehículo ha entrado y avisa al cliente
+func (t *Taller) avisarAdmision(cliente *Cliente, v *Vehiculo, plaza *Plaza, mecanicoID int) {
+	fmt.Printf("Vehículo %s asignado correctamente al cliente %s (plaza %d, mecánico %d)\n",
+		v.Matricula, cliente.Nombre, plaza.ID, mecanicoID)
+	t.notificar(AvisoAdmision, v, datosAviso{Plaza: plaza.ID}, "")
 }
 
 // ---------- SUBMENÚS DE LAS ESTRUCTURAS ----------
 
-func menuClientes(t *Taller) {
+func menuClientes(t *Taller, in *Entrada) {
 	for {
 		fmt.Println("\n--- CLIENTES ---")
 		fmt.Println("1. Crear cliente")
@@ -768,67 +1019,90 @@ func menuClientes(t *Taller) {
 		fmt.Println("5. Listar vehículos de un cliente")
 		fmt.Println("0. Volver al menú principal")
 
-		var op int
-		fmt.Print("Seleccione: ")
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione", 0, 5)
+		if err != nil {
+			return
+		}
 
 		switch op {
 		case 1:
-			var nombre, email string
-			var tel int
-			fmt.Print("Nombre: ")
-			fmt.Scanln(&nombre)
-			fmt.Print("Teléfono: ")
-			fmt.Scanln(&tel)
-			fmt.Print("Email: ")
-			fmt.Scanln(&email)
-			t.newCliente(nombre, tel, email, nil)
-			fmt.Println("Cliente creado correctamente.")
+			nombre, err := in.Texto("Nombre")
+			if err != nil {
+				return
+			}
+			tel, err := in.ValidadoOpcional("Teléfono (+34...)", validarTelefono)
+			if err != nil {
+				return
+			}
+			email, err := in.ValidadoOpcional("Email", validarEmail)
+			if err != nil {
+				return
+			}
+			if _, err := t.newCliente(nombre, tel, email, nil); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Cliente creado correctamente.")
+			}
 		case 2:
-			if len(t.Clientes) == 0 {
+			if len(t.datos().Clientes()) == 0 {
 				fmt.Println("No hay clientes registrados.")
 				break
 			}
-			for _, c := range t.Clientes {
+			for _, c := range t.datos().Clientes() {
 				printCliente(c)
 				fmt.Println("-----------------------------")
 			}
 		case 3:
-			var id, tel int
-			var nombre, email string
-			fmt.Print("ID de cliente: ")
-			fmt.Scanln(&id)
-			fmt.Print("Nuevo nombre: ")
-			fmt.Scanln(&nombre)
-			fmt.Print("Nuevo teléfono: ")
-			fmt.Scanln(&tel)
-			fmt.Print("Nuevo email: ")
-			fmt.Scanln(&email)
+			id, err := in.Entero("ID de cliente")
+			if err != nil {
+				return
+			}
+			c := t.getCliente(id)
+			if c == nil {
+				fmt.Printf("Cliente con ID %d no encontrado.\n", id)
+				break
+			}
+			// Dejar la línea vacía mantiene el valor actual
+			nombre, err := in.TextoDefecto("Nuevo nombre", c.Nombre)
+			if err != nil {
+				return
+			}
+			tel, err := in.ValidadoDefecto("Nuevo teléfono", c.Telefono, validarTelefono)
+			if err != nil {
+				return
+			}
+			email, err := in.ValidadoDefecto("Nuevo email", c.Email, validarEmail)
+			if err != nil {
+				return
+			}
 			if err := t.updateCliente(id, nombre, tel, email); err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Println("Cliente actualizado.")
 			}
 		case 4:
-			var id int
-			fmt.Print("ID de cliente: ")
-			fmt.Scanln(&id)
-			t.deleteCliente(id)
-			fmt.Println("Cliente eliminado.")
+			id, err := in.Entero("ID de cliente")
+			if err != nil {
+				return
+			}
+			if err := t.deleteCliente(id); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Cliente eliminado.")
+			}
 		case 5:
-			var id int
-			fmt.Print("ID de cliente: ")
-			fmt.Scanln(&id)
+			id, err := in.Entero("ID de cliente")
+			if err != nil {
+				return
+			}
 			t.showVehiculosCliente(id)
 		case 0:
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
 
-func menuVehiculos(t *Taller) {
+func menuVehiculos(t *Taller, in *Entrada) {
 	for {
 		fmt.Println("\n--- VEHÍCULOS ---")
 		fmt.Println("1. Crear vehículo")
@@ -837,73 +1111,106 @@ func menuVehiculos(t *Taller) {
 		fmt.Println("4. Eliminar vehículo")
 		fmt.Println("5. Listar incidencias de un vehículo")
 		fmt.Println("6. Asignar vehículo a plaza")
+		fmt.Println("7. Historial de visitas")
 		fmt.Println("0. Volver")
 
-		var op int
-		fmt.Print("Seleccione: ")
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione", 0, 7)
+		if err != nil {
+			return
+		}
 
 		switch op {
 		case 1:
-			var mat, marca, modelo, fechaE string
-			fmt.Print("Matrícula: ")
-			fmt.Scanln(&mat)
-			fmt.Print("Marca: ")
-			fmt.Scanln(&marca)
-			fmt.Print("Modelo: ")
-			fmt.Scanln(&modelo)
-			fmt.Print("Fecha de entrada: ")
-			fmt.Scanln(&fechaE)
-			t.newVehiculo(mat, marca, modelo, fechaE, "", nil)
-			fmt.Println("Vehículo creado.")
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				return
+			}
+			marca, err := in.Texto("Marca")
+			if err != nil {
+				return
+			}
+			modelo, err := in.Texto("Modelo")
+			if err != nil {
+				return
+			}
+			fe, err := in.Fecha("Fecha de entrada", time.Now())
+			if err != nil {
+				return
+			}
+			if _, err := t.newVehiculo(mat, marca, modelo, fe, time.Time{}, nil); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Vehículo creado.")
+			}
 		case 2:
-			if len(t.Vehiculos) == 0 {
+			if len(t.datos().Vehiculos()) == 0 {
 				fmt.Println("No hay vehículos registrados.")
 				break
 			}
-			for _, v := range t.Vehiculos {
+			for _, v := range t.datos().Vehiculos() {
 				printVehiculo(v)
 				fmt.Println("-----------------------------")
 			}
 		case 3:
-			var mat, marca, modelo, fe, fs string
-			fmt.Print("Matrícula: ")
-			fmt.Scanln(&mat)
-			fmt.Print("Marca: ")
-			fmt.Scanln(&marca)
-			fmt.Print("Modelo: ")
-			fmt.Scanln(&modelo)
-			fmt.Print("Fecha entrada: ")
-			fmt.Scanln(&fe)
-			fmt.Print("Fecha salida: ")
-			fmt.Scanln(&fs)
-			fmt.Print("Fecha salida: ")
-			fmt.Scanln(&fs)
-			if err := t.updateVehiculo(mat, marca, modelo, fe, fs); err != nil {
+			mat, err := in.Texto("Matrícula")
+			if err != nil {
+				return
+			}
+			v := t.getVehiculo(mat)
+			if v == nil {
+				fmt.Printf("Vehículo con matrícula %s no encontrado.\n", mat)
+				break
+			}
+			marca, err := in.TextoDefecto("Marca", v.Marca)
+			if err != nil {
+				return
+			}
+			modelo, err := in.TextoDefecto("Modelo", v.Modelo)
+			if err != nil {
+				return
+			}
+			fe, err := in.Fecha("Fecha entrada", v.FechaEntrada)
+			if err != nil {
+				return
+			}
+			fs, err := in.Fecha("Fecha salida", v.FechaSalida)
+			if err != nil {
+				return
+			}
+			if err := t.updateVehiculo(v.Matricula, marca, modelo, fe, fs); err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Println("Vehículo actualizado.")
 			}
 		case 4:
-			var mat string
-			fmt.Print("Matrícula: ")
-			fmt.Scanln(&mat)
-			t.deleteVehiculo(mat)
-			fmt.Println("Vehículo eliminado.")
+			mat, err := in.Texto("Matrícula")
+			if err != nil {
+				return
+			}
+			if err := t.deleteVehiculo(mat); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Vehículo eliminado.")
+			}
 		case 5:
-			var mat string
-			fmt.Print("Matrícula: ")
-			fmt.Scanln(&mat)
+			mat, err := in.Texto("Matrícula")
+			if err != nil {
+				return
+			}
 			t.showIncidenciasVehiculo(mat)
 		case 6:
-			var mat string
-			var mecID, clienteID int
-			fmt.Print("Matrícula del vehículo: ")
-			fmt.Scanln(&mat)
-			fmt.Print("ID del cliente: ")
-			fmt.Scanln(&clienteID)
-			fmt.Print("ID del mecánico: ")
-			fmt.Scanln(&mecID)
+			mat, err := in.Texto("Matrícula del vehículo")
+			if err != nil {
+				return
+			}
+			clienteID, err := in.Entero("ID del cliente")
+			if err != nil {
+				return
+			}
+			mecID, err := in.Entero("ID del mecánico")
+			if err != nil {
+				return
+			}
 
 			v := t.getVehiculo(mat)
 			if v == nil {
@@ -911,23 +1218,25 @@ func menuVehiculos(t *Taller) {
 				break
 			}
 
-			err := t.admitirCliente(clienteID, v, mecID)
+			err = t.admitirCliente(clienteID, v, mecID)
 			if err != nil {
 				fmt.Println("Error:", err)
 			} else {
 				fmt.Println("Vehículo asignado correctamente al cliente.")
 			}
+		case 7:
+			mat, err := in.Texto("Matrícula")
+			if err != nil {
+				return
+			}
+			printHistorial(t, mat)
 		case 0:
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
 
-func menuIncidencias(t *Taller) {
-	reader := bufio.NewReader(os.Stdin)
-
+func menuIncidencias(t *Taller, in *Entrada) {
 	for {
 		fmt.Println("\n--- INCIDENCIAS ---")
 		fmt.Println("1. Crear incidencia")
@@ -937,92 +1246,135 @@ func menuIncidencias(t *Taller) {
 		fmt.Println("5. Cambiar estado de incidencia")
 		fmt.Println("0. Volver")
 
-		var op int
-		fmt.Print("Seleccione: ")
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione", 0, 5)
+		if err != nil {
+			return
+		}
 
 		switch op {
 		case 1:
-			var mat, tipo, pri, desc string
-			var mecID int
-			fmt.Print("Matrícula: ")
-			fmt.Scanln(&mat)
-			fmt.Print("Tipo: ")
-			fmt.Scanln(&tipo)
-			fmt.Print("Prioridad: ")
-			fmt.Scanln(&pri)
-			fmt.Print("Descripción: ")
-			desc, _ = reader.ReadString('\n')
-			desc = strings.TrimSpace(desc)
-			fmt.Print("ID Mecánico: ")
-			fmt.Scanln(&mecID)
+			mat, err := in.Texto("Matrícula")
+			if err != nil {
+				return
+			}
+			tipo, err := in.Especialidad("Tipo", "")
+			if err != nil {
+				return
+			}
+			pri, err := in.TextoDefecto("Prioridad", "Media")
+			if err != nil {
+				return
+			}
+			desc, err := in.Texto("Descripción")
+			if err != nil {
+				return
+			}
+			mecID, err := in.Entero("ID Mecánico")
+			if err != nil {
+				return
+			}
 			mec := t.getMecanico(mecID)
 			if mec == nil {
 				fmt.Println("Mecánico no encontrado.")
 				break
 			}
-			inc, err := t.newIncidencia(mat, []*Mecanico{mec}, tipo, pri, desc)
+			inc, err := t.newIncidencia(mat, []*Mecanico{mec}, string(tipo), pri, desc)
 			if err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Printf("Incidencia creada (ID: %d)\n", inc.ID)
 			}
 		case 2:
-			if len(t.Incidencias) == 0 {
+			if len(t.datos().Incidencias()) == 0 {
 				fmt.Println("No hay incidencias registradas.")
 				break
 			}
-			for _, inc := range t.Incidencias {
+			for _, inc := range t.datos().Incidencias() {
 				printIncidencia(inc)
 				fmt.Println("-----------------------------")
 			}
 		case 3:
-			var id, estado int
-			var tipo, pri, desc string
-			fmt.Print("ID incidencia: ")
-			fmt.Scanln(&id)
-			fmt.Print("Tipo: ")
-			fmt.Scanln(&tipo)
-			fmt.Print("Prioridad: ")
-			fmt.Scanln(&pri)
-			fmt.Print("Descripción: ")
-			desc, _ = reader.ReadString('\n')
-			desc = strings.TrimSpace(desc)
-			fmt.Print("Estado (0 Abierta, 1 En proceso, 2 Cerrada): ")
-			fmt.Scanln(&estado)
-			if err := t.updateIncidencia(id, tipo, pri, desc, estado); err != nil {
+			id, err := in.Entero("ID incidencia")
+			if err != nil {
+				return
+			}
+			inc := t.getIncidencia(id)
+			if inc == nil {
+				fmt.Println("Incidencia no encontrada.")
+				break
+			}
+			tipo, err := in.Especialidad("Tipo", inc.Tipo)
+			if err != nil {
+				return
+			}
+			pri, err := in.TextoDefecto("Prioridad", inc.Prioridad)
+			if err != nil {
+				return
+			}
+			desc, err := in.TextoDefecto("Descripción", inc.Descripcion)
+			if err != nil {
+				return
+			}
+			estado, err := in.leerEstado(inc.Estado)
+			if err != nil {
+				return
+			}
+			if err := t.updateIncidencia(id, string(tipo), pri, desc, estado); err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Println("Incidencia actualizada.")
 			}
 		case 4:
-			var id int
-			fmt.Print("ID incidencia: ")
-			fmt.Scanln(&id)
-			t.deleteIncidencia(id)
-			fmt.Println("Incidencia eliminada.")
+			id, err := in.Entero("ID incidencia")
+			if err != nil {
+				return
+			}
+			if err := t.deleteIncidencia(id); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Incidencia eliminada.")
+			}
 		case 5:
-			var id, estado int
-			fmt.Print("ID incidencia: ")
-			fmt.Scanln(&id)
-			fmt.Print("Nuevo estado (0 Abierta, 1 En proceso, 2 Cerrada): ")
-			fmt.Scanln(&estado)
+			id, err := in.Entero("ID incidencia")
+			if err != nil {
+				return
+			}
 			inc := t.getIncidencia(id)
 			if inc == nil {
 				fmt.Println("Incidencia no encontrada.")
 				break
 			}
-			inc.Estado = estado
+			estado, err := in.leerEstado(inc.Estado)
+			if err != nil {
+				break
+			}
+			if err := t.updateIncidencia(id, "", "", "", estado); err != nil {
+				fmt.Println(err)
+				break
+			}
 			fmt.Println("Estado actualizado.")
 		case 0:
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
 
-func menuMecanicos(t *Taller) {
+// Pide el estado de una incidencia mostrando el actual entre corchetes
+func (e *Entrada) leerEstado(actual int) (int, error) {
+	return leerValor(e, "Estado (0 Abierta, 1 En proceso, 2 Cerrada)", &actual, estadoToString(actual),
+		func(s string) (int, error) {
+			n, err := parsearEntero(s)
+			if err != nil {
+				return 0, err
+			}
+			if n < 0 || n > 2 {
+				return 0, fmt.Errorf("debe ser 0, 1 o 2")
+			}
+			return n, nil
+		})
+}
+
+func menuMecanicos(t *Taller, in *Entrada) {
 	for {
 		fmt.Println("\n--- MECÁNICOS ---")
 		fmt.Println("1. Crear mecánico")
@@ -1031,111 +1383,345 @@ func menuMecanicos(t *Taller) {
 		fmt.Println("4. Eliminar mecánico")
 		fmt.Println("5. Listar incidencias asignadas a un mecánico")
 		fmt.Println("6. Listar mecánicos activos")
+		fmt.Println("7. Definir horario")
+		fmt.Println("8. Registrar ausencia")
+		fmt.Println("9. Ver horario")
 		fmt.Println("0. Volver")
 
-		var op int
-		fmt.Print("Seleccione: ")
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione", 0, 9)
+		if err != nil {
+			return
+		}
 
 		switch op {
 		case 1:
-			var nombre, esp string
-			var exp int
-			fmt.Print("Nombre: ")
-			fmt.Scanln(&nombre)
-			fmt.Print("Especialidad (mecanica / electrica / carroceria): ")
-			fmt.Scanln(&esp)
-			fmt.Print("Años de experiencia: ")
-			fmt.Scanln(&exp)
-			m := t.newMecanico(nombre, esp, exp)
-			fmt.Printf("Mecánico creado (ID: %d)\n", m.ID)
+			nombre, err := in.Texto("Nombre")
+			if err != nil {
+				return
+			}
+			esp, err := in.Especialidad("Especialidad", "")
+			if err != nil {
+				return
+			}
+			exp, err := in.EnteroDefecto("Años de experiencia", 0)
+			if err != nil {
+				return
+			}
+			if m := t.newMecanico(nombre, string(esp), exp); m != nil {
+				fmt.Printf("Mecánico creado (ID: %d)\n", m.ID)
+			}
 		case 2:
-			if len(t.Mecanicos) == 0 {
+			if len(t.datos().Mecanicos()) == 0 {
 				fmt.Println("No hay mecánicos registrados.")
 				break
 			}
-			for _, m := range t.Mecanicos {
+			for _, m := range t.datos().Mecanicos() {
 				printMecanico(m)
 				fmt.Println("-----------------------------")
 			}
 		case 3:
-			var id, exp int
-			var nombre, esp string
-			var activo bool
-			fmt.Print("ID mecánico: ")
-			fmt.Scanln(&id)
-			fmt.Print("Nuevo nombre: ")
-			fmt.Scanln(&nombre)
-			fmt.Print("Nueva especialidad (mecanica / electrica / carroceria): ")
-			fmt.Scanln(&esp)
-			fmt.Print("Años experiencia: ")
-			fmt.Scanln(&exp)
-			fmt.Print("Activo (1 sí / 0 no): ")
-			var act int
-			fmt.Scanln(&act)
-			activo = act == 1
-			if err := t.updateMecanico(id, nombre, esp, exp, activo); err != nil {
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
+			m := t.getMecanico(id)
+			if m == nil {
+				fmt.Printf("Mecánico con ID %d no encontrado.\n", id)
+				break
+			}
+			nombre, err := in.TextoDefecto("Nuevo nombre", m.Nombre)
+			if err != nil {
+				return
+			}
+			esp, err := in.Especialidad("Nueva especialidad", m.Especialidad)
+			if err != nil {
+				return
+			}
+			exp, err := in.EnteroDefecto("Años experiencia", m.AñosExp)
+			if err != nil {
+				return
+			}
+			activo, err := in.SiNo("Activo", m.Activo)
+			if err != nil {
+				return
+			}
+			if err := t.updateMecanico(id, nombre, string(esp), exp, activo); err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Println("Mecánico actualizado.")
 			}
 		case 4:
-			var id int
-			fmt.Print("ID mecánico: ")
-			fmt.Scanln(&id)
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
 			if err := t.deleteMecanico(id); err != nil {
 				fmt.Println(err)
 			} else {
 				fmt.Println("Mecánico eliminado.")
 			}
 		case 5:
-			var id int
-			fmt.Print("ID mecánico: ")
-			fmt.Scanln(&id)
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
 			t.showIncidenciasMecanico(id)
 		case 6:
 			t.showMecanicosActivos()
+		case 7:
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
+			if t.getMecanico(id) == nil {
+				fmt.Printf("Mecánico con ID %d no encontrado.\n", id)
+				break
+			}
+			turnos, err := leerTurnos(in)
+			if err != nil {
+				break
+			}
+			if err := t.definirTurnos(id, turnos); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Horario actualizado.")
+			}
+		case 8:
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
+			if t.getMecanico(id) == nil {
+				fmt.Printf("Mecánico con ID %d no encontrado.\n", id)
+				break
+			}
+			a, err := leerAusencia(in)
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			if err := t.registrarAusencia(id, a); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Ausencia registrada.")
+			}
+		case 9:
+			id, err := in.Entero("ID mecánico")
+			if err != nil {
+				return
+			}
+			if m := t.getMecanico(id); m == nil {
+				fmt.Printf("Mecánico con ID %d no encontrado.\n", id)
+			} else {
+				printHorario(m)
+			}
 		case 0:
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
 
-func menuPlazas(t *Taller) {
+func menuPlazas(t *Taller, in *Entrada) {
 	for {
 		fmt.Println("\n--- PLAZAS / ESTADO DEL TALLER ---")
 		fmt.Println("1. Ver estado completo del taller")
 		fmt.Println("2. Ver plazas ocupadas/libres")
 		fmt.Println("0. Volver")
 
-		var op int
-		fmt.Print("Seleccione: ")
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione", 0, 2)
+		if err != nil {
+			return
+		}
 
 		switch op {
 		case 1:
 			printTaller(t)
 		case 2:
-			if len(t.Plazas) == 0 {
+			if len(t.datos().Plazas()) == 0 {
 				fmt.Println("No hay plazas registradas.")
 				break
 			}
-			for i := range t.Plazas {
-				printPlaza(t.Plazas[i])
+			for _, p := range t.datos().Plazas() {
+				printPlaza(p)
 				fmt.Println("-----------------------------")
 			}
 		case 0:
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
 
 func main() {
-	t := &Taller{}
+	tipoAlmacen := flag.String("almacen", "memoria", "dónde guardar los datos: 'memoria' o 'fichero'")
+	dirDatos := flag.String("datos", "datos", "directorio de datos para el almacén en fichero")
+	tipoAvisos := flag.String("avisos", "fichero", "cómo avisar a los clientes: 'fichero' (avisos.jsonl en el directorio de datos), 'smtp' o 'ninguno'")
+	servidorSMTP := flag.String("smtp", "localhost:2525", "servidor SMTP local para -avisos smtp")
+	escuchar := flag.String("escuchar", "", "dirección (p.ej. 127.0.0.1:7070) en la que aceptar mecánicos remotos durante la simulación")
+	dirCoordinador := flag.String("trabajador", "", "trabajar como mecánico remoto del coordinador en esta dirección")
+	nombre := flag.String("nombre", "Remoto", "nombre del mecánico remoto (con -trabajador)")
+	especialidad := flag.String("especialidad", "mecanica", "especialidad del mecánico remoto (con -trabajador)")
+	experiencia := flag.Int("experiencia", 0, "años de experiencia del mecánico remoto (con -trabajador)")
+	sede := flag.String("sede", "Central", "nombre de esta sede en la federación de talleres")
+	dirFederacion := flag.String("federacion", "", "dirección (p.ej. 127.0.0.1:7080) en la que atender a las otras sedes")
+	vecinas := flag.String("vecinas", "", "direcciones de las otras sedes separadas por comas (con -federacion)")
+	replicas := flag.String("replicas", "", "direcciones de todos los nodos del taller replicado separadas por comas")
+	nodo := flag.Int("nodo", 0, "posición de este nodo en -replicas o -admision")
+	admision := flag.String("admision", "", "direcciones de todos los nodos que admiten vehículos sobre el mismo -datos, separadas por comas")
+	dirFragmento := flag.String("fragmento", "", "dirección (p.ej. 127.0.0.1:7090) en la que este nodo guarda su parte de la flota repartida")
+	fragmentos := flag.String("fragmentos", "", "direcciones de los nodos entre los que repartir la flota por matrícula, separadas por comas")
+	eventos := flag.String("eventos", "", "ficheros de eventos de simulaciones separados por comas: los fusiona en orden causal y busca conflictos")
+	flag.Parse()
+
+	if *eventos != "" {
+		if err := analizarEventos(strings.Split(*eventos, ","), os.Stdout); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *replicas != "" {
+		if err := ejecutarNodoReplica(strings.Split(*replicas, ","), *nodo); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dirCoordinador != "" {
+		esp := Especialidad(strings.ToLower(*especialidad))
+		if err := ejecutarTrabajadorRemoto(*dirCoordinador, *nombre, esp, *experiencia); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	t, err := nuevoTaller(*tipoAlmacen, *dirDatos)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	in := nuevaEntrada(os.Stdin, os.Stdout)
+
+	// El menú tiene el taller salvo mientras espera al usuario (ver conTaller)
+	t.mu.Lock()
+	in.cerrojo = &t.mu
+
+	// Los avisos a clientes se entregan en segundo plano
+	pararAvisos := make(chan struct{})
+	switch *tipoAvisos {
+	case "fichero":
+		nf, err := nuevoNotificadorFichero(filepath.Join(*dirDatos, "avisos.jsonl"))
+		if err != nil {
+			fmt.Println("No se pueden guardar los avisos:", err)
+			break
+		}
+		t.avisos = nuevaBandejaSalida(nf)
+	case "smtp":
+		t.avisos = nuevaBandejaSalida(NotificadorSMTP{Direccion: *servidorSMTP, Remitente: "taller@localhost"})
+	case "ninguno":
+	default:
+		fmt.Printf("Tipo de avisos desconocido (%s): no se avisará a los clientes\n", *tipoAvisos)
+	}
+	// Lo que quedó sin enviar la última vez sigue en la bandeja. Lo que
+	// cambia al enviar se guarda con el taller cogido.
+	avisosParados := make(chan struct{})
+	if t.avisos != nil {
+		t.avisos.restaurar(t.datos().EstadoAvisos())
+	}
+	go func() {
+		defer close(avisosParados)
+		t.avisos.ejecutar(pararAvisos, func() { t.conTaller(t.guardarAvisos) })
+	}()
+
+	// Lo que pasa en el taller se publica por temas (ver broker.go) y los
+	// mensajes van al seguimiento mientras el panel ocupa la pantalla
+	t.broker = nuevoBroker()
+	t.suscribirAvisos(t.broker)
+	t.seguimiento = nuevoSeguimiento()
+
+	if *escuchar != "" {
+		t.coordinador = nuevoCoordinador(t)
+		if dir, err := t.coordinador.escuchar(*escuchar); err != nil {
+			fmt.Println("No se pueden aceptar mecánicos remotos:", err)
+			t.coordinador = nil
+		} else {
+			fmt.Printf("Aceptando mecánicos remotos en %s\n", dir)
+		}
+	}
+
+	// Las sedes se pasan su carga en segundo plano
+	pararFederacion := make(chan struct{})
+	if *dirFederacion != "" {
+		// Con el almacén en fichero los traslados sobreviven a una caída
+		registro := ""
+		if *tipoAlmacen == "fichero" {
+			registro = filepath.Join(*dirDatos, "traslados.jsonl")
+		}
+		if t.federacion, err = abrirFederacion(t, *sede, strings.Split(*vecinas, ","), registro); err != nil {
+			fmt.Println("No se puede atender a las otras sedes:", err)
+		} else if dir, err := t.federacion.escuchar(*dirFederacion); err != nil {
+			fmt.Println("No se puede atender a las otras sedes:", err)
+			t.federacion.cerrar()
+			t.federacion = nil
+		} else {
+			fmt.Printf("Sede %s atendiendo a las otras sedes en %s\n", *sede, dir)
+		}
+	}
+	go t.federacion.ejecutar(pararFederacion)
+
+	// Los vehículos se reparten por matrícula entre los nodos de la flota
+	if *dirFragmento != "" {
+		t.fragmento = nuevoFragmento(t)
+		if dir, err := t.fragmento.escuchar(*dirFragmento); err != nil {
+			fmt.Println("No se puede guardar parte de la flota:", err)
+			t.fragmento = nil
+		} else {
+			fmt.Printf("Guardando parte de la flota en %s\n", dir)
+		}
+	}
+	if *fragmentos != "" {
+		t.flota = nuevoEnrutador(virtualesPorNodo)
+		t.flota.restaurar(t.datos().FlotaFuera())
+		t.flota.guardar = func(fuera map[string]string) error {
+			var err error
+			t.conTaller(func() { err = t.datos().GuardarFlotaFuera(fuera) })
+			return err
+		}
+		// Uno de los nodos puede ser este mismo, que necesita el taller para
+		// atender al enrutador
+		t.sinTaller(func() {
+			for _, nodo := range strings.Split(*fragmentos, ",") {
+				if nodo = strings.TrimSpace(nodo); nodo == "" {
+					continue
+				}
+				if r, err := t.flota.unir(nodo); err != nil {
+					fmt.Printf("No se puede repartir la flota con el nodo %s: %v\n", nodo, err)
+				} else if len(r.Movidos) > 0 {
+					fmt.Printf("Nodo %s: %d vehículos movidos\n", nodo, len(r.Movidos))
+				}
+			}
+		})
+	}
+
+	// Con más nodos de admisión las plazas se ocupan por turno
+	if *admision != "" {
+		if err := t.unirAdmision(*nodo, strings.Split(*admision, ",")); err != nil {
+			fmt.Println("No se puede admitir junto a los otros nodos:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Nodo de admisión %d en %s\n", *nodo, t.exclusion.pares[*nodo])
+	}
+
+	// Dar entrada a los vehículos que tienen cita hoy
+	admitidas, errs := t.admitirCitasDelDia(time.Now())
+	for _, c := range admitidas {
+		fmt.Printf("Vehículo %s con cita hoy admitido en la plaza %d.\n", c.Matricula, c.PlazaID)
+	}
+	for _, err := range errs {
+		fmt.Println("No se pudo admitir:", err)
+	}
 
 	for {
 		fmt.Println("\n===== GESTIÓN DE TALLER =====")
@@ -1146,32 +1732,73 @@ func main() {
 		fmt.Println("5. Plazas y estado del taller")
 		fmt.Println("6. Limpiar pantalla")
 		fmt.Println("7. Simulación concurrente (goroutines)")
+		fmt.Println("8. Citas")
+		fmt.Println("9. Piezas de recambio")
+		fmt.Println("10. Presupuestos y facturas")
+		fmt.Println("11. Sedes")
+		fmt.Println("12. Flota repartida")
 		fmt.Println("0. Salir")
-		fmt.Print("Seleccione una opción: ")
 
-		var op int
-		fmt.Scanln(&op)
+		op, err := in.Opcion("Seleccione una opción", 0, 12)
+		if err != nil {
+			op = 0 // fin de la entrada: salir guardando
+		}
 
 		switch op {
 		case 1:
-			menuClientes(t)
+			menuClientes(t, in)
 		case 2:
-			menuVehiculos(t)
+			menuVehiculos(t, in)
 		case 3:
-			menuIncidencias(t)
+			menuIncidencias(t, in)
 		case 4:
-			menuMecanicos(t)
+			menuMecanicos(t, in)
 		case 5:
-			menuPlazas(t)
+			menuPlazas(t, in)
 		case 6:
 			clearScreen()
 		case 7:
-			simularTaller(t)
+			simularTaller(t, in)
+		case 8:
+			menuCitas(t, in)
+		case 9:
+			menuPiezas(t, in)
+		case 10:
+			menuFacturacion(t, in)
+		case 11:
+			// Los traslados esperan a otras sedes: el menú coge el taller
+			// sólo para lo que hace en ésta
+			in.cerrojo = nil
+			t.sinTaller(func() { menuSedes(t, in) })
+			in.cerrojo = &t.mu
+		case 12:
+			// El enrutador llama a los nodos de la flota, y uno puede ser
+			// este mismo
+			in.cerrojo = nil
+			t.sinTaller(func() { menuFlota(t, in) })
+			in.cerrojo = &t.mu
 		case 0:
+			close(pararAvisos)
+			close(pararFederacion)
+			t.coordinador.cerrar()
+			t.federacion.cerrar()
+			t.flota.cerrar()
+			t.fragmento.cerrar()
+			// El último intento de envío no debe coincidir con el del bucle
+			// de la bandeja, que necesita el taller para guardar
+			t.sinTaller(func() { <-avisosParados })
+			if t.avisos != nil {
+				t.avisos.procesar(time.Now())
+				t.guardarAvisos()
+				if _, pendientes, _ := t.avisos.estado(); pendientes > 0 {
+					fmt.Printf("Quedan %d avisos sin enviar.\n", pendientes)
+				}
+			}
+			if err := t.datos().Sincronizar(); err != nil {
+				fmt.Println("Error guardando los datos:", err)
+			}
 			fmt.Println("Saliendo del sistema...")
 			return
-		default:
-			fmt.Println("Opción inválida.")
 		}
 	}
 }
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ------------ AVISOS A LOS CLIENTES ------------
+
+type EventoAviso string
+
+const (
+	AvisoAdmision          EventoAviso = "admision"
+	AvisoIncidenciaCerrada EventoAviso = "incidencia_cerrada"
+	AvisoVehiculoListo     EventoAviso = "vehiculo_listo"
+	AvisoRetraso           EventoAviso = "retraso"
+)
+
+type CanalAviso string
+
+const (
+	CanalEmail CanalAviso = "email"
+	CanalSMS   CanalAviso = "sms"
+)
+
+// Mensaje ya redactado para un cliente
+type Notificacion struct {
+	Clave     string // identifica el aviso: el mismo aviso no se manda dos veces
+	Evento    EventoAviso
+	ClienteID int
+	Canal     CanalAviso
+	Destino   string // email o teléfono
+	Asunto    string
+	Cuerpo    string
+	Fecha     time.Time
+}
+
+// Notificador entrega un mensaje por algún medio. Si devuelve un error la
+// bandeja de salida lo reintenta más tarde, salvo que sea errNoReintentar.
+type Notificador interface {
+	Enviar(n Notificacion) error
+}
+
+// El notificador no podrá mandar nunca este mensaje (p.ej. un SMS por SMTP)
+var errNoReintentar = errors.New("envío imposible")
+
+// Datos con los que se rellenan las plantillas
+type datosAviso struct {
+	Cliente   string
+	Matricula string
+	Marca     string
+	Modelo    string
+	Tipo      Especialidad // incidencia cerrada
+	Plaza     int
+	Motivo    string  // retraso
+	Total     float64 // importe de la factura al estar listo
+}
+
+// Asunto y cuerpo de cada evento
+var plantillasAviso = map[EventoAviso][2]*template.Template{
+	AvisoAdmision: plantilla("Su vehículo {{.Matricula}} ha entrado en el taller",
+		"Hola {{.Cliente}}, hemos recibido su {{.Marca}} {{.Modelo}} ({{.Matricula}}){{if .Plaza}} en la plaza {{.Plaza}}{{end}}. "+
+			"Le avisaremos cuando esté listo."),
+	AvisoIncidenciaCerrada: plantilla("Reparación de {{.Tipo}} terminada",
+		"Hola {{.Cliente}}, ya hemos terminado la reparación de {{.Tipo}} de su vehículo {{.Matricula}}."),
+	AvisoVehiculoListo: plantilla("Su vehículo {{.Matricula}} está listo",
+		"Hola {{.Cliente}}, su {{.Marca}} {{.Modelo}} ({{.Matricula}}) está reparado y puede pasar a recogerlo."+
+			"{{if .Total}} Importe: {{printf \"%.2f\" .Total}} € (IVA incluido).{{end}}"),
+	AvisoRetraso: plantilla("Retraso en la reparación de {{.Matricula}}",
+		"Hola {{.Cliente}}, la reparación de su vehículo {{.Matricula}} se va a retrasar: {{.Motivo}}. Disculpe las molestias."),
+}
+
+func plantilla(asunto, cuerpo string) [2]*template.Template {
+	return [2]*template.Template{
+		template.Must(template.New("asunto").Parse(asunto)),
+		template.Must(template.New("cuerpo").Parse(cuerpo)),
+	}
+}
+
+func redactarAviso(ev EventoAviso, d datosAviso) (string, string, error) {
+	pl, ok := plantillasAviso[ev]
+	if !ok {
+		return "", "", fmt.Errorf("no hay plantilla para el aviso %q", ev)
+	}
+	var asunto, cuerpo strings.Builder
+	if err := pl[0].Execute(&asunto, d); err != nil {
+		return "", "", err
+	}
+	if err := pl[1].Execute(&cuerpo, d); err != nil {
+		return "", "", err
+	}
+	return asunto.String(), cuerpo.String(), nil
+}
+
+// Redacta el aviso para el cliente del vehículo y lo deja en la bandeja de
+// salida. Los vehículos sin cliente o sin forma de contacto no se avisan.
+// motivo distingue avisos del mismo tipo (incidencia, causa del retraso...).
+func (t *Taller) notificar(ev EventoAviso, v *Vehiculo, d datosAviso, motivo string) {
+	if t.avisos == nil {
+		return
+	}
+	c := t.clienteDeVehiculo(v.Matricula)
+	if c == nil {
+		return
+	}
+	n := Notificacion{Evento: ev, ClienteID: c.ID, Fecha: t.ahora()}
+	switch {
+	case c.Email != "":
+		n.Canal, n.Destino = CanalEmail, c.Email
+	case c.Telefono != "":
+		n.Canal, n.Destino = CanalSMS, c.Telefono
+	default:
+		return
+	}
+	d.Cliente, d.Matricula, d.Marca, d.Modelo = c.Nombre, v.Matricula, v.Marca, v.Modelo
+	var err error
+	if n.Asunto, n.Cuerpo, err = redactarAviso(ev, d); err != nil {
+		t.avisar("Error redactando el aviso %s: %v", ev, err)
+		return
+	}
+	n.Clave = fmt.Sprintf("%s/%s/%s/%s", ev, v.Matricula, v.FechaEntrada.Format(time.RFC3339), motivo)
+	t.avisos.encolar(n)
+	t.guardarAvisos()
+}
+
+// Guarda en el almacén la bandeja de salida si ha cambiado desde la última
+// vez. Se llama con el taller cogido.
+func (t *Taller) guardarAvisos() {
+	e, cambiada := t.avisos.cambios()
+	if !cambiada {
+		return
+	}
+	if err := t.datos().GuardarEstadoAvisos(e); err != nil {
+		t.avisar("Error guardando los avisos pendientes: %v", err)
+	}
+}
+
+// Avisa de que una incidencia del vehículo está terminada
+func (t *Taller) avisarIncidenciaCerrada(inc *Incidencia) {
+	if v := t.vehiculoDeIncidencia(inc.ID); v != nil {
+		t.notificar(AvisoIncidenciaCerrada, v, datosAviso{Tipo: inc.Tipo}, fmt.Sprint(inc.ID))
+	}
+}
+
+// ------------ BANDEJA DE SALIDA ------------
+
+// BandejaSalida guarda los avisos pendientes y los va entregando con el
+// notificador configurado. Si un envío falla se reintenta con una espera que
+// se dobla cada vez, hasta MaxIntentos.
+type BandejaSalida struct {
+	mu          sync.Mutex
+	notificador Notificador
+	MaxIntentos int
+	Espera      time.Duration // espera tras el primer fallo
+	pendientes  []*envioPendiente
+	vistos      map[string]bool // claves ya encoladas
+	cambio      chan struct{}   // avisa a ejecutar de que hay algo nuevo
+
+	enviados int
+	fallidos []Notificacion // agotaron los intentos
+
+	version  int // cambia con cada aviso encolado o procesado
+	guardada int // versión que está en el almacén
+}
+
+// Lo que se guarda de la bandeja en el almacén: los avisos sin entregar, las
+// claves ya encoladas (para no repetir avisos tras reiniciar) y los totales
+type EstadoAvisos struct {
+	Pendientes []AvisoPendiente
+	Vistos     []string
+	Fallidos   []Notificacion
+	Enviados   int
+}
+
+type AvisoPendiente struct {
+	Notificacion Notificacion
+	Intentos     int
+	Siguiente    time.Time
+}
+
+type envioPendiente struct {
+	n         Notificacion
+	intentos  int
+	siguiente time.Time
+}
+
+const (
+	maxIntentosAviso   = 5
+	esperaAvisoDefecto = 2 * time.Second
+	revisionBandeja    = time.Minute // ejecutar revisa la bandeja al menos así de a menudo
+)
+
+func nuevaBandejaSalida(n Notificador) *BandejaSalida {
+	return &BandejaSalida{
+		notificador: n,
+		MaxIntentos: maxIntentosAviso,
+		Espera:      esperaAvisoDefecto,
+		vistos:      map[string]bool{},
+		cambio:      make(chan struct{}, 1),
+	}
+}
+
+// Deja el aviso para enviarlo en cuanto se pueda (nada si ya se encoló)
+func (b *BandejaSalida) encolar(n Notificacion) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n.Clave != "" && b.vistos[n.Clave] {
+		return
+	}
+	b.vistos[n.Clave] = true
+	b.pendientes = append(b.pendientes, &envioPendiente{n: n})
+	b.version++
+	select {
+	case b.cambio <- struct{}{}:
+	default:
+	}
+}
+
+// Intenta entregar los avisos a los que les toca. Devuelve cuántos se han
+// enviado en esta pasada y cuándo toca el siguiente reintento (cero si no
+// queda nada pendiente).
+func (b *BandejaSalida) procesar(ahora time.Time) (int, time.Time) {
+	b.mu.Lock()
+	var toca []*envioPendiente
+	for _, e := range b.pendientes {
+		if !e.siguiente.After(ahora) {
+			toca = append(toca, e)
+		}
+	}
+	b.mu.Unlock()
+
+	// El envío se hace sin el cerrojo: puede tardar
+	resultados := make([]error, len(toca))
+	for i, e := range toca {
+		resultados[i] = b.notificador.Enviar(e.n)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(toca) > 0 {
+		b.version++
+	}
+	enviados := 0
+	terminados := map[*envioPendiente]bool{}
+	for i, e := range toca {
+		e.intentos++
+		switch {
+		case resultados[i] == nil:
+			enviados++
+			terminados[e] = true
+		case errors.Is(resultados[i], errNoReintentar) || e.intentos >= b.MaxIntentos:
+			b.fallidos = append(b.fallidos, e.n)
+			terminados[e] = true
+		default:
+			e.siguiente = ahora.Add(b.Espera << (e.intentos - 1))
+		}
+	}
+	b.enviados += enviados
+
+	var quedan []*envioPendiente
+	var proximo time.Time
+	for _, e := range b.pendientes {
+		if terminados[e] {
+			continue
+		}
+		quedan = append(quedan, e)
+		if proximo.IsZero() || e.siguiente.Before(proximo) {
+			proximo = e.siguiente
+		}
+	}
+	b.pendientes = quedan
+	return enviados, proximo
+}
+
+// Estado de la bandeja para guardarlo y si ha cambiado desde la última vez
+// que se pidió
+func (b *BandejaSalida) cambios() (EstadoAvisos, bool) {
+	if b == nil {
+		return EstadoAvisos{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.version == b.guardada {
+		return EstadoAvisos{}, false
+	}
+	b.guardada = b.version
+	e := EstadoAvisos{Fallidos: slices.Clone(b.fallidos), Enviados: b.enviados}
+	for _, p := range b.pendientes {
+		e.Pendientes = append(e.Pendientes, AvisoPendiente{Notificacion: p.n, Intentos: p.intentos, Siguiente: p.siguiente})
+	}
+	for clave := range b.vistos {
+		e.Vistos = append(e.Vistos, clave)
+	}
+	slices.Sort(e.Vistos)
+	return e, true
+}
+
+// Recupera lo que se guardó de la bandeja (al arrancar)
+func (b *BandejaSalida) restaurar(e EstadoAvisos) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range e.Pendientes {
+		b.pendientes = append(b.pendientes, &envioPendiente{n: p.Notificacion, intentos: p.Intentos, siguiente: p.Siguiente})
+	}
+	for _, clave := range e.Vistos {
+		b.vistos[clave] = true
+	}
+	b.fallidos = append(b.fallidos, e.Fallidos...)
+	b.enviados += e.Enviados
+}
+
+// Bucle que va vaciando la bandeja hasta que se cierra parar. Tras cada
+// pasada llama a guardar (si no es nil) para que se guarde lo que cambió.
+func (b *BandejaSalida) ejecutar(parar <-chan struct{}, guardar func()) {
+	if b == nil {
+		return
+	}
+	for {
+		_, proximo := b.procesar(time.Now())
+		if guardar != nil {
+			guardar()
+		}
+		espera := revisionBandeja
+		if !proximo.IsZero() {
+			espera = min(max(time.Until(proximo), 0), espera)
+		}
+		temporizador := time.NewTimer(espera)
+		select {
+		case <-temporizador.C:
+		case <-b.cambio:
+			temporizador.Stop()
+		case <-parar:
+			temporizador.Stop()
+			return
+		}
+	}
+}
+
+// Enviados, pendientes y fallidos hasta ahora
+func (b *BandejaSalida) estado() (int, int, int) {
+	if b == nil {
+		return 0, 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.enviados, len(b.pendientes), len(b.fallidos)
+}
+
+// ------------ NOTIFICADORES LOCALES ------------
+
+// NotificadorFichero apunta cada aviso como una línea JSON en un fichero:
+// sirve de buzón local para revisar qué se habría mandado
+type NotificadorFichero struct {
+	mu   sync.Mutex
+	ruta string
+}
+
+func nuevoNotificadorFichero(ruta string) (*NotificadorFichero, error) {
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o755); err != nil {
+		return nil, err
+	}
+	return &NotificadorFichero{ruta: ruta}, nil
+}
+
+func (nf *NotificadorFichero) Enviar(n Notificacion) error {
+	linea, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoReintentar, err)
+	}
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	f, err := os.OpenFile(nf.ruta, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(linea, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Avisos apuntados en el fichero, en orden
+func (nf *NotificadorFichero) leer() ([]Notificacion, error) {
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	datos, err := os.ReadFile(nf.ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lista []Notificacion
+	for _, linea := range strings.Split(strings.TrimSpace(string(datos)), "\n") {
+		if linea == "" {
+			continue
+		}
+		var n Notificacion
+		if err := json.Unmarshal([]byte(linea), &n); err != nil {
+			return nil, fmt.Errorf("fichero de avisos corrupto: %w", err)
+		}
+		lista = append(lista, n)
+	}
+	return lista, nil
+}
+
+// NotificadorSMTP manda los emails a un servidor SMTP sin autenticación
+// (pensado para un servidor local). Los SMS no los puede enviar.
+type NotificadorSMTP struct {
+	Direccion string // host:puerto
+	Remitente string
+}
+
+func (ns NotificadorSMTP) Enviar(n Notificacion) error {
+	if n.Canal != CanalEmail {
+		return fmt.Errorf("%w: el canal %s no se puede enviar por SMTP", errNoReintentar, n.Canal)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		ns.Remitente, n.Destino, mime.QEncoding.Encode("utf-8", n.Asunto), n.Cuerpo)
+	return smtp.SendMail(ns.Direccion, nil, ns.Remitente, []string{n.Destino}, []byte(msg))
+}
@@ -0,0 +1,337 @@
+// notificaciones_test.go
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Notificador de prueba: apunta lo que recibe y falla las primeras veces
+type notificadorPrueba struct {
+	mu       sync.Mutex
+	fallos   int // envíos que fallan antes de empezar a funcionar
+	intentos int
+	enviados []Notificacion
+}
+
+func (np *notificadorPrueba) Enviar(n Notificacion) error {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	np.intentos++
+	if np.fallos > 0 {
+		np.fallos--
+		return errors.New("servidor no disponible")
+	}
+	np.enviados = append(np.enviados, n)
+	return nil
+}
+
+// Servidor SMTP mínimo en local. Responde 451 a los primeros `fallos`
+// mensajes y guarda el resto.
+type servidorSMTPPrueba struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	fallos   int
+	mensajes []string
+}
+
+func nuevoServidorSMTPPrueba(t *testing.T, fallos int) *servidorSMTPPrueba {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se puede abrir el servidor SMTP de prueba: %v", err)
+	}
+	s := &servidorSMTPPrueba{ln: ln, fallos: fallos}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.atender(c)
+		}
+	}()
+	return s
+}
+
+func (s *servidorSMTPPrueba) atender(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	responder := func(linea string) { fmt.Fprintf(c, "%s\r\n", linea) }
+	responder("220 localhost ESMTP prueba")
+	for {
+		linea, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		orden := strings.ToUpper(strings.TrimSpace(linea))
+		switch {
+		case strings.HasPrefix(orden, "EHLO"), strings.HasPrefix(orden, "HELO"):
+			responder("250 localhost")
+		case strings.HasPrefix(orden, "MAIL"):
+			s.mu.Lock()
+			falla := s.fallos > 0
+			if falla {
+				s.fallos--
+			}
+			s.mu.Unlock()
+			if falla {
+				responder("451 inténtelo más tarde")
+			} else {
+				responder("250 OK")
+			}
+		case strings.HasPrefix(orden, "RCPT"):
+			responder("250 OK")
+		case orden == "DATA":
+			responder("354 adelante")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			s.mensajes = append(s.mensajes, msg.String())
+			s.mu.Unlock()
+			responder("250 OK")
+		case orden == "QUIT":
+			responder("221 adiós")
+			return
+		default:
+			responder("250 OK")
+		}
+	}
+}
+
+func (s *servidorSMTPPrueba) recibidos() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mensajes...)
+}
+
+func TestRedactarAvisos(t *testing.T) {
+	d := datosAviso{Cliente: "Pepe", Matricula: "1234 BCD", Marca: "Seat", Modelo: "Ibiza", Plaza: 3}
+	asunto, cuerpo, err := redactarAviso(AvisoAdmision, d)
+	if err != nil {
+		t.Fatalf("error redactando: %v", err)
+	}
+	if !strings.Contains(asunto, "1234 BCD") || !strings.Contains(cuerpo, "Pepe") || !strings.Contains(cuerpo, "plaza 3") {
+		t.Errorf("aviso de admisión mal redactado: %q / %q", asunto, cuerpo)
+	}
+
+	_, cuerpo, _ = redactarAviso(AvisoVehiculoListo, datosAviso{Matricula: "1234 BCD", Total: 272.25})
+	if !strings.Contains(cuerpo, "272.25 €") {
+		t.Errorf("el aviso de vehículo listo debería llevar el importe: %q", cuerpo)
+	}
+	_, cuerpo, _ = redactarAviso(AvisoVehiculoListo, datosAviso{Matricula: "1234 BCD"})
+	if strings.Contains(cuerpo, "Importe") {
+		t.Errorf("sin factura no se menciona el importe: %q", cuerpo)
+	}
+	_, cuerpo, _ = redactarAviso(AvisoRetraso, datosAviso{Motivo: "estamos esperando piezas"})
+	if !strings.Contains(cuerpo, "estamos esperando piezas") {
+		t.Errorf("el retraso debería explicar el motivo: %q", cuerpo)
+	}
+	if _, _, err := redactarAviso("desconocido", d); err == nil {
+		t.Error("un evento sin plantilla debería dar error")
+	}
+}
+
+func TestBandejaNoRepiteAvisos(t *testing.T) {
+	np := &notificadorPrueba{}
+	b := nuevaBandejaSalida(np)
+	b.encolar(Notificacion{Clave: "a"})
+	b.encolar(Notificacion{Clave: "a"})
+	b.encolar(Notificacion{Clave: "b"})
+	if enviados, _ := b.procesar(time.Now()); enviados != 2 {
+		t.Fatalf("enviados %d, se esperaban 2", enviados)
+	}
+	b.encolar(Notificacion{Clave: "a"})
+	b.procesar(time.Now())
+	if len(np.enviados) != 2 {
+		t.Errorf("un aviso ya enviado no se repite: %d envíos", len(np.enviados))
+	}
+}
+
+func TestBandejaReintentaConEsperaCreciente(t *testing.T) {
+	np := &notificadorPrueba{fallos: 2}
+	b := nuevaBandejaSalida(np)
+	b.Espera = time.Minute
+	b.encolar(Notificacion{Clave: "a"})
+
+	ahora := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	_, proximo := b.procesar(ahora)
+	if want := ahora.Add(time.Minute); !proximo.Equal(want) {
+		t.Fatalf("primer reintento a las %v, se esperaba %v", proximo, want)
+	}
+	// Antes de tiempo no se reintenta
+	b.procesar(ahora.Add(30 * time.Second))
+	if np.intentos != 1 {
+		t.Fatalf("intentos %d antes de la espera, se esperaba 1", np.intentos)
+	}
+	ahora = proximo
+	_, proximo = b.procesar(ahora)
+	if want := ahora.Add(2 * time.Minute); !proximo.Equal(want) {
+		t.Fatalf("segundo reintento a las %v, se esperaba %v (espera doble)", proximo, want)
+	}
+	if enviados, proximo := b.procesar(proximo); enviados != 1 || !proximo.IsZero() {
+		t.Fatalf("el tercer intento debería entregarlo: %d enviados, próximo %v", enviados, proximo)
+	}
+	if enviados, pendientes, fallidos := b.estado(); enviados != 1 || pendientes != 0 || fallidos != 0 {
+		t.Errorf("estado %d/%d/%d, se esperaba 1/0/0", enviados, pendientes, fallidos)
+	}
+}
+
+func TestBandejaSeRindeTrasMaxIntentos(t *testing.T) {
+	np := &notificadorPrueba{fallos: 100}
+	b := nuevaBandejaSalida(np)
+	b.MaxIntentos = 3
+	b.encolar(Notificacion{Clave: "a"})
+	ahora := time.Now()
+	for i := 0; i < 10; i++ {
+		ahora = ahora.Add(time.Hour)
+		b.procesar(ahora)
+	}
+	if np.intentos != 3 {
+		t.Errorf("intentos %d, se esperaban 3", np.intentos)
+	}
+	if _, pendientes, fallidos := b.estado(); pendientes != 0 || fallidos != 1 {
+		t.Errorf("pendientes %d y fallidos %d, se esperaba 0 y 1", pendientes, fallidos)
+	}
+}
+
+func TestAvisosPorSMTP(t *testing.T) {
+	srv := nuevoServidorSMTPPrueba(t, 1)
+	b := nuevaBandejaSalida(NotificadorSMTP{Direccion: srv.ln.Addr().String(), Remitente: "taller@localhost"})
+	b.Espera = 0
+	b.encolar(Notificacion{Clave: "a", Canal: CanalEmail, Destino: "pepe@correo.es",
+		Asunto: "Su vehículo está listo", Cuerpo: "Puede pasar a recogerlo."})
+	b.encolar(Notificacion{Clave: "b", Canal: CanalSMS, Destino: "600123123", Cuerpo: "SMS"})
+
+	b.procesar(time.Now()) // el servidor rechaza el primer email con 451
+	if got := srv.recibidos(); len(got) != 0 {
+		t.Fatalf("el primer intento debería fallar: %d mensajes", len(got))
+	}
+	b.procesar(time.Now())
+	got := srv.recibidos()
+	if len(got) != 1 || !strings.Contains(got[0], "To: pepe@correo.es") || !strings.Contains(got[0], "Puede pasar a recogerlo.") {
+		t.Fatalf("mensajes recibidos: %q", got)
+	}
+	// El SMS no se puede mandar por SMTP y no se reintenta
+	if enviados, pendientes, fallidos := b.estado(); enviados != 1 || pendientes != 0 || fallidos != 1 {
+		t.Errorf("estado %d/%d/%d, se esperaba 1/0/1", enviados, pendientes, fallidos)
+	}
+}
+
+func TestNotificadorFichero(t *testing.T) {
+	nf, err := nuevoNotificadorFichero(filepath.Join(t.TempDir(), "buzon", "avisos.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nf.Enviar(Notificacion{Clave: "a", Asunto: "Uno"})
+	nf.Enviar(Notificacion{Clave: "b", Asunto: "Dos"})
+	lista, err := nf.leer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lista) != 2 || lista[0].Asunto != "Uno" || lista[1].Clave != "b" {
+		t.Errorf("avisos leídos: %+v", lista)
+	}
+}
+
+func TestAvisosDelCicloDeUnVehiculo(t *testing.T) {
+	taller := &Taller{}
+	np := &notificadorPrueba{}
+	taller.avisos = nuevaBandejaSalida(np)
+	m := taller.newMecanico("Luis", "mecanica", 3)
+	cli, _ := taller.newCliente("Pepe", "600123123", "pepe@correo.es", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, m.ID); err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Baja", "Aceite")
+	p, _ := taller.crearPresupuesto(v.Matricula, time.Now())
+	taller.responderPresupuesto(p.ID, true)
+	if err := taller.updateIncidencia(inc.ID, "", "", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	// Cerrarla otra vez no vuelve a avisar
+	taller.updateIncidencia(inc.ID, "", "", "", 2)
+	taller.liberarPlaza(v)
+	taller.avisos.procesar(time.Now())
+
+	var eventos []EventoAviso
+	for _, n := range np.enviados {
+		eventos = append(eventos, n.Evento)
+		if n.Canal != CanalEmail || n.Destino != "pepe@correo.es" || n.ClienteID != cli.ID {
+			t.Errorf("aviso mal dirigido: %+v", n)
+		}
+	}
+	want := []EventoAviso{AvisoAdmision, AvisoIncidenciaCerrada, AvisoVehiculoListo}
+	if fmt.Sprint(eventos) != fmt.Sprint(want) {
+		t.Errorf("eventos %v, se esperaba %v", eventos, want)
+	}
+}
+
+func TestAvisoPorSMSSinEmail(t *testing.T) {
+	taller := &Taller{}
+	np := &notificadorPrueba{}
+	taller.avisos = nuevaBandejaSalida(np)
+	m := taller.newMecanico("Luis", "mecanica", 3)
+	cli, _ := taller.newCliente("Pepe", "600123123", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.admitirCliente(cli.ID, v, m.ID)
+	taller.avisos.procesar(time.Now())
+	if len(np.enviados) != 1 || np.enviados[0].Canal != CanalSMS || np.enviados[0].Destino != cli.Telefono {
+		t.Errorf("se esperaba un SMS al %s: %+v", cli.Telefono, np.enviados)
+	}
+}
+
+func TestBandejaSobreviveAlReinicio(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taller.avisos = nuevaBandejaSalida(&notificadorPrueba{fallos: 100})
+	m := taller.newMecanico("Luis", "mecanica", 3)
+	cli, _ := taller.newCliente("Pepe", "600123123", "pepe@correo.es", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, m.ID); err != nil {
+		t.Fatal(err)
+	}
+	// El envío falla: el aviso sigue pendiente al cerrar
+	taller.avisos.procesar(time.Now())
+	taller.guardarAvisos()
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := &notificadorPrueba{}
+	recargado.avisos = nuevaBandejaSalida(np)
+	recargado.avisos.restaurar(recargado.datos().EstadoAvisos())
+	if _, pendientes, _ := recargado.avisos.estado(); pendientes != 1 {
+		t.Fatalf("pendientes %d tras reabrir, se esperaba 1", pendientes)
+	}
+	recargado.avisos.procesar(time.Now().Add(time.Hour))
+	if len(np.enviados) != 1 || np.enviados[0].Evento != AvisoAdmision {
+		t.Fatalf("se esperaba entregar el aviso de admisión: %+v", np.enviados)
+	}
+	// La clave ya vista no se vuelve a encolar
+	recargado.avisos.encolar(np.enviados[0])
+	if _, pendientes, _ := recargado.avisos.estado(); pendientes != 0 {
+		t.Errorf("un aviso ya encolado antes de reiniciar no se repite")
+	}
+}
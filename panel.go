@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ SEGUIMIENTO DE LA SIMULACIÓN ------------
+
+const maxEventosPanel = 12 // líneas del registro de eventos que se muestran
+
+// Trabajo que está haciendo un mecánico en este momento
+type trabajoEnCurso struct {
+	Matricula    string
+	Tipo         Especialidad
+	IncidenciaID int
+	PlazaID      int // 0 si el vehículo no tiene plaza
+	Inicio       time.Time
+	Duracion     time.Duration
+}
+
+// Tramo es un intervalo en el que un mecánico trabajó en una incidencia.
+// Con ellos se construye el diagrama de Gantt de la simulación.
+type Tramo struct {
+	MecanicoID   int
+	Mecanico     string
+	PlazaID      int
+	Matricula    string
+	Tipo         Especialidad
+	IncidenciaID int
+	Inicio       time.Time
+	Fin          time.Time
+}
+
+// Seguimiento guarda lo que pasa en la simulación (quién trabaja en qué y
+// los últimos eventos) para poder mostrarlo mientras se ejecuta.
+type Seguimiento struct {
+	mu          sync.Mutex
+	inicio      time.Time
+	enCurso     map[int]*trabajoEnCurso // por ID de mecánico
+	nombres     map[int]string          // nombre de cada mecánico que ha trabajado
+	tramos      []Tramo                 // trabajos ya terminados
+	eventos     []string
+	panelActivo bool // si el panel ocupa la pantalla los mensajes van al registro
+}
+
+func nuevoSeguimiento() *Seguimiento {
+	return &Seguimiento{inicio: time.Now(), enCurso: map[int]*trabajoEnCurso{}, nombres: map[int]string{}}
+}
+
+// Empieza una simulación nueva. El seguimiento del taller es siempre el
+// mismo porque los mecánicos de la anterior pueden no haber terminado aún:
+// lo que apunten a partir de ahora cuenta en la nueva.
+func (s *Seguimiento) reiniciar() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inicio = time.Now()
+	s.enCurso = map[int]*trabajoEnCurso{}
+	s.nombres = map[int]string{}
+	s.tramos = nil
+	s.eventos = nil
+}
+
+// Un mecánico empieza un trabajo
+func (s *Seguimiento) empezar(m *Mecanico, v *Vehiculo, inc *Incidencia, plaza *Plaza, duracion time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tr := &trabajoEnCurso{
+		Matricula:    v.Matricula,
+		Tipo:         inc.Tipo,
+		IncidenciaID: inc.ID,
+		Inicio:       time.Now(),
+		Duracion:     duracion,
+	}
+	if plaza != nil {
+		tr.PlazaID = plaza.ID
+	}
+	s.enCurso[m.ID] = tr
+	s.nombres[m.ID] = m.Nombre
+}
+
+// Un mecánico deja su trabajo (terminado o no) y queda registrado el tramo
+func (s *Seguimiento) terminar(m *Mecanico) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tr, ok := s.enCurso[m.ID]; ok {
+		s.tramos = append(s.tramos, tramoDe(m.ID, s.nombres[m.ID], tr, time.Now()))
+		delete(s.enCurso, m.ID)
+	}
+}
+
+func tramoDe(mecID int, nombre string, tr *trabajoEnCurso, fin time.Time) Tramo {
+	return Tramo{
+		MecanicoID:   mecID,
+		Mecanico:     nombre,
+		PlazaID:      tr.PlazaID,
+		Matricula:    tr.Matricula,
+		Tipo:         tr.Tipo,
+		IncidenciaID: tr.IncidenciaID,
+		Inicio:       tr.Inicio,
+		Fin:          fin,
+	}
+}
+
+// Devuelve los tramos registrados; los trabajos sin acabar se cortan en fin
+func (s *Seguimiento) tramosHasta(fin time.Time) []Tramo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tramos := append([]Tramo(nil), s.tramos...)
+	for id, tr := range s.enCurso {
+		tramos = append(tramos, tramoDe(id, s.nombres[id], tr, fin))
+	}
+	return tramos
+}
+
+// Añade un mensaje al registro de eventos (sólo se guardan los últimos)
+func (s *Seguimiento) evento(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, linea := range strings.Split(msg, "\n") {
+		s.eventos = append(s.eventos, linea)
+	}
+	if len(s.eventos) > maxEventosPanel {
+		s.eventos = s.eventos[len(s.eventos)-maxEventosPanel:]
+	}
+}
+
+// Muestra un mensaje de la simulación: en pantalla o, si el panel está
+// activo, en su registro de eventos para no romper el dibujo.
+func (t *Taller) avisar(formato string, args ...any) {
+	msg := fmt.Sprintf(formato, args...)
+	if s := t.seguimiento; s != nil {
+		s.mu.Lock()
+		activo := s.panelActivo
+		s.mu.Unlock()
+		if activo {
+			s.evento(msg)
+			return
+		}
+	}
+	fmt.Println(msg)
+}
+
+// ------------ PANEL EN TERMINAL ------------
+
+// Secuencias ANSI usadas por el panel
+const (
+	ansiPantallaAlt   = "\x1b[?1049h"
+	ansiPantallaNorm  = "\x1b[?1049l"
+	ansiOcultarCursor = "\x1b[?25l"
+	ansiMostrarCursor = "\x1b[?25h"
+	ansiInicio        = "\x1b[H"
+	ansiBorrarResto   = "\x1b[J"
+	ansiBorrarLinea   = "\x1b[K"
+	ansiNegrita       = "\x1b[1m"
+	ansiRojo          = "\x1b[31m"
+	ansiVerde         = "\x1b[32m"
+	ansiNormal        = "\x1b[0m"
+)
+
+// Color de cada tipo de incidencia (el mismo en el panel y en los informes)
+var colorEspecialidad = map[Especialidad]string{
+	Mecanica:   "\x1b[34m",
+	Electrica:  "\x1b[33m",
+	Carroceria: "\x1b[35m",
+}
+
+const plazasPorFila = 4
+
+// Panel dibuja el estado del taller a pantalla completa varias veces por segundo
+type Panel struct {
+	t     *Taller
+	w     io.Writer
+	cola  func() int // trabajos esperando
+	cada  time.Duration
+	parar chan struct{}
+	hecho chan struct{}
+}
+
+func nuevoPanel(t *Taller, w io.Writer, cola func() int) *Panel {
+	return &Panel{
+		t:     t,
+		w:     w,
+		cola:  cola,
+		cada:  200 * time.Millisecond,
+		parar: make(chan struct{}),
+		hecho: make(chan struct{}),
+	}
+}
+
+// Toma la pantalla y empieza a refrescarla en segundo plano
+func (p *Panel) iniciar() {
+	p.t.seguimiento.mu.Lock()
+	p.t.seguimiento.panelActivo = true
+	p.t.seguimiento.mu.Unlock()
+
+	fmt.Fprint(p.w, ansiPantallaAlt+ansiOcultarCursor)
+	go func() {
+		defer close(p.hecho)
+		tick := time.NewTicker(p.cada)
+		defer tick.Stop()
+		for {
+			var fotograma string
+			p.t.conTaller(func() { fotograma = p.dibujar(time.Now()) })
+			fmt.Fprint(p.w, ansiInicio+fotograma+ansiBorrarResto)
+			select {
+			case <-p.parar:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+}
+
+// Deja de refrescar y devuelve la pantalla normal
+func (p *Panel) detener() {
+	close(p.parar)
+	<-p.hecho
+	fmt.Fprint(p.w, ansiMostrarCursor+ansiPantallaNorm)
+
+	p.t.seguimiento.mu.Lock()
+	p.t.seguimiento.panelActivo = false
+	p.t.seguimiento.mu.Unlock()
+}
+
+// Genera un fotograma completo del panel. Se llama con el taller cogido.
+func (p *Panel) dibujar(ahora time.Time) string {
+	t := p.t
+	s := t.seguimiento
+	var b strings.Builder
+	linea := func(formato string, args ...any) {
+		fmt.Fprintf(&b, formato, args...)
+		b.WriteString(ansiBorrarLinea + "\n")
+	}
+
+	s.mu.Lock()
+	enCurso := map[int]trabajoEnCurso{}
+	for id, tr := range s.enCurso {
+		enCurso[id] = *tr
+	}
+	eventos := append([]string(nil), s.eventos...)
+	transcurrido := ahora.Sub(s.inicio).Truncate(time.Second)
+	s.mu.Unlock()
+
+	cola := 0
+	if p.cola != nil {
+		cola = p.cola()
+	}
+	plazas := t.datos().Plazas()
+	hora := t.ahora()
+	linea("%s=== TALLER EN VIVO ===%s  tiempo %s | %s %s | cola %d trabajos | plazas %d/%d ocupadas",
+		ansiNegrita, ansiNormal, transcurrido, nombresDia[hora.Weekday()], hora.Format("15:04"),
+		cola, len(t.plazasOcupadas()), len(plazas))
+	linea("")
+
+	// ---- PLAZAS ----
+	linea("%sPlazas%s", ansiNegrita, ansiNormal)
+	for i := 0; i < len(plazas); i += plazasPorFila {
+		var fila strings.Builder
+		for _, pl := range plazas[i:min(i+plazasPorFila, len(plazas))] {
+			if pl.Ocupada {
+				fmt.Fprintf(&fila, "%s[P%-2d %-10s]%s ", ansiRojo, pl.ID, pl.VehiculoMat, ansiNormal)
+			} else {
+				fmt.Fprintf(&fila, "%s[P%-2d %-10s]%s ", ansiVerde, pl.ID, "libre", ansiNormal)
+			}
+		}
+		linea("  %s", fila.String())
+	}
+	linea("")
+
+	// ---- MECÁNICOS ----
+	linea("%sMecánicos%s", ansiNegrita, ansiNormal)
+	for _, m := range t.datos().Mecanicos() {
+		tr, ocupado := enCurso[m.ID]
+		if !ocupado {
+			estado := "libre"
+			if mat := t.inventario.esperandoPiezas(m); mat != "" {
+				estado = "esperando piezas para " + mat
+			} else if !m.enTurno(hora) {
+				estado = "fuera de turno"
+			}
+			linea("  %-16s %-10s %s", m.Nombre, m.Especialidad, estado)
+			continue
+		}
+		progreso := 1.0
+		if tr.Duracion > 0 {
+			progreso = min(float64(ahora.Sub(tr.Inicio))/float64(tr.Duracion), 1)
+		}
+		linea("  %-16s %-10s %s%-10s %-10s%s %s", m.Nombre, m.Especialidad,
+			colorEspecialidad[tr.Tipo], tr.Matricula, tr.Tipo, ansiNormal, barraProgreso(progreso, 20))
+	}
+	linea("")
+
+	// ---- PRIORITARIOS ----
+	var prioritarios []string
+	for _, v := range t.datos().Vehiculos() {
+		if v.Prioritario && v.TiempoTotal > 0 {
+			prioritarios = append(prioritarios, fmt.Sprintf("%s (%ds)", v.Matricula, v.TiempoTotal))
+		}
+	}
+	if len(prioritarios) == 0 {
+		prioritarios = []string{"(ninguno)"}
+	}
+	linea("%sPrioritarios:%s %s", ansiNegrita, ansiNormal, strings.Join(prioritarios, ", "))
+	linea("")
+
+	// ---- EVENTOS ----
+	linea("%sEventos%s", ansiNegrita, ansiNormal)
+	for _, e := range eventos {
+		linea("  %s", e)
+	}
+	return b.String()
+}
+
+// Barra del estilo [#########-----------]  45%
+func barraProgreso(fraccion float64, ancho int) string {
+	llenos := int(fraccion * float64(ancho))
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", llenos), strings.Repeat("-", ancho-llenos), fraccion*100)
+}
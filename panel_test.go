@@ -0,0 +1,67 @@
+// panel_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanelDibujaEstadoDelTaller(t *testing.T) {
+	taller := &Taller{seguimiento: nuevoSeguimiento()}
+	m := taller.newMecanico("Luis", "mecanica", 5)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	taller.datos().Plazas()[0].Ocupada = true
+	taller.datos().Plazas()[0].VehiculoMat = v.Matricula
+
+	panel := nuevoPanel(taller, &strings.Builder{}, func() int { return 3 })
+	taller.seguimiento.panelActivo = true
+	taller.avisar("Llega vehículo %s", v.Matricula)
+
+	inicio := time.Now()
+	taller.seguimiento.empezar(m, v, inc, taller.plazaDeVehiculo(v.Matricula), 10*time.Second)
+	taller.seguimiento.enCurso[m.ID].Inicio = inicio
+	frame := panel.dibujar(inicio.Add(5 * time.Second))
+
+	for _, esperado := range []string{
+		"cola 3 trabajos",
+		"1/2 ocupadas",
+		"1234 BCD",
+		"[##########----------]  50%",
+		"Llega vehículo 1234 BCD",
+	} {
+		if !strings.Contains(frame, esperado) {
+			t.Errorf("el panel debería contener %q:\n%s", esperado, frame)
+		}
+	}
+
+	// Al terminar el trabajo el mecánico vuelve a aparecer libre
+	taller.seguimiento.terminar(m)
+	if frame := panel.dibujar(time.Now()); !strings.Contains(frame, "mecanica   libre") {
+		t.Errorf("el mecánico debería aparecer libre:\n%s", frame)
+	}
+}
+
+// Un mecánico de la simulación anterior puede terminar mientras empieza la
+// siguiente: el seguimiento se vacía sin cambiar de instancia
+func TestSeguimientoSeReinicia(t *testing.T) {
+	s := nuevoSeguimiento()
+	m := &Mecanico{ID: 1, Nombre: "Luis"}
+	v := &Vehiculo{Matricula: "1234 BCD"}
+	inc := &Incidencia{ID: 1, Tipo: Mecanica}
+	s.empezar(m, v, inc, nil, time.Second)
+
+	hecho := make(chan struct{})
+	go func() {
+		s.terminar(m)
+		close(hecho)
+	}()
+	s.reiniciar()
+	<-hecho
+
+	s.empezar(m, v, inc, nil, time.Second)
+	if tramos := s.tramosHasta(time.Now()); len(tramos) != 1 {
+		t.Errorf("tras reiniciar quedan %d tramos, se esperaba sólo el nuevo", len(tramos))
+	}
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ PIEZAS DE RECAMBIO ------------
+
+// Pieza del catálogo con su stock. Cuando el stock baja del mínimo se pide
+// CantidadPedido al proveedor, que tarda PlazoEntrega horas de taller.
+type Pieza struct {
+	ID             int
+	Referencia     string
+	Nombre         string
+	Precio         float64 // precio de venta por unidad, sin IVA
+	Stock          int
+	StockMinimo    int
+	CantidadPedido int
+	PlazoEntrega   int
+}
+
+// Material que necesita una incidencia
+type PiezaNecesaria struct {
+	PiezaID  int
+	Cantidad int
+}
+
+type TipoMovimiento string
+
+const (
+	MovEntrada   TipoMovimiento = "entrada"   // alta manual de stock
+	MovConsumo   TipoMovimiento = "consumo"   // un mecánico saca piezas para una incidencia
+	MovRecepcion TipoMovimiento = "recepción" // llega un pedido al proveedor
+	MovAjuste    TipoMovimiento = "ajuste"    // corrección de inventario (puede ser negativa)
+)
+
+// Cada cambio de stock queda registrado en el historial del almacén
+type MovimientoStock struct {
+	Fecha        time.Time
+	PiezaID      int
+	Tipo         TipoMovimiento
+	Cantidad     int // positiva si entra, negativa si sale
+	StockFinal   int
+	IncidenciaID int // sólo en los consumos
+}
+
+// No hay stock suficiente de alguna pieza
+var errSinStock = errors.New("stock insuficiente")
+
+func validarPieza(ref, nombre string, precio float64, stock, minimo, pedido, plazo int) error {
+	switch {
+	case strings.TrimSpace(ref) == "":
+		return errorValidacion("referencia", ref, "no puede estar vacía")
+	case strings.TrimSpace(nombre) == "":
+		return errorValidacion("nombre", nombre, "no puede estar vacío")
+	case precio < 0:
+		return errorValidacion("precio", fmt.Sprint(precio), "no puede ser negativo")
+	case stock < 0:
+		return errorValidacion("stock", fmt.Sprint(stock), "no puede ser negativo")
+	case minimo < 0:
+		return errorValidacion("stock mínimo", fmt.Sprint(minimo), "no puede ser negativo")
+	case pedido <= 0:
+		return errorValidacion("cantidad por pedido", fmt.Sprint(pedido), "debe ser mayor que cero")
+	case plazo < 0:
+		return errorValidacion("plazo de entrega", fmt.Sprint(plazo), "no puede ser negativo")
+	}
+	return nil
+}
+
+func (t *Taller) getPieza(id int) *Pieza {
+	return t.datos().Pieza(id)
+}
+
+func (t *Taller) newPieza(ref, nombre string, precio float64, stock, minimo, pedido, plazo int) (*Pieza, error) {
+	ref = strings.ToUpper(strings.TrimSpace(ref))
+	if err := validarPieza(ref, nombre, precio, stock, minimo, pedido, plazo); err != nil {
+		return nil, err
+	}
+	for _, p := range t.datos().Piezas() {
+		if p.Referencia == ref {
+			return nil, errorValidacion("referencia", ref, fmt.Sprintf("ya existe (pieza %d)", p.ID))
+		}
+	}
+
+	p := &Pieza{
+		ID:             t.nextPiezaID,
+		Referencia:     ref,
+		Nombre:         strings.TrimSpace(nombre),
+		Precio:         precio,
+		StockMinimo:    minimo,
+		CantidadPedido: pedido,
+		PlazoEntrega:   plazo,
+	}
+	t.nextPiezaID++
+	err := t.datos().Transaccion(func() error {
+		if err := t.datos().GuardarPieza(p); err != nil {
+			return err
+		}
+		if stock > 0 {
+			return t.moverStock(p, MovEntrada, stock, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Cambia los datos de catálogo de una pieza (el stock sólo cambia con movimientos)
+func (t *Taller) updatePieza(id int, nombre string, precio float64, minimo, pedido, plazo int) error {
+	p := t.getPieza(id)
+	if p == nil {
+		return fmt.Errorf("pieza con ID %d no encontrada", id)
+	}
+	if err := validarPieza(p.Referencia, nombre, precio, p.Stock, minimo, pedido, plazo); err != nil {
+		return err
+	}
+	p.Nombre = strings.TrimSpace(nombre)
+	p.Precio = precio
+	p.StockMinimo = minimo
+	p.CantidadPedido = pedido
+	p.PlazoEntrega = plazo
+	return t.datos().GuardarPieza(p)
+}
+
+func (t *Taller) deletePieza(id int) error {
+	for _, inc := range t.datos().Incidencias() {
+		if inc.Estado == 2 || inc.PiezasServidas {
+			continue
+		}
+		for _, pn := range inc.Piezas {
+			if pn.PiezaID == id {
+				return fmt.Errorf("no se puede eliminar la pieza ID %d: la necesita la incidencia ID %d", id, inc.ID)
+			}
+		}
+	}
+	return t.datos().BorrarPieza(id)
+}
+
+// Suma (o resta) cantidad al stock de p y lo apunta en el historial
+func (t *Taller) moverStock(p *Pieza, tipo TipoMovimiento, cantidad, incID int) error {
+	if p.Stock+cantidad < 0 {
+		return fmt.Errorf("pieza %s: quedan %d y se piden %d: %w", p.Referencia, p.Stock, -cantidad, errSinStock)
+	}
+	p.Stock += cantidad
+	if err := t.datos().GuardarPieza(p); err != nil {
+		return err
+	}
+	return t.datos().RegistrarMovimiento(MovimientoStock{
+		Fecha:        t.ahora(),
+		PiezaID:      p.ID,
+		Tipo:         tipo,
+		Cantidad:     cantidad,
+		StockFinal:   p.Stock,
+		IncidenciaID: incID,
+	})
+}
+
+// Entrada manual o ajuste de inventario desde el menú
+func (t *Taller) entradaStock(id int, tipo TipoMovimiento, cantidad int) error {
+	p := t.getPieza(id)
+	if p == nil {
+		return fmt.Errorf("pieza con ID %d no encontrada", id)
+	}
+	if cantidad == 0 || (tipo == MovEntrada && cantidad < 0) {
+		return errorValidacion("cantidad", fmt.Sprint(cantidad), "debe ser positiva (use un ajuste para restar)")
+	}
+	return t.datos().Transaccion(func() error {
+		return t.moverStock(p, tipo, cantidad, 0)
+	})
+}
+
+// Indica las piezas que necesita una incidencia (sustituye a las anteriores)
+func (t *Taller) asignarPiezas(incID int, piezas []PiezaNecesaria) error {
+	inc := t.getIncidencia(incID)
+	if inc == nil {
+		return fmt.Errorf("incidencia con ID %d no encontrada", incID)
+	}
+	if inc.PiezasServidas || inc.Estado == 2 {
+		return fmt.Errorf("la incidencia %d ya tiene su material servido", incID)
+	}
+	// Una línea por pieza, sumando las repetidas
+	cantidades := map[int]int{}
+	var orden []int
+	for _, pn := range piezas {
+		if t.getPieza(pn.PiezaID) == nil {
+			return fmt.Errorf("pieza con ID %d no encontrada", pn.PiezaID)
+		}
+		if pn.Cantidad <= 0 {
+			return errorValidacion("cantidad", fmt.Sprint(pn.Cantidad), "debe ser mayor que cero")
+		}
+		if _, ok := cantidades[pn.PiezaID]; !ok {
+			orden = append(orden, pn.PiezaID)
+		}
+		cantidades[pn.PiezaID] += pn.Cantidad
+	}
+	inc.Piezas = nil
+	for _, id := range orden {
+		inc.Piezas = append(inc.Piezas, PiezaNecesaria{PiezaID: id, Cantidad: cantidades[id]})
+	}
+	return t.datos().GuardarIncidencia(inc)
+}
+
+// Piezas de la incidencia de las que no hay bastante stock, con lo que falta
+func (t *Taller) piezasQueFaltan(inc *Incidencia) map[int]int {
+	faltan := map[int]int{}
+	for _, pn := range inc.Piezas {
+		p := t.getPieza(pn.PiezaID)
+		if p == nil {
+			continue // pieza borrada del catálogo: no se puede esperar por ella
+		}
+		if p.Stock < pn.Cantidad {
+			faltan[p.ID] = pn.Cantidad - p.Stock
+		}
+	}
+	return faltan
+}
+
+// Saca del almacén todo el material de la incidencia, o nada si falta algo
+func (t *Taller) servirPiezas(inc *Incidencia) error {
+	if inc.PiezasServidas {
+		return nil
+	}
+	return t.datos().Transaccion(func() error {
+		for _, pn := range inc.Piezas {
+			p := t.getPieza(pn.PiezaID)
+			if p == nil {
+				continue
+			}
+			if err := t.moverStock(p, MovConsumo, -pn.Cantidad, inc.ID); err != nil {
+				return err
+			}
+		}
+		inc.PiezasServidas = true
+		return t.datos().GuardarIncidencia(inc)
+	})
+}
+
+// Historial de una pieza, del más antiguo al más reciente
+func (t *Taller) movimientosPieza(id int) []MovimientoStock {
+	var lista []MovimientoStock
+	for _, mv := range t.datos().Movimientos() {
+		if mv.PiezaID == id {
+			lista = append(lista, mv)
+		}
+	}
+	return lista
+}
+
+// ------------ INVENTARIO DURANTE LA SIMULACIÓN ------------
+
+// Inventario coordina a los mecánicos que sacan piezas del almacén: si falta
+// material el mecánico se queda esperando con el trabajo hasta que llega el
+// pedido al proveedor, que se hace solo al bajar del stock mínimo.
+type Inventario struct {
+	mu        sync.Mutex
+	t         *Taller
+	cambio    chan struct{}       // se cierra (y se renueva) cuando entra material
+	pedidos   map[int]*time.Timer // pedido en camino de cada pieza
+	esperando map[int]string      // mecánicos esperando piezas y su vehículo
+	fin       chan struct{}
+	cerrado   bool
+
+	numPedidos      int
+	esperas         int
+	tiempoEsperando time.Duration // en tiempo de taller
+}
+
+func nuevoInventario(t *Taller) *Inventario {
+	return &Inventario{
+		t:         t,
+		cambio:    make(chan struct{}),
+		pedidos:   map[int]*time.Timer{},
+		esperando: map[int]string{},
+		fin:       make(chan struct{}),
+	}
+}
+
+// El mecánico saca el material de la incidencia. Si no hay bastante pide lo
+// que falta y espera a que llegue. Devuelve false si antes se cierra parar,
+// termina la simulación o no se puede sacar el material (el trabajo vuelve
+// entonces a la cola). Sin inventario (fuera de la simulación) no se
+// controla el stock. Se llama con el taller cogido, que se suelta mientras
+// se espera.
+func (inv *Inventario) reservar(m *Mecanico, v *Vehiculo, inc *Incidencia, parar <-chan struct{}) bool {
+	if inv == nil || inc.PiezasServidas || len(inc.Piezas) == 0 {
+		return true
+	}
+	r := &reservaPiezas{m: m, v: v, inc: inc}
+	for {
+		hecha, ok, cambio := inv.intentar(r)
+		if hecha {
+			return ok
+		}
+		seguir := false
+		inv.t.sinTaller(func() { seguir = inv.esperarCambio(cambio, parar) })
+		if !seguir {
+			inv.dejarDeEsperar(m, r.inicio)
+			return false
+		}
+	}
+}
+
+// Material que un mecánico intenta sacar para una incidencia
+type reservaPiezas struct {
+	m      *Mecanico
+	v      *Vehiculo
+	inc    *Incidencia
+	inicio time.Time // cuándo empezó a esperar (cero si todavía no)
+}
+
+// Saca el material si hay bastante. Si no, pide lo que falta y devuelve el
+// canal que se cierra cuando entre más. hecha indica que ya no hay que
+// esperar y ok, si se sacó. Se llama con el taller cogido.
+func (inv *Inventario) intentar(r *reservaPiezas) (hecha, ok bool, cambio <-chan struct{}) {
+	t := inv.t
+	m, v, inc := r.m, r.v, r.inc
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.cerrado {
+		return true, false, nil
+	}
+	faltan := t.piezasQueFaltan(inc)
+	if len(faltan) == 0 {
+		err := t.servirPiezas(inc)
+		for _, pn := range inc.Piezas {
+			if p := t.getPieza(pn.PiezaID); p != nil && p.Stock <= p.StockMinimo {
+				inv.pedir(p, 0)
+			}
+		}
+		if !r.inicio.IsZero() {
+			inv.tiempoEsperando += t.ahora().Sub(r.inicio)
+			delete(inv.esperando, m.ID)
+		}
+		if err != nil {
+			t.avisar("Error sacando piezas para la incidencia %d: %v", inc.ID, err)
+			return true, false, nil
+		}
+		return true, true, nil
+	}
+
+	if r.inicio.IsZero() {
+		r.inicio = t.ahora()
+		inv.esperas++
+		inv.esperando[m.ID] = v.Matricula
+		t.avisar("Mecánico %s espera piezas para el vehículo %s [%s]", m.Nombre, v.Matricula, inc.Tipo)
+		t.notificar(AvisoRetraso, v, datosAviso{Motivo: "estamos esperando piezas del proveedor"}, "piezas")
+	}
+	for id, cantidad := range faltan {
+		inv.pedir(t.getPieza(id), cantidad)
+	}
+	return false, false, inv.cambio
+}
+
+// Espera (sin el taller) a que entre material. Devuelve false si antes se
+// cierra parar o termina la simulación.
+func (inv *Inventario) esperarCambio(cambio, parar <-chan struct{}) bool {
+	select {
+	case <-cambio:
+		return true
+	case <-parar:
+		return false
+	case <-inv.fin:
+		return false
+	}
+}
+
+func (inv *Inventario) dejarDeEsperar(m *Mecanico, inicio time.Time) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	delete(inv.esperando, m.ID)
+	inv.tiempoEsperando += inv.t.ahora().Sub(inicio)
+}
+
+// Hace un pedido de la pieza si no hay ya uno en camino: lo de siempre o lo
+// que falta para cubrir la demanda y volver al mínimo. Se llama con mu cogido.
+func (inv *Inventario) pedir(p *Pieza, falta int) {
+	if _, enCamino := inv.pedidos[p.ID]; enCamino || inv.cerrado {
+		return
+	}
+	cantidad := max(p.CantidadPedido, falta+p.StockMinimo)
+	inv.numPedidos++
+	inv.t.avisar("Pedido al proveedor: %d x %s (%s), llega en %dh", cantidad, p.Referencia, p.Nombre, p.PlazoEntrega)
+	plazo := inv.t.reloj.Real(time.Duration(p.PlazoEntrega) * time.Hour)
+	inv.pedidos[p.ID] = time.AfterFunc(plazo, func() { inv.recibir(p, cantidad) })
+}
+
+// Llega un pedido: se suma al stock y se avisa a quien esté esperando. Lo
+// llama el temporizador del pedido, que coge el taller antes que mu.
+func (inv *Inventario) recibir(p *Pieza, cantidad int) {
+	inv.t.conTaller(func() { inv.recibirPedido(p, cantidad) })
+}
+
+func (inv *Inventario) recibirPedido(p *Pieza, cantidad int) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.cerrado {
+		return
+	}
+	delete(inv.pedidos, p.ID)
+	err := inv.t.datos().Transaccion(func() error {
+		return inv.t.moverStock(p, MovRecepcion, cantidad, 0)
+	})
+	if err != nil {
+		inv.t.avisar("Error recibiendo el pedido de %s: %v", p.Referencia, err)
+	} else {
+		inv.t.avisar("Recibidas %d unidades de %s (stock %d)", cantidad, p.Referencia, p.Stock)
+	}
+	close(inv.cambio)
+	inv.cambio = make(chan struct{})
+}
+
+// Vehículo para el que el mecánico espera piezas ("" si no espera)
+func (inv *Inventario) esperandoPiezas(m *Mecanico) string {
+	if inv == nil {
+		return ""
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.esperando[m.ID]
+}
+
+// Termina la simulación: se anulan los pedidos en camino y se despierta a
+// los mecánicos que esperaban
+func (inv *Inventario) cerrar() {
+	if inv == nil {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.cerrado {
+		return
+	}
+	inv.cerrado = true
+	for _, tm := range inv.pedidos {
+		tm.Stop()
+	}
+	close(inv.fin)
+}
+
+func (inv *Inventario) informe() string {
+	if inv == nil {
+		return ""
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Pedidos a proveedores: %d (%d sin llegar al terminar)\n", inv.numPedidos, len(inv.pedidos))
+	fmt.Fprintf(&b, "Esperas por falta de piezas: %d (%.1f horas de mecánico)\n", inv.esperas, inv.tiempoEsperando.Hours())
+	return b.String()
+}
+
+// Catálogo de ejemplo para la simulación, con poco stock para que haya pedidos
+func (t *Taller) piezasDeEjemplo() {
+	for _, p := range []struct {
+		ref, nombre string
+		precio      float64
+	}{
+		{"FIL-01", "Filtro de aceite", 12.5},
+		{"BAT-01", "Batería 12V", 95},
+		{"PIN-01", "Pintura (litro)", 30},
+	} {
+		if _, err := t.newPieza(p.ref, p.nombre, p.precio, 2, 1, 4, 3); err != nil {
+			t.avisar("Error creando la pieza %s: %v", p.ref, err)
+		}
+	}
+}
+
+// ------------ MOSTRAR Y MENÚ ------------
+
+func printPieza(p *Pieza) {
+	aviso := ""
+	if p.Stock <= p.StockMinimo {
+		aviso = "  ¡bajo mínimo!"
+	}
+	fmt.Printf("Pieza %d - %s %s (%.2f €): %d en stock (mínimo %d, pedido %d, entrega en %dh)%s\n",
+		p.ID, p.Referencia, p.Nombre, p.Precio, p.Stock, p.StockMinimo, p.CantidadPedido, p.PlazoEntrega, aviso)
+}
+
+func printMovimientos(t *Taller, movs []MovimientoStock) {
+	if len(movs) == 0 {
+		fmt.Println("No hay movimientos.")
+		return
+	}
+	for _, mv := range movs {
+		ref := fmt.Sprintf("pieza %d", mv.PiezaID)
+		if p := t.getPieza(mv.PiezaID); p != nil {
+			ref = p.Referencia
+		}
+		linea := fmt.Sprintf("%s  %-10s %-9s %+4d  -> %d", formatearFecha(mv.Fecha), ref, mv.Tipo, mv.Cantidad, mv.StockFinal)
+		if mv.Tipo == MovConsumo {
+			linea += fmt.Sprintf("  (incidencia %d)", mv.IncidenciaID)
+		}
+		fmt.Println(linea)
+	}
+}
+
+// Pide una o varias piezas con su cantidad
+func leerPiezasNecesarias(t *Taller, in *Entrada) ([]PiezaNecesaria, error) {
+	var piezas []PiezaNecesaria
+	for {
+		id, err := leerValor(in, "ID pieza", nil, "", func(s string) (int, error) {
+			id, err := parsearEntero(s)
+			if err == nil && t.getPieza(id) == nil {
+				err = fmt.Errorf("pieza con ID %d no encontrada", id)
+			}
+			return id, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		cantidad, err := in.EnteroDefecto("Cantidad", 1)
+		if err != nil {
+			return nil, err
+		}
+		piezas = append(piezas, PiezaNecesaria{PiezaID: id, Cantidad: cantidad})
+		otra, err := in.SiNo("¿Otra pieza?", false)
+		if err != nil || !otra {
+			return piezas, nil
+		}
+	}
+}
+
+func menuPiezas(t *Taller, in *Entrada) {
+	for {
+		fmt.Println("\n--- PIEZAS DE RECAMBIO ---")
+		fmt.Println("1. Crear pieza")
+		fmt.Println("2. Listar piezas y stock")
+		fmt.Println("3. Modificar pieza")
+		fmt.Println("4. Eliminar pieza")
+		fmt.Println("5. Entrada o ajuste de stock")
+		fmt.Println("6. Piezas necesarias de una incidencia")
+		fmt.Println("7. Historial de movimientos")
+		fmt.Println("0. Volver al menú principal")
+
+		op, err := in.Opcion("Seleccione", 0, 7)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case 1:
+			ref, err := in.Texto("Referencia")
+			if err != nil {
+				return
+			}
+			nombre, err := in.Texto("Nombre")
+			if err != nil {
+				return
+			}
+			precio, err := in.Importe("Precio por unidad sin IVA (€)", 0)
+			if err != nil {
+				return
+			}
+			stock, err := in.EnteroDefecto("Stock inicial", 0)
+			if err != nil {
+				return
+			}
+			minimo, err := in.EnteroDefecto("Stock mínimo", 1)
+			if err != nil {
+				return
+			}
+			pedido, err := in.EnteroDefecto("Unidades por pedido", 5)
+			if err != nil {
+				return
+			}
+			plazo, err := in.EnteroDefecto("Plazo de entrega (horas)", 24)
+			if err != nil {
+				return
+			}
+			if p, err := t.newPieza(ref, nombre, precio, stock, minimo, pedido, plazo); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Pieza creada (ID: %d)\n", p.ID)
+			}
+		case 2:
+			piezas := append([]*Pieza(nil), t.datos().Piezas()...)
+			if len(piezas) == 0 {
+				fmt.Println("No hay piezas registradas.")
+				break
+			}
+			sort.Slice(piezas, func(i, j int) bool { return piezas[i].Referencia < piezas[j].Referencia })
+			for _, p := range piezas {
+				printPieza(p)
+			}
+		case 3:
+			id, err := in.Entero("ID pieza")
+			if err != nil {
+				return
+			}
+			p := t.getPieza(id)
+			if p == nil {
+				fmt.Printf("Pieza con ID %d no encontrada.\n", id)
+				break
+			}
+			nombre, err := in.TextoDefecto("Nombre", p.Nombre)
+			if err != nil {
+				return
+			}
+			precio, err := in.Importe("Precio por unidad sin IVA (€)", p.Precio)
+			if err != nil {
+				return
+			}
+			minimo, err := in.EnteroDefecto("Stock mínimo", p.StockMinimo)
+			if err != nil {
+				return
+			}
+			pedido, err := in.EnteroDefecto("Unidades por pedido", p.CantidadPedido)
+			if err != nil {
+				return
+			}
+			plazo, err := in.EnteroDefecto("Plazo de entrega (horas)", p.PlazoEntrega)
+			if err != nil {
+				return
+			}
+			if err := t.updatePieza(id, nombre, precio, minimo, pedido, plazo); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Pieza actualizada.")
+			}
+		case 4:
+			id, err := in.Entero("ID pieza")
+			if err != nil {
+				return
+			}
+			if err := t.deletePieza(id); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Pieza eliminada.")
+			}
+		case 5:
+			id, err := in.Entero("ID pieza")
+			if err != nil {
+				return
+			}
+			cantidad, err := in.Entero("Cantidad (negativa para restar en un ajuste)")
+			if err != nil {
+				return
+			}
+			tipo := MovEntrada
+			if cantidad < 0 {
+				tipo = MovAjuste
+			}
+			if err := t.entradaStock(id, tipo, cantidad); err != nil {
+				fmt.Println(err)
+			} else {
+				printPieza(t.getPieza(id))
+			}
+		case 6:
+			id, err := in.Entero("ID incidencia")
+			if err != nil {
+				return
+			}
+			if t.getIncidencia(id) == nil {
+				fmt.Println("Incidencia no encontrada.")
+				break
+			}
+			piezas, err := leerPiezasNecesarias(t, in)
+			if err != nil {
+				break
+			}
+			if err := t.asignarPiezas(id, piezas); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Piezas asignadas.")
+			}
+		case 7:
+			linea, err := in.TextoDefecto("ID pieza (vacío = todas)", "")
+			if err != nil {
+				return
+			}
+			if linea == "" {
+				printMovimientos(t, t.datos().Movimientos())
+				break
+			}
+			id, err := parsearEntero(linea)
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			printMovimientos(t, t.movimientosPieza(id))
+		case 0:
+			return
+		}
+	}
+}
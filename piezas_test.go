@@ -0,0 +1,240 @@
+// piezas_test.go
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidacionPiezas(t *testing.T) {
+	taller := &Taller{}
+	casos := []struct {
+		ref, nombre                  string
+		stock, minimo, pedido, plazo int
+	}{
+		{"", "Filtro", 1, 1, 1, 1},
+		{"F-1", " ", 1, 1, 1, 1},
+		{"F-1", "Filtro", -1, 1, 1, 1},
+		{"F-1", "Filtro", 1, -1, 1, 1},
+		{"F-1", "Filtro", 1, 1, 0, 1},
+		{"F-1", "Filtro", 1, 1, 1, -1},
+	}
+	for _, c := range casos {
+		var ev *ErrorValidacion
+		if _, err := taller.newPieza(c.ref, c.nombre, 10, c.stock, c.minimo, c.pedido, c.plazo); !errors.As(err, &ev) {
+			t.Errorf("%+v: se esperaba un error de validación, se obtuvo %v", c, err)
+		}
+	}
+	if _, err := taller.newPieza("f-1", "Filtro", 10, 1, 1, 1, 1); err != nil {
+		t.Fatalf("pieza válida rechazada: %v", err)
+	}
+	if _, err := taller.newPieza("F-1 ", "Otro filtro", 10, 1, 1, 1, 1); err == nil {
+		t.Error("no debería aceptar una referencia repetida")
+	}
+}
+
+func TestMovimientosDeStock(t *testing.T) {
+	taller := &Taller{}
+	p, _ := taller.newPieza("BAT", "Batería", 10, 3, 1, 5, 24)
+
+	if err := taller.entradaStock(p.ID, MovAjuste, -5); !errors.Is(err, errSinStock) {
+		t.Fatalf("no se puede dejar el stock en negativo: %v", err)
+	}
+	if err := taller.entradaStock(p.ID, MovEntrada, -1); err == nil {
+		t.Fatal("una entrada no puede ser negativa")
+	}
+	if err := taller.entradaStock(p.ID, MovAjuste, -1); err != nil {
+		t.Fatalf("error en el ajuste: %v", err)
+	}
+	if err := taller.entradaStock(p.ID, MovEntrada, 4); err != nil {
+		t.Fatalf("error en la entrada: %v", err)
+	}
+
+	movs := taller.movimientosPieza(p.ID)
+	esperados := []struct {
+		tipo              TipoMovimiento
+		cantidad, despues int
+	}{{MovEntrada, 3, 3}, {MovAjuste, -1, 2}, {MovEntrada, 4, 6}}
+	if len(movs) != len(esperados) {
+		t.Fatalf("se esperaban %d movimientos, hay %d: %+v", len(esperados), len(movs), movs)
+	}
+	for i, e := range esperados {
+		if movs[i].Tipo != e.tipo || movs[i].Cantidad != e.cantidad || movs[i].StockFinal != e.despues {
+			t.Errorf("movimiento %d = %+v, se esperaba %+v", i, movs[i], e)
+		}
+	}
+	if p.Stock != 6 {
+		t.Errorf("stock = %d, se esperaba 6", p.Stock)
+	}
+}
+
+// Si falta alguna pieza no se saca ninguna
+func TestServirPiezasTodoONada(t *testing.T) {
+	taller := &Taller{}
+	filtro, _ := taller.newPieza("FIL", "Filtro", 10, 5, 0, 1, 1)
+	bateria, _ := taller.newPieza("BAT", "Batería", 10, 1, 0, 1, 1)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	if err := taller.asignarPiezas(tr.Incidencia.ID, []PiezaNecesaria{
+		{PiezaID: filtro.ID, Cantidad: 1}, {PiezaID: bateria.ID, Cantidad: 2}, {PiezaID: filtro.ID, Cantidad: 1},
+	}); err != nil {
+		t.Fatalf("error asignando piezas: %v", err)
+	}
+	if len(tr.Incidencia.Piezas) != 2 || tr.Incidencia.Piezas[0].Cantidad != 2 {
+		t.Fatalf("las líneas repetidas deberían sumarse: %+v", tr.Incidencia.Piezas)
+	}
+	if faltan := taller.piezasQueFaltan(tr.Incidencia); len(faltan) != 1 || faltan[bateria.ID] != 1 {
+		t.Errorf("faltan = %v, se esperaba 1 batería", faltan)
+	}
+
+	antes := len(taller.datos().Movimientos())
+	if err := taller.servirPiezas(tr.Incidencia); !errors.Is(err, errSinStock) {
+		t.Fatalf("se esperaba falta de stock, se obtuvo %v", err)
+	}
+	if filtro.Stock != 5 || tr.Incidencia.PiezasServidas || len(taller.datos().Movimientos()) != antes {
+		t.Errorf("un consumo fallido no debe tocar el stock: filtro %d, servidas %v", filtro.Stock, tr.Incidencia.PiezasServidas)
+	}
+	if err := taller.deletePieza(bateria.ID); err == nil {
+		t.Error("no se puede borrar una pieza que necesita una incidencia abierta")
+	}
+}
+
+// El mecánico se queda esperando las piezas con el trabajo; el pedido se
+// hace solo y al llegar termina la reparación
+func TestMecanicoEsperaPiezas(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.reloj = nuevoRelojSimulado(time.Now()) // el pedido tarda 1h = 1s
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	taller.inventario = nuevoInventario(taller)
+	defer taller.inventario.cerrar()
+	resultados := resultadosDePrueba(taller)
+
+	p, _ := taller.newPieza("BAT", "Batería", 10, 0, 1, 3, 1)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	taller.asignarPiezas(tr.Incidencia.ID, []PiezaNecesaria{{PiezaID: p.ID, Cantidad: 2}})
+	taller.colas.encolar(tr)
+	go trabajoMecanico(m, taller)
+
+	limite := time.Now().Add(500 * time.Millisecond)
+	for taller.inventario.esperandoPiezas(m) != "V-01" {
+		if time.Now().After(limite) {
+			t.Fatal("el mecánico no se ha quedado esperando piezas")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if estadoDe(taller, tr.Incidencia) != 0 {
+		t.Errorf("la reparación no debería empezar sin piezas")
+	}
+
+	select {
+	case <-resultados:
+	case <-time.After(3 * time.Second):
+		t.Fatal("el pedido no llegó o el mecánico no siguió con el trabajo")
+	}
+	esperarQue(t, "que se cierre la incidencia", func() bool { return estadoDe(taller, tr.Incidencia) == 2 })
+	taller.conTaller(func() {
+		if !tr.Incidencia.PiezasServidas {
+			t.Errorf("la incidencia se cerró sin sacar sus piezas")
+		}
+		// Se piden 3 (más que lo que falta más el mínimo) y se gastan 2
+		if p.Stock != 1 {
+			t.Errorf("stock = %d, se esperaba 1", p.Stock)
+		}
+		movs := taller.movimientosPieza(p.ID)
+		if len(movs) != 2 || movs[0].Tipo != MovRecepcion || movs[1].Tipo != MovConsumo || movs[1].IncidenciaID != tr.Incidencia.ID {
+			t.Errorf("historial incorrecto: %+v", movs)
+		}
+	})
+}
+
+// Almacén que no deja apuntar movimientos de stock
+type almacenSinMovimientos struct{ Almacen }
+
+func (almacenSinMovimientos) RegistrarMovimiento(MovimientoStock) error {
+	return errors.New("disco lleno")
+}
+
+// Si no se puede sacar el material el mecánico no empieza: el trabajo vuelve
+// a la cola con sus piezas sin servir
+func TestReservarFallaSiNoSePuedenSacarLasPiezas(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.reloj = nuevoRelojSimulado(time.Now())
+	inv := nuevoInventario(taller)
+	defer inv.cerrar()
+	p, _ := taller.newPieza("BAT", "Batería", 10, 5, 0, 1, 1)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	taller.asignarPiezas(tr.Incidencia.ID, []PiezaNecesaria{{PiezaID: p.ID, Cantidad: 1}})
+	taller.almacen = almacenSinMovimientos{taller.datos()}
+
+	var ok bool
+	taller.conTaller(func() { ok = inv.reservar(m, tr.Vehiculo, tr.Incidencia, nil) })
+	if ok {
+		t.Fatal("reservar debería fallar si no se puede sacar el material")
+	}
+	if tr.Incidencia.PiezasServidas || p.Stock != 5 {
+		t.Errorf("servidas %v, stock %d; no debería haberse sacado nada", tr.Incidencia.PiezasServidas, p.Stock)
+	}
+}
+
+func TestCerrarInventarioDespiertaALosQueEsperan(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.reloj = nuevoRelojSimulado(time.Now())
+	inv := nuevoInventario(taller)
+	p, _ := taller.newPieza("BAT", "Batería", 10, 0, 0, 1, 100)
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	taller.asignarPiezas(tr.Incidencia.ID, []PiezaNecesaria{{PiezaID: p.ID, Cantidad: 1}})
+
+	resultado := make(chan bool)
+	go taller.conTaller(func() { resultado <- inv.reservar(m, tr.Vehiculo, tr.Incidencia, nil) })
+	time.Sleep(20 * time.Millisecond)
+	inv.cerrar()
+	select {
+	case ok := <-resultado:
+		if ok {
+			t.Error("reservar debería fallar al cerrar el inventario")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("el mecánico sigue esperando piezas tras cerrar")
+	}
+	if inv.esperandoPiezas(m) != "" {
+		t.Error("el mecánico ya no debería figurar esperando")
+	}
+}
+
+func TestPiezasSePersisten(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo abrir el almacén: %v", err)
+	}
+	p, _ := taller.newPieza("FIL", "Filtro", 10, 4, 1, 5, 24)
+	taller.newVehiculo("M-1111", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	inc, _ := taller.newIncidencia("M-1111", nil, "mecanica", "Alta", "Cambio de filtro")
+	taller.asignarPiezas(inc.ID, []PiezaNecesaria{{PiezaID: p.ID, Cantidad: 1}})
+	if err := taller.servirPiezas(inc); err != nil {
+		t.Fatalf("error sirviendo piezas: %v", err)
+	}
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo reabrir el almacén: %v", err)
+	}
+	p2 := recargado.getPieza(p.ID)
+	if p2 == nil || p2.Stock != 3 || p2.Referencia != "FIL" {
+		t.Fatalf("pieza no recuperada: %+v", p2)
+	}
+	if movs := recargado.movimientosPieza(p.ID); len(movs) != 2 {
+		t.Errorf("se esperaban 2 movimientos, hay %d", len(movs))
+	}
+	inc2 := recargado.getIncidencia(inc.ID)
+	if inc2 == nil || !inc2.PiezasServidas || len(inc2.Piezas) != 1 {
+		t.Errorf("piezas de la incidencia no recuperadas: %+v", inc2)
+	}
+	if p3, _ := recargado.newPieza("BAT", "Batería", 10, 0, 0, 1, 1); p3 == nil || p3.ID == p.ID {
+		t.Error("el contador de piezas no se ha recuperado")
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ CONTROL DE LA PLANTILLA DURANTE LA SIMULACIÓN ------------
+
+// En la simulación cada segundo representa una hora de trabajo en el taller
+const horasPorSegundoSimulado = 1.0
+
+// PoliticaPlantilla fija cuándo se contratan y se despiden mecánicos temporales
+type PoliticaPlantilla struct {
+	MaxPorEspecialidad  map[Especialidad]int // plantilla máxima (fijos + temporales)
+	UmbralCola          int                  // contratar si esperan más trabajos que esto...
+	UmbralEspera        time.Duration        // ...o si el más antiguo lleva esperando más que esto
+	ColaLiberar         int                  // sólo se despide si esperan como mucho estos trabajos
+	RetardoContratacion time.Duration        // tiempo desde que se decide hasta que empieza a trabajar
+	Enfriamiento        time.Duration        // tiempo sin trabajar antes de despedir a un temporal
+	Intervalo           time.Duration        // cada cuánto se revisa la plantilla
+	CosteContratacion   float64              // euros por cada contratación
+	SalarioHora         float64              // euros por hora de cada mecánico
+	RecargoHoraExtra    float64              // la hora extra se paga a SalarioHora * (1 + recargo)
+}
+
+func politicaPorDefecto() PoliticaPlantilla {
+	return PoliticaPlantilla{
+		MaxPorEspecialidad:  map[Especialidad]int{Mecanica: 3, Electrica: 3, Carroceria: 3},
+		UmbralCola:          2,
+		UmbralEspera:        10 * time.Second,
+		ColaLiberar:         0,
+		RetardoContratacion: 3 * time.Second,
+		Enfriamiento:        8 * time.Second,
+		Intervalo:           500 * time.Millisecond,
+		CosteContratacion:   150,
+		SalarioHora:         18,
+		RecargoHoraExtra:    0.5,
+	}
+}
+
+// Mecánico que trabaja en la simulación, fijo o temporal
+type miembroPlantilla struct {
+	m        *Mecanico
+	temporal bool
+	alta     time.Time
+	baja     time.Time     // cero mientras siga en plantilla
+	ocupado  bool          // está reparando algo ahora mismo
+	ultima   time.Time     // última vez que terminó (o empezó) un trabajo
+	despedir chan struct{} // se cierra para que su goroutine termine
+}
+
+// ControladorPlantilla vigila la cola de cada especialidad y contrata o
+// despide temporales según la política, sin pasarse del máximo ni contratar
+// dos veces por la misma necesidad.
+type ControladorPlantilla struct {
+	mu         sync.Mutex
+	t          *Taller
+	pol        PoliticaPlantilla
+	lanzar     func(m *Mecanico) // arranca la goroutine de un mecánico nuevo
+	avisar     func(msg string)
+	miembros   map[int]*miembroPlantilla
+	esperando  map[int]esperaTrabajo      // trabajos en cola, por ID de incidencia
+	pendientes map[Especialidad]time.Time // contrataciones decididas y cuándo se incorporan
+	horasExtra map[int]float64            // horas trabajadas fuera de turno, por mecánico
+
+	contrataciones int
+	despidos       int
+}
+
+type esperaTrabajo struct {
+	tipo     Especialidad
+	encolado time.Time
+}
+
+func nuevoControladorPlantilla(t *Taller, pol PoliticaPlantilla, lanzar func(*Mecanico), avisar func(string)) *ControladorPlantilla {
+	return &ControladorPlantilla{
+		t:          t,
+		pol:        pol,
+		lanzar:     lanzar,
+		avisar:     avisar,
+		miembros:   map[int]*miembroPlantilla{},
+		esperando:  map[int]esperaTrabajo{},
+		pendientes: map[Especialidad]time.Time{},
+		horasExtra: map[int]float64{},
+	}
+}
+
+// Da de alta en la simulación a un mecánico fijo
+func (c *ControladorPlantilla) registrar(m *Mecanico, ahora time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.miembros[m.ID] = &miembroPlantilla{m: m, alta: ahora, ultima: ahora, despedir: make(chan struct{})}
+}
+
+// Canal que se cierra cuando el mecánico es despedido (nil si nunca lo será)
+func (c *ControladorPlantilla) despido(m *Mecanico) <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mp, ok := c.miembros[m.ID]; ok {
+		return mp.despedir
+	}
+	return nil
+}
+
+// Un trabajo entra en la cola
+func (c *ControladorPlantilla) encolado(inc *Incidencia) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.esperando[inc.ID]; !ok {
+		c.esperando[inc.ID] = esperaTrabajo{tipo: inc.Tipo, encolado: time.Now()}
+	}
+}
+
+// Un mecánico empieza a reparar una incidencia (sale de la cola)
+func (c *ControladorPlantilla) empezar(m *Mecanico, inc *Incidencia) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.esperando, inc.ID)
+	if mp, ok := c.miembros[m.ID]; ok {
+		mp.ocupado = true
+		mp.ultima = time.Now()
+	}
+}
+
+// Un mecánico termina lo que estaba haciendo
+func (c *ControladorPlantilla) terminar(m *Mecanico) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mp, ok := c.miembros[m.ID]; ok {
+		mp.ocupado = false
+		mp.ultima = time.Now()
+	}
+}
+
+// Un mecánico ha trabajado horas fuera de su turno para acabar un trabajo
+func (c *ControladorPlantilla) registrarHorasExtra(m *Mecanico, horas float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.horasExtra[m.ID] += horas
+}
+
+// Revisa la plantilla periódicamente hasta que se cierre parar
+func (c *ControladorPlantilla) ejecutar(parar <-chan struct{}) {
+	tick := time.NewTicker(c.pol.Intervalo)
+	defer tick.Stop()
+	for {
+		select {
+		case <-parar:
+			return
+		case ahora := <-tick.C:
+			c.t.conTaller(func() { c.evaluar(ahora) })
+		}
+	}
+}
+
+// Una revisión: incorpora a los ya contratados, decide nuevas contrataciones
+// y despide a los temporales que llevan tiempo sin trabajo, quitando sus
+// plazas. Se llama con el taller cogido.
+func (c *ControladorPlantilla) evaluar(ahora time.Time) {
+	c.mu.Lock()
+	var nuevos []*Mecanico
+	var mensajes []string
+
+	for _, esp := range []Especialidad{Mecanica, Electrica, Carroceria} {
+		cola, espera := c.colaDe(esp, ahora)
+		enPlantilla := c.enPlantilla(esp)
+
+		// Incorporar la contratación decidida hace RetardoContratacion
+		if llegada, ok := c.pendientes[esp]; ok && !ahora.Before(llegada) {
+			delete(c.pendientes, esp)
+			c.contrataciones++
+			m := c.t.newMecanico(fmt.Sprintf("Temporal-%s-%d", esp, c.contrataciones), string(esp), 1)
+			if m != nil {
+				c.miembros[m.ID] = &miembroPlantilla{
+					m: m, temporal: true, alta: ahora, ultima: ahora, despedir: make(chan struct{}),
+				}
+				nuevos = append(nuevos, m)
+				enPlantilla++
+				mensajes = append(mensajes, fmt.Sprintf("Contratado temporal %s (%s): %d en cola, plantilla %d/%d",
+					m.Nombre, esp, cola, enPlantilla, c.pol.MaxPorEspecialidad[esp]))
+			}
+		}
+
+		// Decidir si hace falta otro (sólo una contratación en curso por especialidad)
+		_, enCurso := c.pendientes[esp]
+		falta := cola > 0 && (enPlantilla == 0 || cola > c.pol.UmbralCola || espera > c.pol.UmbralEspera)
+		if falta && !enCurso && enPlantilla < c.pol.MaxPorEspecialidad[esp] {
+			c.pendientes[esp] = ahora.Add(c.pol.RetardoContratacion)
+			mensajes = append(mensajes, fmt.Sprintf("Se necesita otro mecánico de %s (%d en cola, espera %s): llegará en %s",
+				esp, cola, espera.Truncate(time.Second), c.pol.RetardoContratacion))
+		}
+
+		// Despedir temporales ociosos sólo cuando la cola ha bajado (histéresis)
+		if cola > c.pol.ColaLiberar {
+			continue
+		}
+		for _, mp := range c.miembrosOrdenados() {
+			if !mp.temporal || !mp.baja.IsZero() || mp.m.Especialidad != esp || mp.ocupado {
+				continue
+			}
+			if ahora.Sub(mp.ultima) >= c.pol.Enfriamiento {
+				c.despidos++
+				mensajes = append(mensajes, fmt.Sprintf("Despedido temporal %s tras %s sin trabajo",
+					mp.m.Nombre, ahora.Sub(mp.ultima).Truncate(time.Second)))
+				if err := c.darDeBaja(mp, ahora); err != nil {
+					mensajes = append(mensajes, fmt.Sprintf("Error dando de baja a %s: %v", mp.m.Nombre, err))
+				}
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, m := range nuevos {
+		if c.lanzar != nil {
+			c.lanzar(m)
+		}
+	}
+	for _, msg := range mensajes {
+		if c.avisar != nil {
+			c.avisar(msg)
+		}
+	}
+}
+
+// Da de baja a un temporal: su goroutine termina y sale del taller con sus
+// plazas (las que tienen un vehículo, en cuanto sale). Los temporales sólo
+// existen mientras dura la simulación, así que no se quedan guardados. Se
+// llama con el taller y c.mu cogidos.
+func (c *ControladorPlantilla) darDeBaja(mp *miembroPlantilla, ahora time.Time) error {
+	mp.baja = ahora
+	mp.m.Activo = false
+	close(mp.despedir)
+	if err := c.t.retirarPlazas(mp.m); err != nil {
+		return err
+	}
+	return c.t.deleteMecanico(mp.m.ID)
+}
+
+// Al acabar la simulación se da de baja a los temporales que quedan. Los que
+// están reparando terminan lo que tienen entre manos. Se llama con el taller
+// cogido.
+func (c *ControladorPlantilla) liberarTemporales(ahora time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	var mensajes []string
+	for _, mp := range c.miembrosOrdenados() {
+		if !mp.temporal || !mp.baja.IsZero() {
+			continue
+		}
+		mensajes = append(mensajes, fmt.Sprintf("Termina el contrato del temporal %s", mp.m.Nombre))
+		if err := c.darDeBaja(mp, ahora); err != nil {
+			mensajes = append(mensajes, fmt.Sprintf("Error dando de baja a %s: %v", mp.m.Nombre, err))
+		}
+	}
+	c.mu.Unlock()
+
+	for _, msg := range mensajes {
+		if c.avisar != nil {
+			c.avisar(msg)
+		}
+	}
+}
+
+// Trabajos en cola de una especialidad y cuánto lleva esperando el más antiguo
+func (c *ControladorPlantilla) colaDe(esp Especialidad, ahora time.Time) (int, time.Duration) {
+	n := 0
+	var espera time.Duration
+	for _, e := range c.esperando {
+		if e.tipo != esp {
+			continue
+		}
+		n++
+		espera = max(espera, ahora.Sub(e.encolado))
+	}
+	return n, espera
+}
+
+// Mecánicos de una especialidad que siguen en plantilla (fijos y temporales)
+func (c *ControladorPlantilla) enPlantilla(esp Especialidad) int {
+	n := 0
+	for _, mp := range c.miembros {
+		if mp.m.Especialidad == esp && mp.baja.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *ControladorPlantilla) miembrosOrdenados() []*miembroPlantilla {
+	var lista []*miembroPlantilla
+	for _, mp := range c.miembros {
+		lista = append(lista, mp)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].m.ID < lista[j].m.ID })
+	return lista
+}
+
+// Resumen de costes de personal de la simulación que terminó en fin
+type CostesPlantilla struct {
+	Contrataciones    int
+	Despidos          int
+	CosteContratacion float64
+	SalariosFijos     float64
+	SalariosTemporal  float64
+	HorasExtra        float64
+	CosteHorasExtra   float64
+}
+
+func (cp CostesPlantilla) Total() float64 {
+	return cp.CosteContratacion + cp.SalariosFijos + cp.SalariosTemporal + cp.CosteHorasExtra
+}
+
+func (c *ControladorPlantilla) costes(fin time.Time) CostesPlantilla {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := CostesPlantilla{
+		Contrataciones:    c.contrataciones,
+		Despidos:          c.despidos,
+		CosteContratacion: float64(c.contrataciones) * c.pol.CosteContratacion,
+	}
+	for _, mp := range c.miembros {
+		hasta := fin
+		if !mp.baja.IsZero() {
+			hasta = mp.baja
+		}
+		salario := hasta.Sub(mp.alta).Seconds() * horasPorSegundoSimulado * c.pol.SalarioHora
+		if mp.temporal {
+			cp.SalariosTemporal += salario
+		} else {
+			cp.SalariosFijos += salario
+		}
+	}
+	for _, h := range c.horasExtra {
+		cp.HorasExtra += h
+	}
+	cp.CosteHorasExtra = cp.HorasExtra * c.pol.SalarioHora * (1 + c.pol.RecargoHoraExtra)
+	return cp
+}
+
+// Informe de plantilla para mostrar al final de la simulación
+func (c *ControladorPlantilla) informe(fin time.Time) string {
+	cp := c.costes(fin)
+	var b strings.Builder
+	fmt.Fprintln(&b, "=== Plantilla y costes de personal ===")
+	fmt.Fprintf(&b, "Contrataciones temporales: %d (despedidos: %d)\n", cp.Contrataciones, cp.Despidos)
+	fmt.Fprintf(&b, "Coste de contratación: %.2f €\n", cp.CosteContratacion)
+	fmt.Fprintf(&b, "Salarios plantilla fija: %.2f €\n", cp.SalariosFijos)
+	fmt.Fprintf(&b, "Salarios temporales: %.2f €\n", cp.SalariosTemporal)
+	fmt.Fprintf(&b, "Horas extra: %.1f h (%.2f €)\n", cp.HorasExtra, cp.CosteHorasExtra)
+	c.mu.Lock()
+	for _, mp := range c.miembrosOrdenados() {
+		if h := c.horasExtra[mp.m.ID]; h > 0 {
+			fmt.Fprintf(&b, "  %s: %.1f h\n", mp.m.Nombre, h)
+		}
+	}
+	c.mu.Unlock()
+	fmt.Fprintf(&b, "Total personal: %.2f €\n", cp.Total())
+	return b.String()
+}
@@ -0,0 +1,164 @@
+// plantilla_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControlPlantillaContrataConRetardoYSinPasarseDelMaximo(t *testing.T) {
+	taller := &Taller{}
+	fijo := taller.newMecanico("Luis", "mecanica", 5)
+
+	pol := politicaPorDefecto()
+	pol.MaxPorEspecialidad = map[Especialidad]int{Mecanica: 2, Electrica: 1, Carroceria: 1}
+	pol.UmbralCola = 1
+	pol.RetardoContratacion = 3 * time.Second
+	pol.Enfriamiento = 5 * time.Second
+
+	var lanzados []*Mecanico
+	c := nuevoControladorPlantilla(taller, pol, func(m *Mecanico) { lanzados = append(lanzados, m) }, nil)
+	t0 := time.Now()
+	c.registrar(fijo, t0)
+
+	// Tres trabajos de mecánica esperando con un solo mecánico: hace falta otro
+	for i := 0; i < 3; i++ {
+		c.encolado(&Incidencia{ID: i, Tipo: Mecanica})
+	}
+	c.evaluar(t0)
+	c.evaluar(t0.Add(time.Second))
+	if len(lanzados) != 0 {
+		t.Fatalf("no debería incorporarse nadie antes del retardo de contratación")
+	}
+
+	// Tras el retardo se incorpora uno solo, aunque se revise varias veces
+	c.evaluar(t0.Add(3 * time.Second))
+	c.evaluar(t0.Add(4 * time.Second))
+	c.evaluar(t0.Add(8 * time.Second))
+	if len(lanzados) != 1 {
+		t.Fatalf("se esperaba 1 contratación (máximo 2 mecánicos), hubo %d", len(lanzados))
+	}
+	temporal := lanzados[0]
+	if temporal.Especialidad != Mecanica {
+		t.Errorf("el temporal debería ser de mecánica, es %s", temporal.Especialidad)
+	}
+
+	// Sin mecánicos de eléctrica basta un trabajo en cola para contratar
+	c.encolado(&Incidencia{ID: 10, Tipo: Electrica})
+	c.evaluar(t0.Add(9 * time.Second))
+	c.evaluar(t0.Add(12 * time.Second))
+	if len(lanzados) != 2 || lanzados[1].Especialidad != Electrica {
+		t.Fatalf("se esperaba contratar un mecánico de eléctrica")
+	}
+
+	// Mientras queden trabajos en cola no se despide (histéresis)
+	c.evaluar(t0.Add(30 * time.Second))
+	select {
+	case <-c.despido(temporal):
+		t.Fatalf("no se debe despedir con trabajos esperando")
+	default:
+	}
+
+	// Vaciada la cola, tras el enfriamiento se despide al temporal ocioso
+	for i := 0; i < 3; i++ {
+		c.empezar(fijo, &Incidencia{ID: i, Tipo: Mecanica})
+	}
+	c.terminar(fijo)
+	c.evaluar(t0.Add(31 * time.Second))
+	select {
+	case <-c.despido(temporal):
+	default:
+		t.Fatalf("el temporal ocioso debería haber sido despedido")
+	}
+	if c.despido(fijo) == nil {
+		t.Errorf("el mecánico fijo sigue registrado en la plantilla")
+	}
+	select {
+	case <-c.despido(fijo):
+		t.Errorf("un mecánico fijo nunca se despide")
+	default:
+	}
+
+	// Al acabar la simulación se da de baja al que queda y ningún temporal
+	// se queda en el taller
+	c.liberarTemporales(t0.Add(40 * time.Second))
+	select {
+	case <-c.despido(lanzados[1]):
+	default:
+		t.Fatalf("el temporal de eléctrica debería darse de baja al acabar")
+	}
+	for _, m := range lanzados {
+		if taller.getMecanico(m.ID) != nil {
+			t.Errorf("el temporal %s sigue en el taller", m.Nombre)
+		}
+	}
+	if taller.getMecanico(fijo.ID) == nil {
+		t.Errorf("el mecánico fijo no debería borrarse")
+	}
+
+	cp := c.costes(t0.Add(40 * time.Second))
+	if cp.Contrataciones != 2 || cp.Despidos != 1 {
+		t.Errorf("se esperaban 2 contrataciones y 1 despido, hubo %d y %d", cp.Contrataciones, cp.Despidos)
+	}
+	if cp.CosteContratacion != 2*pol.CosteContratacion {
+		t.Errorf("coste de contratación incorrecto: %.2f", cp.CosteContratacion)
+	}
+	if cp.SalariosFijos <= 0 || cp.SalariosTemporal <= 0 {
+		t.Errorf("deberían contarse salarios de fijos y temporales: %+v", cp)
+	}
+}
+
+func TestPlazasDelTemporalSeQuitanAlDespedirlo(t *testing.T) {
+	taller := &Taller{}
+	fijo := taller.newMecanico("Luis", "mecanica", 5)
+	temporal := taller.newMecanico("Temporal", "mecanica", 5)
+	plazasDe := func(m *Mecanico) []*Plaza {
+		var ps []*Plaza
+		for _, p := range taller.datos().Plazas() {
+			if p.MecanicoID == m.ID {
+				ps = append(ps, p)
+			}
+		}
+		return ps
+	}
+	suyas := plazasDe(temporal)
+	if len(suyas) != 2 {
+		t.Fatalf("el temporal debería tener 2 plazas, tiene %d", len(suyas))
+	}
+	ocupada := suyas[0]
+	ocupada.Ocupada = true
+	ocupada.VehiculoMat = "1234ABC"
+	if err := taller.datos().GuardarPlaza(ocupada); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := taller.retirarPlazas(temporal); err != nil {
+		t.Fatal(err)
+	}
+	// La libre se quita ya; la ocupada se queda hasta que sale el vehículo
+	if ps := plazasDe(temporal); len(ps) != 1 || ps[0].ID != ocupada.ID || !ps[0].Retirar {
+		t.Fatalf("solo debería quedar la plaza ocupada, marcada para quitar: %+v", ps)
+	}
+	if err := taller.conPlazas(func() error { return taller.vaciarPlaza(ocupada) }); err != nil {
+		t.Fatal(err)
+	}
+	if ps := plazasDe(temporal); len(ps) != 0 {
+		t.Fatalf("al salir el vehículo se debería quitar la plaza: %+v", ps)
+	}
+	if len(plazasDe(fijo)) != 2 {
+		t.Errorf("las plazas del fijo no se tocan")
+	}
+
+	// Un mecánico nuevo no repite el ID de una plaza que sigue existiendo
+	nuevo := taller.newMecanico("Otro", "mecanica", 5)
+	vistos := map[int]bool{}
+	for _, p := range taller.datos().Plazas() {
+		if vistos[p.ID] {
+			t.Fatalf("plaza %d repetida", p.ID)
+		}
+		vistos[p.ID] = true
+	}
+	if len(plazasDe(nuevo)) != 2 {
+		t.Errorf("el mecánico nuevo debería tener 2 plazas")
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ RELOJES LÓGICOS DE LA SIMULACIÓN ------------
+
+// Lo que pasa en la simulación ocurre en muchas goroutines (y procesos, con
+// los mecánicos remotos), así que la hora de cada mensaje no dice qué fue
+// antes. Cada origen de eventos (el generador de vehículos, cada mecánico,
+// el vigilante de latidos) lleva un reloj de Lamport y uno vectorial. Los
+// trabajos llevan la marca del último evento que los tocó: quien toma uno
+// de la cola la recibe y quien lo vuelve a encolar pone la suya. Así:
+//
+//   - Ordenar por Lamport (y por origen si empatan) da un orden total que
+//     respeta la causalidad, también al fusionar registros de varios
+//     procesos.
+//   - Con los vectores se sabe si dos eventos son concurrentes (ninguno pudo
+//     influir en el otro). Dos mecánicos que empiezan la misma incidencia de
+//     forma concurrente es que la han recibido los dos.
+//
+// Los eventos de los mecánicos remotos los apunta el coordinador en su
+// nombre, que es quien recibe sus mensajes.
+
+// Tipos de evento
+const (
+	eventoLlegada      = "llegada"      // el generador encola la incidencia
+	eventoInicio       = "inicio"       // un mecánico empieza a repararla
+	eventoInterrupcion = "interrupcion" // la deja a medias y vuelve a la cola
+	eventoDevuelto     = "devuelto"     // la devuelve a la cola sin tocarla
+	eventoFin          = "fin"          // la termina
+	eventoReclamado    = "reclamado"    // el vigilante la quita a un mecánico muerto
+)
+
+// Los orígenes llevan delante el nodo (la sede o el nodo de admisión) para
+// que no se confundan al fusionar los registros de varios procesos; en un
+// taller aislado van sin él
+func (t *Taller) origen(nombre string) string {
+	switch {
+	case t.federacion != nil:
+		return t.federacion.Nombre + "/" + nombre
+	case t.exclusion != nil:
+		return fmt.Sprintf("nodo %d/%s", t.exclusion.ID, nombre)
+	}
+	return nombre
+}
+
+func (t *Taller) origenGenerador() string { return t.origen("generador") }
+func (t *Taller) origenVigilante() string { return t.origen("vigilante") }
+func (t *Taller) origenCitas() string     { return t.origen("citas") }
+
+func (t *Taller) origenMecanico(m *Mecanico) string {
+	return t.origen(fmt.Sprintf("mecánico %d", m.ID))
+}
+
+// Marca lógica de un evento, que viaja con los trabajos
+type MarcaLogica struct {
+	Lamport int
+	Vector  map[string]int `json:",omitempty"`
+}
+
+// a ocurrió antes que b (a pudo influir en b)
+func (a MarcaLogica) antesDe(b MarcaLogica) bool {
+	estricto := false
+	for origen, n := range a.Vector {
+		if n > b.Vector[origen] {
+			return false
+		}
+		if n < b.Vector[origen] {
+			estricto = true
+		}
+	}
+	for origen, n := range b.Vector {
+		if _, ok := a.Vector[origen]; !ok && n > 0 {
+			estricto = true
+		}
+	}
+	return estricto
+}
+
+// Ninguno de los dos pudo influir en el otro
+func concurrentes(a, b MarcaLogica) bool {
+	return !a.antesDe(b) && !b.antesDe(a) && !igualesVectores(a.Vector, b.Vector)
+}
+
+func igualesVectores(a, b map[string]int) bool {
+	for origen, n := range a {
+		if b[origen] != n {
+			return false
+		}
+	}
+	for origen, n := range b {
+		if a[origen] != n {
+			return false
+		}
+	}
+	return true
+}
+
+type EventoSim struct {
+	MarcaLogica
+	Origen       string
+	Tipo         string
+	IncidenciaID int
+	Matricula    string
+	Texto        string    `json:",omitempty"`
+	Fecha        time.Time // hora real, sólo informativa
+}
+
+// Registro de los eventos de la simulación con el reloj de cada origen
+type RegistroEventos struct {
+	mu      sync.Mutex
+	relojes map[string]*MarcaLogica
+	eventos []EventoSim
+}
+
+func nuevoRegistroEventos() *RegistroEventos {
+	return &RegistroEventos{relojes: map[string]*MarcaLogica{}}
+}
+
+func (r *RegistroEventos) reloj(origen string) *MarcaLogica {
+	rl := r.relojes[origen]
+	if rl == nil {
+		rl = &MarcaLogica{Vector: map[string]int{}}
+		r.relojes[origen] = rl
+	}
+	return rl
+}
+
+// El origen recibe un mensaje con la marca m: su reloj pasa a ir por detrás
+// de ella
+func (r *RegistroEventos) recibir(origen string, m MarcaLogica) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rl := r.reloj(origen)
+	rl.Lamport = max(rl.Lamport, m.Lamport)
+	for o, n := range m.Vector {
+		rl.Vector[o] = max(rl.Vector[o], n)
+	}
+}
+
+// Apunta un evento del origen sobre el trabajo y devuelve su marca, que es
+// la que debe llevar el trabajo si sale de sus manos
+func (r *RegistroEventos) anotar(origen, tipo string, tr Trabajo, texto string) MarcaLogica {
+	if r == nil {
+		return MarcaLogica{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rl := r.reloj(origen)
+	rl.Lamport++
+	rl.Vector[origen]++
+	m := MarcaLogica{Lamport: rl.Lamport, Vector: make(map[string]int, len(rl.Vector))}
+	for o, n := range rl.Vector {
+		m.Vector[o] = n
+	}
+	e := EventoSim{MarcaLogica: m, Origen: origen, Tipo: tipo, Texto: texto, Fecha: time.Now()}
+	if tr.Incidencia != nil {
+		e.IncidenciaID = tr.Incidencia.ID
+	}
+	if tr.Vehiculo != nil {
+		e.Matricula = tr.Vehiculo.Matricula
+	}
+	r.eventos = append(r.eventos, e)
+	return m
+}
+
+// Eventos en el orden en que se apuntaron
+func (r *RegistroEventos) Eventos() []EventoSim {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]EventoSim(nil), r.eventos...)
+}
+
+// ------------ ORDEN Y CONFLICTOS ------------
+
+// Junta los eventos de varios registros (de varios procesos) en un orden
+// que respeta la causalidad: por Lamport y, si empatan, por origen
+func fusionarEventos(listas ...[]EventoSim) []EventoSim {
+	var todos []EventoSim
+	for _, l := range listas {
+		todos = append(todos, l...)
+	}
+	sort.SliceStable(todos, func(i, j int) bool {
+		if todos[i].Lamport != todos[j].Lamport {
+			return todos[i].Lamport < todos[j].Lamport
+		}
+		return todos[i].Origen < todos[j].Origen
+	})
+	return todos
+}
+
+// Dos eventos de mecánicos distintos sobre la misma incidencia sin relación
+// causal entre ellos
+type ConflictoEventos struct {
+	A, B EventoSim
+}
+
+// Eventos de un mecánico que trabaja en la incidencia
+func eventoDeTrabajo(tipo string) bool {
+	return tipo == eventoInicio || tipo == eventoInterrupcion || tipo == eventoFin
+}
+
+// Busca eventos concurrentes de distintos mecánicos sobre la misma
+// incidencia (p.ej. dos que la empiezan a la vez)
+func conflictosEventos(eventos []EventoSim) []ConflictoEventos {
+	porIncidencia := map[string][]EventoSim{}
+	var claves []string
+	for _, e := range eventos {
+		if !eventoDeTrabajo(e.Tipo) {
+			continue
+		}
+		k := fmt.Sprintf("%s/%d", e.Matricula, e.IncidenciaID)
+		if porIncidencia[k] == nil {
+			claves = append(claves, k)
+		}
+		porIncidencia[k] = append(porIncidencia[k], e)
+	}
+	var conflictos []ConflictoEventos
+	for _, k := range claves {
+		evs := porIncidencia[k]
+		for i := range evs {
+			for j := i + 1; j < len(evs); j++ {
+				if evs[i].Origen != evs[j].Origen && concurrentes(evs[i].MarcaLogica, evs[j].MarcaLogica) {
+					conflictos = append(conflictos, ConflictoEventos{A: evs[i], B: evs[j]})
+				}
+			}
+		}
+	}
+	return conflictos
+}
+
+func (e EventoSim) String() string {
+	var vector []string
+	for o, n := range e.Vector {
+		vector = append(vector, fmt.Sprintf("%s:%d", o, n))
+	}
+	sort.Strings(vector)
+	s := fmt.Sprintf("L%-4d %-13s %-12s %s/%d [%s]", e.Lamport, e.Origen, e.Tipo, e.Matricula, e.IncidenciaID, strings.Join(vector, " "))
+	if e.Texto != "" {
+		s += " " + e.Texto
+	}
+	return s
+}
+
+// Resumen para el final de la simulación
+func (r *RegistroEventos) informe() string {
+	if r == nil {
+		return ""
+	}
+	eventos := r.Eventos()
+	conflictos := conflictosEventos(eventos)
+	s := fmt.Sprintf("Eventos de la simulación: %d, concurrentes sobre la misma incidencia: %d\n", len(eventos), len(conflictos))
+	for _, c := range conflictos {
+		s += fmt.Sprintf("  %s\n  %s\n", c.A, c.B)
+	}
+	return s
+}
+
+// ------------ FICHEROS DE EVENTOS ------------
+
+// Guarda los eventos en dir/eventos.jsonl, uno por línea
+func guardarEventos(eventos []EventoSim, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	ruta := filepath.Join(dir, "eventos.jsonl")
+	f, err := os.Create(ruta)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range eventos {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return "", err
+	}
+	return ruta, f.Close()
+}
+
+func leerEventos(ruta string) ([]EventoSim, error) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var eventos []EventoSim
+	dec := json.NewDecoder(f)
+	for {
+		var e EventoSim
+		if err := dec.Decode(&e); err == io.EOF {
+			return eventos, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", ruta, err)
+		}
+		eventos = append(eventos, e)
+	}
+}
+
+// Fusiona los registros de eventos de las rutas y escribe el orden causal y
+// los eventos concurrentes sobre una misma incidencia
+func analizarEventos(rutas []string, w io.Writer) error {
+	var listas [][]EventoSim
+	for _, ruta := range rutas {
+		evs, err := leerEventos(strings.TrimSpace(ruta))
+		if err != nil {
+			return err
+		}
+		listas = append(listas, evs)
+	}
+	eventos := fusionarEventos(listas...)
+	for _, e := range eventos {
+		fmt.Fprintln(w, e)
+	}
+	conflictos := conflictosEventos(eventos)
+	fmt.Fprintf(w, "\n%d eventos, %d pares concurrentes sobre la misma incidencia\n", len(eventos), len(conflictos))
+	for _, c := range conflictos {
+		fmt.Fprintf(w, "  %s\n  %s\n", c.A, c.B)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// relojes_test.go
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Un mecánico deja la incidencia y otro la termina: todo en cadena causal
+func TestMarcasLogicasOrdenCausal(t *testing.T) {
+	taller := &Taller{}
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	r := nuevoRegistroEventos()
+
+	llegada := r.anotar(taller.origenGenerador(), eventoLlegada, tr, "")
+	r.recibir("mecánico 0", llegada)
+	inicioA := r.anotar("mecánico 0", eventoInicio, tr, "")
+	interrupcion := r.anotar("mecánico 0", eventoInterrupcion, tr, "")
+	r.recibir("mecánico 1", interrupcion)
+	inicioB := r.anotar("mecánico 1", eventoInicio, tr, "")
+	fin := r.anotar("mecánico 1", eventoFin, tr, "")
+
+	cadena := []MarcaLogica{llegada, inicioA, interrupcion, inicioB, fin}
+	for i := 1; i < len(cadena); i++ {
+		if !cadena[i-1].antesDe(cadena[i]) || cadena[i-1].Lamport >= cadena[i].Lamport {
+			t.Errorf("el evento %d debería ir antes que el %d: %+v, %+v", i-1, i, cadena[i-1], cadena[i])
+		}
+		if cadena[i].antesDe(cadena[i-1]) || concurrentes(cadena[i-1], cadena[i]) {
+			t.Errorf("los eventos %d y %d no son concurrentes", i-1, i)
+		}
+	}
+	if c := conflictosEventos(r.Eventos()); len(c) != 0 {
+		t.Errorf("no hay conflictos en una cadena causal: %v", c)
+	}
+	fusion := fusionarEventos(r.Eventos())
+	for i, e := range fusion {
+		if e.Lamport != cadena[i].Lamport {
+			t.Errorf("orden fusionado incorrecto en %d: %v", i, e)
+		}
+	}
+}
+
+// Los dos mecánicos reciben la misma incidencia (p.ej. encolada dos veces) y
+// la empiezan sin saber uno del otro
+func TestDosMecanicosEmpiezanLaMismaIncidencia(t *testing.T) {
+	taller := &Taller{}
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	otro := trabajoDe(taller, "V-02", Mecanica, false)
+	r := nuevoRegistroEventos()
+
+	llegada := r.anotar(taller.origenGenerador(), eventoLlegada, tr, "")
+	r.recibir("mecánico 0", llegada)
+	r.recibir("mecánico 1", llegada)
+	a := r.anotar("mecánico 0", eventoInicio, tr, "")
+	b := r.anotar("mecánico 1", eventoInicio, tr, "")
+	// Lo que hace un tercero en otra incidencia también es concurrente, pero
+	// no es un conflicto
+	r.recibir("mecánico 2", r.anotar(taller.origenGenerador(), eventoLlegada, otro, ""))
+	r.anotar("mecánico 2", eventoInicio, otro, "")
+
+	if !concurrentes(a, b) || a.antesDe(b) || b.antesDe(a) {
+		t.Fatalf("los dos inicios deberían ser concurrentes: %+v, %+v", a, b)
+	}
+	conflictos := conflictosEventos(r.Eventos())
+	if len(conflictos) != 1 {
+		t.Fatalf("se esperaba un conflicto: %v", conflictos)
+	}
+	c := conflictos[0]
+	if c.A.IncidenciaID != tr.Incidencia.ID || c.A.Origen == c.B.Origen || c.A.Tipo != eventoInicio {
+		t.Errorf("conflicto incorrecto: %v", c)
+	}
+	if !strings.Contains(r.informe(), "concurrentes sobre la misma incidencia: 1") {
+		t.Errorf("el informe debería contar el conflicto:\n%s", r.informe())
+	}
+}
+
+// Cada proceso guarda sus eventos; al fusionarlos sale el orden causal
+func TestFusionarEventosDeVariosProcesos(t *testing.T) {
+	taller := &Taller{}
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	taller1, taller2 := nuevoRegistroEventos(), nuevoRegistroEventos()
+	norte := &Taller{federacion: &Federacion{Nombre: "Norte"}}
+	sur := &Taller{federacion: &Federacion{Nombre: "Sur"}}
+	mecanico := sur.origenMecanico(&Mecanico{ID: 7})
+
+	// El proceso 1 encola; el 2 lo recibe por la red y lo repara. Cada uno
+	// tiene su generador, que no se confunde con el del otro.
+	marca := taller1.anotar(norte.origenGenerador(), eventoLlegada, tr, "")
+	taller1.anotar(norte.origenGenerador(), eventoLlegada, trabajoDe(taller, "V-02", Mecanica, false), "")
+	taller2.anotar(sur.origenGenerador(), eventoLlegada, trabajoDe(taller, "V-03", Mecanica, false), "")
+	taller2.recibir(mecanico, marca)
+	taller2.anotar(mecanico, eventoInicio, tr, "")
+	if m := taller2.anotar(mecanico, eventoFin, tr, ""); m.Vector["Norte/generador"] != 1 || m.Vector["Sur/generador"] != 0 {
+		t.Errorf("sólo cuenta el generador del que recibió el trabajo: %v", m.Vector)
+	}
+
+	dir1, dir2 := filepath.Join(t.TempDir(), "p1"), filepath.Join(t.TempDir(), "p2")
+	ruta1, err := guardarEventos(taller1.Eventos(), dir1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruta2, err := guardarEventos(taller2.Eventos(), dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := analizarEventos([]string{ruta2, ruta1}, &out); err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	llegada := strings.Index(s, "llegada      V-01")
+	inicio, fin := strings.Index(s, "inicio"), strings.Index(s, "fin")
+	if llegada < 0 || !(llegada < inicio && inicio < fin) {
+		t.Errorf("orden fusionado incorrecto:\n%s", s)
+	}
+	if !strings.Contains(s, "5 eventos, 0 pares concurrentes") {
+		t.Errorf("resumen incorrecto:\n%s", s)
+	}
+}
+
+// En la simulación cada incidencia llega, se empieza y se termina en ese
+// orden causal, aunque la atiendan mecánicos distintos
+func TestSimulacionApuntaEventosCausales(t *testing.T) {
+	taller := &Taller{}
+	m1 := taller.newMecanico("Mec1", "mecanica", 1)
+	m2 := taller.newMecanico("Mec2", "mecanica", 1)
+	taller.eventos = nuevoRegistroEventos()
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+
+	var trabajos []Trabajo
+	for i := 0; i < 10; i++ {
+		tr := trabajoDe(taller, fmt.Sprintf("V-%02d", i), Mecanica, false)
+		tr.marca = taller.eventos.anotar(taller.origenGenerador(), eventoLlegada, tr, "")
+		trabajos = append(trabajos, tr)
+		taller.colas.encolar(tr)
+	}
+	go trabajoMecanico(m1, taller)
+	go trabajoMecanico(m2, taller)
+	esperarQue(t, "se cierran todas las incidencias", func() bool {
+		return len(taller.eventos.Eventos()) == 3*len(trabajos)
+	})
+
+	porIncidencia := map[int]map[string]MarcaLogica{}
+	for _, e := range taller.eventos.Eventos() {
+		if porIncidencia[e.IncidenciaID] == nil {
+			porIncidencia[e.IncidenciaID] = map[string]MarcaLogica{}
+		}
+		porIncidencia[e.IncidenciaID][e.Tipo] = e.MarcaLogica
+	}
+	for id, evs := range porIncidencia {
+		if !evs[eventoLlegada].antesDe(evs[eventoInicio]) || !evs[eventoInicio].antesDe(evs[eventoFin]) {
+			t.Errorf("incidencia %d fuera de orden causal: %+v", id, evs)
+		}
+	}
+	if c := conflictosEventos(taller.eventos.Eventos()); len(c) != 0 {
+		t.Errorf("ninguna incidencia la empiezan dos mecánicos: %v", c)
+	}
+}
@@ -0,0 +1,934 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/rpc"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ REPARTO DE VEHÍCULOS ENTRE NODOS ------------
+
+// Con flotas muy grandes los vehículos se reparten por matrícula entre varios
+// nodos, cada uno un Taller con sus propios datos. El reparto es un anillo de
+// hash consistente: cada nodo pone virtualesPorNodo puntos en el anillo y una
+// matrícula es del primer punto que encuentra a partir de su hash. Con los
+// puntos virtuales cada nodo se lleva más o menos la misma parte.
+//
+// El enrutador manda cada operación sobre una matrícula a su dueño. Cuando un
+// nodo entra o sale sólo cambian de dueño las matrículas de los tramos del
+// anillo que ocupa, y sólo esos vehículos se mueven, con su cliente y sus
+// incidencias:
+//
+//   - El nodo nuevo se queda con parte de lo de los demás.
+//   - Lo del nodo que sale pasa a los siguientes del anillo.
+//
+// Antes de cambiar el anillo las matrículas que van a cambiar de dueño se
+// apuntan como fuera de sitio, y hasta que se mueven el enrutador las sigue
+// buscando en el nodo viejo. Al moverse un vehículo se copia en el dueño
+// nuevo y sólo después se borra del viejo, así que si algo falla a medias
+// queda repetido, no perdido; el siguiente reequilibrado lo arregla. Las
+// incidencias reciben un ID nuevo en el nodo de destino, como en los
+// traslados entre sedes. Mientras se mueve un vehículo no se atiende ninguna
+// otra operación sobre su matrícula.
+//
+// Los vehículos que están en una plaza no se mueven (la plaza es del nodo
+// viejo): siguen fuera de sitio y se mueven en el siguiente reequilibrado
+// tras su salida (opción Reequilibrar del menú de la flota). Lo que está
+// fuera de sitio se guarda en el almacén del enrutador para no perderlo al
+// reiniciar.
+
+const (
+	virtualesPorNodo = 64
+	esperaReparto    = 5 * time.Second // lo más que se espera a un nodo
+)
+
+var (
+	errSinNodos        = errors.New("no hay nodos en el reparto")
+	errNodoRepetido    = errors.New("el nodo ya está en el reparto")
+	errNodoNoRepartido = errors.New("el nodo no está en el reparto")
+	errVehiculoEnPlaza = errors.New("el vehículo está en una plaza")
+	errOtroVehiculo    = errors.New("el nodo ya tiene otro vehículo con esa matrícula")
+)
+
+// ------------ ANILLO DE HASH CONSISTENTE ------------
+
+type puntoAnillo struct {
+	hash uint32
+	nodo string
+}
+
+type AnilloHash struct {
+	virtuales int
+	puntos    []puntoAnillo // ordenados por hash
+	nodos     map[string]bool
+}
+
+func nuevoAnilloHash(virtuales int) *AnilloHash {
+	return &AnilloHash{virtuales: max(virtuales, 1), nodos: map[string]bool{}}
+}
+
+// Los primeros bytes del MD5, como en ketama: con hashes más sencillos (FNV)
+// las matrículas y los puntos, muy parecidos entre sí, se amontonan
+func hashReparto(s string) uint32 {
+	suma := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(suma[:4])
+}
+
+func (a *AnilloHash) añadir(nodo string) {
+	if a.nodos[nodo] {
+		return
+	}
+	a.nodos[nodo] = true
+	for i := 0; i < a.virtuales; i++ {
+		a.puntos = append(a.puntos, puntoAnillo{hashReparto(fmt.Sprintf("%s#%d", nodo, i)), nodo})
+	}
+	sort.Slice(a.puntos, func(i, j int) bool {
+		if a.puntos[i].hash != a.puntos[j].hash {
+			return a.puntos[i].hash < a.puntos[j].hash
+		}
+		return a.puntos[i].nodo < a.puntos[j].nodo
+	})
+}
+
+func (a *AnilloHash) quitar(nodo string) {
+	if !a.nodos[nodo] {
+		return
+	}
+	delete(a.nodos, nodo)
+	quedan := a.puntos[:0]
+	for _, p := range a.puntos {
+		if p.nodo != nodo {
+			quedan = append(quedan, p)
+		}
+	}
+	a.puntos = quedan
+}
+
+// Nodo dueño de la matrícula ("" si el anillo está vacío)
+func (a *AnilloHash) dueño(mat string) string {
+	if len(a.puntos) == 0 {
+		return ""
+	}
+	h := hashReparto(mat)
+	i := sort.Search(len(a.puntos), func(i int) bool { return a.puntos[i].hash >= h })
+	if i == len(a.puntos) {
+		i = 0 // se da la vuelta al anillo
+	}
+	return a.puntos[i].nodo
+}
+
+func (a *AnilloHash) Nodos() []string {
+	var nodos []string
+	for n := range a.nodos {
+		nodos = append(nodos, n)
+	}
+	sort.Strings(nodos)
+	return nodos
+}
+
+// ------------ LO QUE GUARDA CADA NODO ------------
+
+// Vehículo que pasa de un nodo a otro, con su cliente y sus incidencias
+type VehiculoRepartido struct {
+	Vehiculo    Vehiculo // sin incidencias
+	Cliente     ClienteTrasladado
+	Incidencias []Incidencia // sin mecánicos, que son de cada nodo
+}
+
+type DatosVehiculo struct {
+	Matricula       string
+	Marca, Modelo   string
+	Entrada, Salida time.Time
+}
+
+type DatosIncidencia struct {
+	Matricula   string
+	ID          int
+	Tipo        string
+	Prioridad   string
+	Descripcion string
+	Estado      int
+}
+
+// Parte de la flota que guarda un nodo, servida por RPC al enrutador. Cada
+// llamada coge el taller, como el menú y la simulación.
+type Fragmento struct {
+	t   *Taller
+	mu  sync.Mutex // protege srv
+	srv *servidorRPC
+}
+
+func nuevoFragmento(t *Taller) *Fragmento {
+	return &Fragmento{t: t}
+}
+
+// Empieza a atender al enrutador en dir y devuelve la dirección en la que
+// escucha, que es el nombre del nodo en el anillo
+func (f *Fragmento) escuchar(dir string) (string, error) {
+	srv, err := escucharRPC("Fragmento", f, dir)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	f.srv = srv
+	f.mu.Unlock()
+	return srv.ln.Addr().String(), nil
+}
+
+func (f *Fragmento) cerrar() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.srv.cerrar()
+}
+
+// Falla si el vehículo ya está, para no pisarlo
+func (f *Fragmento) NuevoVehiculo(d DatosVehiculo, v *Vehiculo) error {
+	var err error
+	f.t.conTaller(func() {
+		if f.t.getVehiculo(d.Matricula) != nil {
+			err = fmt.Errorf("el vehículo %s ya existe", d.Matricula)
+			return
+		}
+		var nuevo *Vehiculo
+		if nuevo, err = f.t.newVehiculo(d.Matricula, d.Marca, d.Modelo, d.Entrada, d.Salida, nil); err == nil {
+			*v = *nuevo
+		}
+	})
+	return err
+}
+
+// El vehículo con sus incidencias (sin matrícula si no está en este nodo)
+func (f *Fragmento) Vehiculo(mat string, v *Vehiculo) error {
+	f.t.conTaller(func() {
+		if encontrado := f.t.getVehiculo(mat); encontrado != nil {
+			*v = *encontrado
+		}
+	})
+	return nil
+}
+
+func (f *Fragmento) ActualizarVehiculo(d DatosVehiculo, ok *bool) error {
+	var err error
+	f.t.conTaller(func() {
+		*ok = true
+		err = f.t.updateVehiculo(d.Matricula, d.Marca, d.Modelo, d.Entrada, d.Salida)
+	})
+	return err
+}
+
+// Borra el vehículo con sus incidencias
+func (f *Fragmento) BorrarVehiculo(mat string, ok *bool) error {
+	var err error
+	f.t.conTaller(func() {
+		*ok = true
+		err = f.t.sacarVehiculo(mat)
+	})
+	return err
+}
+
+func (f *Fragmento) NuevaIncidencia(d DatosIncidencia, inc *Incidencia) error {
+	var err error
+	f.t.conTaller(func() {
+		var nueva *Incidencia
+		if nueva, err = f.t.newIncidencia(d.Matricula, nil, d.Tipo, d.Prioridad, d.Descripcion); err == nil {
+			*inc = *nueva
+		}
+	})
+	return err
+}
+
+func (f *Fragmento) ActualizarIncidencia(d DatosIncidencia, ok *bool) error {
+	var err error
+	f.t.conTaller(func() {
+		if err = f.t.incidenciaDeVehiculo(d.Matricula, d.ID); err != nil {
+			return
+		}
+		*ok = true
+		err = f.t.updateIncidencia(d.ID, d.Tipo, d.Prioridad, d.Descripcion, d.Estado)
+	})
+	return err
+}
+
+func (f *Fragmento) BorrarIncidencia(d DatosIncidencia, ok *bool) error {
+	var err error
+	f.t.conTaller(func() {
+		if err = f.t.incidenciaDeVehiculo(d.Matricula, d.ID); err != nil {
+			return
+		}
+		*ok = true
+		err = f.t.deleteIncidencia(d.ID)
+	})
+	return err
+}
+
+func (f *Fragmento) Matriculas(_ struct{}, mats *[]string) error {
+	f.t.conTaller(func() {
+		for _, v := range f.t.datos().Vehiculos() {
+			*mats = append(*mats, v.Matricula)
+		}
+	})
+	return nil
+}
+
+// Lo que hay que mandar al dueño nuevo del vehículo. Falla si está en una
+// plaza.
+func (f *Fragmento) Exportar(mat string, vr *VehiculoRepartido) error {
+	var err error
+	f.t.conTaller(func() { *vr, err = f.t.exportarVehiculo(mat) })
+	return err
+}
+
+func (f *Fragmento) Importar(vr VehiculoRepartido, ok *bool) error {
+	var err error
+	f.t.conTaller(func() {
+		*ok = true
+		err = f.t.importarVehiculo(vr)
+	})
+	return err
+}
+
+// La incidencia id es del vehículo mat
+func (t *Taller) incidenciaDeVehiculo(mat string, id int) error {
+	if v := t.vehiculoDeIncidencia(id); v == nil || v.Matricula != mat {
+		return fmt.Errorf("el vehículo %s no tiene la incidencia %d", mat, id)
+	}
+	return nil
+}
+
+func (t *Taller) exportarVehiculo(mat string) (VehiculoRepartido, error) {
+	v := t.getVehiculo(mat)
+	if v == nil {
+		return VehiculoRepartido{}, fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+	}
+	if t.visitaAbierta(v.Matricula) != nil {
+		return VehiculoRepartido{}, fmt.Errorf("%w: %s", errVehiculoEnPlaza, v.Matricula)
+	}
+	vr := VehiculoRepartido{Vehiculo: *v}
+	vr.Vehiculo.Incidencias = nil
+	if c := t.clienteDeVehiculo(v.Matricula); c != nil {
+		vr.Cliente = ClienteTrasladado{Nombre: c.Nombre, Telefono: c.Telefono, Email: c.Email}
+	}
+	for _, inc := range v.Incidencias {
+		copia := *inc
+		copia.Mecanicos = nil
+		vr.Incidencias = append(vr.Incidencias, copia)
+	}
+	return vr, nil
+}
+
+// Da de alta el vehículo que llega de otro nodo. Si ya estaba la misma copia
+// (se movió a medias) no se hace nada; si hay otro vehículo con esa matrícula
+// falla, para que no se borre el que llega.
+func (t *Taller) importarVehiculo(vr VehiculoRepartido) error {
+	if v := t.getVehiculo(vr.Vehiculo.Matricula); v != nil {
+		if actual, err := t.exportarVehiculo(v.Matricula); err != nil || !mismoVehiculo(actual, vr) {
+			return fmt.Errorf("%w: %s", errOtroVehiculo, v.Matricula)
+		}
+		return nil
+	}
+	return t.datos().Transaccion(func() error {
+		v := vr.Vehiculo
+		v.Incidencias = nil
+		for _, it := range vr.Incidencias {
+			inc := it
+			inc.ID = t.nextIncidenciaID
+			t.nextIncidenciaID++
+			if err := t.datos().GuardarIncidencia(&inc); err != nil {
+				return err
+			}
+			v.Incidencias = append(v.Incidencias, &inc)
+		}
+		if err := t.datos().GuardarVehiculo(&v); err != nil {
+			return err
+		}
+		if vr.Cliente.Nombre == "" {
+			return nil
+		}
+		var cli *Cliente
+		for _, c := range t.datos().Clientes() {
+			if c.Nombre == vr.Cliente.Nombre && c.Email == vr.Cliente.Email {
+				cli = c
+				break
+			}
+		}
+		if cli == nil {
+			var err error
+			if cli, err = t.newCliente(vr.Cliente.Nombre, vr.Cliente.Telefono, vr.Cliente.Email, nil); err != nil {
+				return err
+			}
+		}
+		cli.Vehiculos = append(cli.Vehiculos, &v)
+		return t.datos().GuardarCliente(cli)
+	})
+}
+
+// Las dos copias son del mismo vehículo (las incidencias sin mirar su ID, que
+// es de cada nodo)
+func mismoVehiculo(a, b VehiculoRepartido) bool {
+	va, vb := a.Vehiculo, b.Vehiculo
+	if va.Matricula != vb.Matricula || va.Marca != vb.Marca || va.Modelo != vb.Modelo ||
+		!va.FechaEntrada.Equal(vb.FechaEntrada) || !va.FechaSalida.Equal(vb.FechaSalida) ||
+		a.Cliente != b.Cliente || len(a.Incidencias) != len(b.Incidencias) {
+		return false
+	}
+	for i, inc := range a.Incidencias {
+		otra := b.Incidencias[i]
+		if inc.Tipo != otra.Tipo || inc.Prioridad != otra.Prioridad || inc.Descripcion != otra.Descripcion || inc.Estado != otra.Estado {
+			return false
+		}
+	}
+	return true
+}
+
+// Borra el vehículo, sus incidencias y su rastro en el cliente. Falla si está
+// en una plaza.
+func (t *Taller) sacarVehiculo(mat string) error {
+	v := t.getVehiculo(mat)
+	if v == nil {
+		return fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+	}
+	if t.visitaAbierta(v.Matricula) != nil {
+		return fmt.Errorf("%w: %s", errVehiculoEnPlaza, v.Matricula)
+	}
+	return t.datos().Transaccion(func() error {
+		for _, inc := range v.Incidencias {
+			if err := t.datos().BorrarIncidencia(inc.ID); err != nil {
+				return err
+			}
+		}
+		if c := t.clienteDeVehiculo(v.Matricula); c != nil {
+			var quedan []*Vehiculo
+			for _, otro := range c.Vehiculos {
+				if otro.Matricula != v.Matricula {
+					quedan = append(quedan, otro)
+				}
+			}
+			c.Vehiculos = quedan
+			if err := t.datos().GuardarCliente(c); err != nil {
+				return err
+			}
+		}
+		return t.datos().BorrarVehiculo(v.Matricula)
+	})
+}
+
+// ------------ ENRUTADOR ------------
+
+// Manda las operaciones de cada matrícula al nodo que la guarda y mueve los
+// vehículos cuando entran o salen nodos
+type Enrutador struct {
+	mu       sync.Mutex
+	anillo   *AnilloHash
+	clientes map[string]*rpc.Client // por dirección del nodo
+	fuera    map[string]string      // matrícula -> nodo en el que sigue hasta moverla
+	Espera   time.Duration
+
+	// Las operaciones sobre una matrícula cogen su cerrojo (y mover también,
+	// para que no se pierda lo que llega mientras se copia) y cambio para
+	// leer; unir y quitar lo cogen para escribir mientras miran qué cambia de
+	// dueño y cambian el anillo
+	cerrojos [64]sync.Mutex
+	cambio   sync.RWMutex
+
+	guardando sync.Mutex                          // los cambios de fuera se guardan en orden
+	guardar   func(fuera map[string]string) error // nil = no se guarda
+}
+
+func nuevoEnrutador(virtuales int) *Enrutador {
+	return &Enrutador{
+		anillo:   nuevoAnilloHash(virtuales),
+		clientes: map[string]*rpc.Client{},
+		fuera:    map[string]string{},
+		Espera:   esperaReparto,
+	}
+}
+
+// Lo que quedó fuera de sitio la última vez (ver guardar)
+func (e *Enrutador) restaurar(fuera map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for mat, nodo := range fuera {
+		e.fuera[mat] = nodo
+	}
+}
+
+// Guarda lo que está fuera de sitio. Se llama sin el taller, que lo coge
+// guardar.
+func (e *Enrutador) guardarFuera() error {
+	if e.guardar == nil {
+		return nil
+	}
+	e.guardando.Lock()
+	defer e.guardando.Unlock()
+	e.mu.Lock()
+	fuera := maps.Clone(e.fuera)
+	e.mu.Unlock()
+	return e.guardar(fuera)
+}
+
+func (e *Enrutador) cerrojo(mat string) *sync.Mutex {
+	return &e.cerrojos[hashReparto(mat)%uint32(len(e.cerrojos))]
+}
+
+// Nodo que guarda ahora la matrícula (ya normalizada)
+func (e *Enrutador) nodoDe(mat string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if nodo, ok := e.fuera[mat]; ok {
+		return nodo, nil
+	}
+	if nodo := e.anillo.dueño(mat); nodo != "" {
+		return nodo, nil
+	}
+	return "", errSinNodos
+}
+
+func (e *Enrutador) Nodos() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.anillo.Nodos()
+}
+
+// Llama al nodo; si no responde o se cae la conexión la cierra para volver a
+// conectar en la siguiente llamada
+func (e *Enrutador) llamar(nodo, metodo string, args, respuesta any) error {
+	e.mu.Lock()
+	cliente := e.clientes[nodo]
+	e.mu.Unlock()
+	if cliente == nil {
+		conn, err := net.DialTimeout("tcp", nodo, e.Espera)
+		if err != nil {
+			return fmt.Errorf("no se pudo conectar con el nodo %s: %w", nodo, err)
+		}
+		cliente = rpc.NewClient(conn)
+		e.mu.Lock()
+		e.clientes[nodo] = cliente
+		e.mu.Unlock()
+	}
+
+	var err error
+	llamada := cliente.Go(metodo, args, respuesta, make(chan *rpc.Call, 1))
+	select {
+	case <-llamada.Done:
+		err = llamada.Error
+	case <-time.After(e.Espera):
+		err = fmt.Errorf("el nodo %s no responde", nodo)
+	}
+	var errServidor rpc.ServerError
+	if err != nil && !errors.As(err, &errServidor) {
+		cliente.Close()
+		e.mu.Lock()
+		if e.clientes[nodo] == cliente {
+			delete(e.clientes, nodo)
+		}
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// Llama al nodo que guarda la matrícula
+func (e *Enrutador) llamarDueño(mat, metodo string, args, respuesta any) error {
+	c := e.cerrojo(mat)
+	c.Lock()
+	defer c.Unlock()
+	e.cambio.RLock()
+	defer e.cambio.RUnlock()
+	nodo, err := e.nodoDe(mat)
+	if err != nil {
+		return err
+	}
+	return e.llamar(nodo, metodo, args, respuesta)
+}
+
+func (e *Enrutador) cerrar() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for nodo, c := range e.clientes {
+		c.Close()
+		delete(e.clientes, nodo)
+	}
+}
+
+// --- Operaciones sobre la flota ---
+
+func (e *Enrutador) nuevoVehiculo(mat, marca, modelo string, entrada, salida time.Time) (*Vehiculo, error) {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return nil, err
+	}
+	var v Vehiculo
+	d := DatosVehiculo{Matricula: mat, Marca: marca, Modelo: modelo, Entrada: entrada, Salida: salida}
+	if err := e.llamarDueño(mat, "Fragmento.NuevoVehiculo", d, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (e *Enrutador) vehiculo(mat string) (*Vehiculo, error) {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return nil, err
+	}
+	var v Vehiculo
+	if err := e.llamarDueño(mat, "Fragmento.Vehiculo", mat, &v); err != nil {
+		return nil, err
+	}
+	if v.Matricula == "" {
+		return nil, fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+	}
+	return &v, nil
+}
+
+// Las fechas a cero (y los textos vacíos) dejan el valor actual
+func (e *Enrutador) actualizarVehiculo(mat, marca, modelo string, entrada, salida time.Time) error {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	d := DatosVehiculo{Matricula: mat, Marca: marca, Modelo: modelo, Entrada: entrada, Salida: salida}
+	return e.llamarDueño(mat, "Fragmento.ActualizarVehiculo", d, &ok)
+}
+
+func (e *Enrutador) borrarVehiculo(mat string) error {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if err := e.llamarDueño(mat, "Fragmento.BorrarVehiculo", mat, &ok); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	_, estaba := e.fuera[mat]
+	delete(e.fuera, mat)
+	e.mu.Unlock()
+	if estaba {
+		return e.guardarFuera()
+	}
+	return nil
+}
+
+func (e *Enrutador) nuevaIncidencia(mat, tipo, prioridad, desc string) (*Incidencia, error) {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return nil, err
+	}
+	var inc Incidencia
+	d := DatosIncidencia{Matricula: mat, Tipo: tipo, Prioridad: prioridad, Descripcion: desc}
+	if err := e.llamarDueño(mat, "Fragmento.NuevaIncidencia", d, &inc); err != nil {
+		return nil, err
+	}
+	return &inc, nil
+}
+
+// Como updateIncidencia; un estado fuera de 0..2 deja el actual
+func (e *Enrutador) actualizarIncidencia(mat string, id int, tipo, prioridad, desc string, estado int) error {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	d := DatosIncidencia{Matricula: mat, ID: id, Tipo: tipo, Prioridad: prioridad, Descripcion: desc, Estado: estado}
+	return e.llamarDueño(mat, "Fragmento.ActualizarIncidencia", d, &ok)
+}
+
+func (e *Enrutador) borrarIncidencia(mat string, id int) error {
+	mat, err := validarMatricula(mat)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	return e.llamarDueño(mat, "Fragmento.BorrarIncidencia", DatosIncidencia{Matricula: mat, ID: id}, &ok)
+}
+
+// --- Entrada y salida de nodos ---
+
+// Resultado de un reequilibrado
+type Reequilibrado struct {
+	Movidos   map[string]string // matrícula -> nodo al que se movió
+	EnPlaza   []string          // no se movieron por estar en una plaza
+	Revisados int               // vehículos que se miraron
+}
+
+// Añade el nodo al reparto y le pasa las matrículas que ahora son suyas. Si
+// ya tenía vehículos que no le tocan, los reparte.
+func (e *Enrutador) unir(nodo string) (Reequilibrado, error) {
+	e.mu.Lock()
+	if e.anillo.nodos[nodo] {
+		e.mu.Unlock()
+		return Reequilibrado{}, fmt.Errorf("%w: %s", errNodoRepetido, nodo)
+	}
+	origen := append(e.anillo.Nodos(), nodo)
+	e.mu.Unlock()
+
+	// No se añade al anillo un nodo que no responde
+	if err := e.cambiarAnillo(origen, func(a *AnilloHash) { a.añadir(nodo) }); err != nil {
+		return Reequilibrado{}, err
+	}
+	return e.reequilibrar(origen...)
+}
+
+// Quita el nodo del reparto pasando sus vehículos a los nodos que quedan. Si
+// alguno no se puede mover (está en una plaza) el nodo sigue atendiéndolo.
+func (e *Enrutador) quitar(nodo string) (Reequilibrado, error) {
+	e.mu.Lock()
+	if !e.anillo.nodos[nodo] {
+		e.mu.Unlock()
+		return Reequilibrado{}, fmt.Errorf("%w: %s", errNodoNoRepartido, nodo)
+	}
+	if len(e.anillo.nodos) == 1 {
+		e.mu.Unlock()
+		return Reequilibrado{}, fmt.Errorf("%s es el último nodo: sus vehículos no tienen adónde ir", nodo)
+	}
+	e.mu.Unlock()
+
+	// Sin saber qué tiene el nodo no se quita: sus vehículos se dejarían de
+	// encontrar
+	if err := e.cambiarAnillo([]string{nodo}, func(a *AnilloHash) { a.quitar(nodo) }); err != nil {
+		return Reequilibrado{}, err
+	}
+	return e.reequilibrar(nodo)
+}
+
+// Cambia el anillo dejando fuera de sitio las matrículas de los nodos que
+// pasan a otro dueño, que se siguen buscando donde están hasta moverlas.
+// Mientras tanto no se atiende ninguna operación, para que no aparezcan
+// vehículos nuevos en un nodo que ya no es su dueño.
+func (e *Enrutador) cambiarAnillo(nodos []string, cambio func(a *AnilloHash)) error {
+	e.cambio.Lock()
+	defer e.cambio.Unlock()
+	tienen := map[string][]string{}
+	for _, nodo := range nodos {
+		var mats []string
+		if err := e.llamar(nodo, "Fragmento.Matriculas", struct{}{}, &mats); err != nil {
+			return err
+		}
+		tienen[nodo] = mats
+	}
+	e.mu.Lock()
+	cambio(e.anillo)
+	for nodo, mats := range tienen {
+		for _, mat := range mats {
+			if _, ok := e.fuera[mat]; !ok && e.anillo.dueño(mat) != nodo {
+				e.fuera[mat] = nodo
+			}
+		}
+	}
+	e.mu.Unlock()
+	return e.guardarFuera()
+}
+
+// Mueve a su dueño los vehículos de los nodos indicados que ya no les tocan.
+// Sin indicar ninguno se miran todos, también los que salieron del anillo con
+// vehículos en plaza.
+func (e *Enrutador) reequilibrar(nodos ...string) (Reequilibrado, error) {
+	if len(nodos) == 0 {
+		e.mu.Lock()
+		nodos = e.anillo.Nodos()
+		for _, nodo := range e.fuera {
+			if !e.anillo.nodos[nodo] && !slices.Contains(nodos, nodo) {
+				nodos = append(nodos, nodo)
+			}
+		}
+		e.mu.Unlock()
+	}
+	r := Reequilibrado{Movidos: map[string]string{}}
+	for _, desde := range nodos {
+		var mats []string
+		if err := e.llamar(desde, "Fragmento.Matriculas", struct{}{}, &mats); err != nil {
+			return r, err
+		}
+		r.Revisados += len(mats)
+		for _, mat := range mats {
+			hacia, err := e.mover(mat, desde)
+			var errServidor rpc.ServerError
+			switch {
+			case err == nil:
+				if hacia != desde {
+					r.Movidos[mat] = hacia
+				}
+			case errors.As(err, &errServidor) && strings.Contains(err.Error(), errVehiculoEnPlaza.Error()):
+				r.EnPlaza = append(r.EnPlaza, mat)
+			default:
+				return r, fmt.Errorf("moviendo %s de %s a %s: %w", mat, desde, hacia, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Copia el vehículo en su dueño y lo borra del nodo desde, y devuelve el
+// dueño (desde si ya estaba en su sitio). Mientras no se borra, las
+// llamadas siguen yendo al viejo; con el cerrojo de la matrícula no llega
+// ninguna entre la copia y el borrado.
+func (e *Enrutador) mover(mat, desde string) (string, error) {
+	c := e.cerrojo(mat)
+	c.Lock()
+	defer c.Unlock()
+	e.mu.Lock()
+	hacia := e.anillo.dueño(mat)
+	_, estaba := e.fuera[mat]
+	if hacia == desde {
+		delete(e.fuera, mat)
+	} else {
+		e.fuera[mat] = desde
+	}
+	e.mu.Unlock()
+	if hacia == desde {
+		if estaba {
+			return hacia, e.guardarFuera()
+		}
+		return hacia, nil
+	}
+	if !estaba {
+		if err := e.guardarFuera(); err != nil {
+			return hacia, err
+		}
+	}
+
+	var vr VehiculoRepartido
+	if err := e.llamar(desde, "Fragmento.Exportar", mat, &vr); err != nil {
+		return hacia, err
+	}
+	var ok bool
+	if err := e.llamar(hacia, "Fragmento.Importar", vr, &ok); err != nil {
+		return hacia, err
+	}
+	if err := e.llamar(desde, "Fragmento.BorrarVehiculo", mat, &ok); err != nil {
+		return hacia, err
+	}
+	e.mu.Lock()
+	delete(e.fuera, mat)
+	e.mu.Unlock()
+	return hacia, e.guardarFuera()
+}
+
+// ------------ MENÚ ------------
+
+func menuFlota(t *Taller, in *Entrada) {
+	e := t.flota
+	if e == nil {
+		fmt.Println("Los vehículos no están repartidos entre nodos (arranca con -fragmentos).")
+		return
+	}
+	for {
+		fmt.Println("\n--- FLOTA REPARTIDA ---")
+		fmt.Println("1. Nodos y vehículos de cada uno")
+		fmt.Println("2. Buscar un vehículo")
+		fmt.Println("3. Crear vehículo")
+		fmt.Println("4. Crear incidencia")
+		fmt.Println("5. Añadir un nodo")
+		fmt.Println("6. Quitar un nodo")
+		fmt.Println("7. Reequilibrar (mover lo que quedó fuera de sitio)")
+		fmt.Println("0. Volver al menú principal")
+
+		op, err := in.Opcion("Seleccione", 0, 7)
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case 1:
+			for _, nodo := range e.Nodos() {
+				var mats []string
+				if err := e.llamar(nodo, "Fragmento.Matriculas", struct{}{}, &mats); err != nil {
+					fmt.Printf("Nodo %s: %v\n", nodo, err)
+					continue
+				}
+				fmt.Printf("Nodo %s: %d vehículos\n", nodo, len(mats))
+			}
+		case 2:
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				break
+			}
+			nodo, _ := e.nodoDe(mat)
+			v, err := e.vehiculo(mat)
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			fmt.Printf("%s %s %s (nodo %s), %d incidencias\n", v.Matricula, v.Marca, v.Modelo, nodo, len(v.Incidencias))
+			for _, inc := range v.Incidencias {
+				fmt.Printf("  %d [%s] %s, estado %d\n", inc.ID, inc.Tipo, inc.Descripcion, inc.Estado)
+			}
+		case 3:
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				break
+			}
+			marca, err := in.TextoDefecto("Marca", "")
+			if err != nil {
+				break
+			}
+			modelo, err := in.TextoDefecto("Modelo", "")
+			if err != nil {
+				break
+			}
+			if _, err := e.nuevoVehiculo(mat, marca, modelo, time.Now(), time.Time{}); err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				nodo, _ := e.nodoDe(mat)
+				fmt.Printf("Vehículo %s creado en el nodo %s.\n", mat, nodo)
+			}
+		case 4:
+			mat, err := in.Validado("Matrícula", "", validarMatricula)
+			if err != nil {
+				break
+			}
+			tipo, err := in.TextoDefecto("Tipo (mecanica, electrica, carroceria)", "mecanica")
+			if err != nil {
+				break
+			}
+			prioridad, err := in.TextoDefecto("Prioridad", "Media")
+			if err != nil {
+				break
+			}
+			desc, err := in.TextoDefecto("Descripción", "")
+			if err != nil {
+				break
+			}
+			if inc, err := e.nuevaIncidencia(mat, tipo, prioridad, desc); err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Printf("Incidencia %d creada para el vehículo %s.\n", inc.ID, mat)
+			}
+		case 5, 6, 7:
+			var r Reequilibrado
+			if op == 7 {
+				r, err = e.reequilibrar()
+			} else if nodo, _ := in.TextoDefecto("Dirección del nodo", ""); nodo == "" {
+				break
+			} else if op == 5 {
+				r, err = e.unir(nodo)
+			} else {
+				r, err = e.quitar(nodo)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+			fmt.Printf("Revisados %d vehículos, movidos %d.\n", r.Revisados, len(r.Movidos))
+			if len(r.EnPlaza) > 0 {
+				fmt.Printf("Siguen en su nodo por estar en una plaza: %s\n", strings.Join(r.EnPlaza, ", "))
+			}
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// reparto_test.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func matriculaDePrueba(i int) string {
+	letras := "BCDFGHJKLMNPRSTVWXYZ"
+	return fmt.Sprintf("%04d %c%c%c", i%10000, letras[i%20], letras[i/20%20], letras[i/400%20])
+}
+
+// Cada nodo se lleva una parte parecida y, al entrar o salir uno, sólo
+// cambian de dueño las matrículas que pasan al nodo nuevo o salen del viejo
+func TestAnilloHashRepartoEstable(t *testing.T) {
+	a := nuevoAnilloHash(virtualesPorNodo)
+	for _, n := range []string{"n1", "n2", "n3"} {
+		a.añadir(n)
+	}
+	const total = 6000
+	antes := map[string]string{}
+	cuenta := map[string]int{}
+	for i := 0; i < total; i++ {
+		mat := matriculaDePrueba(i)
+		antes[mat] = a.dueño(mat)
+		cuenta[antes[mat]]++
+	}
+	for n, c := range cuenta {
+		if c < total/5 || c > total/2 {
+			t.Errorf("el nodo %s se lleva %d de %d matrículas", n, c, total)
+		}
+	}
+
+	a.añadir("n4")
+	movidas := 0
+	for mat, viejo := range antes {
+		if nuevo := a.dueño(mat); nuevo != viejo {
+			movidas++
+			if nuevo != "n4" {
+				t.Fatalf("%s pasa de %s a %s al entrar n4", mat, viejo, nuevo)
+			}
+		}
+	}
+	if movidas < total/8 || movidas > total*3/8 {
+		t.Errorf("al entrar un cuarto nodo se mueven %d de %d matrículas", movidas, total)
+	}
+
+	a.quitar("n4")
+	a.quitar("n2")
+	for mat, viejo := range antes {
+		if nuevo := a.dueño(mat); viejo != "n2" && nuevo != viejo {
+			t.Fatalf("%s pasa de %s a %s al salir n2", mat, viejo, nuevo)
+		}
+	}
+}
+
+// n nodos escuchando, cada uno con su taller
+func fragmentosDePrueba(t *testing.T, n int) ([]*Taller, []string) {
+	t.Helper()
+	var talleres []*Taller
+	var dirs []string
+	for i := 0; i < n; i++ {
+		taller := &Taller{}
+		taller.fragmento = nuevoFragmento(taller)
+		dir, err := taller.fragmento.escuchar("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(taller.fragmento.cerrar)
+		talleres = append(talleres, taller)
+		dirs = append(dirs, dir)
+	}
+	return talleres, dirs
+}
+
+// En qué nodos está cada matrícula
+func dondeEsta(talleres []*Taller, dirs []string) map[string][]string {
+	donde := map[string][]string{}
+	for i, taller := range talleres {
+		for _, v := range taller.datos().Vehiculos() {
+			donde[v.Matricula] = append(donde[v.Matricula], dirs[i])
+		}
+	}
+	return donde
+}
+
+// Al entrar y salir nodos sólo se mueven los vehículos que cambian de dueño,
+// con su cliente y sus incidencias, y el enrutador los sigue encontrando
+func TestEnrutadorReequilibraSoloLoAfectado(t *testing.T) {
+	talleres, dirs := fragmentosDePrueba(t, 3)
+	e := nuevoEnrutador(virtualesPorNodo)
+	defer e.cerrar()
+	for _, dir := range dirs[:2] {
+		if _, err := e.unir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const total = 60
+	for i := 0; i < total; i++ {
+		mat := matriculaDePrueba(i)
+		if _, err := e.nuevoVehiculo(mat, "Seat", "Ibiza", time.Now(), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.nuevaIncidencia(mat, "mecanica", "Alta", "Frenos "+mat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Uno con cliente y la incidencia ya empezada (con su presupuesto aceptado)
+	conCliente := matriculaDePrueba(7)
+	for i, dir := range dirs {
+		if nodo, _ := e.nodoDe(conCliente); nodo == dir {
+			talleres[i].conTaller(func() {
+				p, _ := talleres[i].crearPresupuesto(conCliente, time.Now())
+				talleres[i].responderPresupuesto(p.ID, true)
+			})
+		}
+	}
+	v, _ := e.vehiculo(conCliente)
+	if err := e.actualizarIncidencia(conCliente, v.Incidencias[0].ID, "", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i, dir := range dirs {
+		if nodo, _ := e.nodoDe(conCliente); nodo == dir {
+			cli, _ := talleres[i].newCliente("Pepe", "600123123", "pepe@correo.es", nil)
+			cli.Vehiculos = append(cli.Vehiculos, talleres[i].getVehiculo(conCliente))
+		}
+	}
+	antes := dondeEsta(talleres, dirs)
+
+	r, err := e.unir(dirs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	despues := dondeEsta(talleres, dirs)
+	if len(r.Movidos) == 0 || len(r.Movidos) == total {
+		t.Fatalf("movidos %d de %d vehículos", len(r.Movidos), total)
+	}
+	for mat, nodos := range despues {
+		if len(nodos) != 1 {
+			t.Fatalf("%s está en %v", mat, nodos)
+		}
+		if _, movido := r.Movidos[mat]; movido != (nodos[0] != antes[mat][0]) || movido && nodos[0] != dirs[2] {
+			t.Errorf("%s estaba en %s y está en %s (movido: %v)", mat, antes[mat][0], nodos[0], movido)
+		}
+	}
+
+	// Cada vehículo conserva su incidencia, con el ID que le dé su nodo
+	for i := 0; i < total; i++ {
+		mat := matriculaDePrueba(i)
+		v, err := e.vehiculo(mat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v.Incidencias) != 1 || v.Incidencias[0].Descripcion != "Frenos "+mat {
+			t.Fatalf("%s llega sin su incidencia: %+v", mat, v.Incidencias)
+		}
+		if err := e.actualizarIncidencia(mat, v.Incidencias[0].ID, "", "Baja", "", -1); err != nil {
+			t.Errorf("no se puede modificar la incidencia de %s: %v", mat, err)
+		}
+	}
+	v, _ = e.vehiculo(conCliente)
+	if v.Incidencias[0].Estado != 1 {
+		t.Errorf("la incidencia de %s pierde su estado: %+v", conCliente, v.Incidencias[0])
+	}
+	for i, dir := range dirs {
+		if nodo, _ := e.nodoDe(conCliente); nodo == dir {
+			if c := talleres[i].clienteDeVehiculo(conCliente); c == nil || c.Email != "pepe@correo.es" {
+				t.Errorf("el vehículo %s llega sin su cliente", conCliente)
+			}
+		} else if len(talleres[i].datos().Clientes()) > 0 && talleres[i].clienteDeVehiculo(conCliente) != nil {
+			t.Errorf("el nodo %s sigue con el vehículo %s en su cliente", dir, conCliente)
+		}
+	}
+
+	// Sale el primer nodo: sólo se mueve lo suyo
+	antes = dondeEsta(talleres, dirs)
+	r, err = e.quitar(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(talleres[0].datos().Vehiculos()); n != 0 || len(talleres[0].datos().Incidencias()) != 0 {
+		t.Errorf("el nodo que sale se queda con %d vehículos", n)
+	}
+	for mat, nodos := range dondeEsta(talleres, dirs) {
+		if len(nodos) != 1 || antes[mat][0] != dirs[0] && nodos[0] != antes[mat][0] {
+			t.Errorf("%s estaba en %v y está en %v", mat, antes[mat], nodos)
+		}
+	}
+	if err := e.borrarVehiculo(conCliente); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.vehiculo(conCliente); err == nil {
+		t.Error("el vehículo borrado se sigue encontrando")
+	}
+}
+
+// Un vehículo en una plaza se queda en su nodo, que lo sigue atendiendo,
+// hasta que sale y se vuelve a reequilibrar
+func TestEnrutadorNoMueveVehiculosEnPlaza(t *testing.T) {
+	talleres, dirs := fragmentosDePrueba(t, 2)
+	e := nuevoEnrutador(virtualesPorNodo)
+	defer e.cerrar()
+	if _, err := e.unir(dirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		if _, err := e.nuevoVehiculo(matriculaDePrueba(i), "Seat", "Ibiza", time.Now(), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// El primero que pasará al nodo 1 está en una plaza del nodo 0
+	a := nuevoAnilloHash(virtualesPorNodo)
+	a.añadir(dirs[0])
+	a.añadir(dirs[1])
+	var enPlaza *Vehiculo
+	for _, v := range talleres[0].datos().Vehiculos() {
+		if a.dueño(v.Matricula) == dirs[1] {
+			enPlaza = v
+			break
+		}
+	}
+	if enPlaza == nil {
+		t.Fatal("ningún vehículo cambia de dueño")
+	}
+	if _, err := talleres[0].abrirVisita(enPlaza, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	guardado := &Taller{}
+	e.guardar = guardado.datos().GuardarFlotaFuera
+	r, err := e.unir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.EnPlaza) != 1 || r.EnPlaza[0] != enPlaza.Matricula {
+		t.Fatalf("en plaza: %v", r.EnPlaza)
+	}
+	fuera := guardado.datos().FlotaFuera()
+	if len(fuera) != 1 || fuera[enPlaza.Matricula] != dirs[0] {
+		t.Fatalf("fuera de sitio guardado: %v", fuera)
+	}
+	// Otro enrutador con lo guardado lo encuentra aunque su nodo no esté
+	// en el reparto
+	otro := nuevoEnrutador(virtualesPorNodo)
+	defer otro.cerrar()
+	otro.restaurar(fuera)
+	if _, err := otro.unir(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otro.vehiculo(enPlaza.Matricula); err != nil {
+		t.Errorf("tras reiniciar: %v", err)
+	}
+	if err := e.actualizarVehiculo(enPlaza.Matricula, "Renault", "", time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if talleres[0].getVehiculo(enPlaza.Matricula).Marca != "Renault" {
+		t.Error("el cambio no llega al nodo que tiene el vehículo")
+	}
+
+	if err := talleres[0].cerrarVisita(enPlaza, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r, err = e.reequilibrar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Movidos[enPlaza.Matricula] != dirs[1] || len(r.Movidos) != 1 {
+		t.Fatalf("movidos al salir de la plaza: %v", r.Movidos)
+	}
+	if v, err := e.vehiculo(enPlaza.Matricula); err != nil || v.Marca != "Renault" {
+		t.Errorf("tras moverlo: %+v, %v", v, err)
+	}
+	if fuera := guardado.datos().FlotaFuera(); len(fuera) != 0 {
+		t.Errorf("sigue fuera de sitio: %v", fuera)
+	}
+}
+
+// Con el anillo ya cambiado y los vehículos sin mover, se siguen buscando en
+// su nodo; y si el dueño nuevo tiene otro vehículo con la misma matrícula no
+// se borra el que había
+func TestEnrutadorNoPierdeLoQueNoHaMovido(t *testing.T) {
+	talleres, dirs := fragmentosDePrueba(t, 2)
+	e := nuevoEnrutador(virtualesPorNodo)
+	defer e.cerrar()
+	if _, err := e.unir(dirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		mat := matriculaDePrueba(i)
+		if _, err := e.nuevoVehiculo(mat, "Seat", "Ibiza", time.Now(), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.nuevaIncidencia(mat, "mecanica", "Alta", "Frenos"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.cambiarAnillo(dirs, func(a *AnilloHash) { a.añadir(dirs[1]) }); err != nil {
+		t.Fatal(err)
+	}
+	var pasa string
+	for _, v := range talleres[0].datos().Vehiculos() {
+		if nodo := e.anillo.dueño(v.Matricula); nodo == dirs[1] {
+			pasa = v.Matricula
+			break
+		}
+	}
+	if pasa == "" {
+		t.Fatal("ningún vehículo cambia de dueño")
+	}
+	if v, err := e.vehiculo(pasa); err != nil || len(v.Incidencias) != 1 {
+		t.Fatalf("sin mover: %+v, %v", v, err)
+	}
+	if _, err := e.nuevoVehiculo(pasa, "Opel", "Corsa", time.Now(), time.Time{}); err == nil {
+		t.Error("se crea otro vehículo con la misma matrícula")
+	}
+	if n := len(talleres[1].datos().Vehiculos()); n != 0 {
+		t.Fatalf("el dueño nuevo ya tiene %d vehículos", n)
+	}
+
+	// Un vehículo distinto con esa matrícula en el dueño nuevo
+	talleres[1].newVehiculo(pasa, "Opel", "Corsa", time.Now(), time.Time{}, nil)
+	if _, err := e.reequilibrar(dirs[0]); err == nil || !strings.Contains(err.Error(), errOtroVehiculo.Error()) {
+		t.Fatalf("moviéndolo sobre otro vehículo: %v", err)
+	}
+	if v := talleres[0].getVehiculo(pasa); v == nil || len(v.Incidencias) != 1 {
+		t.Fatalf("se pierde el vehículo que había: %+v", v)
+	}
+
+	talleres[1].sacarVehiculo(pasa)
+	r, err := e.reequilibrar(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Movidos[pasa] != dirs[1] || len(e.fuera) != 0 {
+		t.Errorf("movidos %v, fuera de sitio %v", r.Movidos, e.fuera)
+	}
+	for mat, nodos := range dondeEsta(talleres, dirs) {
+		if len(nodos) != 1 || nodos[0] != e.anillo.dueño(mat) {
+			t.Errorf("%s está en %v", mat, nodos)
+		}
+	}
+}
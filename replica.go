@@ -0,0 +1,940 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------ TALLER REPLICADO ------------
+
+// Varios nodos (de 3 a 5, cada uno con su Taller) mantienen el mismo estado
+// con un registro de órdenes replicado al estilo de Raft:
+//
+//   - Uno de los nodos es el líder. Los demás lo siguen mientras les lleguen
+//     sus latidos; si pasa el plazo de elección sin noticias, se presentan a
+//     líder en un término nuevo y lo son si les vota la mayoría. Sólo vota a
+//     un candidato quien no tiene un registro más al día que él.
+//   - Cada cambio del taller (alta de un cliente, una incidencia nueva, un
+//     cambio de estado…) es una Orden que el líder añade a su registro y
+//     copia a los demás. Cuando la tiene la mayoría está comprometida y cada
+//     nodo la aplica a su Taller en el mismo orden que los demás.
+//   - Las lecturas las atiende el líder, y sólo mientras la mayoría le ha
+//     contestado hace menos de un plazo de elección (nadie más puede haber
+//     sido elegido entretanto).
+//
+// El registro se guarda sólo en memoria: un nodo que se cae no vuelve con
+// su estado, así que para sustituirlo se arranca uno nuevo.
+//
+// Los métodos exportados son los del servicio RPC "Replica".
+
+const (
+	metodoPedirVoto = "Replica.PedirVoto"
+	metodoAnexar    = "Replica.Anexar"
+	metodoEjecutar  = "Replica.Ejecutar"
+	metodoLeer      = "Replica.Vehiculo"
+)
+
+const (
+	latidoReplica  = 50 * time.Millisecond  // el líder late a los seguidores
+	eleccionMinima = 300 * time.Millisecond // el plazo de elección se sortea
+	eleccionMaxima = 600 * time.Millisecond // entre estos dos valores
+	esperaReplica  = 100 * time.Millisecond // lo más que se espera a otro nodo
+	esperaOrden    = 2 * time.Second        // lo más que se espera a comprometer una orden
+)
+
+// Papeles de un nodo
+const (
+	papelSeguidor  = "seguidor"
+	papelCandidato = "candidato"
+	papelLider     = "líder"
+	papelDetenido  = "detenido"
+)
+
+var (
+	errNoEsLider      = errors.New("el nodo no es el líder")
+	errSinMayoria     = errors.New("la orden no llegó a la mayoría de los nodos")
+	errNodoDetenido   = errors.New("el nodo está detenido")
+	errSinLiderReplic = errors.New("no se encuentra el líder de las réplicas")
+)
+
+// ------------ ÓRDENES ------------
+
+// Operaciones que se pueden replicar
+const (
+	opNada              = "" // la añade cada líder al empezar su término
+	opNuevoCliente      = "cliente.nuevo"
+	opCambiarCliente    = "cliente.cambiar"
+	opBorrarCliente     = "cliente.borrar"
+	opNuevoVehiculo     = "vehiculo.nuevo"
+	opBorrarVehiculo    = "vehiculo.borrar"
+	opAdmitirVehiculo   = "vehiculo.admitir"
+	opNuevaIncidencia   = "incidencia.nueva"
+	opCambiarIncidencia = "incidencia.cambiar"
+	opBorrarIncidencia  = "incidencia.borrar"
+	opNuevoMecanico     = "mecanico.nuevo"
+	opCambiarMecanico   = "mecanico.cambiar"
+	opBorrarMecanico    = "mecanico.borrar"
+	opNuevoPresupuesto  = "presupuesto.nuevo"
+	opResponderPresup   = "presupuesto.responder"
+)
+
+// Orden que cambia el taller. Lleva todo lo que necesita (también la fecha)
+// para que aplicarla dé lo mismo en todos los nodos; cada operación usa sólo
+// los campos que le tocan.
+type Orden struct {
+	Op           string
+	Cliente      int
+	Mecanico     int
+	Incidencia   int
+	Presupuesto  int
+	Nombre       string
+	Telefono     string
+	Email        string
+	Matricula    string
+	Marca        string
+	Modelo       string
+	Tipo         string // de la incidencia
+	Prioridad    string
+	Descripcion  string
+	Especialidad string
+	Experiencia  int
+	Estado       int // de la incidencia; -1 lo deja como está
+	Activo       bool
+	Aceptar      bool // respuesta al presupuesto
+	Fecha        time.Time
+}
+
+// Resultado de una orden. El error de aplicarla es el mismo en todos los
+// nodos, porque todos la aplican al mismo estado.
+type Resultado struct {
+	Indice    int // posición de la orden en el registro
+	ID        int // del cliente, incidencia o mecánico creado
+	Matricula string
+	Error     string
+	Lider     string // si el nodo no es el líder, dirección del que cree que lo es
+}
+
+// Aplica la orden al taller. Lo que se cuenta fuera del taller (mensajes y
+// avisos a clientes) sólo lo hace el nodo que la propuso, con propia.
+func (t *Taller) aplicarOrden(o Orden, propia bool) (Resultado, error) {
+	var r Resultado
+	var err error
+	switch o.Op {
+	case opNada:
+	case opNuevoCliente:
+		var c *Cliente
+		if c, err = t.newCliente(o.Nombre, o.Telefono, o.Email, nil); err == nil {
+			r.ID = c.ID
+		}
+	case opCambiarCliente:
+		err = t.updateCliente(o.Cliente, o.Nombre, o.Telefono, o.Email)
+	case opBorrarCliente:
+		err = t.deleteCliente(o.Cliente)
+	case opNuevoVehiculo:
+		var v *Vehiculo
+		if v, err = t.newVehiculo(o.Matricula, o.Marca, o.Modelo, o.Fecha, time.Time{}, nil); err == nil {
+			r.Matricula = v.Matricula
+		}
+	case opBorrarVehiculo:
+		err = t.deleteVehiculo(o.Matricula)
+	case opAdmitirVehiculo:
+		v := t.getVehiculo(o.Matricula)
+		if v == nil {
+			err = fmt.Errorf("vehículo con matrícula %s no encontrado", o.Matricula)
+			break
+		}
+		var c *Cliente
+		var p *Plaza
+		if c, p, err = t.admitirEn(o.Cliente, v, o.Mecanico, o.Fecha); err == nil && propia {
+			t.avisarAdmision(c, v, p, o.Mecanico)
+		}
+	case opNuevaIncidencia:
+		var inc *Incidencia
+		if inc, err = t.newIncidencia(o.Matricula, nil, o.Tipo, o.Prioridad, o.Descripcion); err == nil {
+			r.ID = inc.ID
+		}
+	case opCambiarIncidencia:
+		err = t.updateIncidencia(o.Incidencia, o.Tipo, o.Prioridad, o.Descripcion, o.Estado)
+	case opBorrarIncidencia:
+		err = t.deleteIncidencia(o.Incidencia)
+	case opNuevoPresupuesto:
+		var p *Presupuesto
+		if p, err = t.crearPresupuesto(o.Matricula, o.Fecha); err == nil {
+			r.ID = p.ID
+		}
+	case opResponderPresup:
+		err = t.responderPresupuesto(o.Presupuesto, o.Aceptar)
+	case opNuevoMecanico:
+		m := t.newMecanico(o.Nombre, o.Especialidad, o.Experiencia)
+		if m == nil {
+			err = fmt.Errorf("especialidad inválida (%s)", o.Especialidad)
+			break
+		}
+		r.ID = m.ID
+	case opCambiarMecanico:
+		err = t.updateMecanico(o.Mecanico, o.Nombre, o.Especialidad, o.Experiencia, o.Activo)
+	case opBorrarMecanico:
+		err = t.deleteMecanico(o.Mecanico)
+	default:
+		err = fmt.Errorf("orden desconocida (%s)", o.Op)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r, err
+}
+
+// ------------ NODO ------------
+
+type EntradaReplica struct {
+	Termino int
+	Orden   Orden
+}
+
+type PeticionVoto struct {
+	Termino       int
+	Candidato     int
+	UltimoIndice  int
+	UltimoTermino int
+}
+
+type RespuestaVoto struct {
+	Termino int
+	Voto    bool
+}
+
+// Anexos que el líder manda a un seguidor (sin Entradas es sólo un latido)
+type PeticionAnexar struct {
+	Termino      int
+	Lider        int
+	PrevIndice   int
+	PrevTermino  int
+	Entradas     []EntradaReplica
+	Comprometido int
+}
+
+type RespuestaAnexar struct {
+	Termino   int
+	Ok        bool
+	Siguiente int // si no encaja, por dónde seguir probando
+}
+
+// Orden a la espera de que se aplique, en el nodo que la propuso
+type espera struct {
+	termino int
+	fin     chan Resultado
+}
+
+type NodoReplica struct {
+	t     *Taller
+	ID    int      // posición en pares
+	pares []string // direcciones de todos los nodos, éste incluido
+	srv   *servidorRPC
+
+	mu           sync.Mutex
+	papel        string
+	termino      int
+	votoPara     int // -1 si no ha votado en este término
+	lider        int // -1 si no se conoce
+	registro     []EntradaReplica
+	comprometido int
+	aplicado     int
+	contacto     time.Time // último latido del líder o voto dado
+	plazo        time.Duration
+	mayoria      time.Time // última vez que la mayoría contestó al líder
+	siguiente    []int     // del líder: siguiente entrada a mandar a cada nodo
+	coincide     []int     // del líder: última entrada que se sabe que tiene cada nodo
+	contestado   []time.Time
+	esperas      map[int]espera
+	clientes     []*rpc.Client
+	hayNuevas    *sync.Cond // hay entradas comprometidas sin aplicar
+	aplicadas    *sync.Cond // se ha aplicado otra entrada
+	parar        chan struct{}
+	azar         *rand.Rand
+}
+
+// Nodo id de los que escuchan en pares. El registro empieza con una entrada
+// vacía para que los índices empiecen en 1.
+func nuevoNodoReplica(t *Taller, id int, pares []string) *NodoReplica {
+	n := &NodoReplica{
+		t:        t,
+		ID:       id,
+		pares:    pares,
+		papel:    papelSeguidor,
+		votoPara: -1,
+		lider:    -1,
+		registro: []EntradaReplica{{}},
+		esperas:  map[int]espera{},
+		clientes: make([]*rpc.Client, len(pares)),
+		parar:    make(chan struct{}),
+		azar:     rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+	}
+	n.hayNuevas = sync.NewCond(&n.mu)
+	n.aplicadas = sync.NewCond(&n.mu)
+	n.reiniciarPlazo()
+	return n
+}
+
+// Arranca el nodo: atiende a los demás en su dirección, vigila al líder y
+// aplica lo comprometido
+func (n *NodoReplica) arrancar() error {
+	srv, err := escucharRPC("Replica", n, n.pares[n.ID])
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.srv = srv
+	n.pares[n.ID] = srv.ln.Addr().String()
+	n.mu.Unlock()
+	go n.ejecutar()
+	go n.aplicar()
+	return nil
+}
+
+// Simula que el nodo se cae: deja de atender y de mandar nada
+func (n *NodoReplica) detener() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.papel == papelDetenido {
+		return
+	}
+	n.papel = papelDetenido
+	close(n.parar)
+	n.srv.cerrar()
+	for i, c := range n.clientes {
+		if c != nil {
+			c.Close()
+			n.clientes[i] = nil
+		}
+	}
+	for i, e := range n.esperas {
+		e.fin <- Resultado{Indice: i, Error: errNodoDetenido.Error()}
+		delete(n.esperas, i)
+	}
+	n.hayNuevas.Broadcast()
+	n.aplicadas.Broadcast()
+}
+
+func (n *NodoReplica) reiniciarPlazo() {
+	n.contacto = time.Now()
+	n.plazo = eleccionMinima + time.Duration(n.azar.Int63n(int64(eleccionMaxima-eleccionMinima)))
+}
+
+func (n *NodoReplica) ultimo() (indice, termino int) {
+	i := len(n.registro) - 1
+	return i, n.registro[i].Termino
+}
+
+// Pasa a seguidor del término indicado (si es mayor que el suyo)
+func (n *NodoReplica) seguir(termino int) {
+	if termino > n.termino {
+		n.termino = termino
+		n.votoPara = -1
+	}
+	if n.papel == papelLider {
+		n.t.avisar("Nodo %d: deja de ser líder en el término %d", n.ID, n.termino)
+	}
+	n.papel = papelSeguidor
+}
+
+// Cada poco comprueba si toca latir (líder) o presentarse a líder (los demás)
+func (n *NodoReplica) ejecutar() {
+	tic := time.NewTicker(latidoReplica / 5)
+	defer tic.Stop()
+	ultimoLatido := time.Time{}
+	for {
+		select {
+		case <-n.parar:
+			return
+		case <-tic.C:
+		}
+		n.mu.Lock()
+		switch {
+		case n.papel == papelLider && time.Since(ultimoLatido) >= latidoReplica:
+			ultimoLatido = time.Now()
+			n.anexar()
+		case n.papel != papelLider && n.papel != papelDetenido && time.Since(n.contacto) >= n.plazo:
+			n.presentarse()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// ------------ ELECCIÓN ------------
+
+// Se presenta a líder en un término nuevo. Se llama con n.mu cogido.
+func (n *NodoReplica) presentarse() {
+	n.termino++
+	n.papel = papelCandidato
+	n.votoPara = n.ID
+	n.lider = -1
+	n.reiniciarPlazo()
+	ui, ut := n.ultimo()
+	p := PeticionVoto{Termino: n.termino, Candidato: n.ID, UltimoIndice: ui, UltimoTermino: ut}
+	votos := 1
+	if votos > len(n.pares)/2 {
+		n.liderar()
+		return
+	}
+	for i := range n.pares {
+		if i == n.ID {
+			continue
+		}
+		go func(i int) {
+			var r RespuestaVoto
+			if err := n.llamar(i, metodoPedirVoto, p, &r); err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if r.Termino > n.termino {
+				n.seguir(r.Termino)
+				return
+			}
+			if n.papel != papelCandidato || n.termino != p.Termino || !r.Voto {
+				return
+			}
+			if votos++; votos > len(n.pares)/2 {
+				n.liderar()
+			}
+		}(i)
+	}
+}
+
+// PedirVoto vota al candidato si no ha votado a otro en su término y su
+// registro está al menos tan al día como el propio
+func (n *NodoReplica) PedirVoto(p PeticionVoto, r *RespuestaVoto) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.papel == papelDetenido {
+		return errNodoDetenido
+	}
+	if p.Termino > n.termino {
+		n.seguir(p.Termino)
+	}
+	r.Termino = n.termino
+	if p.Termino < n.termino || (n.votoPara != -1 && n.votoPara != p.Candidato) {
+		return nil
+	}
+	ui, ut := n.ultimo()
+	if p.UltimoTermino < ut || (p.UltimoTermino == ut && p.UltimoIndice < ui) {
+		return nil
+	}
+	n.votoPara = p.Candidato
+	n.reiniciarPlazo()
+	r.Voto = true
+	return nil
+}
+
+// Pasa a líder. Añade una orden vacía de su término para poder comprometer
+// lo que quedara de términos anteriores. Se llama con n.mu cogido.
+func (n *NodoReplica) liderar() {
+	n.papel = papelLider
+	n.lider = n.ID
+	n.siguiente = make([]int, len(n.pares))
+	n.coincide = make([]int, len(n.pares))
+	n.contestado = make([]time.Time, len(n.pares))
+	for i := range n.pares {
+		n.siguiente[i] = len(n.registro)
+	}
+	n.registro = append(n.registro, EntradaReplica{Termino: n.termino})
+	n.coincide[n.ID] = len(n.registro) - 1
+	n.t.avisar("Nodo %d: líder en el término %d", n.ID, n.termino)
+	n.anexar()
+}
+
+// ------------ REPLICACIÓN ------------
+
+// Manda a cada seguidor lo que le falta del registro (o un latido). Se
+// llama con n.mu cogido.
+func (n *NodoReplica) anexar() {
+	for i := range n.pares {
+		if i == n.ID {
+			continue
+		}
+		prev := n.siguiente[i] - 1
+		p := PeticionAnexar{
+			Termino:      n.termino,
+			Lider:        n.ID,
+			PrevIndice:   prev,
+			PrevTermino:  n.registro[prev].Termino,
+			Entradas:     append([]EntradaReplica(nil), n.registro[prev+1:]...),
+			Comprometido: n.comprometido,
+		}
+		go func(i int) {
+			var r RespuestaAnexar
+			if err := n.llamar(i, metodoAnexar, p, &r); err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if r.Termino > n.termino {
+				n.seguir(r.Termino)
+				return
+			}
+			if n.papel != papelLider || n.termino != p.Termino {
+				return
+			}
+			n.contestado[i] = time.Now()
+			n.actualizarMayoria()
+			if !r.Ok {
+				n.siguiente[i] = max(1, min(r.Siguiente, n.siguiente[i]-1))
+				return
+			}
+			if hasta := p.PrevIndice + len(p.Entradas); hasta > n.coincide[i] {
+				n.coincide[i] = hasta
+				n.siguiente[i] = hasta + 1
+				n.comprometer()
+			}
+		}(i)
+	}
+}
+
+// Anota cuándo le ha contestado la mayoría por última vez
+func (n *NodoReplica) actualizarMayoria() {
+	fechas := []time.Time{time.Now()} // el propio líder
+	for i, f := range n.contestado {
+		if i != n.ID {
+			fechas = append(fechas, f)
+		}
+	}
+	// La más antigua de las más recientes de la mayoría
+	for len(fechas) > len(n.pares)/2+1 {
+		masAntigua := 0
+		for j := range fechas {
+			if fechas[j].Before(fechas[masAntigua]) {
+				masAntigua = j
+			}
+		}
+		fechas = append(fechas[:masAntigua], fechas[masAntigua+1:]...)
+	}
+	menor := fechas[0]
+	for _, f := range fechas {
+		if f.Before(menor) {
+			menor = f
+		}
+	}
+	if menor.After(n.mayoria) {
+		n.mayoria = menor
+	}
+}
+
+// Compromete hasta la última entrada de su término que tiene la mayoría
+func (n *NodoReplica) comprometer() {
+	for i := len(n.registro) - 1; i > n.comprometido; i-- {
+		if n.registro[i].Termino != n.termino {
+			break
+		}
+		tienen := 0
+		for _, c := range n.coincide {
+			if c >= i {
+				tienen++
+			}
+		}
+		if tienen > len(n.pares)/2 {
+			n.comprometido = i
+			n.hayNuevas.Broadcast()
+			return
+		}
+	}
+}
+
+// Anexar recibe del líder entradas del registro, o sólo su latido
+func (n *NodoReplica) Anexar(p PeticionAnexar, r *RespuestaAnexar) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.papel == papelDetenido {
+		return errNodoDetenido
+	}
+	r.Termino = n.termino
+	if p.Termino < n.termino {
+		return nil
+	}
+	if p.Termino > n.termino || n.papel != papelSeguidor {
+		n.seguir(p.Termino)
+	}
+	r.Termino = n.termino
+	n.lider = p.Lider
+	n.reiniciarPlazo()
+
+	// Lo anterior a las entradas tiene que coincidir con lo del líder
+	if p.PrevIndice >= len(n.registro) {
+		r.Siguiente = len(n.registro)
+		return nil
+	}
+	if t := n.registro[p.PrevIndice].Termino; t != p.PrevTermino {
+		i := p.PrevIndice
+		for i > 1 && n.registro[i-1].Termino == t {
+			i--
+		}
+		r.Siguiente = i
+		return nil
+	}
+
+	// Lo que no coincide se descarta y se sustituye por lo del líder
+	for j, e := range p.Entradas {
+		i := p.PrevIndice + 1 + j
+		if i < len(n.registro) {
+			if n.registro[i].Termino == e.Termino {
+				continue
+			}
+			n.registro = n.registro[:i]
+		}
+		n.registro = append(n.registro, p.Entradas[j:]...)
+		break
+	}
+	if p.Comprometido > n.comprometido {
+		n.comprometido = min(p.Comprometido, p.PrevIndice+len(p.Entradas))
+		n.hayNuevas.Broadcast()
+	}
+	r.Ok = true
+	return nil
+}
+
+// Aplica al Taller las entradas según se comprometen, en orden y con el
+// taller cogido
+func (n *NodoReplica) aplicar() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		for n.aplicado >= n.comprometido && n.papel != papelDetenido {
+			n.hayNuevas.Wait()
+		}
+		if n.papel == papelDetenido {
+			return
+		}
+		i, e := n.aplicado+1, n.registro[n.aplicado+1]
+		es, propia := n.esperas[i]
+		propia = propia && es.termino == e.Termino
+		n.mu.Unlock()
+		var r Resultado
+		n.t.conTaller(func() { r, _ = n.t.aplicarOrden(e.Orden, propia) })
+		n.mu.Lock()
+		n.aplicado = i
+		n.aplicadas.Broadcast()
+		if es, ok := n.esperas[i]; ok {
+			delete(n.esperas, i)
+			r.Indice = i
+			if es.termino != e.Termino {
+				// En su sitio quedó la orden de otro líder
+				r = Resultado{Indice: i, Error: errSinMayoria.Error()}
+			}
+			es.fin <- r
+		}
+	}
+}
+
+// ------------ ÓRDENES Y LECTURAS ------------
+
+// Propone la orden si el nodo es el líder y espera a que se aplique. Si no
+// trae fecha le pone la de ahora, que es la que usarán todos los nodos.
+func (n *NodoReplica) proponer(o Orden) (Resultado, error) {
+	if o.Fecha.IsZero() {
+		o.Fecha = time.Now()
+	}
+	n.mu.Lock()
+	if n.papel != papelLider {
+		r := Resultado{}
+		if n.lider >= 0 {
+			r.Lider = n.pares[n.lider]
+		}
+		n.mu.Unlock()
+		return r, errNoEsLider
+	}
+	n.registro = append(n.registro, EntradaReplica{Termino: n.termino, Orden: o})
+	i := len(n.registro) - 1
+	n.coincide[n.ID] = i
+	es := espera{termino: n.termino, fin: make(chan Resultado, 1)}
+	n.esperas[i] = es
+	n.anexar()
+	n.mu.Unlock()
+
+	select {
+	case r := <-es.fin:
+		if r.Error == errSinMayoria.Error() || r.Error == errNodoDetenido.Error() {
+			return r, fmt.Errorf("orden %d: %s", i, r.Error)
+		}
+		return r, nil
+	case <-time.After(esperaOrden):
+		n.mu.Lock()
+		delete(n.esperas, i)
+		n.mu.Unlock()
+		return Resultado{Indice: i}, fmt.Errorf("orden %d: %w", i, errSinMayoria)
+	}
+}
+
+// Ejecutar replica la orden. Si el nodo no es el líder no hace nada y dice
+// cuál es en el resultado.
+func (n *NodoReplica) Ejecutar(o Orden, r *Resultado) error {
+	res, err := n.proponer(o)
+	*r = res
+	if errors.Is(err, errNoEsLider) {
+		return nil
+	}
+	return err
+}
+
+// Lee el Taller en el líder, si sigue siéndolo
+func (n *NodoReplica) leer(fn func(t *Taller)) error {
+	n.mu.Lock()
+	lider := n.papel == papelLider && time.Since(n.mayoria) < eleccionMinima
+	// Lo comprometido antes de ser líder sólo se sabe cuando se aplica lo suyo
+	alDia := n.comprometido > 0 && n.registro[n.comprometido].Termino == n.termino
+	hasta := n.comprometido
+	n.mu.Unlock()
+	if !lider || !alDia {
+		return errNoEsLider
+	}
+	// Espera a que se aplique lo comprometido, como mucho esperaOrden
+	vencido := false
+	limite := time.AfterFunc(esperaOrden, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		vencido = true
+		n.aplicadas.Broadcast()
+	})
+	defer limite.Stop()
+	n.mu.Lock()
+	for n.aplicado < hasta && !vencido && n.papel != papelDetenido {
+		n.aplicadas.Wait()
+	}
+	alDia = n.aplicado >= hasta
+	n.mu.Unlock()
+	if !alDia {
+		return errSinMayoria
+	}
+	n.t.conTaller(func() { fn(n.t) })
+	return nil
+}
+
+// Vehiculo devuelve el vehículo con sus incidencias tal y como lo ve el líder
+func (n *NodoReplica) Vehiculo(mat string, r *Vehiculo) error {
+	var err error
+	if e := n.leer(func(t *Taller) {
+		v := t.getVehiculo(mat)
+		if v == nil {
+			err = fmt.Errorf("vehículo con matrícula %s no encontrado", mat)
+			return
+		}
+		*r = *v
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// Papel, término y líder que conoce el nodo
+func (n *NodoReplica) estado() (papel string, termino, lider int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.papel, n.termino, n.lider
+}
+
+// Llama a otro nodo; si no responde a tiempo se cierra la conexión para
+// volver a abrirla en la siguiente llamada
+func (n *NodoReplica) llamar(i int, metodo string, args, respuesta any) error {
+	n.mu.Lock()
+	cliente := n.clientes[i]
+	dir := n.pares[i]
+	n.mu.Unlock()
+	if cliente == nil {
+		conn, err := net.DialTimeout("tcp", dir, esperaReplica)
+		if err != nil {
+			return err
+		}
+		cliente = rpc.NewClient(conn)
+		n.mu.Lock()
+		if n.papel == papelDetenido {
+			n.mu.Unlock()
+			cliente.Close()
+			return errNodoDetenido
+		}
+		n.clientes[i] = cliente
+		n.mu.Unlock()
+	}
+	var err error
+	llamada := cliente.Go(metodo, args, respuesta, make(chan *rpc.Call, 1))
+	select {
+	case <-llamada.Done:
+		err = llamada.Error
+	case <-time.After(esperaReplica):
+		err = fmt.Errorf("el nodo %d no responde", i)
+	}
+	var errServidor rpc.ServerError
+	if err != nil && !errors.As(err, &errServidor) {
+		cliente.Close()
+		n.mu.Lock()
+		if n.clientes[i] == cliente {
+			n.clientes[i] = nil
+		}
+		n.mu.Unlock()
+	}
+	return err
+}
+
+// Ejecuta este proceso como el nodo id del taller replicado hasta que se
+// interrumpe (Ctrl+C), contando los cambios de líder
+func ejecutarNodoReplica(dirs []string, id int) error {
+	for i := range dirs {
+		dirs[i] = strings.TrimSpace(dirs[i])
+	}
+	if id < 0 || id >= len(dirs) {
+		return fmt.Errorf("nodo %d: debe estar entre 0 y %d", id, len(dirs)-1)
+	}
+	n := nuevoNodoReplica(&Taller{}, id, dirs)
+	if err := n.arrancar(); err != nil {
+		return err
+	}
+	defer n.detener()
+	fmt.Printf("Nodo %d del taller replicado en %s (%d nodos)\n", id, dirs[id], len(dirs))
+
+	fin := make(chan os.Signal, 1)
+	signal.Notify(fin, os.Interrupt)
+	tic := time.NewTicker(time.Second)
+	defer tic.Stop()
+	liderAntes := -1
+	for {
+		select {
+		case <-fin:
+			n.mu.Lock()
+			fmt.Printf("Nodo %d detenido: %d órdenes aplicadas\n", id, n.aplicado)
+			n.mu.Unlock()
+			return nil
+		case <-tic.C:
+			if papel, termino, lider := n.estado(); lider != liderAntes {
+				liderAntes = lider
+				fmt.Printf("Término %d: el nodo es %s, líder %d\n", termino, papel, lider)
+			}
+		}
+	}
+}
+
+// ------------ CLIENTE ------------
+
+// Cliente de los nodos replicados: busca al líder y le manda las órdenes
+type ClienteReplicas struct {
+	mu       sync.Mutex
+	dirs     []string
+	lider    string // último nodo que se sabe que es el líder
+	clientes map[string]*rpc.Client
+}
+
+// El programa todavía no tiene menú para el cliente de las réplicas: se usa
+// desde el código (ver replica_test.go)
+func nuevoClienteReplicas(dirs []string) *ClienteReplicas {
+	return &ClienteReplicas{dirs: dirs, clientes: map[string]*rpc.Client{}}
+}
+
+// Error al conectar: la petición no llegó a salir
+type errConexionReplica struct{ error }
+
+// Ejecuta la orden en el líder. Si el nodo al que se manda no lo es, se
+// prueba con el que dice que lo es o con el siguiente hasta que pasa el
+// plazo de una elección. Una orden que pudo llegar al registro no se vuelve
+// a mandar (se aplicaría dos veces): se devuelve el error.
+func (c *ClienteReplicas) ejecutar(o Orden) (Resultado, error) {
+	var r Resultado
+	err := c.llamar(metodoEjecutar, o, &r, func(err error) (bool, string) {
+		var conexion errConexionReplica
+		if errors.As(err, &conexion) {
+			return true, ""
+		}
+		if err == nil && r.Indice == 0 {
+			return true, r.Lider // no es el líder
+		}
+		return false, ""
+	})
+	if err == nil && r.Error != "" {
+		err = errors.New(r.Error)
+	}
+	return r, err
+}
+
+// Vehículo leído en el líder
+func (c *ClienteReplicas) vehiculo(mat string) (*Vehiculo, error) {
+	var v Vehiculo
+	err := c.llamar(metodoLeer, mat, &v, func(err error) (bool, string) {
+		var errServidor rpc.ServerError
+		return err != nil && (!errors.As(err, &errServidor) || string(errServidor) == errNoEsLider.Error() ||
+			string(errServidor) == errNodoDetenido.Error()), ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Llama al líder mientras otraVez diga que hay que probar con otro nodo
+// (y, si lo sabe, con cuál)
+func (c *ClienteReplicas) llamar(metodo string, args, respuesta any, otraVez func(error) (bool, string)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limite := time.Now().Add(2 * eleccionMaxima)
+	siguiente := 0
+	for {
+		dir := c.lider
+		if dir == "" {
+			dir = c.dirs[siguiente%len(c.dirs)]
+			siguiente++
+		}
+		err := c.llamarA(dir, metodo, args, respuesta)
+		repetir, lider := otraVez(err)
+		if !repetir {
+			if err == nil {
+				c.lider = dir
+			}
+			return err
+		}
+		if time.Now().After(limite) {
+			if err == nil {
+				err = errNoEsLider
+			}
+			return fmt.Errorf("%w: %v", errSinLiderReplic, err)
+		}
+		c.lider = lider
+		if lider == "" || lider == dir {
+			c.lider = ""
+			time.Sleep(latidoReplica)
+		}
+	}
+}
+
+func (c *ClienteReplicas) llamarA(dir, metodo string, args, respuesta any) error {
+	cl := c.clientes[dir]
+	if cl == nil {
+		conn, err := net.DialTimeout("tcp", dir, esperaReplica)
+		if err != nil {
+			return errConexionReplica{err}
+		}
+		cl = rpc.NewClient(conn)
+		c.clientes[dir] = cl
+	}
+	err := cl.Call(metodo, args, respuesta)
+	var errServidor rpc.ServerError
+	if err != nil && !errors.As(err, &errServidor) {
+		cl.Close()
+		delete(c.clientes, dir)
+		if errors.Is(err, rpc.ErrShutdown) {
+			// La conexión ya estaba rota: la petición no salió
+			return errConexionReplica{err}
+		}
+	}
+	return err
+}
+
+func (c *ClienteReplicas) cerrar() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir, cl := range c.clientes {
+		cl.Close()
+		delete(c.clientes, dir)
+	}
+}
@@ -0,0 +1,302 @@
+// replica_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// n nodos replicados en puertos libres de localhost, ya arrancados
+func replicasDePrueba(t *testing.T, n int) []*NodoReplica {
+	t.Helper()
+	var dirs []string
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, ln.Addr().String())
+		ln.Close()
+	}
+	var nodos []*NodoReplica
+	for i := range dirs {
+		nodo := nuevoNodoReplica(&Taller{}, i, append([]string(nil), dirs...))
+		if err := nodo.arrancar(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nodo.detener)
+		nodos = append(nodos, nodo)
+	}
+	return nodos
+}
+
+// Espera a que haya un único líder entre los nodos vivos y lo devuelve
+func liderDe(t *testing.T, nodos []*NodoReplica) *NodoReplica {
+	t.Helper()
+	var lider *NodoReplica
+	esperarQue(t, "se elige un líder", func() bool {
+		lider = nil
+		lideres := 0
+		for _, n := range nodos {
+			if papel, _, _ := n.estado(); papel == papelLider {
+				lider = n
+				lideres++
+			}
+		}
+		return lideres == 1
+	})
+	return lider
+}
+
+// Clientes, vehículos e incidencias del taller de un nodo, para comparar
+// unos con otros
+func resumenReplica(n *NodoReplica) string {
+	n.t.mu.Lock()
+	defer n.t.mu.Unlock()
+	var b strings.Builder
+	for _, c := range n.t.datos().Clientes() {
+		fmt.Fprintf(&b, "cliente %d %s %d;", c.ID, c.Nombre, len(c.Vehiculos))
+	}
+	for _, v := range n.t.datos().Vehiculos() {
+		fmt.Fprintf(&b, "vehículo %s %d:", v.Matricula, v.TiempoTotal)
+		for _, inc := range v.Incidencias {
+			fmt.Fprintf(&b, " %d/%s/%d", inc.ID, inc.Tipo, inc.Estado)
+		}
+		b.WriteString(";")
+	}
+	fmt.Fprintf(&b, "ocupadas %d", len(n.t.plazasOcupadas()))
+	return b.String()
+}
+
+// Espera a que todos los nodos tengan aplicado lo mismo que el primero
+func replicasIguales(t *testing.T, nodos []*NodoReplica) string {
+	t.Helper()
+	var resumen string
+	esperarQue(t, "las réplicas aplican lo mismo", func() bool {
+		resumen = resumenReplica(nodos[0])
+		for _, n := range nodos[1:] {
+			if resumenReplica(n) != resumen {
+				return false
+			}
+		}
+		return true
+	})
+	return resumen
+}
+
+// Da de alta un cliente con un vehículo admitido y una incidencia
+func altaReplicada(t *testing.T, c *ClienteReplicas, nombre, mat string) int {
+	t.Helper()
+	r, err := c.ejecutar(Orden{Op: opNuevoCliente, Nombre: nombre})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := r.ID
+	ordenes := []Orden{
+		{Op: opNuevoVehiculo, Matricula: mat, Marca: "Seat", Modelo: "Ibiza", Fecha: time.Now()},
+		{Op: opNuevoMecanico, Nombre: "Mec " + nombre, Especialidad: "mecanica", Experiencia: 3},
+		{Op: opAdmitirVehiculo, Cliente: cli, Matricula: mat},
+		{Op: opNuevaIncidencia, Matricula: mat, Tipo: "mecanica", Prioridad: "Alta", Descripcion: "Frenos"},
+	}
+	var inc int
+	for _, o := range ordenes {
+		r, err := c.ejecutar(o)
+		if err != nil {
+			t.Fatalf("%s: %v", o.Op, err)
+		}
+		inc = r.ID
+	}
+	return inc
+}
+
+func TestReplicasEligenUnLider(t *testing.T) {
+	nodos := replicasDePrueba(t, 3)
+	lider := liderDe(t, nodos)
+	_, termino, _ := lider.estado()
+	esperarQue(t, "todos siguen al líder", func() bool {
+		for _, n := range nodos {
+			if _, tn, ln := n.estado(); tn != termino || ln != lider.ID {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestOrdenesSeAplicanEnTodasLasReplicas(t *testing.T) {
+	nodos := replicasDePrueba(t, 3)
+	c := nuevoClienteReplicas(nodos[0].pares)
+	defer c.cerrar()
+
+	inc := altaReplicada(t, c, "Pepe", "1234 BCD")
+	p, err := c.ejecutar(Orden{Op: opNuevoPresupuesto, Matricula: "1234 BCD", Fecha: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ejecutar(Orden{Op: opResponderPresup, Presupuesto: p.ID, Aceptar: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ejecutar(Orden{Op: opCambiarIncidencia, Incidencia: inc, Estado: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Una orden incorrecta falla igual en todas y no cambia nada
+	antes := replicasIguales(t, nodos)
+	if _, err := c.ejecutar(Orden{Op: opNuevoVehiculo, Matricula: "no vale"}); err == nil {
+		t.Error("la matrícula no es válida")
+	}
+	resumen := replicasIguales(t, nodos)
+	if resumen != antes {
+		t.Errorf("la orden incorrecta ha cambiado el taller:\n%s\n%s", antes, resumen)
+	}
+	if !strings.Contains(resumen, "cliente 0 Pepe 1") || !strings.Contains(resumen, "vehículo 1234 BCD 5: 0/mecanica/1") {
+		t.Errorf("estado replicado incorrecto: %s", resumen)
+	}
+	// La fecha de entrada es la que puso el líder, la misma en todos
+	var entradas []time.Time
+	for _, n := range nodos {
+		n.t.conTaller(func() { entradas = append(entradas, n.t.getVehiculo("1234 BCD").FechaEntrada) })
+	}
+	if entradas[0].IsZero() || !entradas[1].Equal(entradas[0]) || !entradas[2].Equal(entradas[0]) {
+		t.Errorf("fechas de entrada distintas: %v", entradas)
+	}
+
+	// Las lecturas las hace el líder
+	v, err := c.vehiculo("1234 BCD")
+	if err != nil || len(v.Incidencias) != 1 || v.Incidencias[0].Estado != 1 {
+		t.Errorf("lectura incorrecta: %+v, %v", v, err)
+	}
+	for _, n := range nodos {
+		if papel, _, _ := n.estado(); papel != papelLider {
+			if err := n.leer(func(*Taller) {}); !errors.Is(err, errNoEsLider) {
+				t.Errorf("un seguidor no debería atender lecturas: %v", err)
+			}
+		}
+	}
+}
+
+// Al caerse el líder los demás eligen otro y siguen con las órdenes; el
+// caído no se entera de lo nuevo
+func TestFalloDelLiderEligeOtro(t *testing.T) {
+	nodos := replicasDePrueba(t, 5)
+	c := nuevoClienteReplicas(nodos[0].pares)
+	defer c.cerrar()
+	altaReplicada(t, c, "Pepe", "1234 BCD")
+	antes := replicasIguales(t, nodos)
+
+	viejo := liderDe(t, nodos)
+	_, terminoViejo, _ := viejo.estado()
+	viejo.detener()
+	var vivos []*NodoReplica
+	for _, n := range nodos {
+		if n != viejo {
+			vivos = append(vivos, n)
+		}
+	}
+	nuevo := liderDe(t, vivos)
+	if _, termino, _ := nuevo.estado(); termino <= terminoViejo {
+		t.Errorf("el nuevo líder debería serlo en un término mayor: %d <= %d", termino, terminoViejo)
+	}
+
+	inc := altaReplicada(t, c, "Ana", "5678 CDF")
+	if _, err := c.ejecutar(Orden{Op: opCambiarIncidencia, Incidencia: inc, Estado: 2}); err != nil {
+		t.Fatal(err)
+	}
+	resumen := replicasIguales(t, vivos)
+	if !strings.Contains(resumen, "cliente 1 Ana 1") || !strings.Contains(resumen, "vehículo 5678 CDF 5: 1/mecanica/2") {
+		t.Errorf("estado replicado incorrecto tras el fallo: %s", resumen)
+	}
+	if r := resumenReplica(viejo); r != antes {
+		t.Errorf("el líder caído no debería aplicar nada nuevo: %s", r)
+	}
+	if v, err := c.vehiculo("5678 CDF"); err != nil || v.Incidencias[0].Estado != 2 {
+		t.Errorf("lectura incorrecta en el nuevo líder: %+v, %v", v, err)
+	}
+}
+
+// Sin la mayoría el líder no compromete nada ni atiende lecturas
+func TestSinMayoriaNoSeCompromete(t *testing.T) {
+	nodos := replicasDePrueba(t, 3)
+	lider := liderDe(t, nodos)
+	esperarQue(t, "el líder compromete su término", func() bool { return lider.leer(func(*Taller) {}) == nil })
+	for _, n := range nodos {
+		if n != lider {
+			n.detener()
+		}
+	}
+	if _, err := lider.proponer(Orden{Op: opNuevoCliente, Nombre: "Pepe"}); !errors.Is(err, errSinMayoria) {
+		t.Errorf("la orden no debería comprometerse: %v", err)
+	}
+	if len(lider.t.datos().Clientes()) != 0 {
+		t.Error("la orden no debería aplicarse")
+	}
+	if err := lider.leer(func(*Taller) {}); !errors.Is(err, errNoEsLider) {
+		t.Errorf("sin la mayoría no debería atender lecturas: %v", err)
+	}
+}
+
+// Un seguidor con entradas de un líder anterior que no llegaron a la
+// mayoría las sustituye por las del líder actual
+func TestSeguidorDescartaLoQueNoCoincide(t *testing.T) {
+	n := nuevoNodoReplica(&Taller{}, 1, []string{"a", "b", "c"})
+	n.termino = 1
+	n.registro = append(n.registro,
+		EntradaReplica{Termino: 1, Orden: Orden{Op: opNuevoCliente, Nombre: "Pepe"}},
+		EntradaReplica{Termino: 1, Orden: Orden{Op: opNuevoCliente, Nombre: "Perdido"}},
+		EntradaReplica{Termino: 1, Orden: Orden{Op: opNuevoCliente, Nombre: "Perdido 2"}},
+	)
+
+	// El líder del término 3 no tiene lo del 1 a partir de la entrada 2
+	var r RespuestaAnexar
+	n.Anexar(PeticionAnexar{Termino: 3, Lider: 0, PrevIndice: 3, PrevTermino: 2}, &r)
+	if r.Ok || r.Siguiente != 1 {
+		t.Fatalf("no coincide: debería pedir desde el principio del término 1: %+v", r)
+	}
+	n.Anexar(PeticionAnexar{
+		Termino: 3, Lider: 0, PrevIndice: 1, PrevTermino: 1, Comprometido: 3,
+		Entradas: []EntradaReplica{
+			{Termino: 2, Orden: Orden{Op: opNuevoCliente, Nombre: "Ana"}},
+			{Termino: 3},
+		},
+	}, &r)
+	if !r.Ok || r.Termino != 3 || len(n.registro) != 4 || n.comprometido != 3 {
+		t.Fatalf("debería quedarse con lo del líder: %+v, %+v", r, n.registro)
+	}
+	if n.registro[2].Orden.Nombre != "Ana" || n.registro[3].Termino != 3 {
+		t.Errorf("registro incorrecto: %+v", n.registro)
+	}
+
+	// Un líder de un término anterior ya no manda
+	r = RespuestaAnexar{}
+	n.Anexar(PeticionAnexar{Termino: 2, Lider: 2, PrevIndice: 3, PrevTermino: 3}, &r)
+	if r.Ok || r.Termino != 3 {
+		t.Errorf("un líder antiguo no debería poder anexar: %+v", r)
+	}
+}
+
+// Sólo se vota a quien tiene el registro al menos tan al día, y una vez por
+// término
+func TestVotoSoloAlMasAlDia(t *testing.T) {
+	n := nuevoNodoReplica(&Taller{}, 0, []string{"a", "b", "c"})
+	n.registro = append(n.registro, EntradaReplica{Termino: 2})
+	n.termino = 2
+
+	var r RespuestaVoto
+	n.PedirVoto(PeticionVoto{Termino: 3, Candidato: 1, UltimoIndice: 5, UltimoTermino: 1}, &r)
+	if r.Voto {
+		t.Error("no debería votar a un candidato con el registro atrasado")
+	}
+	n.PedirVoto(PeticionVoto{Termino: 3, Candidato: 2, UltimoIndice: 1, UltimoTermino: 2}, &r)
+	if !r.Voto || r.Termino != 3 {
+		t.Errorf("debería votar al candidato al día: %+v", r)
+	}
+	r = RespuestaVoto{}
+	n.PedirVoto(PeticionVoto{Termino: 3, Candidato: 1, UltimoIndice: 1, UltimoTermino: 2}, &r)
+	if r.Voto {
+		t.Error("ya votó en este término")
+	}
+}
@@ -1,31 +1,43 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"os"
 	"time"
 )
 
 // Definición de una estructura para simular trabajos
 type Trabajo struct {
+	ID         int // el mismo en todas sus entregas (ver entregas.go)
 	Vehiculo   *Vehiculo
 	Incidencia *Incidencia
+	porEspera  bool        // otra especialidad lo coge porque llevaba demasiado en la cola
+	marca      MarcaLogica // último evento que lo tocó (ver relojes.go)
 }
 
-// Reenviar un trabajo a la cola
-func reasignarTrabajo(chTrabajos chan Trabajo, v *Vehiculo, inc *Incidencia) {
-	chTrabajos <- Trabajo{Vehiculo: v, Incidencia: inc}
+// Devolver un trabajo a la cola de su especialidad (no bloquea al mecánico)
+func reasignarTrabajo(c *ColasTrabajo, tr Trabajo) {
+	c.encolar(Trabajo{ID: tr.ID, Vehiculo: tr.Vehiculo, Incidencia: tr.Incidencia, marca: tr.marca})
+}
+
+// El mecánico devuelve el trabajo a la cola sin haberlo tocado
+func (t *Taller) devolverSinTocar(m *Mecanico, tr Trabajo) {
+	tr.marca = t.eventos.anotar(t.origenMecanico(m), eventoDevuelto, tr, "")
+	reasignarTrabajo(t.colas, tr)
 }
 
 // Inicia la goroutine de trabajo para un mecánico recién creado
-func iniciarGoroutineMecanico(m *Mecanico, chTrabajos chan Trabajo, chResultados chan string, t *Taller) {
+func iniciarGoroutineMecanico(m *Mecanico, t *Taller) {
 	if m == nil {
 		return
 	}
 	if !m.Activo {
 		return
 	}
-	go trabajoMecanico(m, chTrabajos, chResultados, t)
+	go t.sinPanico(m, func() { trabajoMecanico(m, t) })
 }
 
 // Verifica si el mecánico puede atender la incidencia.
@@ -45,183 +57,595 @@ func (t *Taller) verificarAsignacionMecanico(
 	return false
 }
 
-// Goroutine de cada mecánico
-func trabajoMecanico(m *Mecanico, chTrabajos chan Trabajo, chResultados chan string, t *Taller) {
-	for trabajo := range chTrabajos {
-		v := trabajo.Vehiculo
-		inc := trabajo.Incidencia
-
-		// Si la incidencia ya está cerrada, saltarla
-		if inc.Estado == 2 {
-			continue
+// Goroutine de cada mecánico. Lo que hace lo publica en el broker del taller
+// (ver broker.go). Sólo tiene el taller cogido mientras lo lee o lo cambia:
+// nunca mientras espera trabajo, piezas o a que pase el tiempo de reparación.
+func trabajoMecanico(m *Mecanico, t *Taller) {
+	for {
+		// Fuera de turno (descanso, fin de jornada o ausencia) espera al siguiente
+		if !t.esperarTurno(m) {
+			return
 		}
 
-		// Verificar si este mecánico puede atender la incidencia
-		if !t.verificarAsignacionMecanico(m, v, inc) {
-			// Buscar otro mecánico disponible de la especialidad correcta
-			var mecLibre *Mecanico
-			for _, candidato := range t.Mecanicos {
-				if candidato.Activo && candidato.Especialidad == inc.Tipo && candidato.ID != m.ID {
-					mecLibre = candidato
-					break
-				}
-			}
-
-			// Si no hay, contratamos uno nuevo
-			if mecLibre == nil {
-				mecLibre = t.newMecanico(fmt.Sprintf("Auto-%s", inc.Tipo), string(inc.Tipo), 1)
-				iniciarGoroutineMecanico(mecLibre, chTrabajos, chResultados, t)
-				chResultados <- fmt.Sprintf("No había mecánicos disponibles (%s) — contratado nuevo: %s",
-					inc.Tipo, mecLibre.Nombre)
+		// Espera trabajo de su cola (o uno que pueda robar de otra) hasta que
+		// acabe su tramo de trabajo. Termina al acabar la simulación o si el
+		// control de plantilla lo despide.
+		finTramo, cancelar := t.finDeTramo(m)
+		trabajo, ok := t.colas.tomar(m, finTramo)
+		cancelar()
+		if !ok {
+			if t.despedido(m) || t.colasCerradas() {
+				return
 			}
+			continue // se acabó su tramo de trabajo
+		}
 
-			reasignarTrabajo(chTrabajos, v, inc)
+		t.mu.Lock()
+		r, ok := t.empezarTrabajo(m, &trabajo)
+		t.mu.Unlock()
+		if !ok {
 			continue
 		}
 
-		m.Activo = false
-		inc.Estado = 1
+		restante, perdido := repararPorPorciones(r.porciones, r.interrumpir, func(hechas int) {
+			t.concesiones.avance(r.concesion, hechas)
+		})
+
+		t.mu.Lock()
+		sigue := t.acabarTrabajo(m, trabajo, r, restante, perdido)
+		t.mu.Unlock()
+		if !sigue {
+			return
+		}
+	}
+}
+
+// Comprueba que el vehículo del trabajo se puede reparar. Si no, avisa con
+// rechazo y, si lo que falta es el presupuesto, aparta el trabajo hasta que
+// se acepte; un vehículo que se ha ido a otra sede se deja.
+func (t *Taller) puedeEmpezar(tr Trabajo, rechazo func(error)) bool {
+	err := t.puedeRepararse(tr.Vehiculo)
+	if err == nil {
+		return true
+	}
+	rechazo(err)
+	if errors.Is(err, errPresupuestoSinAceptar) {
+		t.colas.aparcar(tr)
+	}
+	return false
+}
+
+// Reparación que un mecánico acaba de empezar
+type tramoReparacion struct {
+	duracion    int       // lo que le faltaba a la incidencia
+	porciones   int       // lo que va a hacer en este tramo
+	fin         time.Time // fin de su tramo de trabajo (cero si no tiene)
+	interrumpir <-chan struct{}
+	concesion   *concesion
+}
+
+// Decide si el mecánico atiende el trabajo que ha sacado de la cola y, si es
+// así, lo empieza. Se llama con el taller cogido, que sólo se suelta si hay
+// que esperar piezas o al final del turno.
+func (t *Taller) empezarTrabajo(m *Mecanico, trabajo *Trabajo) (tramoReparacion, bool) {
+	v := trabajo.Vehiculo
+	inc := trabajo.Incidencia
+	origen := t.origenMecanico(m)
+	t.eventos.recibir(origen, trabajo.marca)
+
+	// Si la incidencia ya está cerrada, o el trabajo es una copia del que
+	// tiene otro mecánico, saltarla
+	if inc.Estado == 2 || t.entregas.repetido(*trabajo) {
+		return tramoReparacion{}, false
+	}
+
+	// Sin el presupuesto aceptado no se toca el vehículo: el trabajo espera
+	// aparte hasta que se acepte
+	if !t.puedeEmpezar(*trabajo, func(err error) {
+		t.resultado("Mecánico %s no atiende el vehículo %s: %v", m.Nombre, v.Matricula, err)
+	}) {
+		return tramoReparacion{}, false
+	}
 
-		fmt.Printf("Mecánico %s (%s) atendiendo vehículos %s [%s]\n", m.Nombre, m.Especialidad, v.Matricula, inc.Tipo)
+	// Verificar si este mecánico puede atender la incidencia. Si no, vuelve
+	// a la cola; si falta personal de esa especialidad lo decide el
+	// control de plantilla según lo que espera en la cola.
+	if !trabajo.porEspera && !t.verificarAsignacionMecanico(m, v, inc) {
+		t.devolverSinTocar(m, *trabajo)
+		return tramoReparacion{}, false
+	}
+
+	// Sin el material necesario se queda esperando a que llegue el pedido;
+	// si antes se acaba su turno, el trabajo vuelve a la cola
+	if !inc.PiezasServidas && len(inc.Piezas) > 0 {
+		finTramo, cancelar := t.finDeTramo(m)
+		servidas := t.inventario.reservar(m, v, inc, finTramo)
+		cancelar()
+		if !servidas {
+			t.devolverSinTocar(m, *trabajo)
+			return tramoReparacion{}, false
+		}
+	}
+
+	// Si no le da tiempo a terminar antes de acabar el turno (contando las
+	// horas extra permitidas) sólo trabaja hasta el final del turno
+	r := tramoReparacion{duracion: inc.pendiente(), porciones: inc.pendiente()}
+	inicio := t.ahora()
+	r.fin = m.finTramo(inicio)
+	if !r.fin.IsZero() {
+		quedan := int(math.Round(r.fin.Sub(inicio).Hours() / horasPorPorcion))
+		if r.duracion > quedan+maxHorasExtra {
+			r.porciones = quedan
+		}
+	}
+	if r.porciones == 0 && r.duracion > 0 {
+		// Termina el turno en menos de una porción: lo deja para otro
+		t.devolverSinTocar(m, *trabajo)
+		t.sinTaller(func() { t.esperarHasta(m, r.fin) })
+		return tramoReparacion{}, false
+	}
 
-		duracion := inc.TiempoAcumulado
-		time.Sleep(time.Duration(duracion) * time.Second)
+	// Otro mecánico puede haberlo empezado mientras tanto
+	if !t.entregas.empezar(*trabajo, m) {
+		return tramoReparacion{}, false
+	}
+	m.Activo = false
+	inc.Estado = 1
+	t.plantilla.empezar(m, inc)
+	trabajo.marca = t.eventos.anotar(origen, eventoInicio, *trabajo, m.Nombre)
+
+	t.avisar("Mecánico %s (%s) atendiendo vehículos %s [%s]", m.Nombre, m.Especialidad, v.Matricula, inc.Tipo)
+	t.publicar(mensajeTrabajo(temaIncidenciaEmpezada, m, *trabajo, ""))
+
+	t.seguimiento.empezar(m, v, inc, t.plazaDeVehiculo(v.Matricula), time.Duration(r.porciones)*porcionReparacion)
+	r.interrumpir = t.colas.empezarReparacion(m, *trabajo)
+	r.concesion = t.concesiones.conceder(m, *trabajo, r.duracion, r.interrumpir)
+	return r, true
+}
 
-		inc.Estado = 2
-		v.TiempoTotal += duracion
-		t.updateTiempoTotalVehiculo(v)
+// Apunta lo que el mecánico ha hecho en la reparación: la incidencia queda
+// terminada o vuelve a la cola con lo que falta. Se llama con el taller
+// cogido. Devuelve false si el mecánico no debe seguir.
+func (t *Taller) acabarTrabajo(m *Mecanico, trabajo Trabajo, r tramoReparacion, restante int, perdido time.Duration) bool {
+	v := trabajo.Vehiculo
+	inc := trabajo.Incidencia
+	origen := t.origenMecanico(m)
+	if !t.concesiones.soltar(r.concesion) {
+		// Se le dio por muerto mientras reparaba: el vigilante ya devolvió
+		// el trabajo a la cola y el mecánico no sigue
+		return false
+	}
+	t.colas.terminarReparacion(m)
+	t.seguimiento.terminar(m)
+	t.plantilla.terminar(m)
+
+	hecho := r.porciones - restante
+	pendiente := r.duracion - hecho
+	if pendiente <= 0 && !t.entregas.terminar(trabajo, m) {
+		// Ya lo terminó otro: no se cuenta ni se acredita dos veces
 		m.Activo = true
+		t.resultado("Mecánico %s: la incidencia del vehículo %s (%s) ya estaba terminada",
+			m.Nombre, v.Matricula, inc.Tipo)
+		return true
+	}
+	t.registrarManoDeObra(inc, m, float64(hecho)*horasPorPorcion)
 
-		// Reportar resultado final
-		if v.TiempoTotal == 0 {
-			chResultados <- fmt.Sprintf(
-				"Mecánico %s terminó incidencia del vehículo %s (%s) en %ds.\nEl vehículo %s está reparado",
-				m.Nombre, v.Matricula, inc.Tipo, duracion, v.Matricula)
-			t.liberarPlaza(v)
-		} else {
-			chResultados <- fmt.Sprintf(
-				"Mecánico %s terminó incidencia del vehículo %s (%s) en %ds [Tiempo restante del vehículo %ds]",
-				m.Nombre, v.Matricula, inc.Tipo, duracion, v.TiempoTotal)
+	// Trabajar más allá del fin del turno son horas extra
+	if !r.fin.IsZero() {
+		if extra := t.ahora().Sub(r.fin); extra > 0 {
+			t.plantilla.registrarHorasExtra(m, extra.Hours())
 		}
 	}
+
+	// Interrumpida por un prioritario o por el fin del turno: el tiempo
+	// que falta vuelve a la cola con la incidencia
+	if pendiente > 0 {
+		motivo := "al acabar su turno"
+		if restante > 0 {
+			motivo = "para atender un prioritario"
+		}
+		msg := fmt.Sprintf(
+			"Mecánico %s deja la incidencia del vehículo %s (%s) tras %ds %s [quedan %ds]",
+			m.Nombre, v.Matricula, inc.Tipo, hecho, motivo, pendiente)
+		trabajo.marca = t.eventos.anotar(origen, eventoInterrupcion, trabajo, msg)
+		t.trabajoInterrumpido(m, trabajo, hecho, perdido, restante > 0)
+		t.publicarInterrupcion(m, trabajo, hecho, msg)
+		return true
+	}
+
+	msg, reparado := t.trabajoTerminado(m, trabajo, r.duracion)
+	t.eventos.anotar(origen, eventoFin, trabajo, msg)
+	if reparado {
+		t.liberarPlaza(v)
+	}
+	return true
 }
 
-// Goroutine generadora de vehículos e incidencias para alimentar el canal de trabajos
-func generadorVehículos(t *Taller, chTrabajos chan Trabajo, nvehiculos int) {
-	tipos := []Especialidad{Mecanica, Electrica, Carroceria}
+// Un trabajo se deja a medias tras hacer hechas porciones: se suman a las de
+// la incidencia y el trabajo vuelve al principio de su cola. urgencia indica
+// si ha sido para atender un prioritario (si no, se acabó el turno del
+// mecánico).
+func (t *Taller) trabajoInterrumpido(m *Mecanico, tr Trabajo, hechas int, perdido time.Duration, urgencia bool) {
+	v := tr.Vehiculo
+	aviso, clave := "se terminará en el siguiente turno", "turno"
+	if urgencia {
+		aviso, clave = "hemos tenido que atender una urgencia", "urgencia"
+	}
+	t.notificar(AvisoRetraso, v, datosAviso{Motivo: aviso}, clave)
+	m.Activo = true
+	t.entregas.soltar(tr, m)
+	t.devolverTrabajo(tr, hechas, perdido)
+}
+
+// Publica que el mecánico ha dejado el trabajo con hechas porciones hechas
+func (t *Taller) publicarInterrupcion(m *Mecanico, tr Trabajo, hechas int, texto string) {
+	msg := mensajeTrabajo(temaIncidenciaInterrumpida, m, tr, texto)
+	msg.Horas = float64(hechas) * horasPorPorcion
+	t.publicar(msg)
+}
+
+// Devuelve el trabajo al principio de su cola sumando a la incidencia las
+// porciones hechas; el tiempo estimado no cambia
+func (t *Taller) devolverTrabajo(tr Trabajo, hechas int, perdido time.Duration) {
+	v, inc := tr.Vehiculo, tr.Incidencia
+	inc.Hecho += hechas
+	inc.Estado = 0
+	t.updateTiempoTotalVehiculo(v)
+	t.plantilla.encolado(inc)
+	t.colas.devolverInterrumpido(tr, perdido)
+}
 
+// Cierra la incidencia del trabajo terminado y lo publica en
+// incidencia.cerrada (de ahí salen el aviso al cliente y el mensaje en
+// pantalla). Devuelve el mensaje y si con ella el vehículo queda reparado
+// (hay que liberar su plaza después de informar).
+func (t *Taller) trabajoTerminado(m *Mecanico, tr Trabajo, duracion int) (string, bool) {
+	v, inc := tr.Vehiculo, tr.Incidencia
+	inc.Estado = 2
+	v.TiempoTotal += duracion
+	t.updateTiempoTotalVehiculo(v)
+	m.Activo = true
+	if err := t.datos().GuardarIncidencia(inc); err != nil {
+		t.avisar("Error guardando incidencia %d: %v", inc.ID, err)
+	}
+
+	reparado := v.TiempoTotal == 0
+	msg := fmt.Sprintf(
+		"Mecánico %s terminó incidencia del vehículo %s (%s) en %ds [Tiempo restante del vehículo %ds]",
+		m.Nombre, v.Matricula, inc.Tipo, duracion, v.TiempoTotal)
+	if reparado {
+		msg = fmt.Sprintf(
+			"Mecánico %s terminó incidencia del vehículo %s (%s) en %ds.\nEl vehículo %s está reparado",
+			m.Nombre, v.Matricula, inc.Tipo, duracion, v.Matricula)
+	}
+	cerrada := mensajeTrabajo(temaIncidenciaCerrada, m, tr, msg)
+	cerrada.Horas = float64(duracion) * horasPorPorcion
+	t.publicar(cerrada)
+	return msg, reparado
+}
+
+// Simula una reparación de segundos en porciones de porcionReparacion. Si se
+// cierra interrumpir se para en seguida y devuelve los segundos que faltan y
+// lo que se llevaba hecho de la porción a medias, que se pierde. Tras cada
+// porción se llama a latir (si no es nil) con las que lleva hechas.
+func repararPorPorciones(segundos int, interrumpir <-chan struct{}, latir func(hechas int)) (int, time.Duration) {
+	for restante := segundos; restante > 0; restante-- {
+		inicio := time.Now()
+		porcion := time.NewTimer(porcionReparacion)
+		select {
+		case <-porcion.C:
+		case <-interrumpir:
+			porcion.Stop()
+			return restante, time.Since(inicio)
+		}
+		if latir != nil {
+			latir(segundos - restante + 1)
+		}
+	}
+	return 0, 0
+}
+
+// Goroutine generadora de vehículos e incidencias para alimentar el canal de trabajos
+func generadorVehículos(t *Taller, nvehiculos int) {
 	for i := 1; i <= nvehiculos; i++ {
-		v := t.newVehiculo(
+		t.conTaller(func() { t.llegadaSimulada(i) })
+		time.Sleep(2 * time.Second) // simulando tiempo entre llegadas
+	}
+}
+
+// Llega el vehículo i de la simulación: ocupa una plaza, se le abren entre 1
+// y 3 incidencias y se encolan con el presupuesto aceptado. Se llama con el
+// taller cogido.
+func (t *Taller) llegadaSimulada(i int) {
+	// Toda la admisión (vehículo, plaza, incidencias y presupuesto) se hace
+	// con el turno de las plazas si hay más nodos de admisión, para que lo
+	// que se escribe salga a disco con el turno
+	err := t.conPlazas(func() error {
+		t.admisionSimulada(i)
+		return nil
+	})
+	if err != nil {
+		t.avisar("Vehículo M-%03d rechazado: %v", i, err)
+	}
+}
+
+func (t *Taller) admisionSimulada(i int) {
+	tipos := []Especialidad{Mecanica, Electrica, Carroceria}
+	// En otra simulación vuelven los mismos vehículos
+	v := t.getVehiculo(fmt.Sprintf("M-%03d", i))
+	if v == nil {
+		var err error
+		v, err = t.newVehiculo(
 			fmt.Sprintf("M-%03d", i),
 			"Fiat",
 			"500",
-			time.Now().Format("2006-01-02 15:04:05"),
-			"",
+			time.Now(),
+			time.Time{},
 			nil,
 		)
+		if err != nil {
+			t.avisar("Error creando vehículo: %v", err)
+			return
+		}
+	}
 
-		// Buscar plaza libre
-		plazaLibre := -1
-		for i, p := range t.Plazas {
-			if !p.Ocupada {
-				plazaLibre = i
-				break
-			}
+	// Buscar plaza libre y ocuparla
+	var plazaLibre *Plaza
+	for _, p := range t.datos().Plazas() {
+		if !p.Ocupada {
+			plazaLibre = p
+			break
 		}
+	}
+	if plazaLibre == nil {
+		t.avisar("Vehículo %s rechazado: no hay plazas disponibles (%d/%d)",
+			v.Matricula, len(t.plazasOcupadas()), len(t.datos().Plazas()))
+		return
+	}
+	plazaLibre.Ocupada = true
+	plazaLibre.VehiculoMat = v.Matricula
+	if err := t.datos().GuardarPlaza(plazaLibre); err != nil {
+		t.avisar("Error guardando plaza: %v", err)
+	}
+	if _, err := t.abrirVisita(v, plazaLibre.ID, t.ahora()); err != nil {
+		t.avisar("Error abriendo la visita del vehículo %s: %v", v.Matricula, err)
+	}
+	t.avisar("Vehículo %s ocupa plaza %d (%d/%d ocupadas)",
+		v.Matricula, plazaLibre.ID, len(t.plazasOcupadas()), len(t.datos().Plazas()))
+	t.publicar(Mensaje{Tema: temaPlazaOcupada, Matricula: v.Matricula, PlazaID: plazaLibre.ID})
 
-		if plazaLibre == -1 {
-			fmt.Printf("Vehículo %s rechazado: no hay plazas disponibles (%d/%d)\n",
-				v.Matricula, len(t.plazasOcupadas()), len(t.Plazas))
+	// Cada vehículo tendrá entre 1 y 3 incidencias
+	numInc := rand.Intn(3) + 1
+	var nuevas []*Incidencia
+
+	for j := 0; j < numInc; j++ {
+		tipo := tipos[rand.Intn(len(tipos))]
+
+		inc, err := t.newIncidencia(
+			v.Matricula,
+			nil,
+			string(tipo),
+			"Alta",
+			fmt.Sprintf("Mantenimiento %s", tipo),
+		)
+		if err != nil {
+			t.avisar("Error creando incidencia: %v", err)
 			continue
 		}
 
-		// Ocupar la plaza
-		t.Plazas[plazaLibre].Ocupada = true
-		t.Plazas[plazaLibre].VehiculoMat = v.Matricula
-		fmt.Printf("Vehículo %s ocupa plaza %d (%d/%d ocupadas)\n",
-			v.Matricula, t.Plazas[plazaLibre].ID, len(t.plazasOcupadas()), len(t.Plazas))
-
-		// Cada vehículo tendrá entre 1 y 3 incidencias
-		numInc := rand.Intn(3) + 1
-
-		for j := 0; j < numInc; j++ {
-			tipo := tipos[rand.Intn(len(tipos))]
-
-			inc, err := t.newIncidencia(
-				v.Matricula,
-				nil,
-				string(tipo),
-				"Alta",
-				fmt.Sprintf("Mantenimiento %s", tipo),
-			)
-			if err != nil {
-				fmt.Println("Error creando incidencia:", err)
-				continue
+		t.avisar("Llega vehículo %s con incidencia %s (tiempo estimado %d s)",
+			v.Matricula, inc.Tipo, inc.TiempoAcumulado)
+		t.publicar(Mensaje{Tema: temaVehiculoLlegada, Matricula: v.Matricula, IncidenciaID: inc.ID, Tipo: inc.Tipo})
+
+		// La mitad de las reparaciones necesitan alguna pieza del catálogo
+		if piezas := t.datos().Piezas(); len(piezas) > 0 && rand.Intn(2) == 0 {
+			p := piezas[rand.Intn(len(piezas))]
+			if err := t.asignarPiezas(inc.ID, []PiezaNecesaria{{PiezaID: p.ID, Cantidad: rand.Intn(2) + 1}}); err != nil {
+				t.avisar("Error asignando piezas: %v", err)
 			}
+		}
 
-			fmt.Printf("Llega vehículo %s con incidencia %s (tiempo estimado %d s)\n",
-				v.Matricula, inc.Tipo, inc.TiempoAcumulado)
+		nuevas = append(nuevas, inc)
+	}
 
-			chTrabajos <- Trabajo{Vehiculo: v, Incidencia: inc}
-		}
+	t.updateTiempoTotalVehiculo(v)
+	t.avisar("El vehículo %s necesitará %d segundos en total", v.Matricula, v.TiempoTotal)
+	if v.Prioritario {
+		t.avisar("El vehículo %s tiene prioridad", v.Matricula)
+	}
 
-		t.updateTiempoTotalVehiculo(v)
-		fmt.Printf("El vehículo %s necesitará %d segundos en total\n", v.Matricula, v.TiempoTotal)
-		if v.Prioritario {
-			fmt.Printf("El vehículo %s tiene prioridad\n", v.Matricula)
-		}
+	t.aReparar(v, nuevas, t.origenGenerador())
+}
 
-		time.Sleep(2 * time.Second) // simulando tiempo entre llegadas
+// Las incidencias nuevas de un vehículo que acaba de entrar pasan a las colas
+// de trabajo. El cliente de la simulación siempre acepta el presupuesto.
+func (t *Taller) aReparar(v *Vehiculo, nuevas []*Incidencia, origen string) {
+	// Sin presupuesto aceptado los mecánicos apartarían los trabajos para
+	// siempre: mejor no encolarlos
+	p, err := t.aceptarPresupuesto(v)
+	if err != nil {
+		t.avisar("El vehículo %s no se reparará, falla el presupuesto: %v", v.Matricula, err)
+		return
+	}
+	_, _, total := totales(p.Lineas)
+	t.avisar("Presupuesto %d del vehículo %s aceptado: %.2f €", p.ID, v.Matricula, total)
+
+	// Se encola cuando ya se sabe si es prioritario, para que pueda
+	// interrumpir reparaciones de otros vehículos
+	for _, inc := range nuevas {
+		t.plantilla.encolado(inc)
+		tr := t.entregas.nuevo(v, inc)
+		tr.marca = t.eventos.anotar(origen, eventoLlegada, tr, "")
+		t.colas.encolar(tr)
 	}
 }
 
-// Mostrar los resultados que van llegando
-func imprimirResultados(chResultados chan string) {
-	for msg := range chResultados {
-		fmt.Println("->", msg)
+// Mostrar los mensajes con texto que van llegando
+func imprimirResultados(s *Suscripcion, t *Taller) {
+	for msg := range s.C {
+		t.avisar("-> %s", msg.Texto)
 	}
 }
 
 //NOTA: no cierro los canales para evitar panic writing on closed channel
 
-// Función principal de simulación concurrente
-func simularTaller(t *Taller) {
+// Tiempo real que dura una simulación (variable para acortarla en los tests)
+var duracionSimulacion = 60 * time.Second
+
+// Función principal de simulación concurrente. Se llama con el taller cogido
+// (desde el menú).
+func simularTaller(t *Taller, in *Entrada) {
 	fmt.Println("\n=== SIMULACIÓN CONCURRENTE DEL TALLER ===")
 
-	var numVehiculos int
-	fmt.Print("Introduce el número de vehículos a generar: ")
-	_, err := fmt.Scan(&numVehiculos)
+	numVehiculos, err := in.EnteroDefecto("Introduce el número de vehículos a generar", 5)
 	if err != nil || numVehiculos <= 0 {
 		numVehiculos = 5 // valor por defecto
 		fmt.Println("Entrada inválida, se generarán 5 vehículos por defecto.")
 	}
 
-	chTrabajos := make(chan Trabajo, 20)
-	chResultados := make(chan string, 50)
+	conPanel, _ := in.SiNo("¿Mostrar el panel en vivo?", true)
 
-	go imprimirResultados(chResultados)
+	esperaRobo, err := in.EnteroDefecto("Segundos en cola antes de que otra especialidad pueda coger un trabajo",
+		int(esperaRoboPorDefecto/time.Second))
+	if err != nil || esperaRobo < 0 {
+		esperaRobo = int(esperaRoboPorDefecto / time.Second)
+	}
 
-	if len(t.Mecanicos) == 0 {
-		fmt.Println("No hay mecánicos activos. Se crean tres de ejemplo.")
-		t.newMecanico("Luis", "mecanica", 5)
-		t.newMecanico("Ana", "electrica", 4)
-		t.newMecanico("Carlos", "carroceria", 6)
+	// Los componentes se enteran de lo que pasa a través del broker: la
+	// pantalla (sin perder mensajes), los avisos a clientes y las métricas
+	if t.broker == nil {
+		t.broker = nuevoBroker()
+		t.suscribirAvisos(t.broker)
+	}
+	pantalla := t.broker.suscribir("*", 50, politicaBloquear, conTexto)
+	go imprimirResultados(pantalla, t)
+	metricas := nuevasMetricas(t.broker)
+
+	if t.seguimiento == nil {
+		t.seguimiento = nuevoSeguimiento()
+	} else {
+		t.seguimiento.reiniciar()
+	}
+	t.eventos = nuevoRegistroEventos()
+	t.entregas = nuevaTablaEntregas()
+
+	if len(t.datos().Mecanicos()) == 0 {
+		fmt.Println("No hay mecánicos activos. Se crean tres de ejemplo con horario estándar.")
+		for _, m := range []*Mecanico{
+			t.newMecanico("Luis", "mecanica", 5),
+			t.newMecanico("Ana", "electrica", 4),
+			t.newMecanico("Carlos", "carroceria", 6),
+		} {
+			t.definirTurnos(m.ID, horarioEstandar())
+		}
 	}
 
-	for _, m := range t.Mecanicos {
+	// Cada segundo de simulación es una hora de taller a partir de la apertura.
+	// Al terminar el taller vuelve a la hora que tenía (nil = hora real).
+	relojAnterior := t.reloj
+	t.reloj = nuevoRelojSimulado(inicioSimulacion(time.Now()))
+	defer func() { t.reloj = relojAnterior }()
+
+	if len(t.datos().Piezas()) == 0 {
+		fmt.Println("No hay piezas en el catálogo. Se crean tres de ejemplo.")
+		t.piezasDeEjemplo()
+	}
+	t.inventario = nuevoInventario(t)
+
+	t.colas = nuevasColasTrabajo(time.Duration(esperaRobo) * time.Second)
+
+	// El panel toma la pantalla antes de que empiecen a llegar mensajes
+	var panel *Panel
+	if conPanel {
+		panel = nuevoPanel(t, os.Stdout, t.colas.Total)
+		panel.iniciar()
+	} else {
+		fmt.Println("(Simulando... espera unos segundos)")
+	}
+
+	facturasAntes := len(t.datos().Facturas())
+	t.plantilla = nuevoControladorPlantilla(t, politicaPorDefecto(),
+		func(m *Mecanico) { iniciarGoroutineMecanico(m, t) },
+		func(msg string) { t.resultado("%s", msg) })
+	pararPlantilla := make(chan struct{})
+
+	// El vigilante da por muertos a los mecánicos que dejan de latir
+	if t.concesiones == nil {
+		t.concesiones = nuevaTablaConcesiones(t, plazoLatidoDefecto)
+	}
+	pararVigilante := make(chan struct{})
+	go t.concesiones.vigilar(pararVigilante)
+
+	// Los mecánicos remotos trabajan en su proceso a través del coordinador
+	for _, m := range t.datos().Mecanicos() {
 		if m.Activo {
-			go trabajoMecanico(m, chTrabajos, chResultados, t)
+			t.plantilla.registrar(m, time.Now())
+			if !t.coordinador.conectado(m.ID) {
+				iniciarGoroutineMecanico(m, t)
+			}
 		}
 	}
+	go t.plantilla.ejecutar(pararPlantilla)
+	go t.admisionCitas(pararPlantilla)
 
 	go func() {
-		generadorVehículos(t, chTrabajos, numVehiculos)
-		//close(chTrabajos)
+		generadorVehículos(t, numVehiculos)
 	}()
 
-	fmt.Println("(Simulando... espera unos segundos)")
-	time.Sleep(60 * time.Second)
+	// Mientras dura la simulación el taller es de los mecánicos y de los
+	// demás componentes, que lo cogen cuando lo necesitan
+	t.sinTaller(func() {
+		time.Sleep(duracionSimulacion)
+		close(pararPlantilla)
+		close(pararVigilante)
+		t.colas.cerrar()
+		t.inventario.cerrar()
+		if panel != nil {
+			panel.detener()
+		}
+	})
+	t.plantilla.liberarTemporales(time.Now())
+	fmt.Print(t.plantilla.informe(time.Now()))
+	fmt.Printf("Trabajos atendidos por otra especialidad: %d\n", t.colas.Robados())
+	fmt.Print(t.colas.informeExpropiaciones(politicaPorDefecto().SalarioHora))
+	fmt.Print(t.inventario.informe())
+	fmt.Print(t.concesiones.informe())
+	fmt.Print(t.eventos.informe())
+	fmt.Print(t.entregas.informe())
+	fmt.Print(metricas.informe())
+	facturado := 0.0
+	nuevas := t.datos().Facturas()[facturasAntes:]
+	for _, f := range nuevas {
+		facturado += f.Total
+	}
+	fmt.Printf("Facturas emitidas: %d (%.2f € IVA incluido)\n", len(nuevas), facturado)
+	if t.avisos != nil {
+		enviados, pendientes, fallidos := t.avisos.estado()
+		fmt.Printf("Avisos a clientes: %d enviados, %d pendientes, %d fallidos\n", enviados, pendientes, fallidos)
+	}
 
-	//close(chResultados)
+	t.broker.cancelar(pantalla)
+	t.broker.cancelar(metricas.s)
+	if err := t.datos().Sincronizar(); err != nil {
+		fmt.Println("Error guardando los datos de la simulación:", err)
+	}
 	fmt.Println("\n=== Fin de la simulación ===")
+
+	if exportar, _ := in.SiNo("¿Exportar el diagrama de Gantt de la simulación?", false); exportar {
+		dir, _ := in.TextoDefecto("Directorio", "informes")
+		rutaSVG, rutaHTML, err := exportarGantt(t.seguimiento.tramosHasta(time.Now()), dir)
+		if err != nil {
+			fmt.Println("Error exportando el diagrama:", err)
+		} else {
+			fmt.Printf("Diagrama guardado en %s y %s\n", rutaSVG, rutaHTML)
+		}
+	}
+	if guardar, _ := in.SiNo("¿Guardar el registro de eventos (para analizarlo con -eventos)?", false); guardar {
+		dir, _ := in.TextoDefecto("Directorio", "informes")
+		if ruta, err := guardarEventos(fusionarEventos(t.eventos.Eventos()), dir); err != nil {
+			fmt.Println("Error guardando los eventos:", err)
+		} else {
+			fmt.Printf("Eventos guardados en %s\n", ruta)
+		}
+	}
 }
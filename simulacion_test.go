@@ -23,12 +23,12 @@ func crearTallerConMecanicos(nombre string, plantilla map[Especialidad]int) *Tal
 func generarVehiculosParaTest(t *Taller, numVehiculos int, numIncidencias int, tipos []Especialidad, r *rand.Rand) []*Vehiculo {
 	var vehiculos []*Vehiculo
 	for i := 1; i <= numVehiculos; i++ {
-		v := t.newVehiculo(
+		v, _ := t.newVehiculo(
 			fmt.Sprintf("V-%02d", i),
 			"Marca",
 			"Modelo",
-			time.Now().Format("2006-01-02 15:04:05"),
-			"",
+			time.Now(),
+			time.Time{},
 			nil,
 		)
 		for j := 0; j < numIncidencias; j++ {
@@ -62,12 +62,18 @@ func simularTallerConStats(t *Taller, vehiculos []*Vehiculo) map[string]int {
 		go func(m *Mecanico) {
 			for trabajo := range chTrabajos {
 				inc := trabajo.Incidencia
-				if inc.Estado == 2 {
-					continue
+				terminada := false
+				t.conTaller(func() {
+					if inc.Estado == 2 {
+						return
+					}
+					inc.Estado = 2
+					stats[m.Nombre]++
+					terminada = true
+				})
+				if terminada {
+					chResultados <- fmt.Sprintf("%s terminó %s de vehículo %s", m.Nombre, inc.Tipo, trabajo.Vehiculo.Matricula)
 				}
-				inc.Estado = 2
-				stats[m.Nombre]++
-				chResultados <- fmt.Sprintf("%s terminó %s de vehículo %s", m.Nombre, inc.Tipo, trabajo.Vehiculo.Matricula)
 			}
 			done <- struct{}{}
 		}(m)
@@ -183,3 +189,22 @@ func TestSimulacionDistribucionMecanicos(t *testing.T) {
 		t.Errorf("Resultados difieren entre distribuciones: %d vs %d", sum1, sum2)
 	}
 }
+
+// Al acabar la simulación el taller vuelve a la hora real
+func TestSimulacionDevuelveLaHoraReal(t *testing.T) {
+	anterior := duracionSimulacion
+	duracionSimulacion = 200 * time.Millisecond
+	defer func() { duracionSimulacion = anterior }()
+
+	taller := &Taller{}
+	in, _ := entradaDePrueba("1\nn\n10\nn\n")
+	taller.mu.Lock()
+	simularTaller(taller, in)
+	ahora := taller.ahora()
+	taller.mu.Unlock()
+
+	if d := time.Since(ahora); d < 0 || d > time.Second {
+		t.Errorf("tras la simulación la hora del taller es %s y la real %s",
+			formatearFecha(ahora), formatearFecha(time.Now()))
+	}
+}
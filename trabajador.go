@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"time"
+)
+
+// ------------ MECÁNICOS REMOTOS: TRABAJADOR ------------
+
+// Trabajador es un mecánico que trabaja en otro proceso: pide trabajos al
+// coordinador por net/rpc y los hace por porciones, informando del avance
+// tras cada una para que el coordinador pueda pedirle que pare. Mientras
+// trabaja late cada cierto tiempo para que no se le dé por muerto.
+type Trabajador struct {
+	cliente      *rpc.Client
+	ID           int
+	Nombre       string
+	Especialidad Especialidad
+	Porcion      time.Duration // tiempo real de cada porción de reparación
+	Espera       time.Duration // cuánto esperar a que haya trabajo en cada petición
+	Latido       time.Duration // cada cuánto avisa al coordinador de que sigue vivo
+	Terminados   int
+}
+
+const (
+	esperaTrabajoRemoto = 2 * time.Second
+	intervaloLatido     = plazoLatidoDefecto / 3
+)
+
+// Se conecta al coordinador de dir y se registra como mecánico
+func conectarTrabajador(dir, nombre string, esp Especialidad, años int) (*Trabajador, error) {
+	cliente, err := rpc.Dial("tcp", dir)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo conectar con el coordinador %s: %w", dir, err)
+	}
+	w := &Trabajador{
+		cliente:      cliente,
+		Nombre:       nombre,
+		Especialidad: esp,
+		Porcion:      porcionReparacion,
+		Espera:       esperaTrabajoRemoto,
+		Latido:       intervaloLatido,
+	}
+	p := PeticionRegistro{Nombre: nombre, Especialidad: esp, AñosExp: años}
+	if err := cliente.Call(metodoRegistrar, p, &w.ID); err != nil {
+		cliente.Close()
+		return nil, fmt.Errorf("registro rechazado: %w", err)
+	}
+	return w, nil
+}
+
+// Pide y hace trabajos hasta que se cierra parar o la simulación termina.
+// Devuelve error si se pierde la conexión con el coordinador o éste le ha
+// dado por muerto.
+func (w *Trabajador) ejecutar(parar <-chan struct{}) error {
+	fin := make(chan struct{})
+	defer close(fin)
+	caido := make(chan error, 1)
+	go w.latir(fin, caido)
+
+	for {
+		select {
+		case <-parar:
+			return nil
+		default:
+		}
+		var r RespuestaTrabajo
+		if err := w.llamar(metodoPedirTrabajo, PeticionTrabajo{MecanicoID: w.ID, Espera: w.Espera}, &r); err != nil {
+			return err
+		}
+		if r.Fin {
+			return nil
+		}
+		if !r.Hay {
+			continue
+		}
+
+		hechas, err := w.reparar(r, parar, caido)
+		if err != nil {
+			return err
+		}
+		var cerrada bool
+		cierre := InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: hechas}
+		if err := w.llamar(metodoTerminar, cierre, &cerrada); err != nil {
+			return err
+		}
+		if cerrada {
+			w.Terminados++
+		}
+	}
+}
+
+// Hace las porciones del trabajo y devuelve cuántas completó (menos si se
+// cierra parar o el coordinador pide dejarlo). Si falla un latido lo deja
+// con el error: el trabajo ya no es suyo.
+func (w *Trabajador) reparar(r RespuestaTrabajo, parar <-chan struct{}, caido <-chan error) (int, error) {
+	for hechas := 0; hechas < r.Porciones; {
+		porcion := time.NewTimer(w.Porcion)
+		select {
+		case <-porcion.C:
+		case <-parar:
+			porcion.Stop()
+			return hechas, nil
+		case err := <-caido:
+			porcion.Stop()
+			return hechas, err
+		}
+		hechas++
+
+		var av RespuestaAvance
+		informe := InformeAvance{MecanicoID: w.ID, Concesion: r.Concesion, Hechas: hechas}
+		if err := w.llamar(metodoAvanzar, informe, &av); err != nil {
+			return hechas, err
+		}
+		if av.Parar {
+			return hechas, nil
+		}
+	}
+	return r.Porciones, nil
+}
+
+// Late cada w.Latido hasta que se cierra fin. Si el coordinador no acepta
+// el latido deja el error en caido y no late más.
+func (w *Trabajador) latir(fin <-chan struct{}, caido chan<- error) {
+	tic := time.NewTicker(w.Latido)
+	defer tic.Stop()
+	for {
+		select {
+		case <-tic.C:
+			var ok bool
+			if err := w.llamar(metodoLatido, w.ID, &ok); err != nil {
+				caido <- fmt.Errorf("el coordinador no acepta los latidos: %w", err)
+				return
+			}
+		case <-fin:
+			return
+		}
+	}
+}
+
+// Llama al coordinador; si la conexión se ha caído lo dice en el error
+func (w *Trabajador) llamar(metodo string, args, respuesta any) error {
+	err := w.cliente.Call(metodo, args, respuesta)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("se ha perdido la conexión con el coordinador: %w", err)
+	}
+	return err
+}
+
+// Se da de baja en el coordinador y cierra la conexión
+func (w *Trabajador) cerrar() error {
+	var ok bool
+	err := w.cliente.Call(metodoBaja, w.ID, &ok)
+	if errCierre := w.cliente.Close(); err == nil {
+		err = errCierre
+	}
+	return err
+}
+
+// Modo trabajador del programa: un mecánico remoto que trabaja hasta que
+// termina la simulación del coordinador
+func ejecutarTrabajadorRemoto(dir, nombre string, esp Especialidad, años int) error {
+	w, err := conectarTrabajador(dir, nombre, esp, años)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mecánico %s (%s) conectado a %s con el ID %d\n", w.Nombre, w.Especialidad, dir, w.ID)
+	err = w.ejecutar(nil)
+	w.cerrar()
+	fmt.Printf("Mecánico %s desconectado: %d trabajos terminados\n", w.Nombre, w.Terminados)
+	return err
+}
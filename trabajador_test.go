@@ -0,0 +1,136 @@
+// trabajador_test.go
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Coordinador de prueba: concede un único trabajo de porciones porciones,
+// pide parar al llegar a pararEn (0 = nunca) y puede rechazar los latidos
+type coordinadorPrueba struct {
+	mu         sync.Mutex
+	porciones  int
+	pararEn    int
+	sinLatidos bool
+	concedido  bool
+	avances    []int
+	cierres    []InformeAvance
+}
+
+func (c *coordinadorPrueba) Registrar(p PeticionRegistro, id *int) error {
+	*id = 7
+	return nil
+}
+
+func (c *coordinadorPrueba) PedirTrabajo(p PeticionTrabajo, r *RespuestaTrabajo) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.concedido {
+		r.Fin = true
+		return nil
+	}
+	c.concedido = true
+	*r = RespuestaTrabajo{Hay: true, Concesion: 1, IncidenciaID: 1, Matricula: "V-01", Tipo: Mecanica, Porciones: c.porciones}
+	return nil
+}
+
+func (c *coordinadorPrueba) Avanzar(a InformeAvance, r *RespuestaAvance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.avances = append(c.avances, a.Hechas)
+	r.Parar = a.Hechas == c.pararEn
+	return nil
+}
+
+func (c *coordinadorPrueba) Terminar(a InformeAvance, cerrada *bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cierres = append(c.cierres, a)
+	*cerrada = a.Hechas == c.porciones
+	return nil
+}
+
+func (c *coordinadorPrueba) Latido(mecID int, ok *bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sinLatidos {
+		return errNoRegistrado
+	}
+	*ok = true
+	return nil
+}
+
+func (c *coordinadorPrueba) Baja(mecID int, ok *bool) error {
+	*ok = true
+	return nil
+}
+
+func trabajadorConCoordinadorPrueba(t *testing.T, c *coordinadorPrueba) *Trabajador {
+	t.Helper()
+	srv, err := escucharRPC("Taller", c, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.cerrar)
+	w := trabajadorDePrueba(t, srv.ln.Addr().String(), "Luis", Mecanica)
+	t.Cleanup(func() { w.cliente.Close() })
+	return w
+}
+
+func TestTrabajadorTerminaElTrabajo(t *testing.T) {
+	c := &coordinadorPrueba{porciones: 3}
+	w := trabajadorConCoordinadorPrueba(t, c)
+	if w.ID != 7 {
+		t.Fatalf("ID %d, se esperaba el que da el coordinador", w.ID)
+	}
+	if err := w.ejecutar(nil); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.Terminados != 1 || len(c.avances) != 3 || len(c.cierres) != 1 || c.cierres[0].Hechas != 3 {
+		t.Errorf("terminados %d, avances %v, cierres %+v", w.Terminados, c.avances, c.cierres)
+	}
+}
+
+func TestTrabajadorParaCuandoSeLoPiden(t *testing.T) {
+	c := &coordinadorPrueba{porciones: 10, pararEn: 2}
+	w := trabajadorConCoordinadorPrueba(t, c)
+	if err := w.ejecutar(nil); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.Terminados != 0 || len(c.cierres) != 1 || c.cierres[0].Hechas != 2 {
+		t.Errorf("debería dejarlo tras 2 porciones: terminados %d, cierres %+v", w.Terminados, c.cierres)
+	}
+}
+
+func TestTrabajadorSinLatidosLoDeja(t *testing.T) {
+	c := &coordinadorPrueba{porciones: 1000, sinLatidos: true}
+	w := trabajadorConCoordinadorPrueba(t, c)
+	w.Latido = 10 * time.Millisecond
+	err := w.ejecutar(nil)
+	if err == nil {
+		t.Fatalf("debería terminar con error al rechazarse los latidos: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cierres) != 0 {
+		t.Errorf("un trabajo que ya no es suyo no se cierra: %+v", c.cierres)
+	}
+}
+
+func TestTrabajadorSinCoordinador(t *testing.T) {
+	srv, err := escucharRPC("Taller", &coordinadorPrueba{}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := srv.ln.Addr().String()
+	srv.cerrar()
+	if _, err := conectarTrabajador(dir, "Luis", Mecanica, 0); err == nil {
+		t.Error("no debería conectarse a un coordinador que no escucha")
+	}
+}
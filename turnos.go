@@ -0,0 +1,496 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------ TURNOS, DESCANSOS Y AUSENCIAS ------------
+
+// Intervalo dentro de un día, en minutos desde medianoche
+type Intervalo struct {
+	Inicio int
+	Fin    int
+}
+
+func (iv Intervalo) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", iv.Inicio/60, iv.Inicio%60, iv.Fin/60, iv.Fin%60)
+}
+
+// Turno semanal de un mecánico: un día de la semana, su horario y las pausas
+// que hace dentro de él
+type Turno struct {
+	Dia       time.Weekday
+	Horario   Intervalo
+	Descansos []Intervalo
+}
+
+type TipoAusencia string
+
+const (
+	Vacaciones TipoAusencia = "vacaciones"
+	Baja       TipoAusencia = "baja"
+)
+
+// Periodo en el que el mecánico no trabaja aunque le toque turno
+type Ausencia struct {
+	Tipo  TipoAusencia
+	Desde time.Time
+	Hasta time.Time
+}
+
+// Días de la semana tal y como se muestran y se escriben en los menús
+var nombresDia = []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"}
+
+// Días hacia delante en los que se busca el siguiente turno de un mecánico
+const horizonteTurnos = 14
+
+// Horas extra que se permiten para acabar un trabajo en lugar de dejárselo a otro
+const maxHorasExtra = 2
+
+// Horas de taller que dura cada porción de una reparación
+const horasPorPorcion = float64(porcionReparacion/time.Second) * horasPorSegundoSimulado
+
+// Convierte "08:00-16:00" en un intervalo
+func parsearIntervalo(campo, s string) (Intervalo, error) {
+	partes := strings.Split(strings.TrimSpace(s), "-")
+	if len(partes) != 2 {
+		return Intervalo{}, errorValidacion(campo, s, "formato esperado HH:MM-HH:MM")
+	}
+	var minutos [2]int
+	for i, p := range partes {
+		var h, m int
+		if _, err := fmt.Sscanf(strings.TrimSpace(p), "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 {
+			return Intervalo{}, errorValidacion(campo, s, "formato esperado HH:MM-HH:MM")
+		}
+		minutos[i] = h*60 + m
+	}
+	iv := Intervalo{Inicio: minutos[0], Fin: minutos[1]}
+	if iv.Fin > 24*60 || iv.Inicio >= iv.Fin {
+		return Intervalo{}, errorValidacion(campo, s, "la hora de fin debe ser posterior a la de inicio (y como mucho 24:00)")
+	}
+	return iv, nil
+}
+
+// Convierte "lunes" (o "lun", "miercoles"...) en día de la semana
+func parsearDia(s string) (time.Weekday, error) {
+	sinTildes := strings.NewReplacer("á", "a", "é", "e")
+	s = sinTildes.Replace(strings.ToLower(strings.TrimSpace(s)))
+	for i, nombre := range nombresDia {
+		if len(s) >= 3 && strings.HasPrefix(sinTildes.Replace(nombre), s) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, errorValidacion("día", s, "debe ser un día de la semana (lunes, martes...)")
+}
+
+// Los descansos deben caer dentro del turno y no solaparse entre sí
+func validarTurno(tu Turno) error {
+	if tu.Horario.Inicio < 0 || tu.Horario.Inicio >= tu.Horario.Fin || tu.Horario.Fin > 24*60 {
+		return errorValidacion("turno", tu.Horario.String(), "horario no válido")
+	}
+	descansos := append([]Intervalo(nil), tu.Descansos...)
+	sort.Slice(descansos, func(i, j int) bool { return descansos[i].Inicio < descansos[j].Inicio })
+	for i, d := range descansos {
+		if d.Inicio < tu.Horario.Inicio || d.Fin > tu.Horario.Fin || d.Inicio >= d.Fin {
+			return errorValidacion("descanso", d.String(), "debe estar dentro del turno "+tu.Horario.String())
+		}
+		if i > 0 && d.Inicio < descansos[i-1].Fin {
+			return errorValidacion("descanso", d.String(), "se solapa con "+descansos[i-1].String())
+		}
+	}
+	return nil
+}
+
+func validarAusencia(a Ausencia) error {
+	if a.Tipo != Vacaciones && a.Tipo != Baja {
+		return errorValidacion("tipo de ausencia", string(a.Tipo), "debe ser 'vacaciones' o 'baja'")
+	}
+	if a.Desde.IsZero() || !a.Hasta.After(a.Desde) {
+		return errorValidacion("ausencia", formatearFecha(a.Desde)+" - "+formatearFecha(a.Hasta),
+			"la fecha de fin debe ser posterior a la de inicio")
+	}
+	return nil
+}
+
+// Periodo de trabajo continuo
+type periodo struct {
+	desde, hasta time.Time
+}
+
+// Periodos en los que el mecánico trabaja desde el día de f hasta dias días
+// después, ya sin descansos ni ausencias, ordenados y con los contiguos unidos
+func (m *Mecanico) periodosTrabajo(f time.Time, dias int) []periodo {
+	var lista []periodo
+	medianoche := time.Date(f.Year(), f.Month(), f.Day(), 0, 0, 0, 0, f.Location())
+	en := func(dia time.Time, min int) time.Time { return dia.Add(time.Duration(min) * time.Minute) }
+
+	for d := 0; d <= dias; d++ {
+		dia := medianoche.AddDate(0, 0, d)
+		for _, tu := range m.Turnos {
+			if tu.Dia != dia.Weekday() {
+				continue
+			}
+			trozos := []periodo{{en(dia, tu.Horario.Inicio), en(dia, tu.Horario.Fin)}}
+			for _, desc := range tu.Descansos {
+				trozos = restarPeriodo(trozos, periodo{en(dia, desc.Inicio), en(dia, desc.Fin)})
+			}
+			lista = append(lista, trozos...)
+		}
+	}
+	for _, a := range m.Ausencias {
+		lista = restarPeriodo(lista, periodo{a.Desde, a.Hasta})
+	}
+
+	sort.Slice(lista, func(i, j int) bool { return lista[i].desde.Before(lista[j].desde) })
+	var unidos []periodo
+	for _, p := range lista {
+		if n := len(unidos); n > 0 && !p.desde.After(unidos[n-1].hasta) {
+			if p.hasta.After(unidos[n-1].hasta) {
+				unidos[n-1].hasta = p.hasta
+			}
+			continue
+		}
+		unidos = append(unidos, p)
+	}
+	return unidos
+}
+
+// Quita de cada periodo la parte que cae dentro de q
+func restarPeriodo(lista []periodo, q periodo) []periodo {
+	var res []periodo
+	for _, p := range lista {
+		if !q.desde.Before(p.hasta) || !p.desde.Before(q.hasta) {
+			res = append(res, p)
+			continue
+		}
+		if p.desde.Before(q.desde) {
+			res = append(res, periodo{p.desde, q.desde})
+		}
+		if q.hasta.Before(p.hasta) {
+			res = append(res, periodo{q.hasta, p.hasta})
+		}
+	}
+	return res
+}
+
+func (m *Mecanico) ausenteEn(f time.Time) *Ausencia {
+	for i, a := range m.Ausencias {
+		if !f.Before(a.Desde) && f.Before(a.Hasta) {
+			return &m.Ausencias[i]
+		}
+	}
+	return nil
+}
+
+// Indica si al mecánico le toca trabajar en el instante f
+func (m *Mecanico) enTurno(f time.Time) bool {
+	if len(m.Turnos) == 0 {
+		return m.ausenteEn(f) == nil
+	}
+	for _, p := range m.periodosTrabajo(f.AddDate(0, 0, -1), 1) {
+		if !f.Before(p.desde) && f.Before(p.hasta) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hasta cuándo trabaja sin interrupción quien está en turno en f. La fecha
+// cero significa que no hay límite (mecánico sin turnos ni ausencias próximas).
+func (m *Mecanico) finTramo(f time.Time) time.Time {
+	if len(m.Turnos) == 0 {
+		fin := time.Time{}
+		for _, a := range m.Ausencias {
+			if a.Desde.After(f) && (fin.IsZero() || a.Desde.Before(fin)) {
+				fin = a.Desde
+			}
+		}
+		return fin
+	}
+	for _, p := range m.periodosTrabajo(f.AddDate(0, 0, -1), horizonteTurnos) {
+		if !f.Before(p.desde) && f.Before(p.hasta) {
+			return p.hasta
+		}
+	}
+	return f
+}
+
+// Primer instante desde f en que el mecánico estará en turno (cero si no
+// vuelve a trabajar dentro del horizonte de búsqueda)
+func (m *Mecanico) proximoTurno(f time.Time) time.Time {
+	if len(m.Turnos) == 0 {
+		if a := m.ausenteEn(f); a != nil {
+			return m.proximoTurno(a.Hasta)
+		}
+		return f
+	}
+	for _, p := range m.periodosTrabajo(f.AddDate(0, 0, -1), horizonteTurnos) {
+		if p.hasta.After(f) {
+			if p.desde.After(f) {
+				return p.desde
+			}
+			return f
+		}
+	}
+	return time.Time{}
+}
+
+// Horario estándar: de lunes a viernes de 8:00 a 17:00 con una hora para comer
+func horarioEstandar() []Turno {
+	var turnos []Turno
+	for dia := time.Monday; dia <= time.Friday; dia++ {
+		turnos = append(turnos, Turno{
+			Dia:       dia,
+			Horario:   Intervalo{8 * 60, 17 * 60},
+			Descansos: []Intervalo{{13 * 60, 14 * 60}},
+		})
+	}
+	return turnos
+}
+
+// Cambia el horario semanal de un mecánico (una lista vacía = sin horario)
+func (t *Taller) definirTurnos(id int, turnos []Turno) error {
+	m := t.getMecanico(id)
+	if m == nil {
+		return fmt.Errorf("mecánico con ID %d no encontrado", id)
+	}
+	for _, tu := range turnos {
+		if err := validarTurno(tu); err != nil {
+			return err
+		}
+	}
+	m.Turnos = turnos
+	return t.datos().GuardarMecanico(m)
+}
+
+func (t *Taller) registrarAusencia(id int, a Ausencia) error {
+	m := t.getMecanico(id)
+	if m == nil {
+		return fmt.Errorf("mecánico con ID %d no encontrado", id)
+	}
+	if err := validarAusencia(a); err != nil {
+		return err
+	}
+	m.Ausencias = append(m.Ausencias, a)
+	return t.datos().GuardarMecanico(m)
+}
+
+func printHorario(m *Mecanico) {
+	if len(m.Turnos) == 0 {
+		fmt.Println("Horario: sin turnos (disponible siempre)")
+	} else {
+		fmt.Println("Horario:")
+		turnos := append([]Turno(nil), m.Turnos...)
+		// De lunes a domingo
+		sort.SliceStable(turnos, func(i, j int) bool { return (turnos[i].Dia+6)%7 < (turnos[j].Dia+6)%7 })
+		for _, tu := range turnos {
+			linea := fmt.Sprintf("  %-10s %s", nombresDia[tu.Dia], tu.Horario)
+			if len(tu.Descansos) > 0 {
+				var desc []string
+				for _, d := range tu.Descansos {
+					desc = append(desc, d.String())
+				}
+				linea += " (descanso " + strings.Join(desc, ", ") + ")"
+			}
+			fmt.Println(linea)
+		}
+	}
+	for _, a := range m.Ausencias {
+		fmt.Printf("Ausencia (%s): %s - %s\n", a.Tipo, formatearFecha(a.Desde), formatearFecha(a.Hasta))
+	}
+}
+
+// ------------ RELOJ DE LA SIMULACIÓN ------------
+
+// RelojSimulado traduce el tiempo real de la simulación a tiempo de taller:
+// cada segundo real son horasPorSegundoSimulado horas.
+type RelojSimulado struct {
+	inicioReal time.Time
+	inicioSim  time.Time
+}
+
+func nuevoRelojSimulado(inicioSim time.Time) *RelojSimulado {
+	return &RelojSimulado{inicioReal: time.Now(), inicioSim: inicioSim}
+}
+
+const escalaSimulacion = horasPorSegundoSimulado * 3600 // segundos de taller por segundo real
+
+// Hora del taller en este momento
+func (r *RelojSimulado) Ahora() time.Time {
+	if r == nil {
+		return time.Now()
+	}
+	real := time.Since(r.inicioReal)
+	return r.inicioSim.Add(time.Duration(float64(real) * escalaSimulacion))
+}
+
+// Tiempo real que tarda en pasar d de tiempo de taller
+func (r *RelojSimulado) Real(d time.Duration) time.Duration {
+	if r == nil {
+		return d
+	}
+	return time.Duration(float64(d) / escalaSimulacion)
+}
+
+// Primer día laborable desde ahora (hoy incluido) a la hora de apertura:
+// la simulación empieza en ese momento del reloj del taller
+func inicioSimulacion(ahora time.Time) time.Time {
+	inicio := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), horaAperturaCitas, 0, 0, 0, ahora.Location())
+	for inicio.Weekday() == time.Saturday || inicio.Weekday() == time.Sunday {
+		inicio = inicio.AddDate(0, 0, 1)
+	}
+	return inicio
+}
+
+// Hora del taller: la del reloj de la simulación si hay una en marcha
+func (t *Taller) ahora() time.Time {
+	return t.reloj.Ahora()
+}
+
+// Espera (en tiempo real) a que al mecánico le toque trabajar. Devuelve false
+// si antes termina la simulación o el control de plantilla lo despide.
+func (t *Taller) esperarTurno(m *Mecanico) bool {
+	for {
+		ahora := t.ahora()
+		if m.enTurno(ahora) {
+			return true
+		}
+		proximo := m.proximoTurno(ahora)
+		if proximo.IsZero() {
+			proximo = ahora.AddDate(0, 0, horizonteTurnos) // volver a mirar más adelante
+		}
+		temporizador := time.NewTimer(t.reloj.Real(proximo.Sub(ahora)))
+		select {
+		case <-temporizador.C:
+		case <-t.plantilla.despido(m):
+			temporizador.Stop()
+			return false
+		case <-t.colas.cerradas():
+			temporizador.Stop()
+			return false
+		}
+	}
+}
+
+// Canal que se cierra cuando acaba el tramo de trabajo en el que está el
+// mecánico (descanso, fin de turno o ausencia) o cuando lo despiden. La
+// función devuelta libera los recursos si se deja de esperar antes.
+func (t *Taller) finDeTramo(m *Mecanico) (<-chan struct{}, func()) {
+	despido := t.plantilla.despido(m)
+	ahora := t.ahora()
+	fin := m.finTramo(ahora)
+	if fin.IsZero() {
+		return despido, func() {}
+	}
+
+	ch := make(chan struct{})
+	cancelar := make(chan struct{})
+	temporizador := time.NewTimer(t.reloj.Real(fin.Sub(ahora)))
+	go func() {
+		defer temporizador.Stop()
+		select {
+		case <-temporizador.C:
+		case <-despido:
+		case <-cancelar:
+		}
+		close(ch)
+	}()
+	return ch, func() { close(cancelar) }
+}
+
+// Espera sin trabajar hasta el instante fin del reloj del taller
+func (t *Taller) esperarHasta(m *Mecanico, fin time.Time) {
+	temporizador := time.NewTimer(t.reloj.Real(fin.Sub(t.ahora())))
+	defer temporizador.Stop()
+	select {
+	case <-temporizador.C:
+	case <-t.plantilla.despido(m):
+	case <-t.colas.cerradas():
+	}
+}
+
+func (t *Taller) colasCerradas() bool {
+	select {
+	case <-t.colas.cerradas():
+		return true
+	default:
+		return false
+	}
+}
+
+// Indica si el control de plantilla ha despedido ya al mecánico
+func (t *Taller) despedido(m *Mecanico) bool {
+	select {
+	case <-t.plantilla.despido(m):
+		return true
+	default:
+		return false
+	}
+}
+
+// Pide un horario semanal: el estándar o uno hecho turno a turno
+func leerTurnos(in *Entrada) ([]Turno, error) {
+	estandar, err := in.SiNo("¿Horario estándar (L-V 8:00-17:00, comida 13:00-14:00)?", true)
+	if err != nil || estandar {
+		return horarioEstandar(), err
+	}
+	var turnos []Turno
+	for {
+		dia, err := leerValor(in, "Día", nil, "", parsearDia)
+		if err != nil {
+			return nil, err
+		}
+		horario, err := leerValor(in, "Horario (HH:MM-HH:MM)", nil, "", func(s string) (Intervalo, error) {
+			return parsearIntervalo("horario", s)
+		})
+		if err != nil {
+			return nil, err
+		}
+		tu := Turno{Dia: dia, Horario: horario}
+		tu.Descansos, err = leerValor(in, "Descansos separados por comas (vacío = ninguno)", nil, "", func(s string) ([]Intervalo, error) {
+			var descansos []Intervalo
+			for _, parte := range strings.Split(s, ",") {
+				if strings.TrimSpace(parte) == "" {
+					continue
+				}
+				d, err := parsearIntervalo("descanso", parte)
+				if err != nil {
+					return nil, err
+				}
+				descansos = append(descansos, d)
+			}
+			return descansos, validarTurno(Turno{Dia: dia, Horario: horario, Descansos: descansos})
+		})
+		if err != nil {
+			return nil, err
+		}
+		turnos = append(turnos, tu)
+		otro, err := in.SiNo("¿Otro turno?", false)
+		if err != nil || !otro {
+			return turnos, nil
+		}
+	}
+}
+
+// Pide el tipo y las fechas de una ausencia
+func leerAusencia(in *Entrada) (Ausencia, error) {
+	tipo, err := leerValor(in, "Tipo (vacaciones/baja)", nil, "", func(s string) (TipoAusencia, error) {
+		tipo := TipoAusencia(strings.ToLower(s))
+		if tipo != Vacaciones && tipo != Baja {
+			return "", errorValidacion("tipo de ausencia", s, "debe ser 'vacaciones' o 'baja'")
+		}
+		return tipo, nil
+	})
+	if err != nil {
+		return Ausencia{}, err
+	}
+	desde, err := in.Fecha("Desde", time.Time{})
+	if err != nil {
+		return Ausencia{}, err
+	}
+	hasta, err := in.Fecha("Hasta", time.Time{})
+	return Ausencia{Tipo: tipo, Desde: desde, Hasta: hasta}, err
+}
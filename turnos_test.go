@@ -0,0 +1,188 @@
+// turnos_test.go
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Lunes 19 de octubre de 2026 a las h:mm
+func lunesA(h, m int) time.Time {
+	return time.Date(2026, 10, 19, h, m, 0, 0, time.Local)
+}
+
+func mecanicoConHorario() *Mecanico {
+	return &Mecanico{ID: 1, Nombre: "Mec", Especialidad: Mecanica, Activo: true, Turnos: horarioEstandar()}
+}
+
+func TestEnTurno(t *testing.T) {
+	m := mecanicoConHorario()
+	casos := []struct {
+		f     time.Time
+		turno bool
+	}{
+		{lunesA(7, 59), false},
+		{lunesA(8, 0), true},
+		{lunesA(12, 59), true},
+		{lunesA(13, 30), false}, // comida
+		{lunesA(14, 0), true},
+		{lunesA(17, 0), false},
+		{time.Date(2026, 10, 24, 10, 0, 0, 0, time.Local), false}, // sábado
+	}
+	for _, c := range casos {
+		if got := m.enTurno(c.f); got != c.turno {
+			t.Errorf("enTurno(%s) = %v, se esperaba %v", formatearFecha(c.f), got, c.turno)
+		}
+	}
+	if sinHorario := (&Mecanico{}); !sinHorario.enTurno(lunesA(3, 0)) {
+		t.Error("un mecánico sin turnos está siempre disponible")
+	}
+}
+
+func TestFinTramoYProximoTurno(t *testing.T) {
+	m := mecanicoConHorario()
+	if fin := m.finTramo(lunesA(10, 0)); !fin.Equal(lunesA(13, 0)) {
+		t.Errorf("el tramo de la mañana acaba a las 13:00, no %s", formatearFecha(fin))
+	}
+	if fin := m.finTramo(lunesA(15, 0)); !fin.Equal(lunesA(17, 0)) {
+		t.Errorf("el tramo de la tarde acaba a las 17:00, no %s", formatearFecha(fin))
+	}
+	if p := m.proximoTurno(lunesA(13, 15)); !p.Equal(lunesA(14, 0)) {
+		t.Errorf("tras la comida vuelve a las 14:00, no %s", formatearFecha(p))
+	}
+	viernes := time.Date(2026, 10, 23, 18, 0, 0, 0, time.Local)
+	if p := m.proximoTurno(viernes); !p.Equal(time.Date(2026, 10, 26, 8, 0, 0, 0, time.Local)) {
+		t.Errorf("tras el viernes vuelve el lunes a las 8:00, no %s", formatearFecha(p))
+	}
+}
+
+func TestAusencias(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 3)
+	if err := taller.definirTurnos(m.ID, horarioEstandar()); err != nil {
+		t.Fatalf("error definiendo turnos: %v", err)
+	}
+	baja := Ausencia{Tipo: Baja, Desde: lunesA(11, 0), Hasta: time.Date(2026, 10, 20, 12, 0, 0, 0, time.Local)}
+	if err := taller.registrarAusencia(m.ID, baja); err != nil {
+		t.Fatalf("error registrando la baja: %v", err)
+	}
+
+	if m.enTurno(lunesA(15, 0)) {
+		t.Error("de baja no le toca trabajar")
+	}
+	if fin := m.finTramo(lunesA(9, 0)); !fin.Equal(lunesA(11, 0)) {
+		t.Errorf("el tramo debe acabar al empezar la baja, no %s", formatearFecha(fin))
+	}
+	if p := m.proximoTurno(lunesA(11, 0)); !p.Equal(time.Date(2026, 10, 20, 12, 0, 0, 0, time.Local)) {
+		t.Errorf("vuelve al acabar la baja, no %s", formatearFecha(p))
+	}
+}
+
+func TestValidacionTurnos(t *testing.T) {
+	for _, s := range []string{"", "8-16", "16:00-08:00", "08:00-25:00", "08:00-08:00"} {
+		var ev *ErrorValidacion
+		if _, err := parsearIntervalo("horario", s); !errors.As(err, &ev) {
+			t.Errorf("parsearIntervalo(%q): se esperaba un error de validación, se obtuvo %v", s, err)
+		}
+	}
+	if iv, err := parsearIntervalo("horario", " 07:30-15:00 "); err != nil || iv != (Intervalo{450, 900}) {
+		t.Errorf("intervalo válido mal leído: %v, %v", iv, err)
+	}
+	if d, err := parsearDia("Miércoles"); err != nil || d != time.Wednesday {
+		t.Errorf("parsearDia(Miércoles) = %v, %v", d, err)
+	}
+	if _, err := parsearDia("lu"); err == nil {
+		t.Error("una abreviatura de menos de tres letras es ambigua")
+	}
+
+	horario := Intervalo{8 * 60, 16 * 60}
+	fuera := Turno{Dia: time.Monday, Horario: horario, Descansos: []Intervalo{{15 * 60, 17 * 60}}}
+	solapados := Turno{Dia: time.Monday, Horario: horario, Descansos: []Intervalo{{10 * 60, 11 * 60}, {10*60 + 30, 12 * 60}}}
+	for _, tu := range []Turno{fuera, solapados} {
+		if err := validarTurno(tu); err == nil {
+			t.Errorf("turno no válido aceptado: %+v", tu)
+		}
+	}
+	if err := validarAusencia(Ausencia{Tipo: Vacaciones, Desde: lunesA(10, 0), Hasta: lunesA(9, 0)}); err == nil {
+		t.Error("una ausencia que acaba antes de empezar no es válida")
+	}
+}
+
+// Una hora antes de acabar el turno le llega un trabajo de cuatro horas: hace
+// una y deja el resto en la cola para el siguiente.
+func TestTrabajoSeDejaAlAcabarElTurno(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.definirTurnos(m.ID, horarioEstandar())
+	taller.reloj = nuevoRelojSimulado(lunesA(16, 0))
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	resultados := resultadosDePrueba(taller)
+
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 4
+	taller.colas.encolar(tr)
+	go trabajoMecanico(m, taller)
+
+	select {
+	case <-resultados:
+	case <-time.After(3 * time.Second):
+		t.Fatal("el mecánico no soltó el trabajo al acabar el turno")
+	}
+	if tr.Incidencia.Estado != 0 || tr.Incidencia.pendiente() != 3 {
+		t.Errorf("estado %d y %dh pendientes; se esperaba 0 y 3", tr.Incidencia.Estado, tr.Incidencia.pendiente())
+	}
+	if taller.colas.Longitud(Mecanica) != 1 {
+		t.Error("el trabajo pendiente debería volver a la cola")
+	}
+}
+
+// Si le falta poco para acabar lo termina aunque se pase del turno, y ese
+// tiempo cuenta como horas extra
+func TestHorasExtraParaAcabar(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Mec", "mecanica", 1)
+	taller.definirTurnos(m.ID, horarioEstandar())
+	taller.reloj = nuevoRelojSimulado(lunesA(16, 0))
+	taller.colas = nuevasColasTrabajo(time.Hour)
+	defer taller.colas.cerrar()
+	taller.plantilla = nuevoControladorPlantilla(taller, politicaPorDefecto(), func(*Mecanico) {}, nil)
+	taller.plantilla.registrar(m, time.Now())
+	resultados := resultadosDePrueba(taller)
+
+	tr := trabajoDe(taller, "V-01", Mecanica, false)
+	tr.Incidencia.TiempoAcumulado = 2
+	taller.colas.encolar(tr)
+	go trabajoMecanico(m, taller)
+
+	select {
+	case <-resultados:
+	case <-time.After(4 * time.Second):
+		t.Fatal("el mecánico no terminó el trabajo")
+	}
+	if tr.Incidencia.Estado != 2 {
+		t.Fatalf("la incidencia debería estar cerrada, estado %d", tr.Incidencia.Estado)
+	}
+	cp := taller.plantilla.costes(time.Now())
+	if math.Abs(cp.HorasExtra-1) > 0.2 || cp.CosteHorasExtra <= 0 {
+		t.Errorf("horas extra = %.2f (%.2f €); se esperaba alrededor de 1", cp.HorasExtra, cp.CosteHorasExtra)
+	}
+}
+
+func TestCitaRechazadaFueraDeTurno(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	for _, m := range taller.datos().Mecanicos() {
+		taller.definirTurnos(m.ID, horarioEstandar())
+	}
+	// A las 13:00 los dos mecánicos están comiendo
+	_, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 13), []Especialidad{Mecanica}, ahoraCitas)
+	if !errors.Is(err, errSinDisponibilidad) {
+		t.Fatalf("se esperaba falta de mecánicos en la hora de comer, se obtuvo %v", err)
+	}
+	// A las 12:00 empieza y la comida sólo pausa el trabajo
+	if _, err := taller.reservarCita(cli.ID, "M-1111", "Seat", "Ibiza", franja(20, 12), []Especialidad{Mecanica}, ahoraCitas); err != nil {
+		t.Errorf("reserva en turno rechazada: %v", err)
+	}
+}
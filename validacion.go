@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ------------ ERRORES DE VALIDACIÓN ------------
+
+// ErrorValidacion indica qué campo no es válido y por qué, para que los
+// menús puedan mostrarlo y los tests comprobarlo con errors.As.
+type ErrorValidacion struct {
+	Campo  string
+	Valor  string
+	Motivo string
+}
+
+func (e *ErrorValidacion) Error() string {
+	return fmt.Sprintf("%s inválido (%q): %s", e.Campo, e.Valor, e.Motivo)
+}
+
+func errorValidacion(campo, valor, motivo string) *ErrorValidacion {
+	return &ErrorValidacion{Campo: campo, Valor: valor, Motivo: motivo}
+}
+
+// ------------ MATRÍCULAS ------------
+
+// Códigos provinciales usados en las matrículas anteriores al año 2000
+var codigosProvincia = map[string]bool{
+	"A": true, "AB": true, "AL": true, "AV": true, "B": true, "BA": true, "BI": true,
+	"BU": true, "C": true, "CA": true, "CC": true, "CE": true, "CO": true, "CR": true,
+	"CS": true, "CU": true, "GC": true, "GE": true, "GI": true, "GR": true, "GU": true,
+	"H": true, "HU": true, "IB": true, "J": true, "L": true, "LE": true, "LO": true,
+	"LU": true, "M": true, "MA": true, "ML": true, "MU": true, "NA": true, "O": true,
+	"OR": true, "OU": true, "P": true, "PM": true, "PO": true, "S": true, "SA": true,
+	"SE": true, "SG": true, "SO": true, "SS": true, "T": true, "TE": true, "TF": true,
+	"TO": true, "V": true, "VA": true, "VI": true, "Z": true, "ZA": true,
+}
+
+var (
+	// Formato actual (desde 2000): 1234 BCD, sin vocales, Ñ ni Q
+	reMatriculaActual = regexp.MustCompile(`^(\d{4})[ -]?([BCDFGHJKLMNPRSTVWXYZ]{3})$`)
+	// Formato provincial (1971-2000): M-1234-AB
+	reMatriculaProvincial = regexp.MustCompile(`^([A-Z]{1,2})[ -]?(\d{4})[ -]?([A-Z]{1,2})$`)
+	// Formato antiguo (hasta 1971): M-123456
+	reMatriculaAntigua = regexp.MustCompile(`^([A-Z]{1,2})[ -]?(\d{1,6})$`)
+)
+
+// Comprueba una matrícula española y la devuelve normalizada
+// (mayúsculas y separadores estándar de su formato).
+func validarMatricula(mat string) (string, error) {
+	m := strings.ToUpper(strings.TrimSpace(mat))
+	if m == "" {
+		return "", errorValidacion("matrícula", mat, "no puede estar vacía")
+	}
+	if p := reMatriculaActual.FindStringSubmatch(m); p != nil {
+		return p[1] + " " + p[2], nil
+	}
+	if p := reMatriculaProvincial.FindStringSubmatch(m); p != nil && codigosProvincia[p[1]] {
+		return p[1] + "-" + p[2] + "-" + p[3], nil
+	}
+	if p := reMatriculaAntigua.FindStringSubmatch(m); p != nil && codigosProvincia[p[1]] {
+		return p[1] + "-" + p[2], nil
+	}
+	return "", errorValidacion("matrícula", mat,
+		"no es un formato español válido (1234 BCD, M-1234-AB o M-123456)")
+}
+
+// ------------ EMAIL Y TELÉFONO ------------
+
+// Comprueba que el email tenga la forma usuario@dominio.tld
+func validarEmail(email string) (string, error) {
+	e := strings.TrimSpace(email)
+	dir, err := mail.ParseAddress(e)
+	if err != nil || dir.Address != e || dir.Name != "" {
+		return "", errorValidacion("email", email, "debe tener la forma usuario@dominio.es")
+	}
+	dominio := e[strings.LastIndex(e, "@")+1:]
+	if !strings.Contains(dominio, ".") || strings.HasPrefix(dominio, ".") || strings.HasSuffix(dominio, ".") {
+		return "", errorValidacion("email", email, "el dominio debe incluir una extensión (p.ej. .es)")
+	}
+	return strings.ToLower(e), nil
+}
+
+var reDigitos = regexp.MustCompile(`^\d+$`)
+
+// Normaliza un teléfono al formato internacional (+34600111222).
+// Sin prefijo se asume un número español de 9 cifras.
+func validarTelefono(tlf string) (string, error) {
+	t := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(tlf))
+	switch {
+	case strings.HasPrefix(t, "+"):
+		t = t[1:]
+	case strings.HasPrefix(t, "00"):
+		t = t[2:]
+	case len(t) == 9:
+		if !strings.ContainsAny(t[:1], "6789") {
+			return "", errorValidacion("teléfono", tlf, "un número español debe empezar por 6, 7, 8 o 9")
+		}
+		t = "34" + t
+	default:
+		return "", errorValidacion("teléfono", tlf, "falta el prefijo de país (p.ej. +34)")
+	}
+	if !reDigitos.MatchString(t) {
+		return "", errorValidacion("teléfono", tlf, "sólo puede contener dígitos")
+	}
+	// E.164: como mucho 15 cifras contando el prefijo de país
+	if len(t) < 8 || len(t) > 15 {
+		return "", errorValidacion("teléfono", tlf, "debe tener entre 8 y 15 cifras con el prefijo")
+	}
+	if strings.HasPrefix(t, "34") && len(t) != 11 {
+		return "", errorValidacion("teléfono", tlf, "un número español tiene 9 cifras")
+	}
+	return "+" + t, nil
+}
+
+// ------------ FECHAS ------------
+
+// Formato con el que se muestran y se piden las fechas
+const formatoFecha = "2006-01-02 15:04"
+
+// Formatos aceptados al leer una fecha
+var formatosFecha = []string{
+	formatoFecha,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02/01/2006 15:04",
+	"02/01/2006",
+}
+
+// Convierte un texto en fecha. Una cadena vacía es la fecha cero (sin fecha).
+func parsearFecha(campo, s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, f := range formatosFecha {
+		if fecha, err := time.ParseInLocation(f, s, time.Local); err == nil {
+			return fecha, nil
+		}
+	}
+	return time.Time{}, errorValidacion(campo, s, "formato esperado AAAA-MM-DD [HH:MM] o DD/MM/AAAA")
+}
+
+// La fecha de entrada es obligatoria y la de salida, si existe, no puede ser anterior
+func validarFechas(entrada, salida time.Time) error {
+	if entrada.IsZero() {
+		return errorValidacion("fecha de entrada", "", "es obligatoria")
+	}
+	if !salida.IsZero() && salida.Before(entrada) {
+		return errorValidacion("fecha de salida", salida.Format(formatoFecha),
+			"no puede ser anterior a la de entrada ("+entrada.Format(formatoFecha)+")")
+	}
+	return nil
+}
+
+// Muestra una fecha o "-" si no está puesta
+func formatearFecha(f time.Time) string {
+	if f.IsZero() {
+		return "-"
+	}
+	return f.Format(formatoFecha)
+}
@@ -0,0 +1,106 @@
+// validacion_test.go
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidarMatricula(t *testing.T) {
+	casos := []struct {
+		entrada  string
+		esperada string
+		valida   bool
+	}{
+		{"1234 BCD", "1234 BCD", true},
+		{"1234bcd", "1234 BCD", true},
+		{"1234-BCD", "1234 BCD", true},
+		{"1234 BAD", "", false}, // vocales no permitidas
+		{"1234 BQD", "", false}, // la Q tampoco
+		{"M-1234-AB", "M-1234-AB", true},
+		{"ma 1234 z", "MA-1234-Z", true},
+		{"XX-1234-AB", "", false}, // provincia inexistente
+		{"V-01", "V-01", true},
+		{"B-123456", "B-123456", true},
+		{"", "", false},
+		{"12345 BCD", "", false},
+	}
+	for _, c := range casos {
+		got, err := validarMatricula(c.entrada)
+		if c.valida && (err != nil || got != c.esperada) {
+			t.Errorf("validarMatricula(%q) = %q, %v; se esperaba %q", c.entrada, got, err, c.esperada)
+		}
+		if !c.valida && err == nil {
+			t.Errorf("validarMatricula(%q) debería fallar, devolvió %q", c.entrada, got)
+		}
+	}
+}
+
+func TestValidarEmailYTelefono(t *testing.T) {
+	for _, e := range []string{"ana@correo.es", "Luis.Perez+taller@urjc.es"} {
+		if _, err := validarEmail(e); err != nil {
+			t.Errorf("validarEmail(%q) no debería fallar: %v", e, err)
+		}
+	}
+	for _, e := range []string{"ana", "ana@correo", "Ana <ana@correo.es>", "ana@@correo.es", "ana@.es"} {
+		if _, err := validarEmail(e); err == nil {
+			t.Errorf("validarEmail(%q) debería fallar", e)
+		}
+	}
+
+	telefonos := map[string]string{
+		"600111222":        "+34600111222",
+		"+34 600 111 222":  "+34600111222",
+		"0034-912-345-678": "+34912345678",
+		"+44 20 7946 0958": "+442079460958",
+	}
+	for entrada, esperado := range telefonos {
+		if got, err := validarTelefono(entrada); err != nil || got != esperado {
+			t.Errorf("validarTelefono(%q) = %q, %v; se esperaba %q", entrada, got, err, esperado)
+		}
+	}
+	for _, tlf := range []string{"123", "500111222", "+34 6001112223", "+34 600 ABC 222"} {
+		if _, err := validarTelefono(tlf); err == nil {
+			t.Errorf("validarTelefono(%q) debería fallar", tlf)
+		}
+	}
+}
+
+func TestValidacionEnAltasYModificaciones(t *testing.T) {
+	taller := &Taller{}
+
+	_, err := taller.newCliente("Ana", "12", "ana@correo.es", nil)
+	var ev *ErrorValidacion
+	if !errors.As(err, &ev) || ev.Campo != "teléfono" {
+		t.Fatalf("se esperaba un error de validación del teléfono, se obtuvo %v", err)
+	}
+
+	if _, err := taller.newVehiculo("ABC", "Seat", "Ibiza", time.Now(), time.Time{}, nil); !errors.As(err, &ev) {
+		t.Errorf("se esperaba un error de validación de la matrícula, se obtuvo %v", err)
+	}
+
+	entrada := time.Date(2025, 3, 10, 9, 0, 0, 0, time.Local)
+	v, err := taller.newVehiculo("1234bcd", "Seat", "Ibiza", entrada, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("error creando vehículo: %v", err)
+	}
+	if v.Matricula != "1234 BCD" {
+		t.Errorf("la matrícula debería guardarse normalizada, es %q", v.Matricula)
+	}
+	if _, err := taller.newVehiculo("1234-BCD", "Renault", "Clio", entrada, time.Time{}, nil); !errors.As(err, &ev) || ev.Campo != "matrícula" {
+		t.Errorf("se esperaba un error por matrícula repetida, se obtuvo %v", err)
+	}
+	if taller.getVehiculo("1234 BCD").Marca != "Seat" {
+		t.Errorf("la matrícula repetida ha sustituido al vehículo")
+	}
+
+	// La salida no puede ser anterior a la entrada
+	err = taller.updateVehiculo("1234 BCD", "", "", time.Time{}, entrada.Add(-time.Hour))
+	if !errors.As(err, &ev) || ev.Campo != "fecha de salida" {
+		t.Errorf("se esperaba un error en la fecha de salida, se obtuvo %v", err)
+	}
+	if err := taller.updateVehiculo("1234-BCD", "", "", time.Time{}, entrada.Add(time.Hour)); err != nil {
+		t.Errorf("una salida posterior a la entrada debería aceptarse: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------ VISITAS (ÓRDENES DE TRABAJO) ------------
+
+// Cada entrada de un vehículo en el taller abre una visita con las
+// incidencias que se le atienden esa vez. Al salir se cierra y queda en el
+// historial de la matrícula; si el vehículo vuelve, empieza una nueva.
+type Visita struct {
+	ID          int
+	Matricula   string
+	ClienteID   int // -1 si el vehículo no tiene cliente
+	PlazaID     int
+	Entrada     time.Time
+	Salida      time.Time // cero mientras el vehículo siga en el taller
+	Incidencias []int
+	Factura     int // número de la factura de salida (0 = sin factura)
+}
+
+func (vi *Visita) Abierta() bool {
+	return vi.Salida.IsZero()
+}
+
+// Visita en curso del vehículo (nil si no está en el taller)
+func (t *Taller) visitaAbierta(mat string) *Visita {
+	for _, vi := range t.datos().Visitas() {
+		if vi.Matricula == mat && vi.Abierta() {
+			return vi
+		}
+	}
+	return nil
+}
+
+// Visita a la que pertenece la incidencia (nil si no se hizo en ninguna)
+func (t *Taller) visitaDeIncidencia(id int) *Visita {
+	for _, vi := range t.datos().Visitas() {
+		for _, incID := range vi.Incidencias {
+			if incID == id {
+				return vi
+			}
+		}
+	}
+	return nil
+}
+
+// Da entrada al vehículo en la plaza. Lo que quedara de visitas anteriores
+// (incidencias cerradas, fechas, prioridad) sale del vehículo, que sigue
+// teniendo las incidencias abiertas y las creadas antes de entrar. Si se
+// había trasladado a otra sede, vuelve a estar en ésta. Si ya estaba dentro
+// devuelve su visita.
+func (t *Taller) abrirVisita(v *Vehiculo, plazaID int, ahora time.Time) (*Visita, error) {
+	if vi := t.visitaAbierta(v.Matricula); vi != nil {
+		return vi, nil
+	}
+	vi := &Visita{ID: t.nextVisitaID, Matricula: v.Matricula, ClienteID: -1, PlazaID: plazaID, Entrada: ahora}
+	if c := t.clienteDeVehiculo(v.Matricula); c != nil {
+		vi.ClienteID = c.ID
+	}
+
+	err := t.datos().Transaccion(func() error {
+		// Las cerradas en visitas anteriores salen del vehículo; las que
+		// siguen abiertas se quedan, pero sólo las nuevas son de esta visita
+		var actuales []*Incidencia
+		for _, inc := range v.Incidencias {
+			switch {
+			case t.visitaDeIncidencia(inc.ID) == nil:
+				actuales = append(actuales, inc)
+				vi.Incidencias = append(vi.Incidencias, inc.ID)
+			case inc.Estado != 2:
+				actuales = append(actuales, inc)
+			}
+		}
+		v.Incidencias = actuales
+		v.FechaEntrada = ahora
+		v.FechaSalida = time.Time{}
+		v.Prioritario = false
+		v.Sede = ""
+		t.updateTiempoTotalVehiculo(v)
+
+		t.nextVisitaID++
+		if err := t.datos().GuardarVehiculo(v); err != nil {
+			return err
+		}
+		return t.datos().GuardarVisita(vi)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vi, nil
+}
+
+// Apunta una incidencia nueva en la visita en curso del vehículo, si la hay
+func (t *Taller) añadirAVisita(mat string, inc *Incidencia) error {
+	vi := t.visitaAbierta(mat)
+	if vi == nil {
+		return nil
+	}
+	vi.Incidencias = append(vi.Incidencias, inc.ID)
+	return t.datos().GuardarVisita(vi)
+}
+
+// El vehículo sale del taller: se cierra su visita con la factura de salida
+func (t *Taller) cerrarVisita(v *Vehiculo, f *Factura, ahora time.Time) error {
+	return t.datos().Transaccion(func() error {
+		v.FechaSalida = ahora
+		if err := t.datos().GuardarVehiculo(v); err != nil {
+			return err
+		}
+		vi := t.visitaAbierta(v.Matricula)
+		if vi == nil {
+			return nil
+		}
+		vi.Salida = ahora
+		if f != nil {
+			vi.Factura = f.Numero
+		}
+		return t.datos().GuardarVisita(vi)
+	})
+}
+
+// ------------ HISTORIAL ------------
+
+// Visitas de una matrícula, de la más antigua a la más reciente
+func (t *Taller) historialVehiculo(mat string) []*Visita {
+	var lista []*Visita
+	for _, vi := range t.datos().Visitas() {
+		if vi.Matricula == mat {
+			lista = append(lista, vi)
+		}
+	}
+	sort.Slice(lista, func(i, j int) bool {
+		if !lista[i].Entrada.Equal(lista[j].Entrada) {
+			return lista[i].Entrada.Before(lista[j].Entrada)
+		}
+		return lista[i].ID < lista[j].ID
+	})
+	return lista
+}
+
+// Resumen de una visita: mecánicos que trabajaron en ella, horas de mano de
+// obra y coste con IVA (el de la factura si ya salió, si no lo que se lleva)
+type resumenVisita struct {
+	Mecanicos []string
+	Horas     float64
+	Coste     float64
+}
+
+func (t *Taller) resumirVisita(vi *Visita) resumenVisita {
+	var r resumenVisita
+	vistos := map[string]bool{}
+	apuntar := func(nombre string) {
+		if !vistos[nombre] {
+			vistos[nombre] = true
+			r.Mecanicos = append(r.Mecanicos, nombre)
+		}
+	}
+	var lineas []LineaFactura
+	for _, id := range vi.Incidencias {
+		inc := t.getIncidencia(id)
+		if inc == nil {
+			continue
+		}
+		for _, m := range inc.Mecanicos {
+			apuntar(m.Nombre)
+		}
+		for _, pt := range inc.ManoDeObra {
+			apuntar(pt.Nombre)
+			r.Horas += pt.Horas
+		}
+		lineas = append(lineas, t.lineasIncidencia(inc)...)
+	}
+	_, _, r.Coste = totales(lineas)
+	if vi.Factura != 0 {
+		if f := t.datos().Factura(vi.Factura); f != nil {
+			r.Coste = f.Total
+		}
+	}
+	return r
+}
+
+func printHistorial(t *Taller, mat string) {
+	visitas := t.historialVehiculo(mat)
+	if len(visitas) == 0 {
+		fmt.Printf("El vehículo %s no tiene visitas registradas.\n", mat)
+		return
+	}
+	fmt.Printf("Historial del vehículo %s (%d visitas)\n", mat, len(visitas))
+	var horas, coste float64
+	for _, vi := range visitas {
+		r := t.resumirVisita(vi)
+		horas += r.Horas
+		coste += r.Coste
+		salida := formatearFecha(vi.Salida)
+		if vi.Abierta() {
+			salida = "en el taller"
+		}
+		fmt.Printf("\nVisita %d: entrada %s, salida %s, plaza %d\n", vi.ID, formatearFecha(vi.Entrada), salida, vi.PlazaID)
+		for _, id := range vi.Incidencias {
+			if inc := t.getIncidencia(id); inc != nil {
+				fmt.Printf("  - [%s] %s: %s\n", estadoToString(inc.Estado), inc.Tipo, inc.Descripcion)
+			}
+		}
+		mecanicos := "-"
+		if len(r.Mecanicos) > 0 {
+			mecanicos = strings.Join(r.Mecanicos, ", ")
+		}
+		fmt.Printf("  Mecánicos: %s\n", mecanicos)
+		fmt.Printf("  Mano de obra: %.1f h | Coste: %.2f € (IVA incluido)", r.Horas, r.Coste)
+		if f := t.datos().Factura(vi.Factura); f != nil {
+			fmt.Printf(" | Factura %s", f.Codigo())
+		}
+		fmt.Println()
+	}
+	fmt.Printf("\nTotal: %.1f h de mano de obra, %.2f € en %d visitas\n", horas, redondear(coste), len(visitas))
+}
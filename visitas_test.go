@@ -0,0 +1,191 @@
+// visitas_test.go
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Repara y deja salir el vehículo: cierra sus incidencias con horas de trabajo
+func repararYSacar(taller *Taller, v *Vehiculo, m *Mecanico, horas float64) {
+	for _, inc := range v.Incidencias {
+		taller.registrarManoDeObra(inc, m, horas)
+		inc.Estado = 2
+	}
+	taller.liberarPlaza(v)
+}
+
+func TestVehiculoQueVuelveEmpiezaVisitaNueva(t *testing.T) {
+	taller := &Taller{}
+	luis := taller.newMecanico("Luis", "mecanica", 0)
+	ana := taller.newMecanico("Ana", "electrica", 0)
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, luis.ID); err != nil {
+		t.Fatal(err)
+	}
+	taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	repararYSacar(taller, v, luis, 2)
+
+	primera := taller.visitaAbierta(v.Matricula)
+	if primera != nil {
+		t.Fatalf("la visita debería cerrarse al salir: %+v", primera)
+	}
+	if v.FechaSalida.IsZero() {
+		t.Error("el vehículo debería tener fecha de salida")
+	}
+
+	// Vuelve con otra avería
+	if err := taller.admitirCliente(cli.ID, v, ana.ID); err != nil {
+		t.Fatalf("un vehículo del mismo cliente puede volver: %v", err)
+	}
+	if len(v.Incidencias) != 0 || v.TiempoTotal != 0 || !v.FechaSalida.IsZero() {
+		t.Fatalf("el vehículo conserva el estado de la visita anterior: %+v", v)
+	}
+	if len(cli.Vehiculos) != 1 {
+		t.Errorf("el cliente no debería tener el vehículo repetido: %d", len(cli.Vehiculos))
+	}
+	inc, _ := taller.newIncidencia(v.Matricula, nil, "electrica", "Media", "Luces")
+	segunda := taller.visitaAbierta(v.Matricula)
+	if segunda == nil || len(segunda.Incidencias) != 1 || segunda.Incidencias[0] != inc.ID {
+		t.Fatalf("la incidencia nueva debería ir a la segunda visita: %+v", segunda)
+	}
+	repararYSacar(taller, v, ana, 1)
+
+	historial := taller.historialVehiculo(v.Matricula)
+	if len(historial) != 2 || historial[0].Factura == 0 || historial[1].Factura == historial[0].Factura {
+		t.Fatalf("historial incorrecto: %+v", historial)
+	}
+	for i, want := range []struct {
+		mecanico string
+		horas    float64
+		coste    float64
+	}{
+		{"Luis", 2, 96.8}, // 2h a 40 € + IVA
+		{"Ana", 1, 54.45}, // 1h a 45 € + IVA
+	} {
+		r := taller.resumirVisita(historial[i])
+		if len(r.Mecanicos) != 1 || r.Mecanicos[0] != want.mecanico || r.Horas != want.horas || math.Abs(r.Coste-want.coste) > 0.001 {
+			t.Errorf("visita %d: %+v, se esperaba %s, %.0fh y %.2f €", i+1, r, want.mecanico, want.horas, want.coste)
+		}
+		if historial[i].ClienteID != cli.ID {
+			t.Errorf("visita %d sin su cliente: %+v", i+1, historial[i])
+		}
+	}
+}
+
+func TestVehiculoQueVuelveConservaLasAbiertas(t *testing.T) {
+	taller := &Taller{}
+	luis := taller.newMecanico("Luis", "mecanica", 0)
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	if err := taller.admitirCliente(cli.ID, v, luis.ID); err != nil {
+		t.Fatal(err)
+	}
+	frenos, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	aceite, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Baja", "Aceite")
+	repararYSacar(taller, v, luis, 1)
+	primera := taller.historialVehiculo(v.Matricula)[0]
+
+	// Los frenos vuelven a fallar después de salir
+	frenos.Estado = 1
+	nueva, _ := taller.newIncidencia(v.Matricula, nil, "mecanica", "Media", "Ruido")
+	if err := taller.admitirCliente(cli.ID, v, luis.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Incidencias) != 2 || v.Incidencias[0] != frenos || v.Incidencias[1] != nueva {
+		t.Fatalf("el vehículo debería quedarse con la abierta y la nueva: %+v", v.Incidencias)
+	}
+	segunda := taller.visitaAbierta(v.Matricula)
+	if segunda == nil || len(segunda.Incidencias) != 1 || segunda.Incidencias[0] != nueva.ID {
+		t.Fatalf("sólo la incidencia nueva es de la segunda visita: %+v", segunda)
+	}
+	if vi := taller.visitaDeIncidencia(frenos.ID); vi == nil || vi.ID != primera.ID {
+		t.Errorf("la abierta sigue siendo de la primera visita: %+v", vi)
+	}
+	if taller.visitaDeIncidencia(aceite.ID).ID != primera.ID {
+		t.Errorf("la cerrada sigue en la primera visita")
+	}
+}
+
+func TestVehiculoDeOtroClienteNoSeAdmite(t *testing.T) {
+	taller := &Taller{}
+	m := taller.newMecanico("Luis", "mecanica", 0)
+	pepe, _ := taller.newCliente("Pepe", "", "", nil)
+	ana, _ := taller.newCliente("Ana", "", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.admitirCliente(pepe.ID, v, m.ID)
+	taller.liberarPlaza(v)
+	if err := taller.admitirCliente(ana.ID, v, m.ID); err == nil {
+		t.Error("el vehículo es de otro cliente")
+	}
+	if n := len(taller.historialVehiculo(v.Matricula)); n != 1 {
+		t.Errorf("visitas %d, se esperaba 1", n)
+	}
+}
+
+func TestCitaDeVehiculoQueVuelve(t *testing.T) {
+	taller, cli := tallerConCitas(t)
+	c, err := taller.reservarCita(cli.ID, "1234 BCD", "Seat", "Ibiza", franja(20, 9), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := taller.admitirCitasDelDia(franja(20, 9)); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	v := taller.getVehiculo("1234 BCD")
+	m := taller.datos().Mecanicos()[0]
+	repararYSacar(taller, v, m, 1)
+
+	c2, err := taller.reservarCita(cli.ID, "1234 BCD", "Seat", "Ibiza", franja(21, 9), []Especialidad{Mecanica}, ahoraCitas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := taller.admitirCitasDelDia(franja(21, 9)); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	vi := taller.visitaAbierta("1234 BCD")
+	if vi == nil || vi.ClienteID != cli.ID || len(vi.Incidencias) != 1 || !vi.Entrada.Equal(franja(21, 9)) {
+		t.Fatalf("visita de la segunda cita incorrecta: %+v", vi)
+	}
+	if len(v.Incidencias) != 1 || v.Incidencias[0].ID != vi.Incidencias[0] {
+		t.Errorf("el vehículo sólo debería tener la incidencia de la cita %d: %+v", c2.ID, v.Incidencias)
+	}
+	if n := len(taller.historialVehiculo("1234 BCD")); n != 2 {
+		t.Errorf("visitas %d tras las citas %d y %d, se esperaban 2", n, c.ID, c2.ID)
+	}
+}
+
+func TestVisitasSePersisten(t *testing.T) {
+	dir := t.TempDir()
+	taller, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo abrir el almacén: %v", err)
+	}
+	m := taller.newMecanico("Luis", "mecanica", 0)
+	cli, _ := taller.newCliente("Pepe", "", "", nil)
+	v, _ := taller.newVehiculo("1234 BCD", "Seat", "Ibiza", time.Now(), time.Time{}, nil)
+	taller.admitirCliente(cli.ID, v, m.ID)
+	taller.newIncidencia(v.Matricula, nil, "mecanica", "Alta", "Frenos")
+	repararYSacar(taller, v, m, 2)
+	taller.admitirCliente(cli.ID, v, m.ID)
+
+	recargado, err := nuevoTaller("fichero", dir)
+	if err != nil {
+		t.Fatalf("no se pudo reabrir el almacén: %v", err)
+	}
+	historial := recargado.historialVehiculo(v.Matricula)
+	if len(historial) != 2 || historial[0].Abierta() || !historial[1].Abierta() || len(historial[0].Incidencias) != 1 {
+		t.Fatalf("visitas no recuperadas: %+v", historial)
+	}
+	if r := recargado.resumirVisita(historial[0]); r.Horas != 2 || len(r.Mecanicos) != 1 {
+		t.Errorf("resumen tras recargar: %+v", r)
+	}
+	if v2 := recargado.getVehiculo(v.Matricula); len(v2.Incidencias) != 0 {
+		t.Errorf("el vehículo recargado conserva incidencias de la visita anterior: %+v", v2.Incidencias)
+	}
+	if vi, _ := recargado.abrirVisita(recargado.getVehiculo(v.Matricula), 1, time.Now()); vi.ID != historial[1].ID {
+		t.Errorf("el vehículo ya tenía una visita abierta: %+v", vi)
+	}
+}